@@ -0,0 +1,135 @@
+// checkpoint.go checkpoints the progress of an iteration of the main loop in the state store, so
+// that Collector resumes an interrupted iteration where it left off after a restart.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	except "github.com/banyanops/collector/except"
+)
+
+const (
+	// Stages of the processing of the metadata of an image in an iteration
+	StageDiscovered = "discovered"
+	StagePulled     = "pulled"
+	StageScanned    = "scanned"
+	StageWritten    = "written"
+	StageRemoved    = "removed"
+
+	// Buckets and keys of the state store
+	stateIteration         = "iteration"
+	stateIterationQueue    = "queue"
+	stateIterationProgress = "progress"
+	stateImageStages       = "imageStages"
+)
+
+// IterationQueue is the work of an iteration, as discovered at its start.
+type IterationQueue struct {
+	Started time.Time
+	// Queue is the new metadata to process, in order
+	Queue []ImageMetadataInfo
+	// Current is all the metadata seen in the iteration
+	Current []ImageMetadataInfo
+}
+
+// IterationProgress is the progress of an iteration, saved after every batch.
+type IterationProgress struct {
+	// MetadataWritten is set once the metadata of the queue has been passed to the writers
+	MetadataWritten bool
+	// Failed is the metadata whose image couldn't be pulled or scanned in the iteration
+	Failed []ImageMetadataInfo
+	// ImageCount is the number of images processed for each repository
+	ImageCount map[RepoType]int
+}
+
+// ImageStage is the stage reached by the metadata of an image in the current iteration.
+type ImageStage struct {
+	Stage string
+	// Metadata as updated by the pull
+	Metadata ImageMetadataInfo
+	Updated  time.Time
+}
+
+// SaveIterationQueue checkpoints the start of an iteration.
+func SaveIterationQueue(queue IterationQueue) error {
+	return State.Put(stateIteration, stateIterationQueue, queue)
+}
+
+// SaveIterationProgress checkpoints the progress of the current iteration.
+func SaveIterationProgress(progress IterationProgress) error {
+	return State.Put(stateIteration, stateIterationProgress, progress)
+}
+
+// LoadIteration returns the checkpoint of an interrupted iteration; queue is nil if there is none.
+func LoadIteration() (queue *IterationQueue, progress IterationProgress, e error) {
+	var q IterationQueue
+	found, e := State.Get(stateIteration, stateIterationQueue, &q)
+	if e != nil || !found {
+		return
+	}
+	if _, e = State.Get(stateIteration, stateIterationProgress, &progress); e != nil {
+		return
+	}
+	return &q, progress, nil
+}
+
+// ClearIteration removes the checkpoint of the current iteration once it is complete.
+func ClearIteration() (e error) {
+	for _, key := range State.Keys(stateImageStages) {
+		if e = State.Delete(stateImageStages, key); e != nil {
+			return
+		}
+	}
+	if e = State.Delete(stateIteration, stateIterationProgress); e != nil {
+		return
+	}
+	return State.Delete(stateIteration, stateIterationQueue)
+}
+
+// SetImageStage records the stage reached by the metadata of an image in the current iteration.
+func SetImageStage(metadata ImageMetadataInfo, stage string) {
+	if State == nil {
+		// no state store, e.g., in tests
+		return
+	}
+	key := metadataKey(metadata)
+	if err := State.Put(stateImageStages, key, ImageStage{Stage: stage, Metadata: metadata,
+		Updated: time.Now()}); err != nil {
+		except.Error(err, ": Error in recording stage", stage, "of", key)
+	}
+}
+
+// GetImageStage returns the stage reached by the metadata of an image in the current iteration,
+// which is StageDiscovered if nothing was recorded.
+func GetImageStage(metadata ImageMetadataInfo) (stage ImageStage) {
+	stage = ImageStage{Stage: StageDiscovered, Metadata: metadata}
+	if State == nil {
+		return
+	}
+	var s ImageStage
+	found, err := State.Get(stateImageStages, metadataKey(metadata), &s)
+	if err != nil {
+		except.Error(errors.New(metadataKey(metadata)+": "+err.Error()), ": Error in reading image stage")
+		return
+	}
+	if found {
+		stage = s
+	}
+	return
+}
+
+// ImageStages returns the stages reached in the current iteration, by metadata key.
+func ImageStages() (stages map[string]ImageStage, e error) {
+	stages = make(map[string]ImageStage)
+	e = State.ForEach(stateImageStages, func(key string, value []byte) error {
+		var s ImageStage
+		if err := json.Unmarshal(value, &s); err != nil {
+			return errors.New(key + ": " + err.Error())
+		}
+		stages[key] = s
+		return nil
+	})
+	return
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIterationCheckpoint(t *testing.T) {
+	dir := openTestState(t)
+	defer closeTestState(dir)
+
+	if queue, _, err := LoadIteration(); queue != nil || err != nil {
+		t.Fatal("Unexpected checkpoint", queue, err)
+	}
+	a := ImageMetadataInfo{OtherMetadata: OtherMetadata{Repo: "team/a", Tag: "v1"}}
+	b := ImageMetadataInfo{OtherMetadata: OtherMetadata{Repo: "team/b", Tag: "v1"}}
+	started := time.Now().UTC()
+	if err := SaveIterationQueue(IterationQueue{Started: started, Queue: []ImageMetadataInfo{a, b},
+		Current: []ImageMetadataInfo{a, b}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveIterationProgress(IterationProgress{MetadataWritten: true, Failed: []ImageMetadataInfo{b},
+		ImageCount: map[RepoType]int{"team/a": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if stage := GetImageStage(a); stage.Stage != StageDiscovered || stage.Metadata != a {
+		t.Fatal("Unexpected stage", stage)
+	}
+	pulled := a
+	pulled.Image = "sha256:aaa"
+	SetImageStage(pulled, StagePulled)
+	SetImageStage(pulled, StageWritten)
+	if stage := GetImageStage(a); stage.Stage != StageWritten || stage.Metadata.Image != pulled.Image {
+		t.Fatal("Unexpected stage", stage)
+	}
+
+	// restart
+	State.Close()
+	if err := OpenState(filepath.Join(dir, "state.db"), filepath.Join(dir, "imagelist")); err != nil {
+		t.Fatal(err)
+	}
+	queue, progress, err := LoadIteration()
+	if err != nil || queue == nil {
+		t.Fatal("No checkpoint", err)
+	}
+	if !queue.Started.Equal(started) || len(queue.Queue) != 2 || queue.Queue[1] != b || !progress.MetadataWritten ||
+		len(progress.Failed) != 1 || progress.ImageCount["team/a"] != 1 {
+		t.Fatal("Unexpected checkpoint", queue, progress)
+	}
+	if stage := GetImageStage(a); stage.Stage != StageWritten {
+		t.Fatal("Unexpected stage", stage)
+	}
+
+	if err := ClearIteration(); err != nil {
+		t.Fatal(err)
+	}
+	if queue, _, err := LoadIteration(); queue != nil || err != nil {
+		t.Fatal("Checkpoint not cleared", queue, err)
+	}
+	if stages, _ := ImageStages(); len(stages) != 0 {
+		t.Fatal("Stages not cleared", stages)
+	}
+}
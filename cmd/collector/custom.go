@@ -20,8 +20,12 @@ func init() {
 	config.BanyanUpdate = func(status ...string) {}
 }
 
+// initMetadataSet loads the metadata of the repo:tags seen before the last restart.
 func initMetadataSet(tokenSync *auth.TokenSyncInfo, metadataSet collector.MetadataSet) {
-	return
+	if e := collector.LoadMetadataSet(metadataSet); e != nil {
+		except.Error(e, ": Error in loading metadata from the state store")
+	}
+	blog.Info("Loaded %d metadata items from the state store", len(metadataSet))
 }
 
 func checkConfigUpdate(initial bool) (updates bool) {
@@ -32,10 +36,18 @@ func checkConfigUpdate(initial bool) (updates bool) {
 func doFlags() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "  Usage: %s [OPTIONS] REGISTRY REPO [REPO...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "         %s scan [OPTIONS] IMAGE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "         %s diff [OPTIONS] OLD NEW\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "         %s quarantine [OPTIONS] [list | release KEY...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n  REGISTRY:\n")
 		fmt.Fprintf(os.Stderr, "\tURL of your Docker registry; use "+config.DockerHub+" for Docker Hub, use local.host to collect images from local Docker host\n")
 		fmt.Fprintf(os.Stderr, "\n  REPO:\n")
 		fmt.Fprintf(os.Stderr, "\tOne or more repos to gather info about; if no repo is specified Collector will gather info on *all* repos in the Registry\n")
+		fmt.Fprintf(os.Stderr, "\tA REPO can also be a glob pattern, e.g., 'team-a/*' or '*/nginx', or a regular expression prefixed with re:, e.g., 're:^team-(a|b)/'\n")
+		fmt.Fprintf(os.Stderr, "\n  REPOLIST file:\n")
+		fmt.Fprintf(os.Stderr, "\tOne REPO per line, optionally followed by tag policy options:\n")
+		fmt.Fprintf(os.Stderr, "\tinclude=PATTERN[,...] exclude=PATTERN[,...] semver=RANGE newest=N maxage=DURATION\n")
+		fmt.Fprintf(os.Stderr, "\te.g., team-a/*  exclude=*-debug semver=>=1.2,<2 newest=3 maxage=30d\n")
 		fmt.Fprintf(os.Stderr, "\n  Environment variables:\n")
 		fmt.Fprintf(os.Stderr, "\tCOLLECTOR_DIR:   (Required) Directory that contains the \"data\" folder with Collector default scripts, e.g., $GOPATH/src/github.com/banyanops/collector\n")
 		fmt.Fprintf(os.Stderr, "\tCOLLECTOR_ID:    ID provided by Banyan web interface to register Collector with the Banyan service\n")
@@ -59,7 +71,13 @@ func doFlags() {
 		flag.Usage()
 		os.Exit(except.ErrorExitStatus)
 	}
-	requiredDirs := []string{config.BANYANDIR(), filepath.Dir(*imageList), filepath.Dir(*repoList), *config.BanyanOutDir, collector.DefaultScriptsDir, collector.UserScriptsDir, collector.BinDir}
+	if *workers < 1 || *batchSize < 1 {
+		except.Fail("--workers and --batchsize must be at least 1")
+	}
+	if *removeThresh > 0 && *workers > *removeThresh {
+		except.Warn("Only %d images can be pulled at once with --removethresh=%d", *removeThresh, *removeThresh)
+	}
+	requiredDirs := []string{config.BANYANDIR(), filepath.Dir(*imageList), filepath.Dir(*collector.StateFile), filepath.Dir(*repoList), *config.BanyanOutDir, collector.DefaultScriptsDir, collector.UserScriptsDir, collector.BinDir}
 	for _, dir := range requiredDirs {
 		blog.Debug("Creating directory: " + dir)
 		err := fsutil.CreateDirIfNotExist(dir)
@@ -67,11 +85,18 @@ func doFlags() {
 			except.Fail(err, ": Error in creating a required directory: ", dir)
 		}
 	}
+	loadConfigFiles()
 	collector.RegistrySpec = flag.Arg(0)
 	// EqualFold: case insensitive comparison
 	if strings.EqualFold(flag.Arg(0), "local.host") {
 		collector.LocalHost = true
 	}
+	if *collector.Daemonless {
+		if collector.LocalHost {
+			except.Fail("--daemonless cannot be used to collect images from local.host")
+		}
+		checkDaemonless()
+	}
 	//nextMaxImages = *maxImages
 
 	if *maxRequests != 0 {
@@ -88,6 +113,45 @@ func doFlags() {
 	}
 }
 
+// loadConfigFiles loads the configuration files given by flags, and checks the flags that are
+// parsed into configuration.
+func loadConfigFiles() {
+	if err := collector.LoadDistroMap(*collector.DistroMapFile); err != nil {
+		except.Fail(err, ": Error in loading distro mapping file", *collector.DistroMapFile)
+	}
+	if err := collector.LoadOutputSchemas(*collector.OutputSchemaFile); err != nil {
+		except.Fail(err, ": Error in loading output schemas file", *collector.OutputSchemaFile)
+	}
+	if *collector.SandboxFile != "" {
+		if err := collector.LoadSandbox(*collector.SandboxFile); err != nil {
+			except.Fail(err, ": Error in loading sandbox file", *collector.SandboxFile)
+		}
+	}
+	if *collector.VulnDBDir != "" {
+		if err := collector.LoadVulnDB(*collector.VulnDBDir); err != nil {
+			except.Fail(err, ": Error in loading vulnerability database", *collector.VulnDBDir)
+		}
+	}
+	if *collector.PolicyFile != "" {
+		if err := collector.LoadPolicy(*collector.PolicyFile); err != nil {
+			except.Fail(err, ": Error in loading policy file", *collector.PolicyFile)
+		}
+	}
+	if _, err := collector.ParsePlatforms(*collector.Platforms); err != nil {
+		except.Fail(err, ": Error in --platforms")
+	}
+}
+
+// checkDaemonless checks the flags that --daemonless depends on, and creates the scratch directory.
+func checkDaemonless() {
+	if *collector.RegistryProto != "v2" {
+		except.Fail("--daemonless requires --registryproto=v2")
+	}
+	if err := fsutil.CreateDirIfNotExist(*collector.ScratchDir); err != nil {
+		except.Fail(err, ": Error in creating --scratchdir", *collector.ScratchDir)
+	}
+}
+
 func printExampleUsage() {
 	fmt.Fprintf(os.Stderr, "\n  Examples:\n")
 	fmt.Fprintf(os.Stderr, "  (a) Running when compiled from source (standalone mode):\n")
@@ -111,6 +175,8 @@ func SetOutputWriters(tokenSync *auth.TokenSyncInfo) {
 		switch dest {
 		case "file":
 			writer = collector.NewFileWriter("json", *config.BanyanOutDir)
+		case collector.SBOMCycloneDX, collector.SBOMSPDX:
+			writer = collector.NewSBOMWriter(dest, *config.BanyanOutDir)
 		default:
 			except.Error("No such output writer!")
 			//ignore the rest and keep going
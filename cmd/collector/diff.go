@@ -0,0 +1,147 @@
+package main
+
+// diff.go has the diff subcommand, which compares the packages of two images, e.g., of two tags
+// of a repository, and reports the packages added, removed, upgraded and downgraded.
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	collector "github.com/banyanops/collector"
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// Exit status of the diff subcommand when the images differ, as with diff(1).
+	// Errors exit with except.ErrorExitStatus.
+	diffExitDifferent = 1
+)
+
+// diffCommand runs the diff subcommand with its arguments, and returns the exit status.
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	for _, name := range append(scanFlags, "banyanoutdir") {
+		fs.AddFlag(flag.CommandLine.Lookup(name))
+	}
+	format := fs.StringP("format", "f", collector.ReportText, "Format of the diff: text or json")
+	output := fs.StringP("output", "o", "-", "File to write the diff to (- for stdout)")
+	pull := fs.String("pull", collector.PullMissing,
+		"When to pull the images: missing (if not on the Docker host), always, or never")
+	keep := fs.Bool("keep", false, "Keep the images on the Docker host if they were pulled for the diff")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "  Usage: %s diff [OPTIONS] OLD NEW\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  OLD, NEW:\n")
+		fmt.Fprintf(os.Stderr, "\tAn image reference [REGISTRY/]REPO[:TAG][@DIGEST] or the ID of an image on the Docker host, whose packages are read,\n")
+		fmt.Fprintf(os.Stderr, "\tthe ID of an image collected in --banyanoutdir, or a package data file or json scan report\n")
+		fmt.Fprintf(os.Stderr, "\n  Reports the packages added, removed, upgraded and downgraded from OLD to NEW, and the change\n")
+		fmt.Fprintf(os.Stderr, "  of distribution. Exits with status 0 if the images have the same packages, %d if they differ,\n",
+			diffExitDifferent)
+		fmt.Fprintf(os.Stderr, "  and %d on errors.\n", except.ErrorExitStatus)
+		fmt.Fprintf(os.Stderr, "\n  Options:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return except.ErrorExitStatus
+	}
+	if fs.NArg() != 2 || config.COLLECTORDIR() == "" {
+		fs.Usage()
+		return except.ErrorExitStatus
+	}
+	switch *format {
+	case collector.ReportJSON, collector.ReportText:
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown --format", *format)
+		return except.ErrorExitStatus
+	}
+	switch *pull {
+	case collector.PullMissing, collector.PullAlways, collector.PullNever:
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown --pull policy", *pull)
+		return except.ErrorExitStatus
+	}
+	if *dockerProto != "unix" && *dockerProto != "tcp" {
+		fs.Usage()
+		return except.ErrorExitStatus
+	}
+
+	logToStderr()
+	defer blog.Close()
+	d := &differ{pull: *pull, keep: *keep}
+	defer d.cleanup()
+	oldPkgs, err := d.packages(fs.Arg(0))
+	if err != nil {
+		except.Error(err, ": Error in reading the packages of", fs.Arg(0))
+		return except.ErrorExitStatus
+	}
+	newPkgs, err := d.packages(fs.Arg(1))
+	if err != nil {
+		except.Error(err, ": Error in reading the packages of", fs.Arg(1))
+		return except.ErrorExitStatus
+	}
+
+	diff := collector.DiffPackages(fs.Arg(0), oldPkgs, fs.Arg(1), newPkgs)
+	if err := writeOutput(*output, func(w io.Writer) error {
+		return collector.WriteDiff(w, diff, *format)
+	}); err != nil {
+		except.Error(err, ": Error in writing diff to", *output)
+		return except.ErrorExitStatus
+	}
+	if !diff.Empty() {
+		return diffExitDifferent
+	}
+	return 0
+}
+
+// differ reads the packages of the images of a diff.
+type differ struct {
+	pull string
+	keep bool
+	// setup is set once setupOneShot was called
+	setup bool
+	// pulled is the images pulled for the diff
+	pulled []collector.ImageRef
+}
+
+// packages returns the packages of the image given by arg: a package data file or scan report,
+// the ID of an image collected in --banyanoutdir, or else an image to read the packages of.
+func (d *differ) packages(arg string) (pkgs []collector.ImageDataInfo, e error) {
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		return collector.LoadPackagesFile(arg)
+	}
+	ref, e := collector.ParseImageRef(arg)
+	if e != nil {
+		return
+	}
+	if ref.ID != "" {
+		pkgs, found, err := collector.LoadCollectedPackages(*config.BanyanOutDir, ref.ID)
+		if err != nil || found {
+			return pkgs, err
+		}
+	}
+	if !d.setup {
+		setupOneShot()
+		d.setup = true
+	}
+	metadata, pulled, e := resolveImage(ref, d.pull)
+	if e != nil {
+		return
+	}
+	if pulled {
+		d.pulled = append(d.pulled, ref)
+	}
+	return collector.GetImagePackages(metadata)
+}
+
+// cleanup removes the images pulled for the diff, unless they are to be kept.
+func (d *differ) cleanup() {
+	if d.keep {
+		return
+	}
+	for _, ref := range d.pulled {
+		collector.RemovePulledImage(ref)
+	}
+}
@@ -3,7 +3,6 @@ package main
 // Collector is a program that extracts static information from container images stored in a Docker registry.
 
 import (
-	"bufio"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -24,15 +23,13 @@ const (
 	CONSOLELOGLEVEL = blog.INFO
 	// File logging level
 	FILELOGLEVEL = blog.FINEST
-	// Number of docker images to process in a single batch.
-	IMAGEBATCH = 5
 )
 
 var (
 	LOGFILENAME = config.BANYANDIR() + "/hostcollector/collector.log"
 	fileLog     = flag.Bool("filelog", false, "Log output to "+LOGFILENAME)
 	imageList   = flag.String("imagelist", config.BANYANDIR()+"/hostcollector/imagelist",
-		"List of previously collected images (file)")
+		"List of previously collected images (file), migrated into --statefile")
 	repoList = flag.StringP("repolist", "r", config.BANYANDIR()+"/hostcollector/repolist",
 		"File containing list of repos to process")
 
@@ -95,17 +92,65 @@ func DoIteration(ReposToLimit RepoSet, tokenSync *auth.TokenSyncInfo,
 
 	blog.Debug("DoIteration: processedImages is %v", processedImages)
 	PulledNew = PulledList
-	metadataSlice, currentMetadataSet := collector.GetNewImageMetadata(oldMetadataSet)
+	// the labels of images that weren't scanned in the last iteration are of no use
+	collector.ForgetImageLabels()
+	var metadataSlice []collector.ImageMetadataInfo
+	// the iteration is checkpointed in the state store, and resumed after a restart
+	queue, progress, e := collector.LoadIteration()
+	if e != nil {
+		except.Error(e, ": Error in loading the checkpoint of the last iteration; starting afresh")
+		queue = nil
+	}
+	if queue != nil {
+		metadataSlice = queue.Queue
+		currentMetadataSet = collector.NewMetadataSet()
+		for _, metadata := range queue.Current {
+			currentMetadataSet.Insert(metadata)
+		}
+		stages, _ := collector.ImageStages()
+		blog.Info("Resuming the iteration started at %s: %d metadata items, %d in progress or done",
+			queue.Started.String(), len(metadataSlice), len(stages))
+	} else {
+		metadataSlice, currentMetadataSet = collector.GetNewImageMetadata(oldMetadataSet)
 
-	if len(metadataSlice) == 0 {
-		blog.Info("No new metadata in this iteration")
-		return
+		if len(metadataSlice) == 0 {
+			blog.Info("No new metadata in this iteration")
+			return
+		}
+		// apply per-repo tag filters and retention before anything gets saved or pulled
+		metadataSlice = collector.ApplyTagPolicies(metadataSlice, currentMetadataSet, time.Now())
+		if len(metadataSlice) == 0 {
+			blog.Info("No new metadata admitted by tag policies in this iteration")
+			return
+		}
+		// leave out the images that are quarantined or backing off after failures
+		metadataSlice = collector.DeferFailedMetadata(metadataSlice, currentMetadataSet, time.Now())
+		if len(metadataSlice) == 0 {
+			blog.Info("No new metadata left after skipping failed images in this iteration")
+			return
+		}
+		blog.Info("Obtained %d new metadata items in this iteration", len(metadataSlice))
+		queue = &collector.IterationQueue{Started: time.Now(), Queue: metadataSlice}
+		for metadata := range currentMetadataSet {
+			queue.Current = append(queue.Current, metadata)
+		}
+		if e := collector.SaveIterationQueue(*queue); e != nil {
+			except.Error(e, ": Failed to checkpoint the iteration")
+		}
+	}
+	if !progress.MetadataWritten {
+		collector.SaveImageMetadata(metadataSlice)
+		progress.MetadataWritten = true
+		if e := collector.SaveIterationProgress(progress); e != nil {
+			except.Error(e, ": Failed to checkpoint the iteration")
+		}
 	}
-	blog.Info("Obtained %d new metadata items in this iteration", len(metadataSlice))
-	collector.SaveImageMetadata(metadataSlice)
 
 	// number of images processed for each repository in this iteration
 	imageCount := make(map[collector.RepoType]int)
+	for repo, count := range progress.ImageCount {
+		imageCount[repo] = count
+	}
 
 	// Set of repos to stop limiting according to maxImages after this iteration completes.
 	StopLimiting := NewRepoSet()
@@ -113,25 +158,49 @@ func DoIteration(ReposToLimit RepoSet, tokenSync *auth.TokenSyncInfo,
 	// processed metadata
 	processedMetadata := collector.NewMetadataSet()
 
+	// images are pulled with the Docker daemon, unless they are local or analyzed daemonless
+	pulling := !collector.LocalHost && !*collector.Daemonless
+
+	// pulled images retained on the Docker host, up to removeThresh
+	tracker := newPullTracker(*removeThresh, PulledList)
+	defer func() { PulledNew = tracker.pulled() }()
+	// metadata whose image couldn't be pulled or scanned in this iteration
+	failedMetadata := collector.NewMetadataSet()
+	for _, metadata := range progress.Failed {
+		currentMetadataSet.Delete(metadata)
+		failedMetadata.Insert(metadata)
+	}
+
 	for {
-		pulledImages := collector.NewImageSet()
-		pulledImagesManifestHash := collector.NewImageSet()
-		pullErrorMetadata := collector.NewMetadataSet()
+		// select a batch of up to batchSize images to process
+		batch := []collector.ImageMetadataInfo{}
+		batchIndex := []int{}
+		batchImages := collector.NewImageSet()
+		batchManifestHashes := collector.NewImageSet()
 		for index, _ := range metadataSlice {
 			metadata := &metadataSlice[index]
+			if failedMetadata.Exists(*metadata) {
+				continue
+			}
 			processedMetadata.Insert(*metadata)
-			if config.FilterRepos && !collector.ReposToProcess[collector.RepoType(metadata.Repo)] {
+			if stage := collector.GetImageStage(*metadata); stage.Stage == collector.StageWritten ||
+				stage.Stage == collector.StageRemoved {
+				// done before a restart
+				*metadata = stage.Metadata
+				processedMetadata.Replace(*metadata)
+				continue
+			}
+			if config.FilterRepos && !collector.CheckRepoToProcess(collector.RepoType(metadata.Repo)) {
 				continue
 			}
-			// TODO: need to filter out images from ExcludedRepo also when collecting from local Docker host?
 			if collector.ExcludeRepo[collector.RepoType(metadata.Repo)] {
 				continue
 			}
-			if len(metadata.Image) > 0 && pulledImages.Exists(collector.ImageIDType(metadata.Image)) {
+			if len(metadata.Image) > 0 && batchImages.Exists(collector.ImageIDType(metadata.Image)) {
 				continue
 			}
 			if len(metadata.ManifestHash) > 0 &&
-				pulledImagesManifestHash.Exists(collector.ImageIDType(metadata.ManifestHash)) {
+				batchManifestHashes.Exists(collector.ImageIDType(metadata.ManifestHash)) {
 				continue
 			}
 			// TODO: need to consider maxImages limit also when collecting from local Docker host?
@@ -156,73 +225,78 @@ func DoIteration(ReposToLimit RepoSet, tokenSync *auth.TokenSyncInfo,
 			}
 
 			imageCount[collector.RepoType(metadata.Repo)]++
-
-			ImageLenBeforePull := len(metadata.Image)
-
-			// docker pull image
-			if !collector.LocalHost {
-				err := collector.PullImage(metadata)
-				if err != nil {
-					// docker pull failed for some reason, possibly a transient failure.
-					// So we remove this metadata element from the current and processed sets,
-					// and move on to process any remaining metadata elements.
-					// In the next iteration, metadata
-					// lookup may rediscover this deleted metadata element
-					// and treat it as new, thus ensuring that the image pull will be retried.
-					// TODO: If the registry is corrupted, this can lead to an infinite
-					// loop in which the same image pull keeps getting tried and consistently fails.
-					currentMetadataSet.Delete(*metadata)
-					processedMetadata.Delete(*metadata)
-					// remember this pull error in order to demote this metadata to the end of the slice.
-					pullErrorMetadata.Insert(*metadata)
-					err = collector.RemoveDanglingImages()
-					if err != nil {
-						except.Error(err, ": RemoveDanglingImages")
-					}
-					continue
-				}
-				updateRepoTagImageID(metadata, oldMetadataSet)
-				processedMetadata.Replace(*metadata)
-				if ImageLenBeforePull == 0 && len(metadata.Image) > 0 {
-					// Docker daemon computed the image ID for us, so now we can record this entry.
-					collector.SaveImageMetadata([]collector.ImageMetadataInfo{*metadata})
-				}
+			batch = append(batch, *metadata)
+			batchIndex = append(batchIndex, index)
+			if len(metadata.Image) > 0 {
+				batchImages.Insert(collector.ImageIDType(metadata.Image))
 			}
-			PulledNew = append(PulledNew, *metadata)
-			excess := len(PulledNew) - *removeThresh
-			if !collector.LocalHost && *removeThresh > 0 && excess > 0 {
-				config.BanyanUpdate("Removing " + strconv.Itoa(excess) + " pulled images")
-				collector.RemoveImages(PulledNew[0:excess])
-				PulledNew = PulledNew[excess:]
+			if len(metadata.ManifestHash) > 0 {
+				batchManifestHashes.Insert(collector.ImageIDType(metadata.ManifestHash))
 			}
-			blog.Info("Added image %s to pulledImages", metadata.Image)
-			pulledImages.Insert(collector.ImageIDType(metadata.Image))
-			pulledImagesManifestHash.Insert(collector.ImageIDType(metadata.ManifestHash))
-			if len(pulledImages) == IMAGEBATCH {
+			if len(batch) == *batchSize {
 				break
 			}
 		}
 
-		if len(pulledImages) == 0 {
-			blog.Info("No pulled images left to process in this iteration")
-			config.BanyanUpdate("No pulled images left to process in this iteration")
+		if len(batch) == 0 {
+			blog.Info("No images left to process in this iteration")
+			config.BanyanUpdate("No images left to process in this iteration")
 			break
 		}
 
-		// reorder metadataSlice by moving images that couldn't be pulled to the end of the list
-		newMDSlice := []collector.ImageMetadataInfo{}
-		for _, metadata := range metadataSlice {
-			if !pullErrorMetadata.Exists(metadata) {
-				newMDSlice = append(newMDSlice, metadata)
+		// pull and scan the batch concurrently, then record the results here
+		pulledImages := collector.NewImageSet()
+		pulledImagesManifestHash := collector.NewImageSet()
+		outMapMap := make(map[string]map[string]interface{})
+		for i, r := range processBatch(batch, tracker, pulling) {
+			if r.pullErr != nil || (r.scanErr != nil && !r.duplicate) {
+				// docker pull or the scan failed for some reason, possibly a transient failure.
+				// So we remove this metadata element from the current and processed sets,
+				// and move on to process any remaining metadata elements.
+				// In the next iteration, metadata
+				// lookup may rediscover this deleted metadata element
+				// and treat it as new, thus ensuring that the image will be retried,
+				// after a backoff that grows with the number of failures in a row,
+				// until the image is quarantined (see --maxfailures).
+				if r.pullErr != nil {
+					collector.RecordFailure(batch[i], collector.FailurePull, r.pullErr)
+				} else {
+					collector.RecordFailure(r.metadata, collector.FailureScan, r.scanErr)
+					if e := collector.RecordScanStatus(r.imageID, r.scanErr); e != nil {
+						except.Error(e, ": Failed to persist scan status of image", string(r.imageID))
+					}
+				}
+				currentMetadataSet.Delete(batch[i])
+				processedMetadata.Delete(batch[i])
+				// remember this failure in order to skip this metadata for the rest of the iteration.
+				failedMetadata.Insert(batch[i])
+				continue
 			}
+			metadata := r.metadata
+			metadataSlice[batchIndex[i]] = metadata
+			if pulling {
+				updateRepoTagImageID(&metadata, oldMetadataSet)
+				processedMetadata.Replace(metadata)
+				if len(batch[i].Image) == 0 && len(metadata.Image) > 0 {
+					// Docker daemon computed the image ID for us, so now we can record this entry.
+					collector.SaveImageMetadata([]collector.ImageMetadataInfo{metadata})
+				}
+			}
+			blog.Info("Added image %s to pulledImages", string(r.imageID))
+			pulledImages.Insert(r.imageID)
+			pulledImagesManifestHash.Insert(collector.ImageIDType(metadata.ManifestHash))
+			if !r.duplicate {
+				if r.outMap != nil {
+					outMapMap[string(r.imageID)] = r.outMap
+				}
+				if e := collector.RecordScanStatus(r.imageID, nil); e != nil {
+					except.Error(e, ": Failed to persist scan status of image", string(r.imageID))
+				}
+			}
+			collector.ClearFailures(metadata)
 		}
-		for metadata := range pullErrorMetadata {
-			newMDSlice = append(newMDSlice, metadata)
-		}
-		metadataSlice = newMDSlice
 
-		// get and save image data for all the images in pulledimages
-		outMapMap := collector.GetImageAllData(pulledImages)
+		// save image data for all the images in pulledImages
 		collector.SaveImageAllData(outMapMap)
 		for imageID := range pulledImages {
 			processedImages.Insert(imageID)
@@ -230,11 +304,21 @@ func DoIteration(ReposToLimit RepoSet, tokenSync *auth.TokenSyncInfo,
 		for manifestHash := range pulledImagesManifestHash {
 			processedImages.Insert(manifestHash)
 		}
-		if e := persistImageList(pulledImages); e != nil {
-			except.Error(e, "Failed to persist list of collected images")
+		if e := collector.SaveProcessedImages(pulledImages, pulledImagesManifestHash); e != nil {
+			except.Error(e, "Failed to persist collected images and manifest hashes")
 		}
-		if e := persistImageManifestHashList(pulledImagesManifestHash); e != nil {
-			except.Error(e, "Failed to persist list of collected image manifest hashes")
+		for i, index := range batchIndex {
+			if !failedMetadata.Exists(batch[i]) {
+				collector.SetImageStage(metadataSlice[index], collector.StageWritten)
+			}
+		}
+		progress.ImageCount = imageCount
+		progress.Failed = nil
+		for metadata := range failedMetadata {
+			progress.Failed = append(progress.Failed, metadata)
+		}
+		if e := collector.SaveIterationProgress(progress); e != nil {
+			except.Error(e, ": Failed to checkpoint the iteration")
 		}
 		if checkConfigUpdate(false) == true {
 			// Config changed, and possibly did so before all current metadata was processed.
@@ -255,97 +339,48 @@ func DoIteration(ReposToLimit RepoSet, tokenSync *auth.TokenSyncInfo,
 	return
 }
 
-// getImageList reads the list of previously processed images from the imageList file.
-func getImageList(processedImages collector.ImageSet) (e error) {
-	f, e := os.Open(*imageList)
-	if e != nil {
-		except.Warn(e, ": Error in opening", *imageList, ": perhaps a fresh start?")
-		return
-	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	data, e := ioutil.ReadAll(r)
-	if e != nil {
-		except.Error(e, ": Error in reading file ", *imageList)
-		return
-	}
-	for _, str := range strings.Split(string(data), "\n") {
-		if len(str) != 0 {
-			blog.Debug("Previous image: %s", str)
-			processedImages.Insert(collector.ImageIDType(str))
-		}
+// checkRepoList gets the list of repositories to process from the command line
+// and from the repoList file. Entries can be repo names, glob patterns like team-a/* or */nginx,
+// or regular expressions prefixed with "re:". In the repoList file, each entry can be followed by
+// tag policy options (see collector.TagPolicy).
+func checkRepoList(initial bool) (updates bool) {
+	newList := make(map[collector.RepoType]bool)
+	newPatterns := []collector.RepoPattern{}
+	newPolicies := make(map[string]collector.TagPolicy)
+	seenPatterns := make(map[string]bool)
+	oldPatterns := make(map[string]bool)
+	for _, p := range collector.RepoPatterns {
+		oldPatterns[p.Spec] = true
 	}
-	return
-}
 
-// persistImageList saves the set of processed images to the imageList file.
-func persistImageList(collectedImages collector.ImageSet) (e error) {
-	var f *os.File
-	f, e = os.OpenFile(*imageList, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if e != nil {
-		return
-	}
-	defer f.Close()
-	for image := range collectedImages {
-		_, e = f.WriteString(string(image) + "\n")
-		if e != nil {
+	addRepo := func(spec string) {
+		if !collector.IsRepoPattern(spec) {
+			r := collector.RepoType(spec)
+			newList[r] = true
+			if _, ok := collector.ReposToProcess[r]; !ok {
+				updates = true
+			}
 			return
 		}
-	}
-	return
-}
-
-// getImageManifestHashList reads the list of previously processed images (manifest hash) from the imageList_ManifestHash file.
-func getImageManifestHashList(processedImagesManifestHash collector.ImageSet) (e error) {
-	filename := *imageList + "_ManifestHash"
-	f, e := os.Open(filename)
-	if e != nil {
-		except.Warn(e, ": Error in opening", filename, ": perhaps a fresh start?")
-		return
-	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	data, e := ioutil.ReadAll(r)
-	if e != nil {
-		except.Error(e, ": Error in reading file ", filename)
-		return
-	}
-	for _, str := range strings.Split(string(data), "\n") {
-		if len(str) != 0 {
-			blog.Debug("Previous image: %s", str)
-			processedImagesManifestHash.Insert(collector.ImageIDType(str))
+		if seenPatterns[spec] {
+			return
 		}
-	}
-	return
-}
-
-// persistImageManifestHashList saves the set of processed image manifest hashes to the imageList_ManifestHash file.
-func persistImageManifestHashList(collectedImagesManifestHash collector.ImageSet) (e error) {
-	var f *os.File
-	filename := *imageList + "_ManifestHash"
-	f, e = os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if e != nil {
-		return
-	}
-	defer f.Close()
-	for manifestHash := range collectedImagesManifestHash {
-		_, e = f.WriteString(string(manifestHash) + "\n")
-		if e != nil {
+		p, err := collector.NewRepoPattern(spec)
+		if err != nil {
+			except.Error(err, ": ignoring repo pattern", spec)
 			return
 		}
+		seenPatterns[spec] = true
+		newPatterns = append(newPatterns, p)
+		if !oldPatterns[spec] {
+			updates = true
+		}
 	}
-	return
-}
-
-// checkRepoList gets the list of repositories to process from the command line
-// and from the repoList file.
-func checkRepoList(initial bool) (updates bool) {
-	newList := make(map[collector.RepoType]bool)
 
 	// check repositories specified on the command line
 	if len(flag.Args()) > 1 {
 		for _, repo := range flag.Args()[1:] {
-			newList[collector.RepoType(repo)] = true
+			addRepo(repo)
 			if initial {
 				updates = true
 			}
@@ -362,32 +397,44 @@ func checkRepoList(initial bool) (updates bool) {
 		for _, line := range arr {
 			// skip over comments and whitespace
 			arr := strings.Split(line, "#")
-			repo := arr[0]
-			repotrim := strings.TrimSpace(repo)
-			if repotrim != "" {
-				r := collector.RepoType(repotrim)
-				newList[r] = true
-				if _, ok := collector.ReposToProcess[r]; !ok {
-					updates = true
-				}
+			spec, policy, hasPolicy, err := collector.ParseRepoListLine(arr[0])
+			if err != nil {
+				except.Error(err, ": ignoring repolist line:", line)
+				continue
+			}
+			if spec == "" {
+				continue
+			}
+			addRepo(spec)
+			if hasPolicy {
+				newPolicies[spec] = policy
 			}
 		}
 	}
-
-	if len(newList) == 0 {
-		collector.ReposToProcess = newList
-		return
+	if len(newPatterns) != len(collector.RepoPatterns) || len(newPolicies) != len(collector.TagPolicies) {
+		updates = true
 	}
+	for spec, policy := range newPolicies {
+		if old, ok := collector.TagPolicies[spec]; !ok || old.Options != policy.Options {
+			updates = true
+		}
+	}
+
 	collector.ReposToProcess = newList
-	if searchTerm := collector.NeedRegistrySearch(); searchTerm != "" {
+	collector.RepoPatterns = newPatterns
+	collector.TagPolicies = newPolicies
+	if len(newList) == 0 && len(newPatterns) == 0 {
 		config.FilterRepos = false
-	} else {
-		config.FilterRepos = true
+		return
 	}
+	config.FilterRepos = true
 	if updates {
 		blog.Info("Limiting collection to the following repos:")
 		for repo := range newList {
-			blog.Info(repo)
+			blog.Info("%s %s", repo, newPolicies[string(repo)].Options)
+		}
+		for _, p := range newPatterns {
+			blog.Info("%s (pattern) %s", p.Spec, newPolicies[p.Spec].Options)
 		}
 	}
 	return
@@ -427,9 +474,21 @@ func InfLoop(tokenSync *auth.TokenSyncInfo, processedImages collector.ImageSet)
 	initMetadataSet(tokenSync, metadataSet)
 	pulledList := []collector.ImageMetadataInfo{}
 
+	// remove what a previous Collector process left behind at startup, and then periodically
+	var lastReap time.Time
 	for {
+		if !*collector.Daemonless && (lastReap.IsZero() ||
+			*collector.ReapInterval > 0 && time.Since(lastReap) >= *collector.ReapInterval) {
+			collector.Reap(pulledList)
+			lastReap = time.Now()
+		}
 		config.BanyanUpdate("New iteration")
 		metadataSet, pulledList = DoIteration(reposToLimit, tokenSync, processedImages, metadataSet, pulledList)
+		if e := collector.SaveMetadataSet(metadataSet); e != nil {
+			except.Error(e, ": Failed to persist metadata")
+		} else if e := collector.ClearIteration(); e != nil {
+			except.Error(e, ": Failed to clear the checkpoint of the iteration")
+		}
 
 		blog.Info("Looping in %d seconds", *poll)
 		config.BanyanUpdate("Sleeping for", strconv.FormatInt(*poll, 10), "seconds")
@@ -438,7 +497,20 @@ func InfLoop(tokenSync *auth.TokenSyncInfo, processedImages collector.ImageSet)
 	}
 }
 
+// subcommands maps the names of the subcommands of collector to the functions that run them with
+// their arguments and return the exit status.
+var subcommands = map[string]func(args []string) int{
+	"scan":       scanCommand,
+	"diff":       diffCommand,
+	"quarantine": quarantineCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 	doFlags()
 
 	setupLogging()
@@ -469,21 +541,30 @@ func main() {
 	}
 
 	// Log the docker version
-	major, minor, revision, e := collector.DockerVersion()
-	if e != nil {
-		except.Error(e, ": Could not identify Docker version")
+	if *collector.Daemonless {
+		blog.Info("Daemonless mode: unpacking images from registry layers into %s to read their packages; scripts are not run",
+			*collector.ScratchDir)
 	} else {
-		blog.Info("Docker version %d.%d.%d", major, minor, revision)
-		config.BanyanUpdate("Docker version", strconv.Itoa(major)+"."+strconv.Itoa(minor)+"."+strconv.Itoa(revision))
+		major, minor, revision, e := collector.DockerVersion()
+		if e != nil {
+			except.Error(e, ": Could not identify Docker version")
+		} else {
+			blog.Info("Docker version %d.%d.%d", major, minor, revision)
+			config.BanyanUpdate("Docker version", strconv.Itoa(major)+"."+strconv.Itoa(minor)+"."+strconv.Itoa(revision))
+		}
 	}
 
+	if e = collector.OpenState(*collector.StateFile, *imageList); e != nil {
+		except.Fail(e, ": Error in opening state store ", *collector.StateFile)
+	}
+	defer collector.State.Close()
+
 	// Images we have processed already
 	processedImages := collector.NewImageSet()
-	e = getImageList(processedImages)
-	if e != nil {
-		blog.Info("Fresh start: No previously collected images were found in %s", *imageList)
+	collector.LoadProcessedImages(processedImages)
+	if len(processedImages) == 0 {
+		blog.Info("Fresh start: No previously collected images were found in %s", *collector.StateFile)
 	}
-	_ = getImageManifestHashList(processedImages)
 	blog.Debug(processedImages)
 
 	// Main infinite loop.
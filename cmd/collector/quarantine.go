@@ -0,0 +1,110 @@
+package main
+
+// quarantine.go has the quarantine subcommand, which lists the images that failed to be pulled or
+// scanned, and releases them so that they are tried again.
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	collector "github.com/banyanops/collector"
+	except "github.com/banyanops/collector/except"
+	statestore "github.com/banyanops/collector/statestore"
+	flag "github.com/spf13/pflag"
+)
+
+// quarantineCommand runs the quarantine subcommand with its arguments, and returns the exit status.
+func quarantineCommand(args []string) int {
+	fs := flag.NewFlagSet("quarantine", flag.ContinueOnError)
+	fs.AddFlag(flag.CommandLine.Lookup("statefile"))
+	fs.AddFlag(flag.CommandLine.Lookup("imagelist"))
+	all := fs.Bool("all", false, "Release all the failed images")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "  Usage: %s quarantine [OPTIONS] [list]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "         %s quarantine [OPTIONS] release KEY [KEY...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "         %s quarantine [OPTIONS] release --all\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  list shows the images that failed to be pulled or scanned, quarantined or backing off.\n")
+		fmt.Fprintf(os.Stderr, "  release forgets the failures of images, identified by their KEY in the list,\n")
+		fmt.Fprintf(os.Stderr, "  so that they are tried again in the next iteration. Collector must not be running.\n")
+		fmt.Fprintf(os.Stderr, "\n  Options:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return except.ErrorExitStatus
+	}
+	switch {
+	case fs.NArg() == 0 || fs.Arg(0) == "list" && fs.NArg() == 1:
+		return listQuarantine()
+	case fs.Arg(0) == "release" && (*all != (fs.NArg() > 1)):
+		return releaseQuarantine(fs.Args()[1:], *all)
+	default:
+		fs.Usage()
+		return except.ErrorExitStatus
+	}
+}
+
+// listQuarantine prints the failure records of the images.
+func listQuarantine() int {
+	store, err := statestore.OpenReadOnly(*collector.StateFile)
+	if os.IsNotExist(err) {
+		fmt.Println("No failed images")
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return except.ErrorExitStatus
+	}
+	collector.State = store
+	records, err := collector.ListFailures()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return except.ErrorExitStatus
+	}
+	if len(records) == 0 {
+		fmt.Println("No failed images")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tFAILURES\tSTAGE\tLAST FAILURE\tSTATUS\tLAST ERROR")
+	for _, r := range records {
+		status := "retry after " + r.NextRetry.Format(time.RFC3339)
+		if r.Quarantined {
+			status = "quarantined"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", r.Key, r.Failures, r.Stage,
+			r.LastFailure.Format(time.RFC3339), status, r.LastError)
+	}
+	w.Flush()
+	return 0
+}
+
+// releaseQuarantine forgets the failures of the images with the given keys, or of all of them.
+func releaseQuarantine(keys []string, all bool) int {
+	if err := collector.OpenState(*collector.StateFile, *imageList); err != nil {
+		if err == statestore.ErrLocked {
+			fmt.Fprintln(os.Stderr, "Collector is running with", *collector.StateFile+"; stop it first")
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return except.ErrorExitStatus
+	}
+	defer collector.State.Close()
+	if all {
+		records, err := collector.ListFailures()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return except.ErrorExitStatus
+		}
+		for _, r := range records {
+			keys = append(keys, r.Key)
+		}
+	}
+	if err := collector.ReleaseFailures(keys); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return except.ErrorExitStatus
+	}
+	fmt.Println("Released", len(keys), "images")
+	return 0
+}
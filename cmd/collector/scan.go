@@ -0,0 +1,190 @@
+package main
+
+// scan.go has the scan subcommand, which scans a single image once, e.g., in a CI pipeline right
+// after docker build, writes the scan report, and exits.
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	collector "github.com/banyanops/collector"
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	fsutil "github.com/banyanops/collector/fsutil"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// Exit status of the scan subcommand when the image was scanned, but some scripts failed.
+	// Other errors exit with except.ErrorExitStatus.
+	scanExitScriptFailures = 1
+	// Exit status of the scan subcommand when the image violates the policy (see --policy),
+	// whether or not scripts failed.
+	scanExitPolicyFailure = 2
+)
+
+// scanFlags are the flags of collector that also apply to the scan subcommand.
+var scanFlags = []string{"dockerproto", "dockeraddr", "daemonless", "scratchdir", "platforms",
+	"registryhttps", "registryauth", "registrytlsnoverify", "userscriptstore", "scripttimeout",
+	"singlecontainer", "sandbox", "distromap", "outputschemas", "vulndb", "policy"}
+
+// stderrLogWriter writes log records to stderr, leaving stdout to the scan report.
+type stderrLogWriter struct{}
+
+func (w stderrLogWriter) LogWrite(rec *blog.LogRecord) {
+	fmt.Fprint(os.Stderr, blog.FormatLogRecord(blog.FORMAT_SHORT, rec))
+}
+
+func (w stderrLogWriter) Close() {}
+
+// scanCommand runs the scan subcommand with its arguments, and returns the exit status.
+func scanCommand(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	for _, name := range scanFlags {
+		fs.AddFlag(flag.CommandLine.Lookup(name))
+	}
+	format := fs.StringP("format", "f", collector.ReportJSON,
+		"Format of the scan report: json, yaml, or text for a summary")
+	output := fs.StringP("output", "o", "-", "File to write the scan report to (- for stdout)")
+	pull := fs.String("pull", collector.PullMissing,
+		"When to pull the image: missing (if not on the Docker host), always, or never")
+	keep := fs.Bool("keep", false, "Keep the image on the Docker host if it was pulled for the scan")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "  Usage: %s scan [OPTIONS] IMAGE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  IMAGE:\n")
+		fmt.Fprintf(os.Stderr, "\t[REGISTRY/]REPO[:TAG][@DIGEST], e.g., nginx:1.25 or myregistry.example.com:5000/team/app@sha256:..., or the ID of an image on the Docker host\n")
+		fmt.Fprintf(os.Stderr, "\n  Scans the image once with the default and user scripts, writes the scan report, and exits\n")
+		fmt.Fprintf(os.Stderr, "  with status 0 if all the scripts succeeded, %d if some failed, %d if the image violates the --policy,\n",
+			scanExitScriptFailures, scanExitPolicyFailure)
+		fmt.Fprintf(os.Stderr, "  and %d if the image couldn't be scanned.\n", except.ErrorExitStatus)
+		fmt.Fprintf(os.Stderr, "\n  Options:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return except.ErrorExitStatus
+	}
+	if fs.NArg() != 1 || config.COLLECTORDIR() == "" {
+		fs.Usage()
+		return except.ErrorExitStatus
+	}
+	switch *format {
+	case collector.ReportJSON, collector.ReportYAML, collector.ReportText:
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown --format", *format)
+		return except.ErrorExitStatus
+	}
+	switch *pull {
+	case collector.PullMissing, collector.PullAlways, collector.PullNever:
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown --pull policy", *pull)
+		return except.ErrorExitStatus
+	}
+	if *dockerProto != "unix" && *dockerProto != "tcp" {
+		fs.Usage()
+		return except.ErrorExitStatus
+	}
+	ref, err := collector.ParseImageRef(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return except.ErrorExitStatus
+	}
+
+	logToStderr()
+	defer blog.Close()
+	setupOneShot()
+	metadata, pulled, err := resolveImage(ref, *pull)
+	if err != nil {
+		except.Error(err, ": Error in resolving image", fs.Arg(0))
+		return except.ErrorExitStatus
+	}
+	if pulled && !*keep {
+		defer collector.RemovePulledImage(ref)
+	}
+	var outMap map[string]interface{}
+	if *collector.Daemonless {
+		imageID := collector.ImageIDType(metadata.Image)
+		if imageID == "" {
+			imageID = collector.ImageIDType(metadata.ManifestHash)
+		}
+		outMap, err = collector.GetImageDataFromRegistry(imageID, metadata)
+	} else {
+		outMap, err = collector.GetImageData(collector.ImageIDType(metadata.Image))
+	}
+	if err != nil {
+		return except.ErrorExitStatus
+	}
+	collector.ApplyPolicy(metadata, outMap)
+
+	report := collector.NewScanReport(fs.Arg(0), metadata, outMap)
+	if err := writeOutput(*output, func(w io.Writer) error {
+		return collector.WriteScanReport(w, report, *format)
+	}); err != nil {
+		except.Error(err, ": Error in writing scan report to", *output)
+		return except.ErrorExitStatus
+	}
+	if report.PolicyFailed() {
+		except.Warn("%s violates policy %s", fs.Arg(0), collector.CurrentPolicy.Name)
+		return scanExitPolicyFailure
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		except.Warn("%d scripts failed in the scan of %s", len(failed), fs.Arg(0))
+		return scanExitScriptFailures
+	}
+	return 0
+}
+
+// logToStderr sends the console logs to stderr, leaving stdout to the output of a subcommand.
+func logToStderr() {
+	// replaces the default console filter of log4go, which writes to stdout
+	blog.AddFilter("stdout", CONSOLELOGLEVEL, stderrLogWriter{})
+}
+
+// setupOneShot prepares the scan and diff subcommands to scan images: it loads the configuration
+// files, copies the scripts and binaries to mount, and connects to the Docker daemon unless in
+// daemonless mode.
+func setupOneShot() {
+	for _, dir := range []string{collector.DefaultScriptsDir, collector.UserScriptsDir, collector.BinDir} {
+		if err := fsutil.CreateDirIfNotExist(dir); err != nil {
+			except.Fail(err, ": Error in creating a required directory: ", dir)
+		}
+	}
+	loadConfigFiles()
+	copyBanyanData()
+	if *collector.Daemonless {
+		checkDaemonless()
+		return
+	}
+	var err error
+	if collector.DockerClient, err = collector.NewDockerClient(*dockerProto, *dockerAddr); err != nil {
+		except.Fail(err, ": Error in connecting to docker remote API socket")
+	}
+}
+
+// resolveImage returns the metadata of the image of ref, from the registry in daemonless mode,
+// or else from the Docker host after pulling the image according to the pull policy. pulled is
+// true if the image was pulled, and is to be removed after use.
+func resolveImage(ref collector.ImageRef, pull string) (metadata collector.ImageMetadataInfo, pulled bool, e error) {
+	if *collector.Daemonless {
+		metadata, e = collector.ResolveRegistryImage(ref)
+		return
+	}
+	return collector.ResolveLocalImage(ref, pull)
+}
+
+// writeOutput calls write with the file output, or with stdout if output is -.
+func writeOutput(output string, write func(w io.Writer) error) (e error) {
+	if output == "-" {
+		return write(os.Stdout)
+	}
+	f, e := os.Create(output)
+	if e != nil {
+		return
+	}
+	e = write(f)
+	if err := f.Close(); e == nil {
+		e = err
+	}
+	return
+}
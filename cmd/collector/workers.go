@@ -0,0 +1,183 @@
+package main
+
+// workers.go has the worker pool that pulls, scans and removes the images of a batch concurrently.
+
+import (
+	"strconv"
+	"sync"
+
+	collector "github.com/banyanops/collector"
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	workers = flag.Int("workers", 1,
+		"Number of images pulled and scanned concurrently (further limited by --removethresh when pulling)")
+	batchSize = flag.Int("batchsize", 5,
+		"Number of images processed between saves of the collected data")
+)
+
+// pullTracker bounds the number of pulled images on the Docker host by limit (0 for no bound).
+// An image counts against the limit from the start of its pull until it is removed.
+// Scanned images are retained, and the least recently pulled ones are removed when
+// a new pull needs room.
+type pullTracker struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	inflight int
+	retained []collector.ImageMetadataInfo
+}
+
+func newPullTracker(limit int, retained []collector.ImageMetadataInfo) *pullTracker {
+	p := &pullTracker{limit: limit, retained: retained}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// acquire waits until there is room for one more pulled image, removing retained images if needed.
+func (p *pullTracker) acquire() {
+	p.mu.Lock()
+	for p.limit > 0 && p.inflight >= p.limit {
+		p.cond.Wait()
+	}
+	var remove []collector.ImageMetadataInfo
+	if excess := p.inflight + len(p.retained) + 1 - p.limit; p.limit > 0 && excess > 0 {
+		remove = append(remove, p.retained[:excess]...)
+		p.retained = p.retained[excess:]
+	}
+	p.inflight++
+	p.mu.Unlock()
+
+	if len(remove) > 0 {
+		config.BanyanUpdate("Removing " + strconv.Itoa(len(remove)) + " pulled images")
+		collector.RemoveImages(remove)
+		for _, metadata := range remove {
+			if collector.GetImageStage(metadata).Stage == collector.StageWritten {
+				collector.SetImageStage(metadata, collector.StageRemoved)
+			}
+		}
+	}
+}
+
+// release ends the processing of an image that was being pulled. The image is retained on
+// the Docker host if metadata is not nil.
+func (p *pullTracker) release(metadata *collector.ImageMetadataInfo) {
+	p.mu.Lock()
+	p.inflight--
+	if metadata != nil {
+		p.retained = append(p.retained, *metadata)
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// pulled returns the images currently retained on the Docker host, from the least recently pulled.
+func (p *pullTracker) pulled() []collector.ImageMetadataInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]collector.ImageMetadataInfo{}, p.retained...)
+}
+
+// imageResult is the outcome of processing the metadata of one image of a batch.
+type imageResult struct {
+	// metadata as updated by the pull
+	metadata collector.ImageMetadataInfo
+	imageID  collector.ImageIDType
+	pullErr  error
+	// duplicate is set if the image was already scanned for other metadata of the batch
+	duplicate bool
+	// outMap is the image data, or nil if the scan failed with scanErr
+	outMap  map[string]interface{}
+	scanErr error
+}
+
+// imageClaims records the images scanned in a batch, so that each is scanned only once
+// even when its ID becomes known only after it is pulled.
+type imageClaims struct {
+	mu     sync.Mutex
+	images collector.ImageSet
+}
+
+// claim returns true if imageID hasn't been claimed before.
+func (c *imageClaims) claim(imageID collector.ImageIDType) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.images.Exists(imageID) {
+		return false
+	}
+	c.images.Insert(imageID)
+	return true
+}
+
+// processBatch pulls (if pulling is true) and scans the images of a batch, with up to --workers
+// images in progress at once. Pulled images are removed through tracker. The results are in
+// the order of the batch. Nothing is saved here: all writes happen in the caller's goroutine.
+func processBatch(batch []collector.ImageMetadataInfo, tracker *pullTracker, pulling bool) (results []imageResult) {
+	results = make([]imageResult, len(batch))
+	claims := &imageClaims{images: collector.NewImageSet()}
+	n := *workers
+	if n > len(batch) {
+		n = len(batch)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = processImage(batch[i], tracker, claims, pulling)
+			}
+		}()
+	}
+	for i := range batch {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return
+}
+
+// processImage pulls and scans the image of one metadata element.
+func processImage(metadata collector.ImageMetadataInfo, tracker *pullTracker, claims *imageClaims,
+	pulling bool) (r imageResult) {
+
+	r.metadata = metadata
+	if pulling {
+		tracker.acquire()
+		if r.pullErr = collector.PullImage(&r.metadata); r.pullErr != nil {
+			tracker.release(nil)
+			if err := collector.RemoveDanglingImages(); err != nil {
+				except.Error(err, ": RemoveDanglingImages")
+			}
+			return
+		}
+		defer tracker.release(&r.metadata)
+		collector.SetImageStage(r.metadata, collector.StagePulled)
+	}
+	r.imageID = collector.ImageIDType(r.metadata.Image)
+	if *collector.Daemonless && r.imageID == "" {
+		// schema 1 manifests don't give us the image ID, so go by the manifest digest
+		r.imageID = collector.ImageIDType(r.metadata.ManifestHash)
+	}
+	if !claims.claim(r.imageID) {
+		r.duplicate = true
+		return
+	}
+	blog.Info("Scanning image %s (%s:%s)", string(r.imageID), r.metadata.Repo, r.metadata.Tag)
+	if *collector.Daemonless {
+		r.outMap, r.scanErr = collector.GetImageDataFromRegistry(r.imageID, r.metadata)
+	} else {
+		r.outMap, r.scanErr = collector.GetImageData(r.imageID)
+	}
+	if r.scanErr == nil {
+		// while the image is still on the Docker host, for its labels
+		collector.ApplyPolicy(r.metadata, r.outMap)
+	}
+	collector.SetImageStage(r.metadata, collector.StageScanned)
+	return
+}
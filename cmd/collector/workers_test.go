@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	collector "github.com/banyanops/collector"
+)
+
+func TestPullTrackerLimit(t *testing.T) {
+	const limit = 2
+	tracker := newPullTracker(limit, nil)
+	var mu sync.Mutex
+	inflight, maxInflight := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.acquire()
+			mu.Lock()
+			inflight++
+			if inflight > maxInflight {
+				maxInflight = inflight
+			}
+			mu.Unlock()
+
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+			// failed pulls are not retained, so no images need to be removed
+			tracker.release(nil)
+		}()
+	}
+	wg.Wait()
+	if maxInflight > limit {
+		t.Fatal("Pulls in progress:", maxInflight, "limit:", limit)
+	}
+	if n := len(tracker.pulled()); n != 0 {
+		t.Fatal("Retained", n, "images after failed pulls")
+	}
+}
+
+// testDigest returns the sha256 digest of content, as in a registry.
+func testDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// testLayer returns a gzip-compressed tar archive of an Alpine image with package pkg.
+func testLayer(t *testing.T, pkg string) string {
+	files := []struct{ name, body string }{
+		{"etc/alpine-release", "3.4.6\n"},
+		{"lib/apk/db/installed", "P:" + pkg + "\nV:1.0-r0\nA:x86_64\n\n"},
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.body))}
+		if e := tw.WriteHeader(hdr); e != nil {
+			t.Fatal(e)
+		}
+		tw.Write([]byte(f.body))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.String()
+}
+
+// TestProcessBatchWorkers scans a batch with several workers in daemonless mode, from a test
+// registry. Run it with -race.
+func TestProcessBatchWorkers(t *testing.T) {
+	contents := make(map[string]string)
+	var batch []collector.ImageMetadataInfo
+	for i := 0; i < 4; i++ {
+		repo := fmt.Sprintf("test/app%d", i)
+		layer := testLayer(t, fmt.Sprintf("pkg%d", i))
+		config := fmt.Sprintf(`{"config":{"Labels":{"index":"%d"}}}`, i)
+		manifest := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",` +
+			`"config":{"size":10,"digest":"` + testDigest(config) + `"},"layers":[` +
+			`{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","digest":"` + testDigest(layer) + `"}]}`
+		contents["/v2/"+repo+"/manifests/"+testDigest(manifest)] = manifest
+		contents["/v2/"+repo+"/blobs/"+testDigest(layer)] = layer
+		contents["/v2/"+repo+"/blobs/"+testDigest(config)] = config
+		for _, tag := range []string{"latest", "1.0"} {
+			batch = append(batch, collector.ImageMetadataInfo{Image: testDigest(config),
+				ManifestHash:  testDigest(manifest),
+				OtherMetadata: collector.OtherMetadata{Repo: repo, Tag: tag}})
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := contents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+	scratch, e := ioutil.TempDir("", "collector-test-")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(scratch)
+	*collector.ScratchDir = scratch
+	*collector.Daemonless = true
+	*collector.AuthRegistry = false
+	collector.RegistrySpec = ts.Listener.Addr().String()
+	collector.RegistryAPIURL = ts.URL
+	*workers = 3
+	defer func() {
+		*collector.Daemonless = false
+		*workers = 1
+	}()
+
+	results := processBatch(batch, newPullTracker(0, nil), false)
+	if len(results) != len(batch) {
+		t.Fatal("Got", len(results), "results for", len(batch), "images")
+	}
+	scanned := 0
+	for i, r := range results {
+		if r.metadata != batch[i] {
+			t.Fatal("Result", i, "is for", r.metadata, "expected", batch[i])
+		}
+		if r.duplicate {
+			continue
+		}
+		scanned++
+		if r.scanErr != nil {
+			t.Fatal(r.metadata.Repo, r.scanErr)
+		}
+		pkgs, _ := r.outMap[collector.PKGEXTRACTSCRIPT].([]collector.ImageDataInfo)
+		expected := fmt.Sprintf("pkg%d", i/2)
+		if len(pkgs) != 1 || pkgs[0].Pkg != expected {
+			t.Fatal(r.metadata.Repo, "has packages", pkgs, "expected", expected)
+		}
+	}
+	// the two tags of each repo are the same image, scanned once
+	if scanned != len(batch)/2 {
+		t.Fatal("Scanned", scanned, "images, expected", len(batch)/2)
+	}
+}
@@ -63,5 +63,5 @@ type BanyanUpdateFunc func(status ...string)
 // DefineDestsFlag is called by the importing package, e.g., main, to create the dests flag.
 func DefineDestsFlag(def string) {
 	Dests = flag.StringP("dests", "d", def,
-		"One or more ',' separated destinations for output generated by scripts. e.g., file or file,custom")
+		"One or more ',' separated destinations for output generated by scripts. one of file, cyclonedx (CycloneDX SBOMs) and spdx (SPDX SBOMs), or custom ones, e.g., file,cyclonedx")
 }
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// CycloneDX is a CycloneDX 1.5 JSON document (https://cyclonedx.org/docs/1.5/json/).
+type CycloneDX struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     CycloneDXMetadata     `json:"metadata"`
+	Components   []CycloneDXComponent  `json:"components"`
+	Dependencies []CycloneDXDependency `json:"dependencies"`
+}
+
+// CycloneDXMetadata describes the BOM: when and by what tool it was made, and the image it is for.
+type CycloneDXMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []CycloneDXComponent `json:"components"`
+	} `json:"tools"`
+	Component CycloneDXComponent `json:"component"`
+}
+
+// CycloneDXComponent is a component of a BOM: the image, its operating system, a package, or a tool.
+type CycloneDXComponent struct {
+	Type        string              `json:"type"`
+	BOMRef      string              `json:"bom-ref,omitempty"`
+	Name        string              `json:"name"`
+	Version     string              `json:"version,omitempty"`
+	Description string              `json:"description,omitempty"`
+	PURL        string              `json:"purl,omitempty"`
+	Hashes      []CycloneDXHash     `json:"hashes,omitempty"`
+	Properties  []CycloneDXProperty `json:"properties,omitempty"`
+}
+
+// CycloneDXHash is a hash of a component.
+type CycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// CycloneDXProperty is a name-value property of a component.
+type CycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CycloneDXDependency lists the components that a component depends on.
+type CycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cycloneDXProperty prefixes the names of the properties added by Collector.
+const cycloneDXProperty = "banyanops:collector:"
+
+// NewCycloneDX returns the CycloneDX BOM of the image imageID, with the metadata of its repo:tags
+// and packages pkgs. The image is a container component, which depends on an operating-system
+// component for its distribution and a library component for each package.
+func NewCycloneDX(imageID string, metadata []ImageMetadataInfo, pkgs []ImageDataInfo) (bom CycloneDX) {
+	bom.BOMFormat = "CycloneDX"
+	bom.SpecVersion = "1.5"
+	bom.SerialNumber = "urn:uuid:" + uuid.New()
+	bom.Version = 1
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	bom.Metadata.Tools.Components = []CycloneDXComponent{{Type: "application", Name: sbomTool}}
+
+	name, tag := sbomImageName(imageID, metadata)
+	image := CycloneDXComponent{Type: "container", BOMRef: imageID, Name: name, Version: tag}
+	if strings.HasPrefix(imageID, "sha256:") {
+		image.Hashes = []CycloneDXHash{{Alg: "SHA-256", Content: strings.TrimPrefix(imageID, "sha256:")}}
+	}
+	image.Properties = append(image.Properties, CycloneDXProperty{cycloneDXProperty + "imageID", imageID})
+	for i, m := range metadata {
+		if m.Repo == "" {
+			continue
+		}
+		if i == 0 {
+			image.PURL = imagePURL(imageID, m)
+		}
+		image.Properties = append(image.Properties, CycloneDXProperty{cycloneDXProperty + "repoTag",
+			m.Repo + ":" + m.Tag})
+		if m.ManifestHash != "" {
+			image.Properties = append(image.Properties, CycloneDXProperty{cycloneDXProperty + "manifestDigest",
+				m.ManifestHash})
+		}
+	}
+	bom.Metadata.Component = image
+
+	bom.Components = []CycloneDXComponent{}
+	dependsOn := []string{}
+	distro := newSBOMDistro(pkgs)
+	if distro.name != "" {
+		os := CycloneDXComponent{Type: "operating-system", BOMRef: "os:" + distro.name + "@" + distro.release,
+			Name: distro.name, Version: distro.release, Description: distro.pretty}
+		bom.Components = append(bom.Components, os)
+		dependsOn = append(dependsOn, os.BOMRef)
+	}
+	seen := make(map[string]bool)
+	for _, p := range sbomPackages(pkgs) {
+		purl := distro.purl(p)
+		if seen[purl] {
+			continue
+		}
+		seen[purl] = true
+		c := CycloneDXComponent{Type: "library", BOMRef: purl, Name: p.Pkg, Version: p.Version, PURL: purl}
+		if p.Origin != "" {
+			c.Properties = []CycloneDXProperty{{cycloneDXProperty + "origin", p.Origin}}
+		}
+		bom.Components = append(bom.Components, c)
+		dependsOn = append(dependsOn, purl)
+	}
+	bom.Dependencies = []CycloneDXDependency{{Ref: imageID, DependsOn: dependsOn}}
+	return
+}
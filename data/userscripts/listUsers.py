@@ -1,17 +1,35 @@
 #/usr/bin/env python
 
-import subprocess
-from subprocess import Popen, PIPE
+# listUsers.py lists the users in /etc/passwd as records of the "users" output schema
+# (see data/outputschemas.yaml).
 
-def executeCmd(cmd, args, out=None, err=None):
-    p = Popen([cmd, args], stdout=PIPE)
-    for line in iter(p.stdout.readline, b''):
-        if line.startswith(b'#'):
-            continue
-        name = line.decode().split(':')
-        print (name[0])
+import json
+
+def listUsers(passwd):
+    records = []
+    with open(passwd) as f:
+        for line in f:
+            line = line.rstrip('\n')
+            if not line or line.startswith('#'):
+                continue
+            fields = line.split(':')
+            if len(fields) < 7:
+                continue
+            try:
+                uid, gid = int(fields[2]), int(fields[3])
+            except ValueError:
+                continue
+            records.append({
+                'name': fields[0],
+                'uid': uid,
+                'gid': gid,
+                'gecos': fields[4],
+                'home': fields[5],
+                'shell': fields[6],
+            })
+    return records
 
 def main():
-    executeCmd("cat", "/etc/passwd") 
+    print(json.dumps({'schema': 'users', 'version': 1, 'records': listUsers('/etc/passwd')}))
 
 main()
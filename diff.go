@@ -0,0 +1,297 @@
+// diff.go compares the packages of two images (see the diff command): the packages added, removed,
+// upgraded and downgraded, and the change of distribution.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	version "github.com/banyanops/collector/version"
+)
+
+// DiffImage identifies one of the two images of a package diff.
+type DiffImage struct {
+	// Reference is how the image was given, e.g., a repo:tag or an image ID
+	Reference  string `json:"reference"`
+	Image      string `json:"image,omitempty"`
+	DistroName string `json:"distroName,omitempty"`
+	DistroID   string `json:"distroID,omitempty"`
+	Packages   int    `json:"packages"`
+}
+
+// PackageChange is a package that differs between the two images of a diff. OldVersion is empty
+// for an added package, and NewVersion for a removed one.
+type PackageChange struct {
+	Pkg          string `json:"pkg"`
+	Architecture string `json:"architecture,omitempty"`
+	OldVersion   string `json:"oldVersion,omitempty"`
+	NewVersion   string `json:"newVersion,omitempty"`
+}
+
+// PackageDiff is the difference between the packages of an old and a new image.
+type PackageDiff struct {
+	Old           DiffImage       `json:"old"`
+	New           DiffImage       `json:"new"`
+	DistroChanged bool            `json:"distroChanged"`
+	Added         []PackageChange `json:"added"`
+	Removed       []PackageChange `json:"removed"`
+	Upgraded      []PackageChange `json:"upgraded"`
+	Downgraded    []PackageChange `json:"downgraded"`
+}
+
+// Empty returns true if the two images have the same distribution and packages.
+func (d PackageDiff) Empty() bool {
+	return !d.DistroChanged && len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Upgraded) == 0 &&
+		len(d.Downgraded) == 0
+}
+
+// packageKey identifies a package across images. The same package can be installed for several
+// architectures, e.g., with dpkg multiarch.
+type packageKey struct {
+	pkg, arch string
+}
+
+// packageVersions maps the packages of an image to their installed versions, in sorted order.
+// Some package managers install several versions of a package, e.g., kernels with rpm.
+func packageVersions(pkgs []ImageDataInfo) map[packageKey][]string {
+	versions := make(map[packageKey][]string)
+	for _, p := range pkgs {
+		if p.Pkg == "" {
+			// an image without packages has a single entry for its distribution
+			continue
+		}
+		key := packageKey{p.Pkg, p.Architecture}
+		versions[key] = append(versions[key], p.Version)
+	}
+	for _, v := range versions {
+		sort.Strings(v)
+	}
+	return versions
+}
+
+// diffImage returns the description of an image with packages pkgs, referenced by reference.
+func diffImage(reference string, pkgs []ImageDataInfo) (image DiffImage) {
+	image.Reference = reference
+	if len(pkgs) > 0 {
+		image.Image = pkgs[0].Image
+		image.DistroName = pkgs[0].DistroName
+		image.DistroID = pkgs[0].DistroID
+	}
+	for _, p := range pkgs {
+		if p.Pkg != "" {
+			image.Packages++
+		}
+	}
+	return
+}
+
+// DiffPackages compares the packages oldPkgs of the image oldRef with the packages newPkgs
+// of the image newRef. A package with a single version in both images is upgraded or downgraded,
+// comparing the versions as the package manager of the new image's distribution does; otherwise
+// the versions that are only in one image are removed or added.
+func DiffPackages(oldRef string, oldPkgs []ImageDataInfo, newRef string, newPkgs []ImageDataInfo) (d PackageDiff) {
+	d.Old = diffImage(oldRef, oldPkgs)
+	d.New = diffImage(newRef, newPkgs)
+	d.DistroChanged = d.Old.DistroName != d.New.DistroName || d.Old.DistroID != d.New.DistroID
+	d.Added, d.Removed, d.Upgraded, d.Downgraded = []PackageChange{}, []PackageChange{}, []PackageChange{},
+		[]PackageChange{}
+	distroID := d.New.DistroID
+	if distroID == "" {
+		distroID = d.Old.DistroID
+	}
+	scheme := version.ForDistro(distroID)
+	oldVersions := packageVersions(oldPkgs)
+	newVersions := packageVersions(newPkgs)
+	for key, olds := range oldVersions {
+		news := newVersions[key]
+		if len(olds) == 1 && len(news) == 1 {
+			change := PackageChange{Pkg: key.pkg, Architecture: key.arch, OldVersion: olds[0], NewVersion: news[0]}
+			switch c := version.Compare(scheme, olds[0], news[0]); {
+			case c < 0:
+				d.Upgraded = append(d.Upgraded, change)
+			case c > 0:
+				d.Downgraded = append(d.Downgraded, change)
+			}
+			continue
+		}
+		for _, v := range olds {
+			if !containsString(news, v) {
+				d.Removed = append(d.Removed, PackageChange{Pkg: key.pkg, Architecture: key.arch, OldVersion: v})
+			}
+		}
+	}
+	for key, news := range newVersions {
+		olds := oldVersions[key]
+		if len(olds) == 1 && len(news) == 1 {
+			continue
+		}
+		for _, v := range news {
+			if !containsString(olds, v) {
+				d.Added = append(d.Added, PackageChange{Pkg: key.pkg, Architecture: key.arch, NewVersion: v})
+			}
+		}
+	}
+	for _, changes := range [][]PackageChange{d.Added, d.Removed, d.Upgraded, d.Downgraded} {
+		sort.Sort(byPackage(changes))
+	}
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// byPackage sorts package changes by package name, architecture and versions.
+type byPackage []PackageChange
+
+func (a byPackage) Len() int      { return len(a) }
+func (a byPackage) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPackage) Less(i, j int) bool {
+	if a[i].Pkg != a[j].Pkg {
+		return a[i].Pkg < a[j].Pkg
+	}
+	if a[i].Architecture != a[j].Architecture {
+		return a[i].Architecture < a[j].Architecture
+	}
+	return a[i].OldVersion+" "+a[i].NewVersion < a[j].OldVersion+" "+a[j].NewVersion
+}
+
+// LoadPackagesFile reads the packages of an image from a file written by the file writer
+// (pkgextractscript/IMAGE-pkgdata.json), or from a json scan report (see the scan command).
+func LoadPackagesFile(filename string) (pkgs []ImageDataInfo, e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
+	}
+	if e = json.Unmarshal(data, &pkgs); e == nil {
+		return
+	}
+	var report struct {
+		Image ScanReportImage
+		Data  map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(data, &report); err != nil || report.Data[PKGEXTRACTSCRIPT] == nil {
+		return nil, errors.New(filename + " is neither package data nor a scan report")
+	}
+	if e = json.Unmarshal(report.Data[PKGEXTRACTSCRIPT], &pkgs); e != nil {
+		return nil, errors.New(filename + ": " + e.Error())
+	}
+	return
+}
+
+// LoadCollectedPackages reads the packages of the image with ID imageID (full or abbreviated, with
+// or without the sha256: prefix) collected by the file writer in dir. found is false if the image
+// wasn't collected there.
+func LoadCollectedPackages(dir, imageID string) (pkgs []ImageDataInfo, found bool, e error) {
+	id := cleanImageID(imageID)
+	filenames, e := filepath.Glob(filepath.Join(dir, trimExtension(PKGEXTRACTSCRIPT), "*-pkgdata.json"))
+	if e != nil {
+		return
+	}
+	matches := []string{}
+	for _, filename := range filenames {
+		fileID := cleanImageID(strings.TrimSuffix(filepath.Base(filename), "-pkgdata.json"))
+		if fileID != "" && (strings.HasPrefix(id, fileID) || strings.HasPrefix(fileID, id)) {
+			matches = append(matches, filename)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, false, nil
+	case 1:
+		pkgs, e = LoadPackagesFile(matches[0])
+		return pkgs, true, e
+	}
+	return nil, true, errors.New("Image ID " + imageID + " is ambiguous in " + dir + ": " +
+		strings.Join(matches, ", "))
+}
+
+// GetImagePackages reads the packages of the image of metadata, from the pulled image, or from its
+// registry layers with --daemonless.
+func GetImagePackages(metadata ImageMetadataInfo) (pkgs []ImageDataInfo, e error) {
+	if !*Daemonless {
+		return getImagePkgData(ImageIDType(metadata.Image), "")
+	}
+	imageID := ImageIDType(metadata.Image)
+	if imageID == "" {
+		imageID = ImageIDType(metadata.ManifestHash)
+	}
+	rootfs, e := ExtractImageRootfs(metadata)
+	if e != nil {
+		return
+	}
+	defer os.RemoveAll(rootfs)
+	return getImagePkgData(imageID, rootfs)
+}
+
+// WriteDiff writes d to w in format: json, or text.
+func WriteDiff(w io.Writer, d PackageDiff, format string) (e error) {
+	switch format {
+	case ReportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(d)
+	case ReportText:
+		return writeDiffText(w, d)
+	}
+	return errors.New("Unknown diff format " + format)
+}
+
+// writeDiffText writes d in a human readable form.
+func writeDiffText(w io.Writer, d PackageDiff) (e error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, image := range []struct {
+		marker string
+		DiffImage
+	}{{"---", d.Old}, {"+++", d.New}} {
+		fmt.Fprintf(tw, "%s %s", image.marker, image.Reference)
+		if image.Image != "" && image.Image != image.Reference {
+			fmt.Fprintf(tw, " (%s)", image.Image)
+		}
+		fmt.Fprintf(tw, ": %s, %d packages\n", image.DistroName, image.Packages)
+	}
+	if d.DistroChanged {
+		fmt.Fprintf(tw, "\nDistribution changed: %s -> %s\n", d.Old.DistroName, d.New.DistroName)
+	}
+	sections := []struct {
+		title   string
+		marker  string
+		changes []PackageChange
+	}{
+		{"Added", "+", d.Added},
+		{"Removed", "-", d.Removed},
+		{"Upgraded", "^", d.Upgraded},
+		{"Downgraded", "v", d.Downgraded},
+	}
+	for _, s := range sections {
+		if len(s.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s (%d):\n", s.title, len(s.changes))
+		for _, c := range s.changes {
+			versions := c.OldVersion + " -> " + c.NewVersion
+			if c.OldVersion == "" {
+				versions = c.NewVersion
+			} else if c.NewVersion == "" {
+				versions = c.OldVersion
+			}
+			fmt.Fprintf(tw, "  %s %s\t%s\t%s\n", s.marker, c.Pkg, c.Architecture, versions)
+		}
+	}
+	fmt.Fprintf(tw, "\n%d added, %d removed, %d upgraded, %d downgraded\n", len(d.Added), len(d.Removed),
+		len(d.Upgraded), len(d.Downgraded))
+	return tw.Flush()
+}
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func pkgData(image, distro string, pkgs ...string) (data []ImageDataInfo) {
+	for _, p := range pkgs {
+		// name arch version
+		f := strings.Fields(p)
+		data = append(data, ImageDataInfo{Image: image, DistroName: distro, DistroID: "DEBIAN-bookworm",
+			Pkg: f[0], Architecture: f[1], Version: f[2]})
+	}
+	return
+}
+
+func TestDiffPackages(t *testing.T) {
+	oldPkgs := pkgData("sha256:old", "Debian GNU/Linux 11 (bullseye)",
+		"libc6 amd64 2.31-13", "curl amd64 7.74.0-1.3", "libssl1.1 amd64 1.1.1n-0",
+		"zlib1g amd64 1.2.13", "bash amd64 5.2-2", "kernel x86_64 5.14.0-1", "kernel x86_64 5.14.0-2")
+	newPkgs := pkgData("sha256:new", "Debian GNU/Linux 12 (bookworm)",
+		"libc6 amd64 2.36-9", "curl amd64 7.88.1-10", "libssl3 amd64 3.0.11-1",
+		"zlib1g amd64 1.2.13", "bash amd64 5.1-2", "libc6 i386 2.36-9",
+		"kernel x86_64 5.14.0-2", "kernel x86_64 5.14.0-3")
+	d := DiffPackages("myapp:1.4", oldPkgs, "myapp:1.5", newPkgs)
+	if !d.DistroChanged || d.Old.Packages != 7 || d.New.Packages != 8 || d.New.Image != "sha256:new" {
+		t.Fatal("Unexpected images", d.Old, d.New, d.DistroChanged)
+	}
+	expected := PackageDiff{
+		Old: d.Old, New: d.New, DistroChanged: true,
+		Added: []PackageChange{{Pkg: "kernel", Architecture: "x86_64", NewVersion: "5.14.0-3"},
+			{Pkg: "libc6", Architecture: "i386", NewVersion: "2.36-9"},
+			{Pkg: "libssl3", Architecture: "amd64", NewVersion: "3.0.11-1"}},
+		Removed: []PackageChange{{Pkg: "kernel", Architecture: "x86_64", OldVersion: "5.14.0-1"},
+			{Pkg: "libssl1.1", Architecture: "amd64", OldVersion: "1.1.1n-0"}},
+		Upgraded: []PackageChange{{Pkg: "curl", Architecture: "amd64", OldVersion: "7.74.0-1.3", NewVersion: "7.88.1-10"},
+			{Pkg: "libc6", Architecture: "amd64", OldVersion: "2.31-13", NewVersion: "2.36-9"}},
+		Downgraded: []PackageChange{{Pkg: "bash", Architecture: "amd64", OldVersion: "5.2-2", NewVersion: "5.1-2"}},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatal("Unexpected diff", d)
+	}
+	if d := DiffPackages("a", oldPkgs, "b", oldPkgs); !d.Empty() {
+		t.Fatal("Expected no differences", d)
+	}
+
+	var b bytes.Buffer
+	if err := WriteDiff(&b, d, ReportText); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(b.String())
+	for _, s := range []string{"Distribution changed: Debian GNU/Linux 11 (bullseye) -> Debian GNU/Linux 12 (bookworm)",
+		"^ libc6", "2.31-13 -> 2.36-9", "3 added, 2 removed, 2 upgraded, 1 downgraded"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatal("Missing", s, "in diff")
+		}
+	}
+	b.Reset()
+	if err := WriteDiff(&b, d, ReportJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"downgraded": [`) {
+		t.Fatal("Unexpected json diff", b.String())
+	}
+}
+
+func TestLoadCollectedPackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	id := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+	pkgs := pkgData(id, "Alpine Linux v3.19", "musl x86_64 1.2.4-r2")
+	NewFileWriter("json", dir).WriteImageAllData(map[string]map[string]interface{}{
+		id: {PKGEXTRACTSCRIPT: pkgs}})
+
+	for _, ref := range []string{id, strings.Repeat("0123456789abcdef", 4), "0123456789ab"} {
+		loaded, found, err := LoadCollectedPackages(dir, ref)
+		if err != nil || !found || !reflect.DeepEqual(loaded, pkgs) {
+			t.Fatal(ref, "got", loaded, found, err)
+		}
+	}
+	if _, found, err := LoadCollectedPackages(dir, "fedcba987654"); found || err != nil {
+		t.Fatal("Found packages of an image that wasn't collected", err)
+	}
+
+	// a scan report
+	var b bytes.Buffer
+	WriteScanReport(&b, NewScanReport("alpine:3.19", ImageMetadataInfo{Image: id},
+		map[string]interface{}{PKGEXTRACTSCRIPT: pkgs}), ReportJSON)
+	filename := filepath.Join(dir, "report.json")
+	ioutil.WriteFile(filename, b.Bytes(), 0644)
+	if loaded, err := LoadPackagesFile(filename); err != nil || !reflect.DeepEqual(loaded, pkgs) {
+		t.Fatal("Got", loaded, err)
+	}
+	ioutil.WriteFile(filename, []byte(`{"image": {}}`), 0644)
+	if _, err := LoadPackagesFile(filename); err == nil {
+		t.Fatal("Expected an error for a file without packages")
+	}
+}
@@ -1,120 +1,233 @@
 // distro.go has functions for identifying Linux distribution type and version.
-// Currently handles some CentOS, Ubuntu, and Debian versions.
+// Distributions are mapped to distro IDs by the rules of a mapping file, by default data/distromap.yaml.
 package collector
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
+	config "github.com/banyanops/collector/config"
 	except "github.com/banyanops/collector/except"
+	pkgdb "github.com/banyanops/collector/pkgdb"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
 )
 
-// DistroMap is a reference that maps each pretty name to the corresponding distribution name.
-var DistroMap = map[string]string{
-	"Ubuntu 16.10":                               "UBUNTU-yakkety",
-	"Ubuntu 16.04":                               "UBUNTU-xenial",
-	"Ubuntu 15.10":                               "UBUNTU-wily",
-	"Ubuntu 15.04":                               "UBUNTU-vivid",
-	"Ubuntu 14.10":                               "UBUNTU-utopic",
-	"Ubuntu Utopic Unicorn (development branch)": "UBUNTU-utopic",
-	"Ubuntu 13.10":                               "UBUNTU-saucy",
-	"Ubuntu 13.04":                               "UBUNTU-raring",
-	"Ubuntu 12.10":                               "UBUNTU-quantal",
-	"Ubuntu 11.10":                               "UBUNTU-oneiric",
-	"Ubuntu 11.04":                               "UBUNTU-natty",
-	"Ubuntu 10.10":                               "UBUNTU-maverick",
-	"CentOS Linux 7 (Core)":                      "REDHAT-7Server",
-	"Debian GNU/Linux 7 (wheezy)":                "DEBIAN-wheezy",
-	"Debian 6.0.10":                              "DEBIAN-squeeze",
-	"Debian GNU/Linux 8 (jessie)":                "DEBIAN-jessie",
-	"Debian GNU/Linux jessie/sid":                "DEBIAN-jessie",
-	"Debian GNU/Linux stretch/sid":               "DEBIAN-stretch-sid",
-}
+var (
+	DistroMapFile = flag.String("distromap", config.COLLECTORDIR()+"/data/distromap.yaml",
+		"File with the rules that map os-release fields to distro IDs")
+	// DistroRules are the rules loaded from the distro mapping file, in order.
+	DistroRules []DistroRule
+
+	alpineReleaseRegexp = regexp.MustCompile(`^([\d]+\.[\d]+)`)
+)
 
-// distroRegexp is a map of compiled regular expressions indexed by names.
-var distroRegexp = make(map[regexpPattern]*regexp.Regexp)
+// OSRelease holds the fields of /etc/os-release that identify a Linux distribution.
+// For images without /etc/os-release, only PrettyName is set, from a legacy release file.
+type OSRelease struct {
+	ID              string
+	IDLike          []string
+	VersionID       string
+	VersionCodename string
+	PrettyName      string
+}
 
-type regexpPattern int
+// DistroRule maps the distributions matching all the regular expressions of the rule to a distro ID.
+// See data/distromap.yaml for a description of the fields.
+type DistroRule struct {
+	ID       string `yaml:"id"`
+	Version  string `yaml:"version"`
+	Codename string `yaml:"codename"`
+	Pretty   string `yaml:"pretty"`
+	DistroID string `yaml:"distroid"`
 
-const (
-	rel6z regexpPattern = iota
-	rel5z
-)
+	id, version, codename, pretty *regexp.Regexp
+}
 
-func init() {
-	type elem struct {
-		name    regexpPattern
-		pattern string
+// LoadDistroMap reads the distro mapping rules from a YAML file and makes them the current DistroRules.
+func LoadDistroMap(filename string) (e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
 	}
-	patternList := []elem{
-		elem{name: rel6z, pattern: `release 6\.([\d]+)`},
-		elem{name: rel5z, pattern: `release 5\.([\d]+)`},
+	var distroMap struct {
+		Rules []DistroRule
 	}
-
-	for _, p := range patternList {
-		r, err := regexp.Compile(p.pattern)
-		if err != nil {
-			except.Fail(err, p.name, p.pattern)
+	if e = yaml.Unmarshal(data, &distroMap); e != nil {
+		return
+	}
+	for i := range distroMap.Rules {
+		if e = distroMap.Rules[i].compile(); e != nil {
+			e = errors.New(filename + " rule " + strconv.Itoa(i+1) + ": " + e.Error())
+			return
 		}
-		distroRegexp[p.name] = r
 	}
+	DistroRules = distroMap.Rules
+	blog.Info("Loaded %d distro mapping rules from %s", len(DistroRules), filename)
+	return
 }
 
-// getDistroID takes a distribution "pretty name" as input and returns the corresponding
-// distribution ID, or "Unknown" if no match can be found.
-func getDistroID(distroName string) string {
-	if id, ok := DistroMap[distroName]; ok {
-		return id
+// compile compiles the regular expressions of a rule.
+func (r *DistroRule) compile() (e error) {
+	if r.DistroID == "" {
+		return errors.New("missing distroid")
 	}
-
-	//Exceptions to the rule: There are many such cases, so bucketing them together
-	if strings.HasPrefix(distroName, `Ubuntu 16.10`) {
-		return "UBUNTU-yakkety"
+	if r.ID == "" && r.Version == "" && r.Codename == "" && r.Pretty == "" {
+		return errors.New("rule " + r.DistroID + " matches everything")
+	}
+	compile := func(pattern string) (*regexp.Regexp, error) {
+		if pattern == "" {
+			return nil, nil
+		}
+		return regexp.Compile(pattern)
 	}
-	if strings.HasPrefix(distroName, `Ubuntu 16.04`) {
-		return "UBUNTU-xenial"
+	if r.ID != "" {
+		// the ID must match as a whole
+		if r.id, e = compile(`^(?:` + r.ID + `)$`); e != nil {
+			return
+		}
 	}
-	if strings.HasPrefix(distroName, `Ubuntu 14.04`) {
-		return "UBUNTU-trusty"
+	if r.version, e = compile(r.Version); e != nil {
+		return
 	}
-	if strings.HasPrefix(distroName, `Ubuntu precise`) {
-		return "UBUNTU-precise"
+	if r.codename, e = compile(r.Codename); e != nil {
+		return
 	}
-	if strings.HasPrefix(distroName, `Ubuntu 12.04`) {
-		return "UBUNTU-precise"
+	r.pretty, e = compile(r.Pretty)
+	return
+}
+
+// match tests the rule against osr, taking id as the os-release ID, and returns the expanded distro ID.
+func (r *DistroRule) match(osr OSRelease, id string) (distroID string, ok bool) {
+	if r.id != nil && !r.id.MatchString(id) {
+		return
 	}
-	if strings.HasPrefix(distroName, `Ubuntu 10.04`) {
-		return "UBUNTU-lucid"
+	if r.codename != nil && !r.codename.MatchString(osr.VersionCodename) {
+		return
 	}
-	if strings.HasPrefix(distroName, `CentOS release 5`) ||
-		strings.HasPrefix(distroName, `Red Hat Enterprise Linux Server release 5`) ||
-		strings.HasPrefix(distroName, `Red Hat Enterprise Linux Server 5`) {
-		m := distroRegexp[rel5z].FindStringSubmatch(distroName)
-		if len(m) > 1 {
-			return "REDHAT-5Server-5." + m[1]
+	var groups []string
+	if r.pretty != nil {
+		if groups = r.pretty.FindStringSubmatch(osr.PrettyName); groups == nil {
+			return
+		}
+	}
+	if r.version != nil {
+		if groups = r.version.FindStringSubmatch(osr.VersionID); groups == nil {
+			return
+		}
+	}
+	distroID = os.Expand(r.DistroID, func(name string) string {
+		switch name {
+		case "ID":
+			return id
+		case "VERSION_ID":
+			return osr.VersionID
+		case "VERSION_CODENAME":
+			return osr.VersionCodename
+		}
+		if n, err := strconv.Atoi(name); err == nil && n < len(groups) {
+			return groups[n]
 		}
-		return "REDHAT-5Server"
-	}
-	if strings.HasPrefix(distroName, `CentOS release 6`) ||
-		strings.HasPrefix(distroName, `Red Hat Enterprise Linux Server release 6`) ||
-		strings.HasPrefix(distroName, `Red Hat Enterprise Linux Server 6`) {
-		m := distroRegexp[rel6z].FindStringSubmatch(distroName)
-		if len(m) > 1 {
-			return "REDHAT-6Server-6." + m[1]
+		return ""
+	})
+	return distroID, true
+}
+
+// DistroID returns the distro ID of the first rule in DistroRules that matches the distribution.
+// If no rule matches the os-release ID, the rules are tried with each ID_LIKE entry instead.
+// It returns "Unknown" if no rule matches.
+func (osr OSRelease) DistroID() string {
+	for _, id := range append([]string{osr.ID}, osr.IDLike...) {
+		for i := range DistroRules {
+			if distroID, ok := DistroRules[i].match(osr, id); ok {
+				return distroID
+			}
 		}
-		return "REDHAT-6Server"
 	}
-	if strings.HasPrefix(distroName, `Red Hat Enterprise Linux Server release 7`) ||
-		strings.HasPrefix(distroName, `Red Hat Enterprise Linux Server 7`) {
-		return "REDHAT-7Server"
+	except.Warn("DISTRO %s (ID=%s VERSION_ID=%s) UNKNOWN", osr.PrettyName, osr.ID, osr.VersionID)
+	return "Unknown"
+}
+
+// getDistroID takes a distribution "pretty name" as input and returns the corresponding
+// distribution ID, or "Unknown" if no match can be found.
+func getDistroID(distroName string) string {
+	return OSRelease{PrettyName: distroName}.DistroID()
+}
+
+// readOSRelease identifies the Linux distribution of an image from /etc/os-release, or else from the
+// first of these legacy files found: /etc/lsb-release, /etc/centos-release, /etc/redhat-release,
+// /etc/alpine-release and /etc/debian_version. The PrettyName is "Unknown" if none is found.
+func readOSRelease(fs pkgdb.FS) (osr OSRelease, e error) {
+	files := []struct {
+		name  string
+		parse func(string) OSRelease
+	}{
+		{"/etc/os-release", parseOSRelease},
+		{"/etc/lsb-release", func(s string) OSRelease {
+			return OSRelease{PrettyName: releaseField(s, "DISTRIB_DESCRIPTION")}
+		}},
+		{"/etc/centos-release", func(s string) OSRelease { return OSRelease{PrettyName: strings.TrimSpace(s)} }},
+		{"/etc/redhat-release", func(s string) OSRelease { return OSRelease{PrettyName: strings.TrimSpace(s)} }},
+		{"/etc/alpine-release", func(s string) OSRelease { return OSRelease{PrettyName: alpinePrettyName(s)} }},
+		{"/etc/debian_version", func(s string) OSRelease {
+			return OSRelease{PrettyName: `DEBIAN "` + strings.TrimSpace(s) + `"`}
+		}},
 	}
-	if strings.HasPrefix(distroName, `Ubuntu Vivid`) {
-		return "UBUNTU-vivid"
+	for _, f := range files {
+		data, err := fs.ReadFile(f.name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return osr, err
+		}
+		return f.parse(string(data)), nil
 	}
-	if strings.HasPrefix(distroName, `Ubuntu Wily`) {
-		return "UBUNTU-wily"
+	osr.PrettyName = "Unknown"
+	return
+}
+
+// parseOSRelease parses the contents of an os-release file.
+func parseOSRelease(data string) (osr OSRelease) {
+	osr.ID = releaseField(data, "ID")
+	osr.IDLike = strings.Fields(releaseField(data, "ID_LIKE"))
+	osr.VersionID = releaseField(data, "VERSION_ID")
+	osr.VersionCodename = releaseField(data, "VERSION_CODENAME")
+	if osr.VersionCodename == "" {
+		osr.VersionCodename = releaseField(data, "UBUNTU_CODENAME")
 	}
+	osr.PrettyName = releaseField(data, "PRETTY_NAME")
+	return
+}
 
-	except.Warn("DISTRO %s UNKNOWN", distroName)
-	return "Unknown"
+// alpinePrettyName turns the contents of /etc/alpine-release, e.g., 3.4.6, into the PRETTY_NAME
+// used in the os-release file of later Alpine versions, e.g., Alpine Linux v3.4.
+func alpinePrettyName(release string) string {
+	release = strings.TrimSpace(release)
+	if m := alpineReleaseRegexp.FindStringSubmatch(release); len(m) > 1 && !strings.Contains(release, "_") {
+		return "Alpine Linux v" + m[1]
+	}
+	// development snapshots, e.g., 3.19_alpha20230901
+	return "Alpine Linux edge"
+}
+
+// releaseField returns the unquoted value of a KEY=value line in a release file like /etc/os-release.
+func releaseField(data, key string) string {
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, key+"=") {
+			continue
+		}
+		value := strings.TrimPrefix(line, key+"=")
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		return value
+	}
+	return ""
 }
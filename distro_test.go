@@ -7,6 +7,9 @@ import (
 
 func TestParseDistro(t *testing.T) {
 	fmt.Println("TestParseDistro")
+	if e := LoadDistroMap("data/distromap.yaml"); e != nil {
+		t.Fatal(e)
+	}
 	var tests = []struct {
 		pretty   string
 		codename string
@@ -17,6 +20,11 @@ func TestParseDistro(t *testing.T) {
 		{"CentOS release 6.7 (Final)", "REDHAT-6Server-6.7"},
 		{"CentOS release 6.6 (Final)", "REDHAT-6Server-6.6"},
 		{"CentOS Linux 7 (Core)", "REDHAT-7Server"},
+		{"Alpine Linux v3.18", "ALPINE-3.18"},
+		{"Alpine Linux v3.4", "ALPINE-3.4"},
+		{"Alpine Linux edge", "ALPINE-edge"},
+		{alpinePrettyName("3.7.3\n"), "ALPINE-3.7"},
+		{alpinePrettyName("3.19_alpha20230901\n"), "ALPINE-edge"},
 	}
 	for _, trial := range tests {
 		distro := getDistroID(trial.pretty)
@@ -27,3 +35,43 @@ func TestParseDistro(t *testing.T) {
 	}
 	return
 }
+
+func TestOSReleaseDistroID(t *testing.T) {
+	if e := LoadDistroMap("data/distromap.yaml"); e != nil {
+		t.Fatal(e)
+	}
+	var tests = []struct {
+		osRelease string
+		distroID  string
+	}{
+		{"NAME=\"Ubuntu\"\nVERSION_ID=\"22.04\"\nID=ubuntu\nID_LIKE=debian\nVERSION_CODENAME=jammy\n", "UBUNTU-jammy"},
+		{"ID=ubuntu\nVERSION_ID=\"18.04\"\nUBUNTU_CODENAME=bionic\n", "UBUNTU-bionic"},
+		{"ID=ubuntu\nVERSION_ID=\"14.04\"\nPRETTY_NAME=\"Ubuntu 14.04.6 LTS\"\n", "UBUNTU-trusty"},
+		{"ID=debian\nVERSION_ID=\"12\"\nVERSION_CODENAME=bookworm\n", "DEBIAN-bookworm"},
+		{"ID=debian\nVERSION_ID=\"9\"\n", "DEBIAN-stretch"},
+		{"ID=debian\nVERSION_CODENAME=trixie\nPRETTY_NAME=\"Debian GNU/Linux trixie/sid\"\n", "DEBIAN-trixie-sid"},
+		{"ID=\"rhel\"\nVERSION_ID=\"8.9\"\n", "REDHAT-8"},
+		{"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"9.3\"\n", "REDHAT-9"},
+		{"ID=\"almalinux\"\nVERSION_ID=\"8.8\"\n", "REDHAT-8"},
+		{"ID=\"centos\"\nVERSION_ID=\"7\"\n", "REDHAT-7Server"},
+		{"ID=\"amzn\"\nVERSION_ID=\"2023\"\nID_LIKE=\"fedora\"\n", "AMZN-2023"},
+		{"ID=fedora\nVERSION_ID=39\n", "FEDORA-39"},
+		{"ID=\"opensuse-leap\"\nVERSION_ID=\"15.5\"\nID_LIKE=\"suse opensuse\"\n", "OPENSUSE-LEAP-15.5"},
+		{"ID=\"opensuse-tumbleweed\"\nVERSION_ID=\"20231004\"\n", "OPENSUSE-TUMBLEWEED"},
+		{"ID=photon\nVERSION_ID=4.0\n", "PHOTON-4.0"},
+		{"ID=alpine\nVERSION_ID=3.18.4\n", "ALPINE-3.18"},
+		{"ID=alpine\nVERSION_ID=3.19_alpha20230901\n", "ALPINE-edge"},
+		{"ID=linuxmint\nID_LIKE=\"ubuntu debian\"\nVERSION_CODENAME=jammy\n", "UBUNTU-jammy"},
+		{"ID=unheardof\nVERSION_ID=1\n", "Unknown"},
+	}
+	for _, trial := range tests {
+		distro := parseOSRelease(trial.osRelease).DistroID()
+		if distro != trial.distroID {
+			t.Fatal("input:", trial.osRelease, "output", distro, "expected:", trial.distroID)
+		}
+	}
+
+	if e := LoadDistroMap("/nonexistent/distromap.yaml"); e == nil {
+		t.Fatal("LoadDistroMap should fail for a missing file")
+	}
+}
@@ -48,6 +48,18 @@ type HostConfig struct {
 	Links       []string
 	Privileged  bool
 	VolumesFrom []string
+	// Sandbox settings of the scan containers (see Sandbox)
+	NetworkMode    string            `json:",omitempty"`
+	ReadonlyRootfs bool              `json:",omitempty"`
+	CapAdd         []string          `json:",omitempty"`
+	CapDrop        []string          `json:",omitempty"`
+	SecurityOpt    []string          `json:",omitempty"`
+	Memory         int64             `json:",omitempty"`
+	MemorySwap     int64             `json:",omitempty"`
+	CpuPeriod      int64             `json:",omitempty"`
+	CpuQuota       int64             `json:",omitempty"`
+	PidsLimit      int64             `json:",omitempty"`
+	Tmpfs          map[string]string `json:",omitempty"`
 }
 
 type ContainerConfig struct {
@@ -69,6 +81,16 @@ type Container struct {
 	HostConfig HostConfig
 }
 
+// ContainerSummary describes a container in the list of containers of the Docker host.
+type ContainerSummary struct {
+	Id     string
+	Names  []string
+	Labels map[string]string
+	// Created is the creation time in seconds since the epoch
+	Created int64
+	State   string
+}
+
 type ContainerInspection struct {
 	Config     ContainerConfig
 	HostConfig HostConfig
@@ -269,16 +291,20 @@ func DockerVersion() (major, minor, revision int, err error) {
 }
 
 // createCmd returns a json byte slice desribing the container we want to create
-func createCmd(imageID ImageIDType, scriptName, staticBinary, dirPath string) (jsonString []byte, err error) {
+// to run the bash command line cmd[0], with arguments cmd[1:], and the environment variables env,
+// confined by the sandbox.
+func createCmd(imageID ImageIDType, cmd []string, env []string, sandbox Sandbox) (jsonString []byte, err error) {
 	var container Container
-	container.User = "0"
+	sandbox.apply(&container)
 	container.AttachStdout = true
 	container.AttachStderr = true
 	container.HostConfig.Binds = []string{config.BANYANHOSTDIR() + "/hosttarget" + ":" + TARGETCONTAINERDIR + ":ro"}
 	container.Image = string(imageID)
+	container.Env = env
+	container.Labels = scanLabels()
 
 	container.Entrypoint = []string{TARGETCONTAINERDIR + "/bin/bash-static", "-c"}
-	container.Cmd = []string{"PATH=" + TARGETCONTAINERDIR + "/bin" + ":$PATH " + staticBinary + " " + dirPath + "/" + scriptName}
+	container.Cmd = cmd
 	blog.Info("Executing command: docker %v", container.Cmd)
 	return json.Marshal(container)
 }
@@ -303,6 +329,7 @@ func CreateContainer(containerSpec []byte) (containerID string, err error) {
 	}
 	blog.Info("Got ID %s Warnings %s\n", msg.Id, msg.Warnings)
 	containerID = msg.Id
+	registerContainer(containerID, true)
 	return
 }
 
@@ -340,9 +367,15 @@ func WaitContainer(containerID string) (statusCode int, err error) {
 	return
 }
 
-// LogsContainer makes a docker remote API call to get logs from a container.
+// LogsContainer makes a docker remote API call to get the stdout logs from a container.
 func LogsContainer(containerID string) (output []byte, err error) {
-	apipath := "/containers/" + containerID + "/logs?stdout=1"
+	output, _, err = LogsContainerStreams(containerID)
+	return
+}
+
+// LogsContainerStreams makes a docker remote API call to get the stdout and stderr logs from a container.
+func LogsContainerStreams(containerID string) (stdout, stderr []byte, err error) {
+	apipath := "/containers/" + containerID + "/logs?stdout=1&stderr=1"
 	resp, err := DockerAPI(DockerClient, "GET", apipath, []byte{}, "")
 	if err != nil {
 		except.Error(err, ": Error in Remote Docker API call: ", apipath)
@@ -357,17 +390,33 @@ func LogsContainer(containerID string) (output []byte, err error) {
 		var size int32
 		buf := bytes.NewBuffer(header[4:8])
 		binary.Read(buf, binary.BigEndian, &size)
+		if int(size) > len(resp)-8 {
+			break
+		}
 		payload := resp[8:(8 + size)]
-		// blog.Info(string(frame))
 		resp = resp[(8 + size):]
-		if header[0] == uint8(1) {
-			// 1=stdout: return only the stdout log
-			output = append(output, payload...)
+		switch header[0] {
+		case 1:
+			stdout = append(stdout, payload...)
+		case 2:
+			stderr = append(stderr, payload...)
 		}
 	}
 	return
 }
 
+// KillContainer makes a docker remote API call to kill a running container.
+func KillContainer(containerID string) (resp []byte, err error) {
+	apipath := "/containers/" + containerID + "/kill"
+	resp, err = DockerAPI(DockerClient, "POST", apipath, []byte{}, "")
+	if err != nil {
+		except.Error(err, ": Error in Remote Docker API call: ", apipath)
+		return
+	}
+	blog.Debug("Response from docker remote API call for kill: " + string(resp))
+	return
+}
+
 // RemoveContainer makes a docker remote API call to remove a container.
 func RemoveContainer(containerID string) (resp []byte, err error) {
 	apipath := "/containers/" + containerID
@@ -376,10 +425,40 @@ func RemoveContainer(containerID string) (resp []byte, err error) {
 		except.Error(err)
 		return
 	}
+	registerContainer(containerID, false)
 	blog.Debug("Response from docker remote API call for remove: " + string(resp))
 	return
 }
 
+// ForceRemoveContainer makes a docker remote API call to remove a container, even if it is running,
+// along with its anonymous volumes.
+func ForceRemoveContainer(containerID string) (resp []byte, err error) {
+	apipath := "/containers/" + containerID + "?force=1&v=1"
+	resp, err = DockerAPI(DockerClient, "DELETE", apipath, []byte{}, "")
+	if err != nil {
+		except.Error(err)
+		return
+	}
+	registerContainer(containerID, false)
+	blog.Debug("Response from docker remote API call for forced remove: " + string(resp))
+	return
+}
+
+// ListContainers makes a docker remote API call to get the list of all containers, including
+// stopped ones.
+func ListContainers() (containers []ContainerSummary, err error) {
+	apipath := "/containers/json?all=1"
+	resp, err := DockerAPI(DockerClient, "GET", apipath, []byte{}, "")
+	if err != nil {
+		except.Error(err, "ListContainers")
+		return
+	}
+	if err = json.Unmarshal(resp, &containers); err != nil {
+		except.Error(err, "ListContainers JSON unmarshal")
+	}
+	return
+}
+
 // listImages makes a docker remote API call to get a list of images
 func listImages() (resp []byte, err error) {
 	apipath := "/images/json"
@@ -9,6 +9,7 @@ import (
 
 	except "github.com/banyanops/collector/except"
 	fsutil "github.com/banyanops/collector/fsutil"
+	vulndb "github.com/banyanops/collector/vulndb"
 	blog "github.com/ccpaging/log4go"
 )
 
@@ -57,7 +58,28 @@ func (f *FileWriter) WriteImageAllData(outMapMap map[string]map[string]interface
 				image = string(imageID)[0:minLen]
 			}
 			filenamePath := scriptDir + "/" + image
-			if _, ok := out.([]byte); ok {
+			if o, ok := out.(ScriptOutput); ok {
+				// script output is written as is, with the extension of its format
+				filenamePath += "-miscdata." + o.Extension()
+				blog.Info("Writing " + filenamePath + "...")
+				if err := ioutil.WriteFile(filenamePath, o.Data, 0644); err != nil {
+					except.Error(err, ": Error in writing to file: ", filenamePath)
+				}
+				continue
+			}
+			if _, ok := out.(ScriptRecords); ok {
+				f.format = "json"
+				filenamePath += "-records"
+			} else if _, ok := out.([]ScriptResult); ok {
+				f.format = "json"
+				filenamePath += "-results"
+			} else if _, ok := out.([]vulndb.Finding); ok {
+				f.format = "json"
+				filenamePath += "-findings"
+			} else if _, ok := out.(PolicyVerdict); ok {
+				f.format = "json"
+				filenamePath += "-verdict"
+			} else if _, ok := out.([]byte); ok {
 				f.format = "txt"
 				filenamePath += "-miscdata"
 			} else {
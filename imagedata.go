@@ -5,13 +5,36 @@ package collector
 import (
 	"errors"
 	"strings"
+	"sync"
+	"time"
 
 	config "github.com/banyanops/collector/config"
 	except "github.com/banyanops/collector/except"
 	blog "github.com/ccpaging/log4go"
 )
 
-var ()
+var (
+	// pullLocks serializes the pulls of each repo:tag: pulling another platform of a repo:tag
+	// retags it on the Docker host, so the ID of a pulled image must be read before the next pull.
+	pullLocks = struct {
+		sync.Mutex
+		locks map[string]*sync.Mutex
+	}{locks: make(map[string]*sync.Mutex)}
+)
+
+// lockPull waits until no other pull of the local repo:tag is in progress, and returns
+// the function that ends the pull.
+func lockPull(repotag string) (unlock func()) {
+	pullLocks.Lock()
+	lock, ok := pullLocks.locks[repotag]
+	if !ok {
+		lock = &sync.Mutex{}
+		pullLocks.locks[repotag] = lock
+	}
+	pullLocks.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
 
 // ImageDataInfo describes a package included in the contents of an image.
 type ImageDataInfo struct {
@@ -21,6 +44,7 @@ type ImageDataInfo struct {
 	Pkg          string
 	Version      string
 	Architecture string
+	Origin       string //source package, e.g., the apk origin, dpkg Source or rpm source package name
 }
 
 // PullImage performs a docker pull on an image specified by repo/tag.
@@ -31,6 +55,14 @@ func PullImage(metadata *ImageMetadataInfo) (err error) {
 		tagspec = RegistrySpec + "/" + tagspec
 	}
 	apipath := "/images/create?fromImage=" + tagspec
+	if metadata.Platform() != "" {
+		// select the right image from a multi-architecture manifest list
+		apipath += "&platform=" + metadata.Platform()
+	}
+	// a repo:tag that is already on the Docker host belongs to the user, and is never reaped
+	localRepo := localRepoName(RegistrySpec, metadata.Repo)
+	defer lockPull(localRepo + ":" + metadata.Tag)()
+	preexisting := localImageExists(localRepo + ":" + metadata.Tag)
 	blog.Info("PullImage downloading %s, Image ID: %s", apipath, metadata.Image)
 	config.BanyanUpdate("Pull", apipath, metadata.Image)
 	resp, err := DockerAPI(DockerClient, "POST", apipath, []byte{}, XRegistryAuth)
@@ -62,18 +94,33 @@ func PullImage(metadata *ImageMetadataInfo) (err error) {
 		return err
 	}
 	metadata.Image = calculatedID
+	if !preexisting {
+		trackPulledImage(PulledImage{Image: calculatedID, Repo: metadata.Repo, LocalRepo: localRepo,
+			Tag: metadata.Tag, Platform: metadata.Platform(), Pulled: time.Now()})
+	}
 	return
 }
 
-func dockerImageID(regspec string, metadata *ImageMetadataInfo) (ID string, err error) {
-	matchRepo := string(metadata.Repo)
+// localImageExists returns true if the Docker host has an image named repotag.
+func localImageExists(repotag string) bool {
+	_, err := DockerAPI(DockerClient, "GET", "/images/"+repotag+"/json", []byte{}, "")
+	return err == nil
+}
+
+// localRepoName returns the name on the Docker host of a repository pulled from registry regspec.
+func localRepoName(regspec, repo string) string {
 	if regspec != config.DockerHub {
-		matchRepo = regspec + "/" + matchRepo
+		repo = regspec + "/" + repo
 	}
-	matchTag := string(metadata.Tag)
-	if strings.HasPrefix(matchRepo, "library/") {
-		matchRepo = strings.Replace(matchRepo, "library/", "", 1)
+	if strings.HasPrefix(repo, "library/") {
+		repo = strings.Replace(repo, "library/", "", 1)
 	}
+	return repo
+}
+
+func dockerImageID(regspec string, metadata *ImageMetadataInfo) (ID string, err error) {
+	matchRepo := localRepoName(regspec, metadata.Repo)
+	matchTag := string(metadata.Tag)
 	// verify the image ID of the pulled image matches the expected metadata.
 	imageMap, err := GetLocalImages(false, false)
 	if err != nil {
@@ -96,6 +143,8 @@ func dockerImageID(regspec string, metadata *ImageMetadataInfo) (ID string, err
 // RemoveImages removes least recently pulled docker images from the local docker host.
 func RemoveImages(PulledImages []ImageMetadataInfo) {
 	numRemoved := 0
+	// images no longer on the Docker host, which the reaper can forget
+	removed := NewImageSet()
 	imageMap, err := GetLocalImages(false, false)
 	if err != nil {
 		except.Error(err, ": RemoveImages unable to list local images")
@@ -123,6 +172,7 @@ func RemoveImages(PulledImages []ImageMetadataInfo) {
 			except.Error("imageMap is %v", imageMap)
 			continue
 		}
+		removed.Insert(imageID)
 		for _, repotag := range repoTagSlice {
 			// basespec := RegistrySpec + "/" + string(t.Repo) + ":"
 			if ExcludeRepo[RepoType(repotag.Repo)] {
@@ -135,12 +185,16 @@ func RemoveImages(PulledImages []ImageMetadataInfo) {
 			if err != nil {
 				except.Error(err, "RemoveImages Repo:Tag", repotag.Repo, repotag.Tag,
 					"image", metadata.Image)
+				delete(removed, imageID)
 			}
 			numRemoved++
 		}
 	}
 
 	blog.Info("Number of repo/tags removed this time around: %d", numRemoved)
+	if len(removed) > 0 {
+		untrackPulledImages(removed)
+	}
 
 	RemoveDanglingImages()
 	return
@@ -169,20 +223,26 @@ func RemoveDanglingImages() (e error) {
 	return
 }
 
-// GetImageAllData extracts content info from each pulled image. Currently it gets system package info.
-func GetImageAllData(pulledImages ImageSet) (outMapMap map[string]map[string]interface{}) {
-	//Map ImageID -> Script Map; Script Map: Script name -> output
-	outMapMap = make(map[string]map[string]interface{})
-	for imageID := range pulledImages {
-		config.BanyanUpdate("Scripts", string(imageID))
-		outMap, err := runAllScripts(imageID)
-		if err != nil {
-			except.Error(err, ": Error processing image", string(imageID))
-			continue
-		}
-		outMapMap[string(imageID)] = outMap
+// GetImageData extracts content info from a pulled image. Currently it gets system package info
+// and the output of the default and user scripts.
+func GetImageData(imageID ImageIDType) (outMap map[string]interface{}, err error) {
+	config.BanyanUpdate("Scripts", string(imageID))
+	outMap, err = runAllScripts(imageID)
+	if err != nil {
+		except.Error(err, ": Error processing image", string(imageID))
 	}
+	return
+}
 
+// GetImageDataFromRegistry is like GetImageData, but instead of relying on a pulled image,
+// it unpacks the image from its layers in the registry (see --daemonless). Only the package data
+// is collected: no script is run.
+func GetImageDataFromRegistry(imageID ImageIDType, metadata ImageMetadataInfo) (outMap map[string]interface{}, err error) {
+	config.BanyanUpdate("Scripts", string(imageID))
+	outMap, err = getImageDataFromRootfs(imageID, metadata)
+	if err != nil {
+		except.Error(err, ": Error processing image", string(imageID))
+	}
 	return
 }
 
@@ -3,6 +3,8 @@ package collector
 import (
 	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -163,3 +165,26 @@ func TestGetTagsMetadataHub(t *testing.T) {
 	fmt.Printf("metadataSlice len=%d, contents %v\n", len(metadataSlice), metadataSlice)
 	return
 }
+
+func TestLocalImageExists(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/reg.io:5000/team/app:v1/json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"Id": "sha256:aaa"}`)
+	}))
+	defer ts.Close()
+	savedClient, savedProto, savedAddr, savedTLS := DockerClient, DockerProto, DockerAddr, DockerTLSVerify
+	defer func() {
+		DockerClient, DockerProto, DockerAddr, DockerTLSVerify = savedClient, savedProto, savedAddr, savedTLS
+	}()
+	DockerClient, DockerProto, DockerAddr, DockerTLSVerify = &http.Client{}, "tcp", ts.Listener.Addr().String(), false
+
+	if !localImageExists("reg.io:5000/team/app:v1") {
+		t.Fatal("reg.io:5000/team/app:v1 should exist")
+	}
+	if localImageExists("reg.io:5000/team/app:v2") {
+		t.Fatal("reg.io:5000/team/app:v2 should not exist")
+	}
+}
@@ -0,0 +1,232 @@
+// manifest.go has definitions for the image manifest formats served by Docker V2 registries
+// (schema 1, schema 2, and OCI image manifests), and functions to identify and digest them.
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	MediaTypeManifestV2Schema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeManifestV2Schema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeManifestV2Schema2       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIManifest             = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeManifestList            = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIIndex                = "application/vnd.oci.image.index.v1+json"
+)
+
+var (
+	Platforms = flag.String("platforms", "",
+		"One or more ',' separated platforms (os/arch[/variant]) to collect from multi-architecture images, e.g., linux/amd64,linux/arm64 (default: all)")
+)
+
+// manifestAcceptTypes lists the manifest media types that collector understands, in order of preference.
+var manifestAcceptTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeManifestV2Schema2,
+	MediaTypeManifestV2Schema1Signed,
+	MediaTypeManifestV2Schema1,
+}
+
+type V1Compat struct {
+	V1Compatibility string
+}
+type V2Schema1FSLayer struct {
+	BlobSum string
+}
+type ManifestV2Schema1 struct {
+	SchemaVersion int
+	Name          string
+	Tag           string
+	Architecture  string
+	FsLayers      []V2Schema1FSLayer
+	History       []V1Compat
+	// Ignoring the signatures for now, sorry...
+	// Signatures    []string
+}
+
+// V2Descriptor references a blob (image config or layer) stored in a V2 registry.
+type V2Descriptor struct {
+	MediaType string
+	Size      int64
+	Digest    string
+}
+
+// ManifestV2Schema2 is a Docker schema 2 image manifest. OCI image manifests have the same layout.
+type ManifestV2Schema2 struct {
+	SchemaVersion int
+	MediaType     string
+	Config        V2Descriptor
+	Layers        []V2Descriptor
+}
+
+// LayersSize returns the total compressed size of the image layers.
+func (m ManifestV2Schema2) LayersSize() (size uint64) {
+	for _, layer := range m.Layers {
+		if layer.Size > 0 {
+			size += uint64(layer.Size)
+		}
+	}
+	return
+}
+
+// ManifestPlatform describes the platform an image in a manifest list runs on.
+type ManifestPlatform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// String returns the platform in os/arch[/variant] form.
+func (p ManifestPlatform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// ManifestListEntry references the manifest of one platform in a manifest list.
+type ManifestListEntry struct {
+	V2Descriptor
+	Platform ManifestPlatform
+}
+
+// ManifestList is a Docker manifest list. OCI image indexes have the same layout.
+type ManifestList struct {
+	SchemaVersion int
+	MediaType     string
+	Manifests     []ManifestListEntry
+}
+
+// ImageConfigV2 records the fields of an image configuration blob that are of interest to collector.
+type ImageConfigV2 struct {
+	Created      string
+	Author       string
+	Comment      string
+	Parent       string
+	Architecture string
+	OS           string
+	Variant      string
+	// Config is the configuration of the containers created from the image
+	Config struct {
+		Labels map[string]string
+	}
+}
+
+// ParsePlatforms parses a ',' separated list of os/arch[/variant] platforms.
+func ParsePlatforms(spec string) (platforms []ManifestPlatform, e error) {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		arr := strings.Split(item, "/")
+		if len(arr) < 2 || len(arr) > 3 || arr[0] == "" || arr[1] == "" {
+			e = errors.New("Invalid platform " + item + ", expected os/arch[/variant]")
+			return
+		}
+		p := ManifestPlatform{OS: arr[0], Architecture: arr[1]}
+		if len(arr) == 3 {
+			p.Variant = arr[2]
+		}
+		platforms = append(platforms, p)
+	}
+	return
+}
+
+// PlatformSelected returns true if images for platform p should be collected, according to
+// the --platforms flag. A platform in the flag without a variant matches all variants.
+// Entries with an unknown platform, such as build attestations, are never selected.
+func PlatformSelected(p ManifestPlatform) bool {
+	if p.OS == "unknown" || p.Architecture == "unknown" {
+		return false
+	}
+	platforms, e := ParsePlatforms(*Platforms)
+	if e != nil || len(platforms) == 0 {
+		return true
+	}
+	for _, want := range platforms {
+		if want.OS == p.OS && want.Architecture == p.Architecture &&
+			(want.Variant == "" || want.Variant == p.Variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentDigest returns the sha256 digest of data in "sha256:<hex>" form.
+func contentDigest(data []byte) string {
+	hash := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// manifestMediaType determines the media type of a manifest, preferring the Content-Type
+// returned by the registry and falling back to the contents of the manifest itself.
+func manifestMediaType(response []byte, header http.Header) string {
+	if header != nil {
+		if mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+			switch mediaType {
+			case MediaTypeManifestV2Schema1, MediaTypeManifestV2Schema1Signed,
+				MediaTypeManifestV2Schema2, MediaTypeOCIManifest,
+				MediaTypeManifestList, MediaTypeOCIIndex:
+				return mediaType
+			}
+		}
+	}
+	// Some registries serve manifests as application/json, so look inside.
+	var m struct {
+		SchemaVersion int
+		MediaType     string
+		Config        *V2Descriptor
+		Manifests     []V2Descriptor
+	}
+	if err := json.Unmarshal(response, &m); err != nil {
+		return ""
+	}
+	switch {
+	case m.SchemaVersion == 1:
+		return MediaTypeManifestV2Schema1
+	case m.MediaType != "":
+		return m.MediaType
+	case m.SchemaVersion == 2 && m.Config != nil:
+		// the mediaType field is optional in OCI image manifests and indexes
+		return MediaTypeOCIManifest
+	case m.SchemaVersion == 2 && m.Manifests != nil:
+		return MediaTypeOCIIndex
+	}
+	return ""
+}
+
+// manifestDigest returns the content digest of a manifest. The digest reported by the registry
+// in the Docker-Content-Digest header is verified against the manifest contents, except for
+// signed schema 1 manifests whose digest is computed without the signatures.
+func manifestDigest(response []byte, header http.Header, mediaType string) (digest string, e error) {
+	computed := contentDigest(response)
+	reported := ""
+	if header != nil {
+		reported = header.Get("Docker-Content-Digest")
+	}
+	if reported == "" || !strings.HasPrefix(reported, "sha256:") {
+		return computed, nil
+	}
+	switch mediaType {
+	case MediaTypeManifestV2Schema1, MediaTypeManifestV2Schema1Signed:
+		return reported, nil
+	}
+	if reported != computed {
+		e = errors.New("Manifest digest " + computed + " does not match registry digest " + reported)
+		return
+	}
+	return computed, nil
+}
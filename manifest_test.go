@@ -0,0 +1,189 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testImageConfig = `{"architecture":"amd64","os":"linux","author":"banyan",` +
+	`"created":"2016-03-01T10:20:30.123456789Z"}`
+
+const testImageConfigArm = `{"architecture":"arm64","os":"linux","variant":"v8","author":"banyan",` +
+	`"created":"2016-03-02T10:20:30Z"}`
+
+// testContent is the body and content type served by the test registry for one path.
+type testContent struct {
+	contentType, body string
+}
+
+// newTestRegistry starts an HTTP server that serves the given contents, indexed by URL path,
+// and points collector at it.
+func newTestRegistry(contents map[string]testContent) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := contents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if c.contentType != "" {
+			w.Header().Set("Content-Type", c.contentType)
+		}
+		w.Header().Set("Docker-Content-Digest", contentDigest([]byte(c.body)))
+		fmt.Fprint(w, c.body)
+	}))
+	*AuthRegistry = false
+	RegistrySpec = ts.Listener.Addr().String()
+	RegistryAPIURL = ts.URL
+	return ts
+}
+
+// testSchema2Manifest returns a schema 2 manifest referencing the given image config.
+func testSchema2Manifest(config string) string {
+	return `{"schemaVersion":2,"mediaType":"` + MediaTypeManifestV2Schema2 + `",` +
+		`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":10,"digest":"` +
+		contentDigest([]byte(config)) + `"},` +
+		`"layers":[{"size":100,"digest":"sha256:aa"},{"size":23,"digest":"sha256:bb"}]}`
+}
+
+func TestV2GetMetadataSchema2(t *testing.T) {
+	configDigest := contentDigest([]byte(testImageConfig))
+	manifest := testSchema2Manifest(testImageConfig)
+	cases := []struct {
+		name, contentType string
+	}{
+		{"schema2", MediaTypeManifestV2Schema2},
+		{"oci", MediaTypeOCIManifest},
+		{"oci-no-content-type", ""},
+	}
+	for _, c := range cases {
+		ts := newTestRegistry(map[string]testContent{
+			"/v2/test/app/manifests/1.0":         {c.contentType, manifest},
+			"/v2/test/app/blobs/" + configDigest: {"", testImageConfig},
+		})
+		metadataSlice, e := v2GetMetadata(&http.Client{}, "test/app", "1.0")
+		ts.Close()
+		if e != nil {
+			t.Fatal(c.name, e)
+		}
+		if len(metadataSlice) != 1 {
+			t.Fatal(c.name, "expected 1 metadata entry, got", len(metadataSlice))
+		}
+		metadata := metadataSlice[0]
+		if metadata.Image != configDigest {
+			t.Fatal(c.name, "image:", metadata.Image, "expected:", configDigest)
+		}
+		if metadata.ManifestHash != contentDigest([]byte(manifest)) {
+			t.Fatal(c.name, "manifest digest:", metadata.ManifestHash)
+		}
+		if metadata.Size != 123 || metadata.Author != "banyan" || metadata.Platform() != "linux/amd64" {
+			t.Fatalf("%s: unexpected metadata %+v", c.name, metadata)
+		}
+		created, _ := time.Parse(time.RFC3339Nano, "2016-03-01T10:20:30.123456789Z")
+		if !metadata.Datetime.Equal(created) {
+			t.Fatal(c.name, "created:", metadata.Datetime)
+		}
+	}
+}
+
+func TestV2GetMetadataSchema1(t *testing.T) {
+	manifest := `{"schemaVersion":1,"name":"test/app","tag":"1.0","architecture":"amd64",` +
+		`"fsLayers":[{"blobSum":"sha256:aa"}],"history":[{"v1Compatibility":` +
+		`"{\"id\":\"abc\",\"parent\":\"def\",\"created\":\"2015-10-01T00:00:00Z\",\"author\":\"me\",\"Size\":42}"}]}`
+	ts := newTestRegistry(map[string]testContent{
+		"/v2/test/app/manifests/1.0": {MediaTypeManifestV2Schema1Signed, manifest},
+	})
+	defer ts.Close()
+	metadataSlice, e := v2GetMetadata(&http.Client{}, "test/app", "1.0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	metadata := metadataSlice[0]
+	if metadata.Image != "" || metadata.Parent != "def" || metadata.Size != 42 || metadata.Author != "me" {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+}
+
+func TestV2GetMetadataManifestList(t *testing.T) {
+	amd64 := testSchema2Manifest(testImageConfig)
+	arm64 := testSchema2Manifest(testImageConfigArm)
+	list := `{"schemaVersion":2,"mediaType":"` + MediaTypeOCIIndex + `","manifests":[` +
+		`{"mediaType":"` + MediaTypeManifestV2Schema2 + `","digest":"` + contentDigest([]byte(amd64)) +
+		`","platform":{"os":"linux","architecture":"amd64"}},` +
+		`{"mediaType":"` + MediaTypeManifestV2Schema2 + `","digest":"` + contentDigest([]byte(arm64)) +
+		`","platform":{"os":"linux","architecture":"arm64","variant":"v8"}},` +
+		`{"mediaType":"` + MediaTypeOCIManifest + `","digest":"sha256:cc",` +
+		`"platform":{"os":"unknown","architecture":"unknown"}}]}`
+	ts := newTestRegistry(map[string]testContent{
+		"/v2/test/app/manifests/1.0":                                      {MediaTypeOCIIndex, list},
+		"/v2/test/app/manifests/" + contentDigest([]byte(amd64)):          {MediaTypeManifestV2Schema2, amd64},
+		"/v2/test/app/manifests/" + contentDigest([]byte(arm64)):          {MediaTypeManifestV2Schema2, arm64},
+		"/v2/test/app/blobs/" + contentDigest([]byte(testImageConfig)):    {"", testImageConfig},
+		"/v2/test/app/blobs/" + contentDigest([]byte(testImageConfigArm)): {"", testImageConfigArm},
+	})
+	defer ts.Close()
+
+	cases := []struct {
+		platforms string
+		expected  []string
+	}{
+		{"", []string{"linux/amd64", "linux/arm64/v8"}},
+		{"linux/arm64", []string{"linux/arm64/v8"}},
+		{"linux/amd64,linux/arm64/v8", []string{"linux/amd64", "linux/arm64/v8"}},
+		{"linux/arm64/v7", nil},
+	}
+	defer func() { *Platforms = "" }()
+	for _, c := range cases {
+		*Platforms = c.platforms
+		metadataSlice, e := v2GetMetadata(&http.Client{}, "test/app", "1.0")
+		if len(c.expected) == 0 {
+			if e == nil {
+				t.Fatal(c.platforms, "v2GetMetadata was supposed to return an error here")
+			}
+			continue
+		}
+		if e != nil {
+			t.Fatal(c.platforms, e)
+		}
+		if len(metadataSlice) != len(c.expected) {
+			t.Fatal(c.platforms, "got", len(metadataSlice), "entries, expected", len(c.expected))
+		}
+		for i, metadata := range metadataSlice {
+			if metadata.Platform() != c.expected[i] || metadata.Tag != "1.0" {
+				t.Fatalf("%s: unexpected metadata %+v", c.platforms, metadata)
+			}
+			if metadata.ManifestHash == contentDigest([]byte(list)) {
+				t.Fatal(c.platforms, "ManifestHash should be the per-platform manifest digest")
+			}
+		}
+	}
+}
+
+func TestParsePlatforms(t *testing.T) {
+	testCases := map[string]bool{
+		"":                           true,
+		"linux/amd64":                true,
+		"linux/amd64,linux/arm64/v8": true,
+		"linux":                      false,
+		"linux/arm/v7/extra":         false,
+		"/amd64":                     false,
+	}
+	for spec, expected := range testCases {
+		if _, e := ParsePlatforms(spec); (e == nil) != expected {
+			t.Fatalf("ParsePlatforms(%s) returned %v", spec, e)
+		}
+	}
+}
+
+func TestManifestDigestMismatch(t *testing.T) {
+	header := http.Header{}
+	header.Set("Docker-Content-Digest", "sha256:0000")
+	if _, e := manifestDigest([]byte("{}"), header, MediaTypeManifestV2Schema2); e == nil {
+		t.Fatal("manifestDigest was supposed to return an error here")
+	}
+	if d, e := manifestDigest([]byte("{}"), header, MediaTypeManifestV2Schema1Signed); e != nil || d != "sha256:0000" {
+		t.Fatal("schema 1 digest:", d, e)
+	}
+}
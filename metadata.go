@@ -4,9 +4,6 @@ package collector
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"crypto/tls"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -70,7 +67,7 @@ type ImageMetadataInfo struct {
 	Image    string    //this has to be the first field (used in order by)
 	Datetime time.Time //created at
 	OtherMetadata
-	ManifestHash string // we calculate a sha256 hex of JSON image manifest returned by a v2 registry
+	ManifestHash string // content digest ("sha256:...") of the image manifest returned by a v2 registry
 	Registry     string
 }
 
@@ -82,6 +79,18 @@ type OtherMetadata struct {
 	Checksum string
 	Comment  string
 	Parent   string
+	// Platform of the image, e.g., linux/arm64/v8
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// Platform returns the platform of the image in os/arch[/variant] form, or "" if unknown.
+func (m ImageMetadataInfo) Platform() string {
+	if m.OS == "" && m.Architecture == "" {
+		return ""
+	}
+	return ManifestPlatform{OS: m.OS, Architecture: m.Architecture, Variant: m.Variant}.String()
 }
 
 // MetadataSet is a set of Image Metadata Info structures.
@@ -157,9 +166,10 @@ func (m MetadataSet) Exists(metadata ImageMetadataInfo) bool {
 }
 
 // SameRepoTag returns metadata entries from MetadataSet with the same repo & tag as metadata.
+// Entries for other platforms of a multi-architecture image are not considered matches.
 func (m MetadataSet) SameRepoTag(metadata ImageMetadataInfo) (matches []ImageMetadataInfo) {
 	for item, _ := range m {
-		if item.Repo == metadata.Repo && item.Tag == metadata.Tag {
+		if item.Repo == metadata.Repo && item.Tag == metadata.Tag && item.Platform() == metadata.Platform() {
 			matches = append(matches, item)
 		}
 	}
@@ -264,9 +274,12 @@ type ImageStruct struct {
 	Checksum string
 	Created  string
 	// Container string
-	Author  string
-	Size    uint64
-	Comment string
+	Author       string
+	Size         uint64
+	Comment      string
+	OS           string
+	Architecture string
+	Variant      string
 }
 
 // LocalImageStruct records information returned by the local daemon to describe an image,ff
@@ -357,15 +370,25 @@ func GetImageToMDMap(imageMDs []ImageMetadataInfo) (imageToMDMap map[string][]Im
 	return
 }
 
-// CheckRepoToProcess returns true if ReposToProcess is empty
-// or if the given repo exists in ReposToProcess.
+// CheckRepoToProcess returns true if the given repo is not in ExcludeRepo, and either
+// no repos were specified or it exists in ReposToProcess or matches one of RepoPatterns.
 func CheckRepoToProcess(repo RepoType) bool {
-	if len(ReposToProcess) == 0 {
+	if ExcludeRepo[repo] {
+		return false
+	}
+	if len(ReposToProcess) == 0 && len(RepoPatterns) == 0 {
 		return true
 	}
 	// just check the presence of the key. Value doesn't matter.
-	_, ok := ReposToProcess[repo]
-	return ok
+	if _, ok := ReposToProcess[repo]; ok {
+		return true
+	}
+	for _, pattern := range RepoPatterns {
+		if pattern.Match(repo) {
+			return true
+		}
+	}
+	return false
 }
 
 // repoTag info of local images queried from local Docker daemon may include a registry name
@@ -551,23 +574,14 @@ func GetImageMetadata(oldMetadataSet MetadataSet) (metadataSlice []ImageMetadata
 	return
 }
 
-// NeedRegistrySearch checks ReposToProcess and returns the search term to use
-// if the registry search API needs to be invoked, else "".
+// NeedRegistrySearch checks ReposToProcess and RepoPatterns and returns the search term to use
+// if the V1 registry search API can narrow down the list of repos, else "".
+// That is the case when the only repo specified is a pattern ending in wildcard "*", e.g., "banyan/*".
 func NeedRegistrySearch() (searchTerm string) {
-	if len(ReposToProcess) != 1 {
+	if len(ReposToProcess) != 0 || len(RepoPatterns) != 1 {
 		return ""
 	}
-	for repo, _ := range ReposToProcess {
-		if strings.HasSuffix(string(repo), "*") {
-			searchTerm := strings.Replace(string(repo), "*", "", 1)
-			if strings.HasSuffix(searchTerm, "/") {
-				searchTerm = searchTerm[:len(searchTerm)-1]
-			}
-			config.FilterRepos = false
-			return searchTerm
-		}
-	}
-	return ""
+	return RepoPatterns[0].prefixSearchTerm()
 }
 
 // GetImageMetadataTokenAuthV1 returns repositories/tags/image metadata from the Docker Hub
@@ -576,7 +590,7 @@ func NeedRegistrySearch() (searchTerm string) {
 // The function queries the index server, e.g., Docker Hub, to get the token and registry, and then uses
 // the token to query the registry.
 func GetImageMetadataTokenAuthV1(oldMetadataSet MetadataSet) (tagSlice []TagInfo, metadataSlice []ImageMetadataInfo) {
-	if len(ReposToProcess) == 0 {
+	if len(ReposToProcess) == 0 && len(RepoPatterns) == 0 {
 		return
 	}
 	client := &http.Client{}
@@ -593,6 +607,7 @@ func GetImageMetadataTokenAuthV1(oldMetadataSet MetadataSet) (tagSlice []TagInfo
 			except.Error(e, ":registry search")
 			return
 		}
+		allRepos = SelectRepos(allRepos)
 	}
 	// If search wasn't needed, the repos were individually specified.
 	if len(allRepos) == 0 {
@@ -678,39 +693,79 @@ func registrySearchV1(client *http.Client, searchTerm string) (repoSlice []RepoT
 	return
 }
 
-// getRepos queries the Docker registry for the list of the repositories it is currently hosting.
-// However, if the user specified a list of repositories, then getRepos() just returns that list
-// of specified repositories and does not query the Docker registry.
+// getRepos queries the Docker registry for the list of the repositories it is currently hosting,
+// using the catalog API of a V2 registry or the search API of a V1 registry.
+// However, if the user specified a list of repositories without any patterns, then getRepos() just
+// returns that list of specified repositories and does not query the Docker registry.
+// If repo patterns were specified, then the repositories hosted by the registry are matched against them.
 func getRepos() (repoSlice []RepoType, err error) {
-	if len(ReposToProcess) > 0 {
+	if len(ReposToProcess) > 0 && len(RepoPatterns) == 0 {
 		for repo := range ReposToProcess {
 			repoSlice = append(repoSlice, repo)
 		}
 		return
 	}
 
+	client := registryClient()
 	if *RegistryProto == "v2" {
-		except.Error("v2 registry search/catalog interface not yet supported in collector")
+		repoSlice, err = v2Catalog(client)
+	} else {
+		// a query with an empty query string returns all the repos
+		repoSlice, err = registrySearchV1(client, NeedRegistrySearch())
+	}
+	if err != nil || len(RepoPatterns) == 0 {
 		return
 	}
+	// keep the repos that were named explicitly, even if the registry did not list them
+	listed := make(map[RepoType]bool)
+	for _, repo := range repoSlice {
+		listed[repo] = true
+	}
+	for repo := range ReposToProcess {
+		if !listed[repo] && !ExcludeRepo[repo] {
+			repoSlice = append(repoSlice, repo)
+		}
+	}
+	repoSlice = SelectRepos(repoSlice)
+	return
+}
 
-	// a query with an empty query string returns all the repos
-	var client *http.Client
-	if *RegistryTLSNoVerify {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// V2Catalog is the reply to a V2 registry catalog request.
+type V2Catalog struct {
+	Repositories []string
+}
+
+// v2Catalog queries the catalog API of a V2 registry, returning a slice of all the repos it hosts.
+func v2Catalog(client *http.Client) (repoSlice []RepoType, err error) {
+	URL := RegistryAPIURL + "/v2/_catalog?n=" + strconv.Itoa(v2PageSize)
+	err = v2QueryPages(client, URL, func(response []byte) (e error) {
+		var catalog V2Catalog
+		if e = json.Unmarshal(response, &catalog); e != nil {
+			except.Error(e, "unmarshal", string(response))
+			return
 		}
-		client = &http.Client{Transport: tr}
-	} else {
-		client = &http.Client{}
+		for _, name := range catalog.Repositories {
+			if ExcludeRepo[RepoType(name)] {
+				continue
+			}
+			repoSlice = append(repoSlice, RepoType(name))
+		}
+		return
+	})
+	if err != nil {
+		except.Error(err)
+		if s, ok := err.(*HTTPStatusCodeError); ok {
+			except.Error("HTTP bad status code %d from registry %s catalog using --registryhttps=%v --registryauth=%v --registryproto=%s", s.StatusCode, RegistryAPIURL, *HTTPSRegistry, *AuthRegistry, *RegistryProto)
+		}
+		return
 	}
-	return registrySearchV1(client, "")
+	blog.Info("Registry catalog lists %d repos", len(repoSlice))
+	return
 }
 
 // getReposTokenAuthV1 validates the user-specified list of repositories against an index server, e.g., Docker Hub.
 // It returns a list of IndexInfo structs with index info for each validated repository.
 func getReposTokenAuthV1(repo RepoType, client *http.Client) (indexInfo IndexInfo, e error) {
-	_, _, BasicAuth, XRegistryAuth = GetRegistryURL()
 	URL := RegistryAPIURL + "/v1/repositories/" + string(repo) + "/images"
 	req, e := http.NewRequest("GET", URL, nil)
 	req.Header.Set("X-Docker-Token", "true")
@@ -745,15 +800,7 @@ func getReposTokenAuthV1(repo RepoType, client *http.Client) (indexInfo IndexInf
 }
 
 func v1GetTags(repoSlice []RepoType) (tagSlice []TagInfo, e error) {
-	var client *http.Client
-	if *RegistryTLSNoVerify {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client = &http.Client{Transport: tr}
-	} else {
-		client = &http.Client{}
-	}
+	client := registryClient()
 	for _, repo := range repoSlice {
 		// get tags for one repo
 		var response []byte
@@ -784,74 +831,124 @@ type V2Tag struct {
 	Tags []string
 }
 
-type V1Compat struct {
-	V1Compatibility string
-}
-type V2Schema1FSLayer struct {
-	BlobSum string
-}
-type ManifestV2Schema1 struct {
-	SchemaVersion int
-	Name          string
-	Tag           string
-	Architecture  string
-	FsLayers      []V2Schema1FSLayer
-	History       []V1Compat
-	// Ignoring the signatures for now, sorry...
-	// Signatures    []string
+// v2GetMetadata fetches the manifest of repo:tag from a V2 registry and returns the image metadata
+// described by it. Schema 1, schema 2 and OCI image manifests are supported. If the tag refers to a
+// manifest list or OCI image index, then one metadata entry is returned for each selected platform.
+func v2GetMetadata(client *http.Client, repo, tag string) (metadataSlice []ImageMetadataInfo, e error) {
+	response, mediaType, digest, e := v2GetManifest(client, repo, tag)
+	if e != nil {
+		return
+	}
+	switch mediaType {
+	case MediaTypeManifestList, MediaTypeOCIIndex:
+		return v2ManifestListMetadata(client, repo, tag, response)
+	}
+	metadata, e := v2ManifestMetadata(client, repo, tag, response, mediaType, digest)
+	if e != nil {
+		return
+	}
+	metadataSlice = append(metadataSlice, metadata)
+	return
 }
 
-func v2GetMetadata(client *http.Client, repo, tag string) (metadata ImageMetadataInfo, e error) {
-	response, err := RegistryQueryV2(client, RegistryAPIURL+"/v2/"+repo+"/manifests/"+tag)
-	if err != nil {
-		except.Error(err)
-		if s, ok := err.(*HTTPStatusCodeError); ok {
-			except.Error("Skipping Repo: %s, tag lookup status code %d", string(repo), s.StatusCode)
-			e = err
+// v2GetManifest fetches a manifest, specified by tag or by digest, from a V2 registry and returns it
+// along with its media type and content digest.
+func v2GetManifest(client *http.Client, repo, reference string) (response []byte, mediaType, digest string, e error) {
+	response, header, e := RegistryQueryV2Header(client, RegistryAPIURL+"/v2/"+repo+"/manifests/"+reference,
+		manifestAcceptTypes)
+	if e != nil {
+		except.Error(e)
+		if s, ok := e.(*HTTPStatusCodeError); ok {
+			except.Error("Skipping Repo: %s, manifest %s lookup status code %d", repo, reference, s.StatusCode)
 		}
 		return
 	}
+	mediaType = manifestMediaType(response, header)
+	// The manifest digest identifies this exact manifest content, and lets us filter out images
+	// that have previously been processed before Docker has a chance to compute the image ID.
+	digest, e = manifestDigest(response, header, mediaType)
+	if e != nil {
+		return
+	}
+	blog.Info("Manifest %s:%s media type %s digest %s", repo, reference, mediaType, digest)
+	return
+}
 
+// v2ManifestMetadata returns the metadata for repo:tag described by a single image manifest.
+func v2ManifestMetadata(client *http.Client, repo, tag string, response []byte,
+	mediaType, digest string) (metadata ImageMetadataInfo, e error) {
 	metadata.Registry = RegistrySpec
 	metadata.Repo = repo
 	metadata.Tag = tag
 	metadata.Image = ""
+	metadata.ManifestHash = digest
+
+	switch mediaType {
+	case MediaTypeManifestV2Schema1, MediaTypeManifestV2Schema1Signed:
+		e = v2Schema1Metadata(response, &metadata)
+	case MediaTypeManifestV2Schema2, MediaTypeOCIManifest:
+		e = v2Schema2Metadata(client, repo, response, &metadata)
+	default:
+		blog.Warn("Manifest media type %s is not yet supported\n", mediaType)
+		e = errors.New("Manifest media type " + mediaType + " not yet supported by collector")
+	}
+	return
+}
+
+// v2ManifestListMetadata resolves a manifest list or OCI image index to the manifests of the
+// platforms selected by the --platforms flag, and returns the metadata for each of them.
+func v2ManifestListMetadata(client *http.Client, repo, tag string, response []byte) (
+	metadataSlice []ImageMetadataInfo, e error) {
+	var list ManifestList
+	if e = json.Unmarshal(response, &list); e != nil {
+		blog.Warn("Failed to parse manifest list")
+		return
+	}
+	for _, entry := range list.Manifests {
+		if !PlatformSelected(entry.Platform) {
+			blog.Debug("Skipping platform %s of %s:%s", entry.Platform.String(), repo, tag)
+			continue
+		}
+		response, mediaType, digest, err := v2GetManifest(client, repo, entry.Digest)
+		if err != nil {
+			except.Error(err, ":Unable to get manifest for repo", repo, "tag", tag, "platform", entry.Platform.String())
+			continue
+		}
+		if digest != entry.Digest {
+			except.Error("Manifest for %s:%s platform %s has digest %s, expected %s", repo, tag,
+				entry.Platform.String(), digest, entry.Digest)
+			continue
+		}
+		metadata, err := v2ManifestMetadata(client, repo, tag, response, mediaType, digest)
+		if err != nil {
+			except.Error(err, ":Unable to get metadata for repo", repo, "tag", tag, "platform", entry.Platform.String())
+			continue
+		}
+		// the platform recorded in the list takes precedence over what the image config says
+		metadata.OS = entry.Platform.OS
+		metadata.Architecture = entry.Platform.Architecture
+		metadata.Variant = entry.Platform.Variant
+		metadataSlice = append(metadataSlice, metadata)
+	}
+	if len(metadataSlice) == 0 {
+		e = errors.New("repo " + repo + ":" + tag + " has no manifests for the selected platforms")
+	}
+	return
+}
 
+// v2Schema1Metadata fills in metadata from a schema 1 manifest, using the V1 compatibility
+// information of the topmost layer.
+func v2Schema1Metadata(response []byte, metadata *ImageMetadataInfo) (e error) {
 	var m ManifestV2Schema1
 	b := bytes.NewBuffer(response)
 	if e = json.NewDecoder(b).Decode(&m); e != nil {
 		blog.Warn("Failed to parse manifest")
 		return
 	}
-	if m.SchemaVersion != 1 {
-		blog.Warn("Manifest schema version %d is not yet supported\n", m.SchemaVersion)
-		e = errors.New("Manifest schema version " + strconv.Itoa(m.SchemaVersion) + " not yet supported by collector")
-		return
-	}
 	if len(m.History) == 0 {
-		e = errors.New("repo " + repo + ":" + tag + " no images found in history")
+		e = errors.New("repo " + metadata.Repo + ":" + metadata.Tag + " no images found in history")
 		return
 	}
-	// Recent versions of Docker daemon (1.8.3+?) have complex code that calculates
-	// the image ID from the V2 manifest.
-	// This seems to be in flux as Docker moves toward content-addressable images in 1.10+,
-	// and as the registry image manifest schema itself is still evolving.
-	// As a temporary measure until Docker converges to a more stable state, collector
-	// will calculate its own hash over the (re-serialized) V2 manifest and use the calculated
-	// value to try to filter out images that have previously been processed.
-	// The Docker-calculated image ID will get added to the metadata struct
-	// after the image is pulled.
-	// blog.Info("Response to /v2/"+repo+"/manifests/"+tag+": %s", string(response))
-
-	serializedManifest, e := json.Marshal(m)
-	hash := sha256.Sum256(serializedManifest)
-	metadata.ManifestHash = hex.EncodeToString(hash[:])
-	blog.Info("Computed manifest hash %s", metadata.ManifestHash)
-	// blog.Info("Writing manifest to " + fname)
-	// err = ioutil.WriteFile(fname, response, 0644)
-	// if err != nil {
-	// 	blog.Error(err)
-	// }
 
 	var image ImageStruct
 	if e = json.Unmarshal([]byte(m.History[0].V1Compatibility), &image); e != nil {
@@ -869,7 +966,61 @@ func v2GetMetadata(client *http.Client, repo, tag string) (metadata ImageMetadat
 	metadata.Checksum = image.Checksum
 	metadata.Comment = image.Comment
 	metadata.Parent = image.Parent
+	metadata.OS = image.OS
+	metadata.Architecture = m.Architecture
+	return
+}
+
+// v2Schema2Metadata fills in metadata from a schema 2 or OCI image manifest. The image configuration
+// blob referenced by the manifest is fetched to obtain the creation time and author.
+func v2Schema2Metadata(client *http.Client, repo string, response []byte, metadata *ImageMetadataInfo) (e error) {
+	var m ManifestV2Schema2
+	if e = json.Unmarshal(response, &m); e != nil {
+		blog.Warn("Failed to parse manifest")
+		return
+	}
+	if m.Config.Digest == "" {
+		e = errors.New("repo " + repo + ":" + metadata.Tag + " manifest has no image config")
+		return
+	}
+	imageConfig, e := v2GetImageConfig(client, repo, m.Config.Digest)
+	if e != nil {
+		return
+	}
+	var creationTime time.Time
+	if creationTime, e = time.Parse(time.RFC3339Nano, imageConfig.Created); e != nil {
+		blog.Warn("Failed to parse creation time")
+		return
+	}
+	// The digest of the image configuration is the image ID that Docker computes on pull.
+	metadata.Image = m.Config.Digest
+	metadata.Datetime = creationTime
+	metadata.Size = m.LayersSize()
+	metadata.Author = imageConfig.Author
+	metadata.Comment = imageConfig.Comment
+	metadata.Parent = imageConfig.Parent
+	metadata.OS = imageConfig.OS
+	metadata.Architecture = imageConfig.Architecture
+	metadata.Variant = imageConfig.Variant
+	keepImageLabels(metadata.Image, imageConfig.Config.Labels)
+	return
+}
 
+// v2GetImageConfig downloads and parses the image configuration blob with the given digest.
+func v2GetImageConfig(client *http.Client, repo, digest string) (imageConfig ImageConfigV2, e error) {
+	response, e := RegistryQueryV2(client, RegistryAPIURL+"/v2/"+repo+"/blobs/"+digest)
+	if e != nil {
+		except.Error(e, ":Unable to get image config", digest, "for repo", repo)
+		return
+	}
+	if computed := contentDigest(response); computed != digest {
+		e = errors.New("Image config for repo " + repo + " has digest " + computed + ", expected " + digest)
+		return
+	}
+	if e = json.Unmarshal(response, &imageConfig); e != nil {
+		blog.Warn("Failed to parse image config")
+		return
+	}
 	return
 }
 
@@ -1021,6 +1172,9 @@ func lookupMetadataTokenAuthV1(imageID ImageIDType, client *http.Client, indexIn
 	metadata.Checksum = m.Checksum
 	metadata.Comment = m.Comment
 	metadata.Parent = m.Parent
+	metadata.OS = m.OS
+	metadata.Architecture = m.Architecture
+	metadata.Variant = m.Variant
 	return
 }
 
@@ -1054,8 +1208,8 @@ func GetNewImageMetadata(oldMetadataSet MetadataSet) (metadataSlice []ImageMetad
 	obsolete := []ImageMetadataInfo{}
 	for metadata := range oldMetadataSet {
 		if !currentMetadataSet.Exists(metadata) {
-			if len(ReposToProcess) > 0 {
-				if _, present := ReposToProcess[RepoType(metadata.Repo)]; present {
+			if len(ReposToProcess) > 0 || len(RepoPatterns) > 0 {
+				if CheckRepoToProcess(RepoType(metadata.Repo)) {
 					obsolete = append(obsolete, metadata)
 					blog.Info("Obsolete ImageMetadata: %v", metadata)
 				}
@@ -1107,41 +1261,44 @@ func RemoveObsoleteMetadata(obsolete []ImageMetadataInfo) {
 	return
 }
 
-func v2GetTagsMetadata(repoSlice []RepoType) (metadataSlice []ImageMetadataInfo, e error) {
-	var client *http.Client
-	if *RegistryTLSNoVerify {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// v2GetTags queries a V2 registry for all the tags of a repository.
+func v2GetTags(client *http.Client, repo RepoType) (tags []string, e error) {
+	URL := RegistryAPIURL + "/v2/" + string(repo) + "/tags/list?n=" + strconv.Itoa(v2PageSize)
+	e = v2QueryPages(client, URL, func(response []byte) (err error) {
+		//parse JSON output
+		var m V2Tag
+		if err = json.Unmarshal(response, &m); err != nil {
+			return
 		}
-		client = &http.Client{Transport: tr}
-	} else {
-		client = &http.Client{}
-	}
+		tags = append(tags, m.Tags...)
+		return
+	})
+	return
+}
+
+func v2GetTagsMetadata(repoSlice []RepoType) (metadataSlice []ImageMetadataInfo, e error) {
+	client := registryClient()
 	for _, repo := range repoSlice {
 		// get tags for one repo
-		response, err := RegistryQueryV2(client, RegistryAPIURL+"/v2/"+string(repo)+"/tags/list")
+		tags, err := v2GetTags(client, repo)
 		if err != nil {
 			except.Error(err)
 			if s, ok := err.(*HTTPStatusCodeError); ok {
 				except.Error("Skipping Repo: %s, tag lookup status code %d", string(repo), s.StatusCode)
 				continue
 			}
-			return
-		}
-		//parse JSON output
-		var m V2Tag
-		if e = json.Unmarshal(response, &m); e != nil {
+			e = err
 			return
 		}
 		// t := TagInfo{Repo: repo, TagMap: make(map[TagType]ImageIDType)}
-		for _, tag := range m.Tags {
-			metadata, e := v2GetMetadata(client, string(repo), tag)
+		for _, tag := range tags {
+			tagMetadata, e := v2GetMetadata(client, string(repo), tag)
 			if e != nil {
 				except.Error(e, ":Unable to get metadata for repo", string(repo), "tag", tag)
 				continue
 			}
 			// t.TagMap[TagType(tag)] = ImageIDType(metadata.Image)
-			metadataSlice = append(metadataSlice, metadata)
+			metadataSlice = append(metadataSlice, tagMetadata...)
 		}
 		// tagSlice = append(tagSlice, t)
 	}
@@ -1163,15 +1320,7 @@ func GetImageMetadataSpecifiedV1(imageMap map[ImageIDType][]RepoTagType,
 	ch := make(chan ImageMetadataInfo)
 	errch := make(chan error)
 	goCount := 0
-	var client *http.Client
-	if *RegistryTLSNoVerify {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client = &http.Client{Transport: tr}
-	} else {
-		client = &http.Client{}
-	}
+	client := registryClient()
 	for imageID := range imageMap {
 		var curr ImageMetadataInfo
 		if previousImages[imageID] {
@@ -1229,6 +1378,9 @@ func GetImageMetadataSpecifiedV1(imageMap map[ImageIDType][]RepoTagType,
 				metadata.Checksum = m.Checksum
 				metadata.Comment = m.Comment
 				metadata.Parent = m.Parent
+				metadata.OS = m.OS
+				metadata.Architecture = m.Architecture
+				metadata.Variant = m.Variant
 			}
 			ch <- metadata
 		}(imageID, ch, errch)
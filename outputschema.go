@@ -0,0 +1,276 @@
+// outputschema.go parses and validates the structured output of scripts. A script with json or yaml
+// output can emit an envelope that names a registered schema and holds a list of records, e.g.,
+//
+//	{"schema": "users", "version": 1, "records": [{"name": "root", "uid": 0}]}
+//
+// Records that validate against the schema are passed on to the Writers as ScriptRecords.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// Types of record fields
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBool    = "bool"
+	FieldList    = "list"
+	FieldObject  = "object"
+)
+
+var (
+	OutputSchemaFile = flag.String("outputschemas", config.COLLECTORDIR()+"/data/outputschemas.yaml",
+		"File with the schemas of the structured output of scripts")
+
+	// outputSchemas maps schema name -> version -> schema
+	outputSchemas   = make(map[string]map[int]OutputSchema)
+	outputSchemasMu sync.RWMutex
+)
+
+// OutputSchema describes the records of one version of a structured output schema.
+type OutputSchema struct {
+	Name    string `yaml:"name"`
+	Version int    `yaml:"version"`
+	// Fields maps the name of each record field to its type: string, number, integer, bool, list or object.
+	Fields map[string]string `yaml:"fields"`
+	// Required lists the fields that every record must have.
+	Required []string `yaml:"required"`
+}
+
+// ScriptRecords is the structured output of a script, validated against its schema.
+type ScriptRecords struct {
+	Schema  string                   `json:"schema"`
+	Version int                      `json:"version"`
+	Records []map[string]interface{} `json:"records"`
+}
+
+// RegisterOutputSchema validates schema and adds it to the registry, replacing any schema
+// with the same name and version.
+func RegisterOutputSchema(schema OutputSchema) (e error) {
+	if schema.Name == "" {
+		return errors.New("Output schema without a name")
+	}
+	id := schema.Name + " version " + strconv.Itoa(schema.Version)
+	if schema.Version < 1 {
+		return errors.New("Output schema " + id + ": version must be at least 1")
+	}
+	if len(schema.Fields) == 0 {
+		return errors.New("Output schema " + id + " has no fields")
+	}
+	for field, fieldType := range schema.Fields {
+		switch fieldType {
+		case FieldString, FieldNumber, FieldInteger, FieldBool, FieldList, FieldObject:
+		default:
+			return errors.New("Output schema " + id + ": field " + field + " has unknown type " + fieldType)
+		}
+	}
+	for _, field := range schema.Required {
+		if _, ok := schema.Fields[field]; !ok {
+			return errors.New("Output schema " + id + ": required field " + field + " is not defined")
+		}
+	}
+	outputSchemasMu.Lock()
+	defer outputSchemasMu.Unlock()
+	if outputSchemas[schema.Name] == nil {
+		outputSchemas[schema.Name] = make(map[int]OutputSchema)
+	}
+	outputSchemas[schema.Name][schema.Version] = schema
+	return
+}
+
+// LoadOutputSchemas reads output schemas from a YAML file and registers them.
+func LoadOutputSchemas(filename string) (e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
+	}
+	var schemaFile struct {
+		Schemas []OutputSchema
+	}
+	if e = yaml.UnmarshalStrict(data, &schemaFile); e != nil {
+		return
+	}
+	for _, schema := range schemaFile.Schemas {
+		if e = RegisterOutputSchema(schema); e != nil {
+			return errors.New(filename + ": " + e.Error())
+		}
+	}
+	blog.Info("Loaded %d output schemas from %s", len(schemaFile.Schemas), filename)
+	return
+}
+
+// lookupOutputSchema returns the registered schema with the given name and version.
+func lookupOutputSchema(name string, version int) (schema OutputSchema, ok bool) {
+	outputSchemasMu.RLock()
+	defer outputSchemasMu.RUnlock()
+	schema, ok = outputSchemas[name][version]
+	return
+}
+
+// parseScriptRecords parses script output as an envelope. ok is false if the output is not an envelope,
+// i.e., not a json or yaml object with a schema key. Otherwise, e tells why the envelope is invalid.
+func parseScriptRecords(o ScriptOutput) (records ScriptRecords, ok bool, e error) {
+	var v interface{}
+	switch o.Format {
+	case ScriptOutputJSON:
+		e = json.Unmarshal(o.Data, &v)
+	case ScriptOutputYAML:
+		e = yaml.Unmarshal(o.Data, &v)
+	default:
+		return
+	}
+	if e != nil {
+		return records, false, nil
+	}
+	envelope, isObject := normalizeValue(v).(map[string]interface{})
+	if !isObject {
+		return
+	}
+	if _, ok = envelope["schema"]; !ok {
+		return
+	}
+	for key := range envelope {
+		if key != "schema" && key != "version" && key != "records" {
+			return records, ok, errors.New("Unknown envelope key " + key)
+		}
+	}
+	schemaName, isString := envelope["schema"].(string)
+	if !isString || schemaName == "" {
+		return records, ok, errors.New("Envelope schema must be a non-empty string")
+	}
+	version, isInt := integerValue(envelope["version"])
+	if !isInt {
+		return records, ok, errors.New("Envelope version must be an integer")
+	}
+	schema, found := lookupOutputSchema(schemaName, int(version))
+	if !found {
+		return records, ok, fmt.Errorf("Unknown output schema %s version %d", schemaName, version)
+	}
+	list, isList := envelope["records"].([]interface{})
+	if !isList && envelope["records"] != nil {
+		return records, ok, errors.New("Envelope records must be a list")
+	}
+	records = ScriptRecords{Schema: schema.Name, Version: schema.Version, Records: []map[string]interface{}{}}
+	for i, item := range list {
+		record, isObject := item.(map[string]interface{})
+		if !isObject {
+			return records, ok, fmt.Errorf("Record %d is not an object", i+1)
+		}
+		if e = schema.validate(record); e != nil {
+			return records, ok, fmt.Errorf("Record %d: %s", i+1, e.Error())
+		}
+		records.Records = append(records.Records, record)
+	}
+	return
+}
+
+// validate checks a record against the schema.
+func (schema OutputSchema) validate(record map[string]interface{}) (e error) {
+	for _, field := range schema.Required {
+		if record[field] == nil {
+			return errors.New("missing required field " + field)
+		}
+	}
+	fields := []string{}
+	for field := range record {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fieldType, ok := schema.Fields[field]
+		if !ok {
+			return errors.New("unknown field " + field)
+		}
+		value := record[field]
+		if value == nil {
+			continue
+		}
+		valid := false
+		switch fieldType {
+		case FieldString:
+			_, valid = value.(string)
+		case FieldNumber:
+			_, valid = value.(float64)
+		case FieldInteger:
+			_, valid = integerValue(value)
+		case FieldBool:
+			_, valid = value.(bool)
+		case FieldList:
+			_, valid = value.([]interface{})
+		case FieldObject:
+			_, valid = value.(map[string]interface{})
+		}
+		if !valid {
+			return fmt.Errorf("field %s is not of type %s: %v", field, fieldType, value)
+		}
+	}
+	return
+}
+
+// normalizeValue converts a decoded yaml or json value to the types used by encoding/json:
+// objects become map[string]interface{}, and all numbers become float64.
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalizeValue(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeValue(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeValue(value)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	}
+	return v
+}
+
+// integerValue returns the value of a normalized number that is a whole number.
+func integerValue(v interface{}) (n int64, ok bool) {
+	f, ok := v.(float64)
+	if !ok || f != float64(int64(f)) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// structureScriptOutput returns the records of script output that is an envelope, or else the output itself.
+// Invalid envelopes are passed on as plain output.
+func structureScriptOutput(script Script, o ScriptOutput) interface{} {
+	records, ok, err := parseScriptRecords(o)
+	if !ok {
+		return o
+	}
+	if err != nil {
+		except.Warn(err, ": Invalid output envelope from script", script.Name())
+		return o
+	}
+	blog.Debug("Script %s produced %d %s records", script.Name(), len(records.Records), records.Schema)
+	return records
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestParseScriptRecords(t *testing.T) {
+	if err := LoadOutputSchemas("data/outputschemas.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		format, output string
+		envelope       bool
+		// valid is set if there is no error, which is always the case for output that is not an envelope
+		valid   bool
+		records int
+	}{
+		{ScriptOutputText, `{"schema": "users", "version": 1, "records": []}`, false, true, 0},
+		{ScriptOutputJSON, `{"users": []}`, false, true, 0},
+		{ScriptOutputJSON, `["schema"]`, false, true, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": [{"name": "root", "uid": 0, "gid": 0}]}`, true, true, 1},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": []}`, true, true, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1}`, true, true, 0},
+		{ScriptOutputYAML, "schema: users\nversion: 1\nrecords:\n- {name: root, uid: 0, gid: 0, shell: /bin/sh}\n- {name: bin, uid: 2, gid: 2, gecos: null}\n", true, true, 2},
+		{ScriptOutputJSON, `{"schema": "users", "version": 2, "records": []}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "groups", "version": 1, "records": []}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": "1", "records": []}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": [], "extra": 1}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": {}}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": ["root"]}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": [{"name": "root", "uid": 0}]}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": [{"name": "root", "uid": 0.5, "gid": 0}]}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": [{"name": 0, "uid": 0, "gid": 0}]}`, true, false, 0},
+		{ScriptOutputJSON, `{"schema": "users", "version": 1, "records": [{"name": "root", "uid": 0, "gid": 0, "pw": "x"}]}`, true, false, 0},
+	}
+	for _, c := range cases {
+		records, ok, err := parseScriptRecords(ScriptOutput{Format: c.format, Data: []byte(c.output)})
+		if ok != c.envelope || (err == nil) != c.valid {
+			t.Fatal(c.output, "envelope:", ok, "error:", err)
+		}
+		if c.valid && len(records.Records) != c.records {
+			t.Fatal(c.output, "got records", records.Records)
+		}
+		fmt.Println(c.output, "->", err)
+	}
+}
+
+func TestRegisterOutputSchema(t *testing.T) {
+	cases := []struct {
+		schema OutputSchema
+		valid  bool
+	}{
+		{OutputSchema{Name: "ports", Version: 1, Fields: map[string]string{"port": FieldInteger}}, true},
+		{OutputSchema{Name: "", Version: 1, Fields: map[string]string{"port": FieldInteger}}, false},
+		{OutputSchema{Name: "ports", Version: 0, Fields: map[string]string{"port": FieldInteger}}, false},
+		{OutputSchema{Name: "ports", Version: 1}, false},
+		{OutputSchema{Name: "ports", Version: 1, Fields: map[string]string{"port": "short"}}, false},
+		{OutputSchema{Name: "ports", Version: 1, Fields: map[string]string{"port": FieldInteger},
+			Required: []string{"proto"}}, false},
+	}
+	for _, c := range cases {
+		if err := RegisterOutputSchema(c.schema); (err == nil) != c.valid {
+			t.Fatal(c.schema, err)
+		}
+	}
+}
+
+// TestListUsersScript checks that the output of the listUsers.py user script validates, if python is around.
+func TestListUsersScript(t *testing.T) {
+	if err := LoadOutputSchemas("data/outputschemas.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("python3", "data/userscripts/listUsers.py").Output()
+	if err != nil {
+		t.Skip("Cannot run listUsers.py: ", err)
+	}
+	records, ok, err := parseScriptRecords(ScriptOutput{Format: ScriptOutputJSON, Data: output})
+	if !ok || err != nil {
+		t.Fatal("Invalid listUsers.py output:", err)
+	}
+	b, _ := json.Marshal(records.Records[0])
+	fmt.Println(len(records.Records), "users, first:", string(b))
+}
@@ -0,0 +1,41 @@
+package pkgdb
+
+import (
+	"strings"
+)
+
+// ParseApkInstalled parses the apk database of installed packages (lib/apk/db/installed).
+// Each package is a block of "X:value" lines, and blocks are separated by blank lines.
+// The origin is the name of the APKBUILD the package was built from.
+func ParseApkInstalled(data []byte) (pkgs []Package) {
+	var pkg Package
+	flush := func() {
+		if pkg.Name != "" {
+			pkgs = append(pkgs, pkg)
+		}
+		pkg = Package{}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'P':
+			pkg.Name = value
+		case 'V':
+			pkg.Version = value
+		case 'A':
+			pkg.Architecture = value
+		case 'o':
+			pkg.Origin = value
+		}
+	}
+	flush()
+	return
+}
@@ -0,0 +1,146 @@
+package pkgdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// Layout of the Berkeley DB hash database used by rpm before 4.16 (Packages, see db_page.h).
+// Numbers are stored in the byte order of the host that created the database, which is detected
+// from the magic number in the metadata page.
+const (
+	bdbHashMagic = 0x061561
+
+	// offsets in the metadata page
+	bdbMagicOffset    = 12
+	bdbPageSizeOffset = 20
+	bdbLastPgnoOffset = 32
+
+	// page header fields and size
+	bdbNextPgnoOffset = 16
+	bdbEntriesOffset  = 20
+	bdbHfOffset       = 22
+	bdbTypeOffset     = 25
+	bdbPageHeaderSize = 26
+
+	// page types
+	bdbPageHashUnsorted = 2
+	bdbPageOverflow     = 7
+	bdbPageHash         = 13
+
+	// hash item types
+	bdbHashKeyData = 1
+	bdbHashOffPage = 3
+	// size of an off-page item: type, 3 unused bytes, page number, total length
+	bdbHashOffPageSize = 12
+	// bdbMinValueSize is the size of the smallest on-page item that can hold an rpm header: type,
+	// number of index entries and size of the data store
+	bdbMinValueSize = 9
+)
+
+// bdbPackageBlobs returns the package header blobs stored as values in a Berkeley DB hash database.
+func bdbPackageBlobs(data []byte) (blobs [][]byte, e error) {
+	if len(data) < 512 {
+		return nil, errors.New("Berkeley DB database too short")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(data[bdbMagicOffset:]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(data[bdbMagicOffset:]) != bdbHashMagic {
+			return nil, errors.New("not a Berkeley DB hash database")
+		}
+	}
+	pageSize := uint64(order.Uint32(data[bdbPageSizeOffset:]))
+	if pageSize < 512 || pageSize > 64*1024 {
+		return nil, errors.New("invalid Berkeley DB page size " + strconv.FormatUint(pageSize, 10))
+	}
+	lastPgno := uint64(order.Uint32(data[bdbLastPgnoOffset:]))
+	if (lastPgno+1)*pageSize > uint64(len(data)) {
+		return nil, errors.New("Berkeley DB database is truncated")
+	}
+	page := func(pgno uint64) []byte {
+		return data[pgno*pageSize : (pgno+1)*pageSize]
+	}
+
+	for pgno := uint64(1); pgno <= lastPgno; pgno++ {
+		p := page(pgno)
+		if p[bdbTypeOffset] != bdbPageHash && p[bdbTypeOffset] != bdbPageHashUnsorted {
+			continue
+		}
+		entries := uint64(order.Uint16(p[bdbEntriesOffset:]))
+		if bdbPageHeaderSize+2*entries > pageSize {
+			return nil, errors.New("Berkeley DB page " + strconv.FormatUint(pgno, 10) + " has too many entries")
+		}
+		// Entries are key/value pairs. Items are stored from the end of the page downwards, not
+		// necessarily in index order, so an item ends where the next item in the page starts.
+		offsets := make([]uint64, entries)
+		for i := range offsets {
+			offsets[i] = uint64(order.Uint16(p[bdbPageHeaderSize+2*i:]))
+			if offsets[i] < bdbPageHeaderSize+2*entries || offsets[i] >= pageSize {
+				return nil, errors.New("Berkeley DB page " + strconv.FormatUint(pgno, 10) + " has a bad item offset")
+			}
+		}
+		sorted := append([]uint64{}, offsets...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		item := func(i uint64) []byte {
+			offset := offsets[i]
+			end := pageSize
+			if j := sort.Search(len(sorted), func(j int) bool { return sorted[j] > offset }); j < len(sorted) {
+				end = sorted[j]
+			}
+			return p[offset:end]
+		}
+		for i := uint64(1); i < entries; i += 2 {
+			if key := item(i - 1); key[0] == bdbHashKeyData && len(key) == 5 && order.Uint32(key[1:]) == 0 {
+				// key 0 holds the counter of header instances, not a header
+				continue
+			}
+			item := item(i)
+			switch item[0] {
+			case bdbHashKeyData:
+				if len(item) < bdbMinValueSize {
+					continue
+				}
+				blobs = append(blobs, item[1:])
+			case bdbHashOffPage:
+				if len(item) < bdbHashOffPageSize {
+					return nil, errors.New("Berkeley DB off-page item is truncated")
+				}
+				blob, err := bdbOverflow(data, pageSize, order, uint64(order.Uint32(item[4:])),
+					uint64(order.Uint32(item[8:])))
+				if err != nil {
+					return nil, err
+				}
+				blobs = append(blobs, blob)
+			}
+		}
+	}
+	return
+}
+
+// bdbOverflow reads a value of length tlen stored in the chain of overflow pages starting at pgno.
+func bdbOverflow(data []byte, pageSize uint64, order binary.ByteOrder, pgno, tlen uint64) (blob []byte, e error) {
+	numPages := uint64(len(data)) / pageSize
+	for visited := uint64(0); pgno != 0; visited++ {
+		if pgno >= numPages || visited >= numPages {
+			return nil, errors.New("Berkeley DB overflow page chain is broken")
+		}
+		p := data[pgno*pageSize : (pgno+1)*pageSize]
+		if p[bdbTypeOffset] != bdbPageOverflow {
+			return nil, errors.New("Berkeley DB page " + strconv.FormatUint(pgno, 10) + " is not an overflow page")
+		}
+		// for overflow pages, hf_offset holds the number of bytes used on the page
+		used := uint64(order.Uint16(p[bdbHfOffset:]))
+		if bdbPageHeaderSize+used > pageSize {
+			return nil, errors.New("Berkeley DB overflow page " + strconv.FormatUint(pgno, 10) + " is corrupt")
+		}
+		blob = append(blob, p[bdbPageHeaderSize:bdbPageHeaderSize+used]...)
+		pgno = uint64(order.Uint32(p[bdbNextPgnoOffset:]))
+	}
+	if uint64(len(blob)) < tlen {
+		return nil, errors.New("Berkeley DB overflow value is truncated")
+	}
+	return blob[:tlen], nil
+}
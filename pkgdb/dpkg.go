@@ -0,0 +1,63 @@
+package pkgdb
+
+import (
+	"strings"
+)
+
+// ParseDpkgStatus parses a dpkg status file, or a status.d file, and returns the installed packages.
+// Packages whose Status field shows that they are not installed are skipped. status.d files
+// have no Status field. The origin is the Source field, or the package name if there is none.
+func ParseDpkgStatus(data []byte) (pkgs []Package) {
+	for _, paragraph := range controlParagraphs(string(data)) {
+		if status, ok := paragraph["Status"]; ok {
+			// Status: want flag status, e.g., "install ok installed"
+			fields := strings.Fields(status)
+			if len(fields) != 3 || fields[2] != "installed" {
+				continue
+			}
+		}
+		if paragraph["Package"] == "" {
+			continue
+		}
+		// Source: name [(version)], present when it differs from the binary package
+		origin := paragraph["Package"]
+		if source := strings.Fields(paragraph["Source"]); len(source) > 0 {
+			origin = source[0]
+		}
+		pkgs = append(pkgs, Package{
+			Name:         paragraph["Package"],
+			Version:      paragraph["Version"],
+			Architecture: paragraph["Architecture"],
+			Origin:       origin,
+		})
+	}
+	return
+}
+
+// controlParagraphs splits a Debian control file into paragraphs separated by blank lines, and each
+// paragraph into fields. Continuation lines of multi-line fields are ignored.
+func controlParagraphs(data string) (paragraphs []map[string]string) {
+	paragraph := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			if len(paragraph) > 0 {
+				paragraphs = append(paragraphs, paragraph)
+				paragraph = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		paragraph[line[:i]] = strings.TrimSpace(line[i+1:])
+	}
+	if len(paragraph) > 0 {
+		paragraphs = append(paragraphs, paragraph)
+	}
+	return
+}
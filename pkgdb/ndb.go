@@ -0,0 +1,67 @@
+package pkgdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Layout of the rpm "ndb" database (Packages.db, see lib/backend/ndb/rpmpkg.c). All numbers are
+// little-endian. The file starts with a header and the slot table, which maps each package index to
+// the location of its blob. Blobs are stored in 16 byte blocks.
+const (
+	ndbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic   = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbVersion     = 0
+	ndbPageSize    = 4096
+	ndbHeaderSize  = 32
+	ndbSlotSize    = 16
+	ndbBlkSize     = 16
+	ndbBlobHdrSize = 16
+)
+
+// ndbPackageBlobs returns the package header blobs stored in an ndb database.
+func ndbPackageBlobs(data []byte) (blobs [][]byte, e error) {
+	if len(data) < ndbHeaderSize {
+		return nil, errors.New("ndb database too short")
+	}
+	le := binary.LittleEndian
+	if le.Uint32(data[0:4]) != ndbHeaderMagic {
+		return nil, errors.New("not an ndb database")
+	}
+	if v := le.Uint32(data[4:8]); v != ndbVersion {
+		return nil, errors.New("unsupported ndb database version " + strconv.Itoa(int(v)))
+	}
+	slotNPages := uint64(le.Uint32(data[12:16]))
+	slotsEnd := slotNPages * ndbPageSize
+	if slotsEnd > uint64(len(data)) {
+		return nil, errors.New("ndb slot table is truncated")
+	}
+	for off := uint64(ndbHeaderSize); off+ndbSlotSize <= slotsEnd; off += ndbSlotSize {
+		slot := data[off : off+ndbSlotSize]
+		if le.Uint32(slot[0:4]) != ndbSlotMagic {
+			return nil, errors.New("ndb slot " + strconv.FormatUint(off, 10) + " has bad magic")
+		}
+		pkgIndex := le.Uint32(slot[4:8])
+		if pkgIndex == 0 {
+			// free slot
+			continue
+		}
+		blkOffset := uint64(le.Uint32(slot[8:12])) * ndbBlkSize
+		blkEnd := blkOffset + uint64(le.Uint32(slot[12:16]))*ndbBlkSize
+		if blkEnd > uint64(len(data)) || blkOffset+ndbBlobHdrSize > blkEnd {
+			return nil, errors.New("ndb blob of package " + strconv.Itoa(int(pkgIndex)) + " is out of range")
+		}
+		blob := data[blkOffset:blkEnd]
+		if le.Uint32(blob[0:4]) != ndbBlobMagic || le.Uint32(blob[4:8]) != pkgIndex {
+			return nil, errors.New("ndb blob of package " + strconv.Itoa(int(pkgIndex)) + " has bad header")
+		}
+		blobLen := uint64(le.Uint32(blob[12:16]))
+		if ndbBlobHdrSize+blobLen > uint64(len(blob)) {
+			return nil, errors.New("ndb blob of package " + strconv.Itoa(int(pkgIndex)) + " is truncated")
+		}
+		blobs = append(blobs, blob[ndbBlobHdrSize:ndbBlobHdrSize+blobLen])
+	}
+	return
+}
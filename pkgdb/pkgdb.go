@@ -0,0 +1,143 @@
+// Package pkgdb reads the databases of installed packages of dpkg, rpm and apk based Linux
+// distributions straight from the files of an image, without running any package manager.
+package pkgdb
+
+import (
+	"os"
+	"path"
+)
+
+// Package describes an installed package.
+type Package struct {
+	Name         string
+	Version      string
+	Architecture string
+	// Origin is the name of the source package the package was built from
+	Origin string
+}
+
+// FS gives read access to the files of an image.
+// Both methods must return an error satisfying os.IsNotExist if name does not exist.
+type FS interface {
+	// ReadFile returns the contents of the file name, following symbolic links.
+	ReadFile(name string) ([]byte, error)
+	// ReadDir returns the names of the regular files in directory name.
+	ReadDir(name string) ([]string, error)
+}
+
+var (
+	// rpmDirs lists the directories where rpm keeps its database, current location first.
+	rpmDirs = []string{"/usr/lib/sysimage/rpm", "/var/lib/rpm"}
+)
+
+const (
+	dpkgStatus    = "/var/lib/dpkg/status"
+	dpkgStatusDir = "/var/lib/dpkg/status.d"
+	apkInstalled  = "/lib/apk/db/installed"
+
+	rpmSqlite = "rpmdb.sqlite"
+	rpmNDB    = "Packages.db"
+	rpmBDB    = "Packages"
+)
+
+// Installed returns the packages recorded in all the package databases found in fs.
+// managers lists the package managers whose databases were found, e.g., "dpkg".
+func Installed(fs FS) (pkgs []Package, managers []string, e error) {
+	dpkgPkgs, found, e := readDpkg(fs)
+	if e != nil {
+		return
+	}
+	if found {
+		managers = append(managers, "dpkg")
+		pkgs = append(pkgs, dpkgPkgs...)
+	}
+
+	rpmPkgs, found, e := readRpm(fs)
+	if e != nil {
+		return
+	}
+	if found {
+		managers = append(managers, "rpm")
+		pkgs = append(pkgs, rpmPkgs...)
+	}
+
+	data, e := fs.ReadFile(apkInstalled)
+	switch {
+	case e == nil:
+		managers = append(managers, "apk")
+		pkgs = append(pkgs, ParseApkInstalled(data)...)
+	case os.IsNotExist(e):
+		e = nil
+	default:
+		return
+	}
+	return
+}
+
+// readDpkg reads the dpkg status file, as well as the per-package status files
+// in status.d used by distroless images.
+func readDpkg(fs FS) (pkgs []Package, found bool, e error) {
+	data, e := fs.ReadFile(dpkgStatus)
+	switch {
+	case e == nil:
+		found = true
+		pkgs = append(pkgs, ParseDpkgStatus(data)...)
+	case os.IsNotExist(e):
+		e = nil
+	default:
+		return
+	}
+
+	names, e := fs.ReadDir(dpkgStatusDir)
+	if e != nil {
+		if os.IsNotExist(e) {
+			e = nil
+		}
+		return
+	}
+	for _, name := range names {
+		if path.Ext(name) == ".md5sums" {
+			continue
+		}
+		data, e = fs.ReadFile(path.Join(dpkgStatusDir, name))
+		if e != nil {
+			return
+		}
+		found = true
+		pkgs = append(pkgs, ParseDpkgStatus(data)...)
+	}
+	return
+}
+
+// readRpm reads the first rpm database found, trying the sqlite, NDB and Berkeley DB formats.
+func readRpm(fs FS) (pkgs []Package, found bool, e error) {
+	for _, dir := range rpmDirs {
+		for _, db := range []struct {
+			name  string
+			blobs func([]byte) ([][]byte, error)
+		}{
+			{rpmSqlite, sqlitePackageBlobs},
+			{rpmNDB, ndbPackageBlobs},
+			{rpmBDB, bdbPackageBlobs},
+		} {
+			var data []byte
+			data, e = fs.ReadFile(path.Join(dir, db.name))
+			if os.IsNotExist(e) {
+				e = nil
+				continue
+			}
+			if e != nil {
+				return
+			}
+			found = true
+			var blobs [][]byte
+			blobs, e = db.blobs(data)
+			if e != nil {
+				return
+			}
+			pkgs, e = parseRpmHeaders(blobs)
+			return
+		}
+	}
+	return
+}
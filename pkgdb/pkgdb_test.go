@@ -0,0 +1,344 @@
+package pkgdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mapFS is an FS backed by a map from file names to contents.
+type mapFS map[string]string
+
+func (fs mapFS) ReadFile(name string) ([]byte, error) {
+	data, ok := fs[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return []byte(data), nil
+}
+
+func (fs mapFS) ReadDir(name string) (names []string, e error) {
+	for file := range fs {
+		if path.Dir(file) == name {
+			names = append(names, path.Base(file))
+		}
+	}
+	if len(names) == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	sort.Strings(names)
+	return
+}
+
+// rpmHeader builds an rpm header blob with the given string tags, plus one padding tag of pad bytes.
+// The source rpm is named after the package.
+func rpmHeader(name, version, release, arch string, pad int) []byte {
+	return rpmEpochHeader(name, -1, version, release, arch, pad)
+}
+
+// rpmEpochHeader is like rpmHeader, with an epoch tag unless epoch is negative.
+func rpmEpochHeader(name string, epoch int, version, release, arch string, pad int) []byte {
+	tags := []struct {
+		tag, typ uint32
+		value    string
+	}{
+		{rpmTagName, rpmTypeString, name},
+		{rpmTagVersion, rpmTypeString, version},
+		{rpmTagRelease, rpmTypeString, release},
+		{1004, rpmTypeI18NString, strings.Repeat("x", pad)},
+		{rpmTagSource, rpmTypeString, name + "-" + version + "-" + release + ".src.rpm"},
+	}
+	if arch != "" {
+		tags = append(tags, struct {
+			tag, typ uint32
+			value    string
+		}{rpmTagArch, rpmTypeString, arch})
+	}
+	if epoch >= 0 {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, uint32(epoch))
+		tags = append(tags, struct {
+			tag, typ uint32
+			value    string
+		}{rpmTagEpoch, rpmTypeInt32, string(value)})
+	}
+	var index, store []byte
+	for _, t := range tags {
+		entry := make([]byte, rpmIndexEntrySize)
+		binary.BigEndian.PutUint32(entry[0:], t.tag)
+		binary.BigEndian.PutUint32(entry[4:], t.typ)
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(store)))
+		binary.BigEndian.PutUint32(entry[12:], 1)
+		index = append(index, entry...)
+		store = append(store, append([]byte(t.value), 0)...)
+	}
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint32(blob[0:], uint32(len(tags)))
+	binary.BigEndian.PutUint32(blob[4:], uint32(len(store)))
+	return append(append(blob, index...), store...)
+}
+
+func checkPackages(t *testing.T, what string, pkgs []Package, expected []Package) {
+	if len(pkgs) != len(expected) {
+		t.Fatalf("%s: got %d packages %v, expected %d", what, len(pkgs), pkgs, len(expected))
+	}
+	for i := range pkgs {
+		if pkgs[i] != expected[i] {
+			t.Fatalf("%s: package %d is %v, expected %v", what, i, pkgs[i], expected[i])
+		}
+	}
+}
+
+func TestParseDpkgStatus(t *testing.T) {
+	status := `Package: libc6
+Source: glibc
+Status: install ok installed
+Priority: required
+Architecture: amd64
+Version: 2.31-13+deb11u5
+Description: GNU C Library: Shared libraries
+ Contains the standard libraries that are used by nearly all programs on
+ the system.
+
+Package: removed-pkg
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 2021a-1+deb11u8
+`
+	checkPackages(t, "status", ParseDpkgStatus([]byte(status)), []Package{
+		{"libc6", "2.31-13+deb11u5", "amd64", "glibc"},
+		{"tzdata", "2021a-1+deb11u8", "all", "tzdata"},
+	})
+	// distroless status.d files have no Status field
+	checkPackages(t, "status.d", ParseDpkgStatus([]byte("Package: base-files\nVersion: 11.1+deb11u7\nArchitecture: amd64\n")),
+		[]Package{{"base-files", "11.1+deb11u7", "amd64", "base-files"}})
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	installed := "C:Q1abc=\nP:musl\nV:1.2.4-r2\nA:x86_64\nS:383152\no:musl\n\n" +
+		"C:Q1def=\nP:ssl_client\nV:1.36.1-r5\nA:x86_64\no:busybox\nF:usr/bin\nR:ssl_client\n\n"
+	checkPackages(t, "apk", ParseApkInstalled([]byte(installed)), []Package{
+		{"musl", "1.2.4-r2", "x86_64", "musl"},
+		{"ssl_client", "1.36.1-r5", "x86_64", "busybox"},
+	})
+}
+
+func TestParseRpmHeader(t *testing.T) {
+	pkg, e := ParseRpmHeader(rpmHeader("bash", "5.1.8", "6.el9", "x86_64", 10))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if pkg != (Package{"bash", "5.1.8-6.el9", "x86_64", "bash"}) {
+		t.Fatal("unexpected package", pkg)
+	}
+	pkg, e = ParseRpmHeader(rpmHeader("gpg-pubkey", "fd431d51", "4ae0493b", "", 0))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if pkg.Architecture != "(none)" {
+		t.Fatal("unexpected architecture", pkg.Architecture)
+	}
+	pkg, e = ParseRpmHeader(rpmEpochHeader("openssl", 1, "3.0.7", "25.el9_3", "x86_64", 0))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if pkg != (Package{"openssl", "1:3.0.7-25.el9_3", "x86_64", "openssl"}) {
+		t.Fatal("unexpected package", pkg)
+	}
+	// an epoch of 0 that is set is reported, as rpm does
+	pkg, e = ParseRpmHeader(rpmEpochHeader("tzdata", 0, "2023c", "1.el9", "noarch", 0))
+	if e != nil || pkg.Version != "0:2023c-1.el9" {
+		t.Fatal("unexpected package", pkg, e)
+	}
+	blob := rpmHeader("bash", "5.1.8", "6.el9", "x86_64", 10)
+	if _, e = ParseRpmHeader(blob[:len(blob)-20]); e == nil {
+		t.Fatal("truncated header should fail to parse")
+	}
+}
+
+// bdbDatabase builds a Berkeley DB hash database with one hash page, which has the counter of
+// header instances under key 0, like rpm databases, then the values: the first one is stored on
+// the page and the others on overflow pages. Items are laid out in reverse index order.
+func bdbDatabase(order binary.ByteOrder, values [][]byte) []byte {
+	const pageSize = 512
+	pages := [][]byte{make([]byte, pageSize), make([]byte, pageSize)}
+	meta := pages[0]
+	order.PutUint32(meta[bdbMagicOffset:], bdbHashMagic)
+	order.PutUint32(meta[bdbPageSizeOffset:], pageSize)
+
+	counter := make([]byte, 5)
+	counter[0] = bdbHashKeyData
+	order.PutUint32(counter[1:], uint32(len(values)+1))
+	items := [][]byte{{bdbHashKeyData, 0, 0, 0, 0}, counter}
+	for i, value := range values {
+		key := make([]byte, 5)
+		key[0] = bdbHashKeyData
+		order.PutUint32(key[1:], uint32(i+1))
+		if i == 0 {
+			items = append(items, key, append([]byte{bdbHashKeyData}, value...))
+			continue
+		}
+		// store the value in a chain of overflow pages
+		first := len(pages)
+		for chunk := value; len(chunk) > 0; {
+			n := len(chunk)
+			if n > pageSize-bdbPageHeaderSize {
+				n = pageSize - bdbPageHeaderSize
+			}
+			p := make([]byte, pageSize)
+			p[bdbTypeOffset] = bdbPageOverflow
+			order.PutUint16(p[bdbHfOffset:], uint16(n))
+			copy(p[bdbPageHeaderSize:], chunk[:n])
+			chunk = chunk[n:]
+			if len(chunk) > 0 {
+				order.PutUint32(p[bdbNextPgnoOffset:], uint32(len(pages)+1))
+			}
+			pages = append(pages, p)
+		}
+		offPage := make([]byte, bdbHashOffPageSize)
+		offPage[0] = bdbHashOffPage
+		order.PutUint32(offPage[4:], uint32(first))
+		order.PutUint32(offPage[8:], uint32(len(value)))
+		items = append(items, key, offPage)
+	}
+	hash := pages[1]
+	hash[bdbTypeOffset] = bdbPageHash
+	order.PutUint16(hash[bdbEntriesOffset:], uint16(len(items)))
+	top := pageSize
+	for i := len(items) - 1; i >= 0; i-- {
+		top -= len(items[i])
+		copy(hash[top:], items[i])
+		order.PutUint16(hash[bdbPageHeaderSize+2*i:], uint16(top))
+	}
+	order.PutUint32(meta[bdbLastPgnoOffset:], uint32(len(pages)-1))
+	var data []byte
+	for _, p := range pages {
+		data = append(data, p...)
+	}
+	return data
+}
+
+// ndbDatabase builds an ndb database with one page of slots, the second of which is free.
+func ndbDatabase(values [][]byte) []byte {
+	le := binary.LittleEndian
+	data := make([]byte, ndbPageSize)
+	le.PutUint32(data[0:], ndbHeaderMagic)
+	le.PutUint32(data[12:], 1)
+	for i := 0; i < (ndbPageSize-ndbHeaderSize)/ndbSlotSize; i++ {
+		le.PutUint32(data[ndbHeaderSize+i*ndbSlotSize:], ndbSlotMagic)
+	}
+	for i, value := range values {
+		slot := data[ndbHeaderSize+2*i*ndbSlotSize:]
+		pkgIndex := uint32(i + 1)
+		blob := make([]byte, ndbBlobHdrSize, ndbBlobHdrSize+len(value)+ndbBlkSize)
+		le.PutUint32(blob[0:], ndbBlobMagic)
+		le.PutUint32(blob[4:], pkgIndex)
+		le.PutUint32(blob[12:], uint32(len(value)))
+		blob = append(blob, value...)
+		for len(blob)%ndbBlkSize != 0 {
+			blob = append(blob, 0)
+		}
+		le.PutUint32(slot[4:], pkgIndex)
+		le.PutUint32(slot[8:], uint32(len(data)/ndbBlkSize))
+		le.PutUint32(slot[12:], uint32(len(blob)/ndbBlkSize))
+		data = append(data, blob...)
+	}
+	return data
+}
+
+func TestRpmDatabases(t *testing.T) {
+	headers := [][]byte{
+		rpmHeader("bash", "4.2.46", "34.el7", "x86_64", 10),
+		rpmHeader("glibc", "2.17", "326.el7_9", "x86_64", 1200),
+		rpmHeader("tzdata", "2023c", "1.el7", "noarch", 700),
+	}
+	expected := []Package{
+		{"bash", "4.2.46-34.el7", "x86_64", "bash"},
+		{"glibc", "2.17-326.el7_9", "x86_64", "glibc"},
+		{"tzdata", "2023c-1.el7", "noarch", "tzdata"},
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		pkgs, managers, e := Installed(mapFS{"/var/lib/rpm/Packages": string(bdbDatabase(order, headers))})
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(managers) != 1 || managers[0] != "rpm" {
+			t.Fatal("unexpected package managers", managers)
+		}
+		checkPackages(t, "bdb "+order.String(), pkgs, expected)
+	}
+
+	pkgs, _, e := Installed(mapFS{"/usr/lib/sysimage/rpm/Packages.db": string(ndbDatabase(headers))})
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkPackages(t, "ndb", pkgs, expected)
+
+	// a bad header is skipped
+	bad := append([][]byte{{0, 0, 0, 9, 0, 0, 0, 1}}, headers...)
+	pkgs, _, e = Installed(mapFS{"/usr/lib/sysimage/rpm/Packages.db": string(ndbDatabase(bad))})
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkPackages(t, "ndb with a bad header", pkgs, expected)
+
+	// testdata/rpmdb.sqlite has 512 byte pages, so it has interior b-tree pages and overflow pages.
+	data, e := ioutil.ReadFile("testdata/rpmdb.sqlite")
+	if e != nil {
+		t.Fatal(e)
+	}
+	pkgs, _, e = Installed(mapFS{"/var/lib/rpm/rpmdb.sqlite": string(data)})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(pkgs) != 30 {
+		t.Fatal("expected 30 packages in sqlite database, got", len(pkgs))
+	}
+	if pkgs[0] != (Package{"pkg00", "1.0-0.el9", "noarch", ""}) || pkgs[29] != (Package{"pkg29", "1.29-2.el9", "x86_64", ""}) {
+		t.Fatal("unexpected packages in sqlite database", pkgs[0], pkgs[29])
+	}
+
+	for name, db := range map[string]string{
+		"/var/lib/rpm/Packages":    "not a database",
+		"/var/lib/rpm/Packages.db": string(ndbDatabase(headers)[:ndbPageSize+20]),
+	} {
+		if _, _, e = Installed(mapFS{name: db}); e == nil {
+			t.Fatal("corrupt database", name, "should fail to parse")
+		}
+	}
+}
+
+func TestInstalled(t *testing.T) {
+	fs := mapFS{
+		"/var/lib/dpkg/status.d/base-files":         "Package: base-files\nVersion: 11.1\nArchitecture: amd64\n",
+		"/var/lib/dpkg/status.d/base-files.md5sums": "0123 etc/issue\n",
+		"/var/lib/dpkg/status.d/tzdata":             "Package: tzdata\nVersion: 2021a-1\nArchitecture: all\n",
+		"/lib/apk/db/installed":                     "P:musl\nV:1.2.4-r2\nA:x86_64\n",
+	}
+	pkgs, managers, e := Installed(fs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if strings.Join(managers, ",") != "dpkg,apk" {
+		t.Fatal("unexpected package managers", managers)
+	}
+	checkPackages(t, "installed", pkgs, []Package{
+		{"base-files", "11.1", "amd64", "base-files"},
+		{"tzdata", "2021a-1", "all", "tzdata"},
+		{"musl", "1.2.4-r2", "x86_64", ""},
+	})
+
+	pkgs, managers, e = Installed(mapFS{})
+	if e != nil || len(pkgs) != 0 || len(managers) != 0 {
+		t.Fatal("expected no packages and no error for an empty image", pkgs, managers, e)
+	}
+}
@@ -0,0 +1,132 @@
+package pkgdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+
+	blog "github.com/ccpaging/log4go"
+)
+
+// rpm header tags and data types (see rpmtag.h)
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagArch    = 1022
+	rpmTagSource  = 1044
+
+	rpmTypeInt32      = 4
+	rpmTypeString     = 6
+	rpmTypeI18NString = 9
+
+	// rpmIndexEntrySize is the size of an entry of the header index: tag, type, offset, count.
+	rpmIndexEntrySize = 16
+	// rpmMaxHeaderSize is the sanity limit rpm itself puts on the size of a header.
+	rpmMaxHeaderSize = 256 * 1024 * 1024
+)
+
+// parseRpmHeaders parses the header blobs stored in an rpm database. Bad headers are skipped;
+// the error of the last one is returned only if no header could be parsed.
+func parseRpmHeaders(blobs [][]byte) (pkgs []Package, e error) {
+	for i, blob := range blobs {
+		pkg, err := ParseRpmHeader(blob)
+		if err != nil {
+			blog.Warn("Skipping rpm header %d: %v", i, err)
+			e = err
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) > 0 {
+		e = nil
+	}
+	return
+}
+
+// ParseRpmHeader parses an rpm package header, as stored in the rpm database, and returns the
+// package name, [epoch:]version-release and architecture, like
+// rpm -qa --qf '%{n}\t%|epoch?{%{epoch}:}|%{v}-%{r}\t%{arch}\n', and the name of the source package.
+// The header consists of the number of index entries il and the size of the data store dl,
+// followed by il index entries and the data store itself, all in big-endian byte order.
+func ParseRpmHeader(blob []byte) (pkg Package, e error) {
+	if len(blob) < 8 {
+		e = errors.New("rpm header too short")
+		return
+	}
+	il := binary.BigEndian.Uint32(blob[0:4])
+	dl := binary.BigEndian.Uint32(blob[4:8])
+	if uint64(il)*rpmIndexEntrySize+uint64(dl) > rpmMaxHeaderSize ||
+		uint64(len(blob)) < 8+uint64(il)*rpmIndexEntrySize+uint64(dl) {
+		e = errors.New("rpm header with " + strconv.Itoa(int(il)) + " index entries and " +
+			strconv.Itoa(int(dl)) + " data bytes is truncated")
+		return
+	}
+	store := blob[8+il*rpmIndexEntrySize : 8+il*rpmIndexEntrySize+dl]
+	strs := make(map[uint32]string)
+	epoch := ""
+	for i := uint32(0); i < il; i++ {
+		entry := blob[8+i*rpmIndexEntrySize : 8+(i+1)*rpmIndexEntrySize]
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		typ := binary.BigEndian.Uint32(entry[4:8])
+		offset := binary.BigEndian.Uint32(entry[8:12])
+		switch tag {
+		case rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagArch, rpmTagSource:
+			if typ != rpmTypeString && typ != rpmTypeI18NString {
+				continue
+			}
+		case rpmTagEpoch:
+			if typ != rpmTypeInt32 {
+				continue
+			}
+		default:
+			continue
+		}
+		if offset >= uint32(len(store)) || tag == rpmTagEpoch && offset+4 > uint32(len(store)) {
+			e = errors.New("rpm header tag " + strconv.Itoa(int(tag)) + " has invalid offset")
+			return
+		}
+		if tag == rpmTagEpoch {
+			epoch = strconv.FormatUint(uint64(binary.BigEndian.Uint32(store[offset:offset+4])), 10)
+			continue
+		}
+		s := store[offset:]
+		if end := bytes.IndexByte(s, 0); end >= 0 {
+			s = s[:end]
+		}
+		strs[tag] = string(s)
+	}
+	if strs[rpmTagName] == "" {
+		e = errors.New("rpm header has no package name")
+		return
+	}
+	pkg.Name = strs[rpmTagName]
+	pkg.Version = strs[rpmTagVersion] + "-" + strs[rpmTagRelease]
+	if epoch != "" {
+		pkg.Version = epoch + ":" + pkg.Version
+	}
+	pkg.Architecture = strs[rpmTagArch]
+	if pkg.Architecture == "" {
+		// e.g., gpg-pubkey pseudo-packages
+		pkg.Architecture = "(none)"
+	}
+	pkg.Origin = sourceRpmName(strs[rpmTagSource])
+	return
+}
+
+// sourceRpmName returns the package name of a source rpm file name, e.g., bash for bash-5.1.8-6.el9.src.rpm.
+func sourceRpmName(sourceRpm string) string {
+	name := strings.TrimSuffix(sourceRpm, ".rpm")
+	for i := 0; i < 2; i++ {
+		// remove the release, then the version
+		dash := strings.LastIndex(name, "-")
+		if dash <= 0 {
+			return ""
+		}
+		name = name[:dash]
+	}
+	return name
+}
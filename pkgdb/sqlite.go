@@ -0,0 +1,283 @@
+package pkgdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// This file has a minimal reader for the SQLite database file format (https://www.sqlite.org/fileformat.html),
+// just enough to read the Packages table of rpmdb.sqlite: CREATE TABLE Packages (hnum INTEGER PRIMARY KEY, blob BLOB).
+// Only the main database file is read, so changes not yet checkpointed from a -wal file are missed.
+
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+
+	// b-tree page types
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+
+	// sqliteMaxDepth limits the depth of the b-tree walk, to protect against loops in corrupt files.
+	sqliteMaxDepth = 64
+)
+
+// sqliteDB is an SQLite database file loaded in memory.
+type sqliteDB struct {
+	data       []byte
+	pageSize   uint64
+	usableSize uint64
+}
+
+// sqlitePackageBlobs returns the header blobs stored in the Packages table of an rpm sqlite database.
+func sqlitePackageBlobs(data []byte) (blobs [][]byte, e error) {
+	db, e := newSqliteDB(data)
+	if e != nil {
+		return
+	}
+	root, e := db.tableRootPage("Packages")
+	if e != nil {
+		return
+	}
+	e = db.walkTable(root, 0, func(record [][]byte) error {
+		if len(record) < 2 {
+			return errors.New("sqlite Packages row has too few columns")
+		}
+		blobs = append(blobs, record[1])
+		return nil
+	})
+	return
+}
+
+func newSqliteDB(data []byte) (db *sqliteDB, e error) {
+	if len(data) < sqliteHeaderSize || string(data[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, errors.New("not an SQLite database")
+	}
+	pageSize := uint64(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, errors.New("invalid SQLite page size " + strconv.FormatUint(pageSize, 10))
+	}
+	reserved := uint64(data[20])
+	db = &sqliteDB{data: data, pageSize: pageSize, usableSize: pageSize - reserved}
+	return
+}
+
+// page returns page number pgno; pages are numbered from 1.
+func (db *sqliteDB) page(pgno uint64) ([]byte, error) {
+	if pgno == 0 || pgno*db.pageSize > uint64(len(db.data)) {
+		return nil, errors.New("SQLite page " + strconv.FormatUint(pgno, 10) + " is out of range")
+	}
+	return db.data[(pgno-1)*db.pageSize : pgno*db.pageSize], nil
+}
+
+// tableRootPage looks up the root page of a table in the schema table stored in page 1.
+func (db *sqliteDB) tableRootPage(name string) (root uint64, e error) {
+	e = db.walkTable(1, 0, func(record [][]byte) error {
+		// columns: type, name, tbl_name, rootpage, sql
+		if len(record) >= 4 && string(record[0]) == "table" && string(record[1]) == name {
+			root = sqliteInt(record[3])
+		}
+		return nil
+	})
+	if e == nil && root == 0 {
+		e = errors.New("SQLite table " + name + " not found")
+	}
+	return
+}
+
+// walkTable calls handleRecord with the columns of each row of the table b-tree rooted at pgno.
+// Integer columns are returned as big-endian bytes, and NULL columns as nil.
+func (db *sqliteDB) walkTable(pgno uint64, depth int, handleRecord func([][]byte) error) (e error) {
+	if depth > sqliteMaxDepth {
+		return errors.New("SQLite b-tree is too deep")
+	}
+	p, e := db.page(pgno)
+	if e != nil {
+		return
+	}
+	hdr := p
+	if pgno == 1 {
+		hdr = p[sqliteHeaderSize:]
+	}
+	numCells := int(binary.BigEndian.Uint16(hdr[3:5]))
+	switch hdr[0] {
+	case sqliteLeafTable:
+		for i := 0; i < numCells; i++ {
+			off, err := cellOffset(p, hdr, 8, i)
+			if err != nil {
+				return err
+			}
+			payload, err := db.leafPayload(p, off)
+			if err != nil {
+				return err
+			}
+			record, err := parseSqliteRecord(payload)
+			if err != nil {
+				return err
+			}
+			if e = handleRecord(record); e != nil {
+				return
+			}
+		}
+	case sqliteInteriorTable:
+		for i := 0; i < numCells; i++ {
+			off, err := cellOffset(p, hdr, 12, i)
+			if err != nil {
+				return err
+			}
+			if off+4 > uint64(len(p)) {
+				return errors.New("SQLite interior cell is truncated")
+			}
+			if e = db.walkTable(uint64(binary.BigEndian.Uint32(p[off:])), depth+1, handleRecord); e != nil {
+				return
+			}
+		}
+		e = db.walkTable(uint64(binary.BigEndian.Uint32(hdr[8:12])), depth+1, handleRecord)
+	default:
+		e = errors.New("SQLite page " + strconv.FormatUint(pgno, 10) + " is not a table b-tree page")
+	}
+	return
+}
+
+// cellOffset returns the offset in page p of cell i, whose pointer is in the array after the page header hdr.
+func cellOffset(p, hdr []byte, headerSize, i int) (off uint64, e error) {
+	ptr := len(p) - len(hdr) + headerSize + 2*i
+	if ptr+2 > len(p) {
+		return 0, errors.New("SQLite cell pointer array is truncated")
+	}
+	off = uint64(binary.BigEndian.Uint16(p[ptr:]))
+	if off >= uint64(len(p)) {
+		return 0, errors.New("SQLite cell offset is out of range")
+	}
+	return
+}
+
+// leafPayload returns the payload of the table leaf cell at offset off in page p,
+// including the part that spills onto overflow pages.
+func (db *sqliteDB) leafPayload(p []byte, off uint64) (payload []byte, e error) {
+	size, n := sqliteVarint(p[off:])
+	if n == 0 {
+		return nil, errors.New("SQLite cell is truncated")
+	}
+	off += uint64(n)
+	// skip the rowid
+	_, n = sqliteVarint(p[off:])
+	if n == 0 {
+		return nil, errors.New("SQLite cell is truncated")
+	}
+	off += uint64(n)
+
+	u := db.usableSize
+	local := size
+	maxLocal := u - 35
+	if size > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + (size-minLocal)%(u-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if off+local > uint64(len(p)) {
+		return nil, errors.New("SQLite cell payload is truncated")
+	}
+	payload = append(payload, p[off:off+local]...)
+	if local == size {
+		return
+	}
+	if off+local+4 > uint64(len(p)) {
+		return nil, errors.New("SQLite cell overflow pointer is truncated")
+	}
+	next := uint64(binary.BigEndian.Uint32(p[off+local:]))
+	numPages := uint64(len(db.data)) / db.pageSize
+	for visited := uint64(0); uint64(len(payload)) < size; visited++ {
+		if next == 0 || visited > numPages {
+			return nil, errors.New("SQLite overflow chain is broken")
+		}
+		op, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := op[4:u]
+		if remaining := size - uint64(len(payload)); uint64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = uint64(binary.BigEndian.Uint32(op[0:4]))
+	}
+	return
+}
+
+// parseSqliteRecord splits a record into its column values.
+func parseSqliteRecord(payload []byte) (columns [][]byte, e error) {
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || headerSize > uint64(len(payload)) {
+		return nil, errors.New("SQLite record header is truncated")
+	}
+	types := []uint64{}
+	for off := uint64(n); off < headerSize; {
+		t, n := sqliteVarint(payload[off:headerSize])
+		if n == 0 {
+			return nil, errors.New("SQLite record header is corrupt")
+		}
+		types = append(types, t)
+		off += uint64(n)
+	}
+	body := payload[headerSize:]
+	for _, t := range types {
+		var size uint64
+		switch {
+		case t == 0 || t == 8 || t == 9:
+			// NULL, or the integer constants 0 and 1
+			size = 0
+		case t <= 4:
+			size = t
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t >= 12:
+			size = (t - 12) / 2
+		default:
+			return nil, errors.New("SQLite record has reserved serial type " + strconv.FormatUint(t, 10))
+		}
+		if size > uint64(len(body)) {
+			return nil, errors.New("SQLite record is truncated")
+		}
+		value := body[:size]
+		if t == 9 {
+			value = []byte{1}
+		}
+		columns = append(columns, value)
+		body = body[size:]
+	}
+	return
+}
+
+// sqliteVarint decodes a big-endian variable-length integer of up to 9 bytes.
+// It returns the number of bytes read, or 0 if b is too short.
+func sqliteVarint(b []byte) (v uint64, n int) {
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return
+}
+
+// sqliteInt returns the value of a big-endian integer column.
+func sqliteInt(b []byte) (v uint64) {
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return
+}
@@ -0,0 +1,216 @@
+// pkgextract.go has functions that read the installed packages of an image from its package databases,
+// either in the root filesystem of an unpacked image, or through a container created from a pulled image.
+package collector
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	except "github.com/banyanops/collector/except"
+	pkgdb "github.com/banyanops/collector/pkgdb"
+	blog "github.com/ccpaging/log4go"
+)
+
+const (
+	// maxArchiveSymlinks limits the number of symbolic links followed when reading a file from a container.
+	maxArchiveSymlinks = 16
+)
+
+// rootfsFS gives access to the files of an image unpacked in a root filesystem (see ExtractImageRootfs).
+type rootfsFS string
+
+// ReadFile reads a file of the image.
+func (fs rootfsFS) ReadFile(name string) (data []byte, e error) {
+	hostPath, e := securePath(string(fs), name, true)
+	if e != nil {
+		return
+	}
+	return ioutil.ReadFile(hostPath)
+}
+
+// ReadDir returns the names of the regular files in a directory of the image.
+func (fs rootfsFS) ReadDir(name string) (names []string, e error) {
+	hostPath, e := securePath(string(fs), name, true)
+	if e != nil {
+		return
+	}
+	files, e := ioutil.ReadDir(hostPath)
+	if e != nil {
+		return
+	}
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			names = append(names, file.Name())
+		}
+	}
+	return
+}
+
+// containerFS gives access to the files of a pulled image through a container created, but never started,
+// from the image, using the container archive API of the Docker daemon.
+type containerFS struct {
+	containerID string
+	// files holds the contents of the files read by ReadDir
+	files map[string][]byte
+}
+
+// newContainerFS creates a container from an image to read its files. Close removes the container.
+func newContainerFS(imageID ImageIDType) (fs *containerFS, e error) {
+	var container Container
+	container.Image = string(imageID)
+	// the container is never started, so the command doesn't matter, but Docker requires one
+	container.Cmd = []string{"/bin/true"}
+	container.Labels = scanLabels()
+	jsonString, e := json.Marshal(container)
+	if e != nil {
+		return
+	}
+	containerID, e := CreateContainer(jsonString)
+	if e != nil {
+		return
+	}
+	fs = &containerFS{containerID: containerID, files: make(map[string][]byte)}
+	return
+}
+
+// Close removes the container.
+func (fs *containerFS) Close() {
+	RemoveContainer(fs.containerID)
+}
+
+// archive returns the tar archive of a file or directory in the container.
+func (fs *containerFS) archive(name string) (tr *tar.Reader, e error) {
+	resp, e := DockerAPI(DockerClient, "GET", "/containers/"+fs.containerID+"/archive?path="+
+		url.QueryEscape(name), []byte{}, "")
+	if e != nil {
+		if strings.Contains(e.Error(), "status code: 404") {
+			e = &os.PathError{Op: "archive", Path: name, Err: os.ErrNotExist}
+		}
+		return
+	}
+	tr = tar.NewReader(bytes.NewReader(resp))
+	return
+}
+
+// ReadFile reads a file of the image, following symbolic links.
+func (fs *containerFS) ReadFile(name string) (data []byte, e error) {
+	if data, ok := fs.files[path.Clean(name)]; ok {
+		return data, nil
+	}
+	for links := 0; links <= maxArchiveSymlinks; links++ {
+		tr, err := fs.archive(name)
+		if err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			if path.IsAbs(hdr.Linkname) {
+				name = hdr.Linkname
+			} else {
+				name = path.Join(path.Dir(name), hdr.Linkname)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			return ioutil.ReadAll(tr)
+		default:
+			return nil, errors.New(name + " is not a regular file")
+		}
+	}
+	return nil, errors.New("Too many levels of symbolic links in " + name)
+}
+
+// ReadDir returns the names of the regular files in a directory of the image.
+// The file contents are kept, so that reading them does not need another archive request.
+func (fs *containerFS) ReadDir(name string) (names []string, e error) {
+	tr, e := fs.archive(name)
+	if e != nil {
+		return
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// entries are named after the base name of the archived directory, e.g., status.d/libc6
+		parts := strings.Split(strings.Trim(hdr.Name, "/"), "/")
+		if len(parts) != 2 || hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, parts[1])
+		fs.files[path.Join(path.Clean(name), parts[1])] = data
+	}
+	return
+}
+
+// getPkgData reads the Linux distribution name and the installed packages of an image.
+func getPkgData(imageID ImageIDType, fs pkgdb.FS) (imageDataInfo []ImageDataInfo, err error) {
+	osRelease, err := readOSRelease(fs)
+	if err != nil {
+		except.Error(err, ": Error in reading distribution of image", string(imageID))
+		return
+	}
+	distroName := osRelease.PrettyName
+	distroID := osRelease.DistroID()
+	pkgs, managers, err := pkgdb.Installed(fs)
+	if err != nil {
+		except.Error(err, ": Error in reading package database of image", string(imageID))
+		return
+	}
+	blog.Info("Image %s distro %s (%s): %d packages found in %v databases", string(imageID), distroName,
+		distroID, len(pkgs), managers)
+
+	for _, pkg := range pkgs {
+		var imageData ImageDataInfo
+		imageData.DistroName = distroName
+		imageData.DistroID = distroID
+		imageData.Image = string(imageID)
+		imageData.Pkg = pkg.Name
+		imageData.Version = pkg.Version
+		imageData.Architecture = pkg.Architecture
+		imageData.Origin = pkg.Origin
+		imageDataInfo = append(imageDataInfo, imageData)
+	}
+	// if no package info was found, return a single entry with empty string in the package info fields.
+	if len(pkgs) == 0 {
+		var imageData ImageDataInfo
+		imageData.DistroName = distroName
+		imageData.DistroID = distroID
+		imageData.Image = string(imageID)
+		imageDataInfo = append(imageDataInfo, imageData)
+	}
+	return
+}
+
+// getImagePkgData reads the package data of an image, from its root filesystem if rootfs
+// is not empty, or else from a container created from the pulled image.
+func getImagePkgData(imageID ImageIDType, rootfs string) (imageDataInfo []ImageDataInfo, err error) {
+	if rootfs != "" {
+		return getPkgData(imageID, rootfsFS(filepath.Clean(rootfs)))
+	}
+	fs, err := newContainerFS(imageID)
+	if err != nil {
+		except.Error(err, ": Error in creating container to read packages of image", string(imageID))
+		return
+	}
+	defer fs.Close()
+	return getPkgData(imageID, fs)
+}
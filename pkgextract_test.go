@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetPkgDataRootfs(t *testing.T) {
+	if e := LoadDistroMap("data/distromap.yaml"); e != nil {
+		t.Fatal(e)
+	}
+	rootfs, e := ioutil.TempDir("", "collector-test-")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(rootfs)
+	files := map[string]string{
+		"usr/lib/os-release":                  "NAME=\"Debian GNU/Linux\"\nPRETTY_NAME=\"Debian GNU/Linux 8 (jessie)\"\n",
+		"var/lib/dpkg/status.d/libc6":         "Package: libc6\nVersion: 2.19-18+deb8u10\nArchitecture: amd64\n",
+		"var/lib/dpkg/status.d/libc6.md5sums": "",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(rootfs, name)), 0755)
+		if e = ioutil.WriteFile(filepath.Join(rootfs, name), []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	os.MkdirAll(filepath.Join(rootfs, "etc"), 0755)
+	// a symlink that would point outside rootfs if followed on the host
+	os.Symlink("/usr/lib/os-release", filepath.Join(rootfs, "etc/os-release"))
+
+	imageDataInfo, e := getImagePkgData("image1", rootfs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := ImageDataInfo{Image: "image1", DistroName: "Debian GNU/Linux 8 (jessie)", DistroID: "DEBIAN-jessie",
+		Pkg: "libc6", Version: "2.19-18+deb8u10", Architecture: "amd64", Origin: "libc6"}
+	if len(imageDataInfo) != 1 || imageDataInfo[0] != expected {
+		t.Fatal("unexpected package data", imageDataInfo)
+	}
+
+	// no release files and no package databases
+	os.RemoveAll(filepath.Join(rootfs, "etc"))
+	os.RemoveAll(filepath.Join(rootfs, "var"))
+	imageDataInfo, e = getImagePkgData("image1", rootfs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(imageDataInfo) != 1 || imageDataInfo[0].DistroName != "Unknown" || imageDataInfo[0].Pkg != "" {
+		t.Fatal("unexpected package data", imageDataInfo)
+	}
+
+	// Alpine image without os-release
+	os.MkdirAll(filepath.Join(rootfs, "etc"), 0755)
+	os.MkdirAll(filepath.Join(rootfs, "lib/apk/db"), 0755)
+	ioutil.WriteFile(filepath.Join(rootfs, "etc/alpine-release"), []byte("3.4.6\n"), 0644)
+	ioutil.WriteFile(filepath.Join(rootfs, "lib/apk/db/installed"),
+		[]byte("P:libcrypto1.0\nV:1.0.2n-r0\nA:x86_64\no:openssl\n\n"), 0644)
+	imageDataInfo, e = getImagePkgData("image1", rootfs)
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected = ImageDataInfo{Image: "image1", DistroName: "Alpine Linux v3.4", DistroID: "ALPINE-3.4",
+		Pkg: "libcrypto1.0", Version: "1.0.2n-r0", Architecture: "x86_64", Origin: "openssl"}
+	if len(imageDataInfo) != 1 || imageDataInfo[0] != expected {
+		t.Fatal("unexpected package data", imageDataInfo)
+	}
+}
@@ -0,0 +1,346 @@
+// policy.go evaluates the images against a declarative policy (see --policy), e.g., to gate
+// deployments on the results of Collector: every scanned image gets a verdict, pass or fail with
+// the rules it violates.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	version "github.com/banyanops/collector/version"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// POLICYVERDICT is the output map key of the policy verdict of an image, as PolicyVerdict.
+	POLICYVERDICT = "policy"
+
+	// Rules of a policy, as reported in violations
+	RuleBannedPackage = "bannedpackages"
+	RuleMinVersion    = "minversions"
+	RuleAllowedDistro = "alloweddistros"
+	RuleMaxAge        = "maxage"
+	RuleRequiredLabel = "requiredlabels"
+	RuleMaxSize       = "maxsize"
+
+	policyVerdictPass = "pass"
+	policyVerdictFail = "fail"
+	// policyUnknown is the subject of the violations of rules whose data is missing
+	policyUnknown = "unknown"
+)
+
+var (
+	PolicyFile = flag.String("policy", "",
+		"YAML file of a policy to evaluate every scanned image against (see docs/CollectorDetails.md)")
+	// CurrentPolicy is the policy loaded from PolicyFile, nil if there is none.
+	CurrentPolicy *Policy
+
+	// imageLabels holds the labels of the image configurations fetched from the registry while
+	// collecting metadata, by image ID, until the policy is evaluated on the images or the next
+	// iteration starts.
+	imageLabels = struct {
+		sync.Mutex
+		labels map[string]map[string]string
+	}{labels: make(map[string]map[string]string)}
+)
+
+// Policy is a set of rules that images must follow. Rules left out are not checked. For example:
+//
+//	bannedpackages: [telnetd, "netcat-*"]
+//	minversions:
+//	  openssl: 3.0.11-1~deb12u2
+//	alloweddistros: [DEBIAN-bookworm, "ALPINE-3.*"]
+//	maxage: 90d
+//	requiredlabels: [org.opencontainers.image.source, "com.example.team=payments"]
+//	maxsize: 500m
+type Policy struct {
+	// BannedPackages are names or glob patterns of packages that images must not have
+	BannedPackages []string `yaml:"bannedpackages"`
+	// MinVersions maps package names to their oldest allowed version, compared with the version
+	// scheme of the distribution; images without the package pass
+	MinVersions map[string]string `yaml:"minversions"`
+	// AllowedDistros are distro IDs or glob patterns of distro IDs, e.g., UBUNTU-jammy or ALPINE-3.*
+	AllowedDistros []string `yaml:"alloweddistros"`
+	// MaxAge is the maximum age of images since their creation, e.g., 90d, 12w or 36h
+	MaxAge string `yaml:"maxage"`
+	// RequiredLabels are labels that images must have, as NAME or NAME=VALUE
+	RequiredLabels []string `yaml:"requiredlabels"`
+	// MaxSize is the maximum size of images, e.g., 500m or 2g
+	MaxSize string `yaml:"maxsize"`
+
+	// Name is the file the policy was loaded from.
+	Name           string `yaml:"-"`
+	bannedPackages []RepoPattern
+	allowedDistros []RepoPattern
+	maxAge         time.Duration
+	maxSize        int64
+}
+
+// PolicyViolation is a rule of a policy that an image violates.
+type PolicyViolation struct {
+	Rule string `json:"rule" yaml:"rule"`
+	// Subject is what violates the rule, e.g., a package, a distro ID or a label
+	Subject string `json:"subject" yaml:"subject"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// PolicyVerdict is the outcome of the evaluation of a policy on an image.
+type PolicyVerdict struct {
+	Policy string `json:"policy" yaml:"policy"`
+	// Verdict is pass or fail
+	Verdict    string            `json:"verdict" yaml:"verdict"`
+	Violations []PolicyViolation `json:"violations" yaml:"violations"`
+	Evaluated  time.Time         `json:"evaluated" yaml:"evaluated"`
+}
+
+// Passed returns true if the image follows the policy.
+func (v PolicyVerdict) Passed() bool {
+	return v.Verdict == policyVerdictPass
+}
+
+// LoadPolicy reads a policy from a YAML file and makes it the CurrentPolicy.
+func LoadPolicy(filename string) (e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
+	}
+	policy, e := ParsePolicy(data)
+	if e != nil {
+		return errors.New(filename + ": " + e.Error())
+	}
+	policy.Name = filename
+	CurrentPolicy = policy
+	blog.Info("Loaded policy from %s: %+v", filename, *policy)
+	return
+}
+
+// ParsePolicy parses and checks a policy in YAML.
+func ParsePolicy(data []byte) (p *Policy, e error) {
+	p = &Policy{}
+	if e = yaml.UnmarshalStrict(data, p); e != nil {
+		return nil, e
+	}
+	for _, spec := range p.BannedPackages {
+		pattern, err := NewRepoPattern(spec)
+		if err != nil {
+			return nil, errors.New("Invalid banned package " + spec + ": " + err.Error())
+		}
+		p.bannedPackages = append(p.bannedPackages, pattern)
+	}
+	for _, spec := range p.AllowedDistros {
+		pattern, err := NewRepoPattern(spec)
+		if err != nil {
+			return nil, errors.New("Invalid allowed distro " + spec + ": " + err.Error())
+		}
+		p.allowedDistros = append(p.allowedDistros, pattern)
+	}
+	for pkg, minVersion := range p.MinVersions {
+		if pkg == "" || minVersion == "" {
+			return nil, errors.New("Invalid minimum version " + minVersion + " of package " + pkg)
+		}
+	}
+	for _, label := range p.RequiredLabels {
+		if strings.HasPrefix(label, "=") || label == "" {
+			return nil, errors.New("Invalid required label " + label)
+		}
+	}
+	if p.MaxAge != "" {
+		if p.maxAge, e = parseAge(p.MaxAge); e != nil {
+			return nil, e
+		}
+	}
+	if p.maxSize, e = parseSize(p.MaxSize); e != nil {
+		return nil, e
+	}
+	return
+}
+
+// needsLabels returns true if evaluating the policy requires the labels of the image.
+func (p *Policy) needsLabels() bool {
+	return len(p.RequiredLabels) > 0
+}
+
+// Evaluate returns the verdict of the policy on the image of metadata, with packages pkgs and
+// labels, at time now. A rule that cannot be checked because the data is missing, e.g., the
+// creation time or the labels of the image, is violated.
+func (p *Policy) Evaluate(metadata ImageMetadataInfo, pkgs []ImageDataInfo, labels map[string]string,
+	now time.Time) (verdict PolicyVerdict) {
+
+	verdict = PolicyVerdict{Policy: p.Name, Violations: []PolicyViolation{}, Evaluated: now}
+	violate := func(rule, subject, format string, args ...interface{}) {
+		verdict.Violations = append(verdict.Violations,
+			PolicyViolation{Rule: rule, Subject: subject, Message: fmt.Sprintf(format, args...)})
+	}
+
+	distroID := ""
+	if len(pkgs) > 0 {
+		distroID = pkgs[0].DistroID
+	}
+	scheme := version.ForDistro(distroID)
+	for _, pkg := range sbomPackages(pkgs) {
+		for _, pattern := range p.bannedPackages {
+			if pattern.matchName(pkg.Pkg) {
+				violate(RuleBannedPackage, pkg.Pkg, "package %s %s is banned (%s)", pkg.Pkg, pkg.Version, pattern.Spec)
+				break
+			}
+		}
+		if minVersion, found := p.MinVersions[pkg.Pkg]; found && version.Less(scheme, pkg.Version, minVersion) {
+			violate(RuleMinVersion, pkg.Pkg, "package %s %s is older than %s", pkg.Pkg, pkg.Version, minVersion)
+		}
+	}
+
+	if len(p.allowedDistros) > 0 {
+		allowed := false
+		for _, pattern := range p.allowedDistros {
+			if distroID != "" && pattern.matchName(distroID) {
+				allowed = true
+				break
+			}
+		}
+		switch {
+		case distroID == "":
+			violate(RuleAllowedDistro, policyUnknown, "unknown distribution")
+		case !allowed:
+			violate(RuleAllowedDistro, distroID, "distribution %s is not allowed", distroID)
+		}
+	}
+
+	if p.maxAge > 0 {
+		switch age := now.Sub(metadata.Datetime); {
+		case metadata.Datetime.IsZero():
+			violate(RuleMaxAge, policyUnknown, "unknown creation time")
+		case age > p.maxAge:
+			violate(RuleMaxAge, metadata.Datetime.UTC().Format(time.RFC3339),
+				"image is %s old, more than %s", age.Truncate(time.Hour), p.MaxAge)
+		}
+	}
+
+	for _, label := range p.RequiredLabels {
+		name, value, hasValue := label, "", false
+		if i := strings.Index(label, "="); i >= 0 {
+			name, value, hasValue = label[:i], label[i+1:], true
+		}
+		actual, found := labels[name]
+		switch {
+		case labels == nil:
+			violate(RuleRequiredLabel, name, "unknown labels, required label %s", name)
+		case !found:
+			violate(RuleRequiredLabel, name, "missing label %s", name)
+		case hasValue && actual != value:
+			violate(RuleRequiredLabel, name, "label %s is %q, expected %q", name, actual, value)
+		}
+	}
+
+	if p.maxSize > 0 {
+		switch {
+		case metadata.Size == 0:
+			violate(RuleMaxSize, policyUnknown, "unknown size")
+		case metadata.Size > uint64(p.maxSize):
+			violate(RuleMaxSize, fmt.Sprintf("%d", metadata.Size), "image size %d is more than %s",
+				metadata.Size, p.MaxSize)
+		}
+	}
+
+	sort.SliceStable(verdict.Violations, func(i, j int) bool {
+		return verdict.Violations[i].Rule < verdict.Violations[j].Rule
+	})
+	verdict.Verdict = policyVerdictPass
+	if len(verdict.Violations) > 0 {
+		verdict.Verdict = policyVerdictFail
+	}
+	return
+}
+
+// ApplyPolicy evaluates the CurrentPolicy, if any, on the image of metadata with image data outMap,
+// as returned by GetImageData or GetImageDataFromRegistry, and adds the verdict to outMap.
+// The image must still be available, on the Docker host or in the registry, if the policy needs
+// its labels.
+func ApplyPolicy(metadata ImageMetadataInfo, outMap map[string]interface{}) {
+	if CurrentPolicy == nil || outMap == nil {
+		return
+	}
+	pkgs, _ := outMap[PKGEXTRACTSCRIPT].([]ImageDataInfo)
+	var labels map[string]string
+	if CurrentPolicy.needsLabels() {
+		var err error
+		if labels, err = ImageLabels(metadata); err != nil {
+			blog.Warn("Failed to get the labels of image %s: %v", metadata.Image, err)
+			labels = nil
+		}
+	}
+	verdict := CurrentPolicy.Evaluate(metadata, pkgs, labels, time.Now().UTC())
+	if !verdict.Passed() {
+		blog.Info("Image %s (%s:%s) violates policy %s: %d violations", metadata.Image, metadata.Repo,
+			metadata.Tag, CurrentPolicy.Name, len(verdict.Violations))
+	}
+	outMap[POLICYVERDICT] = verdict
+	imageLabels.Lock()
+	delete(imageLabels.labels, metadata.Image)
+	imageLabels.Unlock()
+}
+
+// ForgetImageLabels forgets the labels kept from the image configurations fetched so far, e.g.,
+// those of images that were left out of the scans. It is called before every iteration.
+func ForgetImageLabels() {
+	imageLabels.Lock()
+	imageLabels.labels = make(map[string]map[string]string)
+	imageLabels.Unlock()
+}
+
+// keepImageLabels records the labels of the image configuration of imageID, as fetched from the
+// registry, if the CurrentPolicy needs them.
+func keepImageLabels(imageID string, labels map[string]string) {
+	if CurrentPolicy == nil || !CurrentPolicy.needsLabels() || imageID == "" {
+		return
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	imageLabels.Lock()
+	imageLabels.labels[imageID] = labels
+	imageLabels.Unlock()
+}
+
+// ImageLabels returns the labels of the image of metadata: those of its image configuration
+// fetched while collecting its metadata from the registry, or else from the Docker host, or in
+// daemonless mode from the registry. An image without labels has an empty map.
+func ImageLabels(metadata ImageMetadataInfo) (labels map[string]string, e error) {
+	imageLabels.Lock()
+	labels, found := imageLabels.labels[metadata.Image]
+	imageLabels.Unlock()
+	var config ImageConfigV2
+	switch {
+	case found:
+		return
+	case *Daemonless:
+		if metadata.Image == "" {
+			return nil, errors.New("No image configuration for " + metadata.Repo + ":" + metadata.Tag)
+		}
+		// the metadata wasn't collected by this process, e.g., when an iteration resumes after a restart;
+		// the image ID is the digest of the image configuration blob
+		if config, e = v2GetImageConfig(registryClient(), metadata.Repo, metadata.Image); e != nil {
+			return
+		}
+	default:
+		var response []byte
+		if response, e = InspectImage(metadata.Image); e != nil {
+			return
+		}
+		if e = json.Unmarshal(response, &config); e != nil {
+			return
+		}
+	}
+	labels = config.Config.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		policy string
+		valid  bool
+	}{
+		{"", true},
+		{"bannedpackages: [telnetd, \"netcat-*\"]\nmaxage: 90d\nmaxsize: 500m", true},
+		{"alloweddistros: [\"re:^UBUNTU-(jammy|noble)$\"]\nrequiredlabels: [maintainer, team=payments]", true},
+		{"minversions: {openssl: 3.0.11-1~deb12u2}", true},
+		{"bannedpackages: [\"netcat-[\"]", false},
+		{"maxage: forever", false},
+		{"maxsize: -1", false},
+		{"requiredlabels: [\"=x\"]", false},
+		{"minversions: {openssl: \"\"}", false},
+		{"maxsizes: 1g", false},
+	}
+	for _, c := range cases {
+		_, err := ParsePolicy([]byte(c.policy))
+		if (err == nil) != c.valid {
+			t.Fatal("Policy", c.policy, "expected valid", c.valid, "got error", err)
+		}
+	}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	metadata := ImageMetadataInfo{Image: "sha256:abc", Datetime: now.Add(-30 * 24 * time.Hour)}
+	metadata.Repo, metadata.Tag, metadata.Size = "team/app", "1.0", 100<<20
+	pkgs := []ImageDataInfo{
+		{DistroID: "DEBIAN-bookworm", Pkg: "openssl", Version: "3.0.11-1~deb12u1", Architecture: "amd64"},
+		{DistroID: "DEBIAN-bookworm", Pkg: "netcat-openbsd", Version: "1.219-1", Architecture: "amd64"},
+		{DistroID: "DEBIAN-bookworm", Pkg: "bash", Version: "5.2.15-2+b2", Architecture: "amd64"},
+	}
+	labels := map[string]string{"maintainer": "ops", "team": "search"}
+	cases := []struct {
+		policy   string
+		metadata ImageMetadataInfo
+		labels   map[string]string
+		// violated are the rules violated, in order
+		violated []string
+	}{
+		{"", metadata, labels, nil},
+		{"bannedpackages: [telnetd, \"netcat-*\"]", metadata, labels, []string{RuleBannedPackage}},
+		// 3.0.11-1~deb12u1 < 3.0.11-1~deb12u2 < 3.0.11-1
+		{"minversions: {openssl: 3.0.11-1~deb12u2, bash: 5.2.15-2}", metadata, labels, []string{RuleMinVersion}},
+		{"minversions: {openssl: 3.0.11-1~deb12u1, curl: 8.0.0-1}", metadata, labels, nil},
+		{"alloweddistros: [\"DEBIAN-*\"]", metadata, labels, nil},
+		{"alloweddistros: [UBUNTU-jammy, \"ALPINE-3.*\"]", metadata, labels, []string{RuleAllowedDistro}},
+		{"maxage: 60d", metadata, labels, nil},
+		{"maxage: 4w", metadata, labels, []string{RuleMaxAge}},
+		{"maxage: 4w", ImageMetadataInfo{Image: "sha256:abc"}, labels, []string{RuleMaxAge}},
+		{"requiredlabels: [maintainer, team=search]", metadata, labels, nil},
+		{"requiredlabels: [maintainer, team=payments, org.opencontainers.image.source]", metadata, labels,
+			[]string{RuleRequiredLabel, RuleRequiredLabel}},
+		{"requiredlabels: [maintainer]", metadata, nil, []string{RuleRequiredLabel}},
+		{"maxsize: 100m", metadata, labels, nil},
+		{"maxsize: 99m", metadata, labels, []string{RuleMaxSize}},
+		{"maxsize: 1g\nmaxage: 1d\nbannedpackages: [bash]", metadata, labels,
+			[]string{RuleBannedPackage, RuleMaxAge}},
+	}
+	for _, c := range cases {
+		p, err := ParsePolicy([]byte(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verdict := p.Evaluate(c.metadata, pkgs, c.labels, now)
+		violated := []string{}
+		for _, v := range verdict.Violations {
+			violated = append(violated, v.Rule)
+		}
+		if strings.Join(violated, ",") != strings.Join(c.violated, ",") || verdict.Passed() != (len(c.violated) == 0) {
+			t.Fatal("Policy", c.policy, "expected violations", c.violated, "got", verdict)
+		}
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	f, err := ioutil.TempFile("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("bannedpackages: [telnetd]\n")
+	f.Close()
+
+	outMap := map[string]interface{}{PKGEXTRACTSCRIPT: []ImageDataInfo{
+		{DistroID: "UBUNTU-jammy", Pkg: "telnetd", Version: "0.17-44build1", Architecture: "amd64"}}}
+	ApplyPolicy(ImageMetadataInfo{Image: "sha256:abc"}, outMap)
+	if _, found := outMap[POLICYVERDICT]; found {
+		t.Fatal("Expected no verdict without a policy")
+	}
+	if err := LoadPolicy(f.Name() + ".missing"); err == nil {
+		t.Fatal("Expected an error for a missing policy file")
+	}
+	if err := LoadPolicy(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { CurrentPolicy = nil }()
+	ApplyPolicy(ImageMetadataInfo{Image: "sha256:abc"}, outMap)
+	verdict, ok := outMap[POLICYVERDICT].(PolicyVerdict)
+	if !ok || verdict.Passed() || verdict.Policy != f.Name() || len(verdict.Violations) != 1 ||
+		verdict.Violations[0].Subject != "telnetd" {
+		t.Fatal("Unexpected verdict", outMap[POLICYVERDICT])
+	}
+
+	report := NewScanReport("app:1.0", ImageMetadataInfo{Image: "sha256:abc"}, outMap)
+	if !report.PolicyFailed() {
+		t.Fatal("Expected the scan to fail the policy")
+	}
+	var b bytes.Buffer
+	if err := WriteScanReport(&b, report, ReportText); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(b.String())
+	for _, s := range []string{"Policy:", "fail", "bannedpackages", "telnetd"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatal("Missing", s, "in summary")
+		}
+	}
+}
+
+func TestImageLabels(t *testing.T) {
+	policy, err := ParsePolicy([]byte("requiredlabels: [team=payments]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CurrentPolicy = policy
+	defer func() { CurrentPolicy = nil }()
+	*Daemonless = true
+	defer func() { *Daemonless = false }()
+
+	config := `{"created":"2016-03-01T10:20:30Z","config":{"Labels":{"team":"payments"}}}`
+	configDigest := contentDigest([]byte(config))
+	ts := newTestRegistry(map[string]testContent{
+		"/v2/test/app/manifests/1.0":         {MediaTypeManifestV2Schema2, testSchema2Manifest(config)},
+		"/v2/test/app/blobs/" + configDigest: {"", config},
+	})
+	metadataSlice, err := v2GetMetadata(registryClient(), "test/app", "1.0")
+	// the labels come from the image configuration fetched with the metadata
+	ts.Close()
+	if err != nil || len(metadataSlice) != 1 {
+		t.Fatal("Unexpected metadata", metadataSlice, err)
+	}
+	labels, err := ImageLabels(metadataSlice[0])
+	if err != nil || labels["team"] != "payments" {
+		t.Fatal("Unexpected labels", labels, err)
+	}
+	outMap := map[string]interface{}{PKGEXTRACTSCRIPT: []ImageDataInfo{}}
+	ApplyPolicy(metadataSlice[0], outMap)
+	if verdict, ok := outMap[POLICYVERDICT].(PolicyVerdict); !ok || !verdict.Passed() {
+		t.Fatal("Unexpected verdict", outMap[POLICYVERDICT])
+	}
+	// once the policy is evaluated, the labels are forgotten, and fetching them fails without the registry
+	if labels, err = ImageLabels(metadataSlice[0]); err == nil {
+		t.Fatal("Expected an error, got labels", labels)
+	}
+
+	// the labels of images that are never scanned are forgotten at the next iteration
+	keepImageLabels("sha256:unscanned", map[string]string{"team": "payments"})
+	ForgetImageLabels()
+	if labels, err = ImageLabels(ImageMetadataInfo{Image: "sha256:unscanned"}); err == nil {
+		t.Fatal("Expected an error, got labels", labels)
+	}
+}
@@ -0,0 +1,162 @@
+// quarantine.go keeps track of the images that fail to be pulled or scanned. A failed image is retried
+// with exponential backoff, and quarantined after --maxfailures failures in a row, until it is
+// released with the quarantine command.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	except "github.com/banyanops/collector/except"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// Stages at which an image can fail
+	FailurePull = "pull"
+	FailureScan = "scan"
+
+	// stateFailures is the bucket of the failure records in the state store
+	stateFailures = "failures"
+)
+
+var (
+	MaxFailures = flag.Int("maxfailures", 5,
+		"Number of failures in a row to pull or scan an image after which it is quarantined (0 for never)")
+	RetryBackoff = flag.Duration("retrybackoff", 5*time.Minute,
+		"Time before retrying an image that failed to be pulled or scanned, doubled after every further failure")
+	MaxRetryBackoff = flag.Duration("maxretrybackoff", 24*time.Hour,
+		"Maximum time before retrying an image that failed to be pulled or scanned")
+)
+
+// FailureRecord tracks the failures in a row of an image.
+type FailureRecord struct {
+	// Key identifies the repo:tag (and platform) of the image
+	Key      string
+	Metadata ImageMetadataInfo
+	Failures int
+	// Stage is where the last failure happened: pull or scan
+	Stage        string
+	LastError    string
+	FirstFailure time.Time
+	LastFailure  time.Time
+	// NextRetry is the earliest time at which the image is tried again, unless it is quarantined
+	NextRetry   time.Time
+	Quarantined bool
+}
+
+// retryBackoff returns the time to wait before retrying after failures failures in a row.
+func retryBackoff(failures int) time.Duration {
+	backoff := *RetryBackoff
+	for i := 1; i < failures && backoff < *MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > *MaxRetryBackoff {
+		backoff = *MaxRetryBackoff
+	}
+	return backoff
+}
+
+// RecordFailure records a failure to pull or scan the image of metadata, and returns the updated
+// failure record.
+func RecordFailure(metadata ImageMetadataInfo, stage string, failure error) (r FailureRecord) {
+	key := metadataKey(metadata)
+	now := time.Now()
+	if found, err := State.Get(stateFailures, key, &r); err != nil || !found {
+		r = FailureRecord{Key: key, FirstFailure: now}
+	}
+	r.Metadata = metadata
+	r.Failures++
+	r.Stage = stage
+	r.LastError = failure.Error()
+	r.LastFailure = now
+	r.NextRetry = now.Add(retryBackoff(r.Failures))
+	if *MaxFailures > 0 && r.Failures >= *MaxFailures {
+		if !r.Quarantined {
+			except.Warn("Quarantining %s after %d failures in a row; last %s error: %s", key, r.Failures,
+				stage, r.LastError)
+		}
+		r.Quarantined = true
+	} else {
+		blog.Info("Failure %d to %s %s; retrying after %s", r.Failures, stage, key, r.NextRetry.String())
+	}
+	if err := State.Put(stateFailures, key, r); err != nil {
+		except.Error(err, ": Error in recording failure of", key)
+	}
+	return
+}
+
+// ClearFailures forgets the failures of the image of metadata, after it was pulled and scanned.
+func ClearFailures(metadata ImageMetadataInfo) {
+	if err := State.Delete(stateFailures, metadataKey(metadata)); err != nil {
+		except.Error(err, ": Error in clearing failures of", metadataKey(metadata))
+	}
+}
+
+// retryDeferred returns the failure record of metadata, and true if the image is not to be tried
+// at time now because it is quarantined or backing off.
+func retryDeferred(metadata ImageMetadataInfo, now time.Time) (r FailureRecord, deferred bool) {
+	found, err := State.Get(stateFailures, metadataKey(metadata), &r)
+	if err != nil {
+		except.Error(err, ": Error in reading failures of", metadataKey(metadata))
+		return r, false
+	}
+	return r, found && (r.Quarantined || now.Before(r.NextRetry))
+}
+
+// DeferFailedMetadata leaves out the metadata of images that are quarantined or backing off after
+// failures. They are also removed from currentMetadataSet, so that they are discovered again
+// in the next iterations, until they are tried again.
+func DeferFailedMetadata(metadataSlice []ImageMetadataInfo, currentMetadataSet MetadataSet,
+	now time.Time) (admitted []ImageMetadataInfo) {
+
+	quarantined, backingOff := 0, 0
+	for _, metadata := range metadataSlice {
+		r, deferred := retryDeferred(metadata, now)
+		if !deferred {
+			admitted = append(admitted, metadata)
+			continue
+		}
+		currentMetadataSet.Delete(metadata)
+		if r.Quarantined {
+			quarantined++
+		} else {
+			backingOff++
+		}
+	}
+	if quarantined+backingOff > 0 {
+		blog.Info("Skipping %d quarantined images and %d images backing off after failures", quarantined, backingOff)
+	}
+	return
+}
+
+// ListFailures returns the failure records of the images that failed in a row, by key.
+func ListFailures() (records []FailureRecord, e error) {
+	e = State.ForEach(stateFailures, func(key string, value []byte) error {
+		var r FailureRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return errors.New(key + ": " + err.Error())
+		}
+		records = append(records, r)
+		return nil
+	})
+	return
+}
+
+// ReleaseFailures forgets the failures of the images with the given keys, so that they are tried
+// again in the next iteration.
+func ReleaseFailures(keys []string) (e error) {
+	for _, key := range keys {
+		if !State.Exists(stateFailures, key) {
+			return errors.New("No failure record for " + key)
+		}
+	}
+	for _, key := range keys {
+		if e = State.Delete(stateFailures, key); e != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	cases := []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{1, *RetryBackoff},
+		{2, 2 * *RetryBackoff},
+		{4, 8 * *RetryBackoff},
+		{100, *MaxRetryBackoff},
+	}
+	for _, c := range cases {
+		if b := retryBackoff(c.failures); b != c.backoff {
+			t.Fatal(c.failures, "failures: expected", c.backoff, "got", b)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := openTestState(t)
+	defer closeTestState(dir)
+	saved := *MaxFailures
+	*MaxFailures = 3
+	defer func() { *MaxFailures = saved }()
+
+	bad := ImageMetadataInfo{OtherMetadata: OtherMetadata{Repo: "team/bad", Tag: "v1"}}
+	good := ImageMetadataInfo{OtherMetadata: OtherMetadata{Repo: "team/good", Tag: "v1"}}
+	r := RecordFailure(bad, FailurePull, errors.New("manifest unknown"))
+	if r.Failures != 1 || r.Quarantined || r.Key != metadataKey(bad) {
+		t.Fatal("Unexpected failure record", r)
+	}
+	now := time.Now()
+	set := NewMetadataSet()
+	set.Insert(bad)
+	set.Insert(good)
+	admitted := DeferFailedMetadata([]ImageMetadataInfo{bad, good}, set, now)
+	if len(admitted) != 1 || admitted[0] != good || set.Exists(bad) {
+		t.Fatal("Expected the failed image to back off", admitted, set)
+	}
+	// retried after the backoff
+	if admitted := DeferFailedMetadata([]ImageMetadataInfo{bad}, set, now.Add(*RetryBackoff+time.Second)); len(admitted) != 1 {
+		t.Fatal("Expected the failed image to be retried after its backoff")
+	}
+	RecordFailure(bad, FailureScan, errors.New("scripts failed"))
+	r = RecordFailure(bad, FailureScan, errors.New("scripts failed"))
+	if r.Failures != 3 || !r.Quarantined || r.Stage != FailureScan {
+		t.Fatal("Expected the image to be quarantined", r)
+	}
+	if admitted := DeferFailedMetadata([]ImageMetadataInfo{bad}, set, now.Add(365*24*time.Hour)); len(admitted) != 0 {
+		t.Fatal("Expected the quarantined image to be skipped")
+	}
+
+	records, err := ListFailures()
+	if err != nil || len(records) != 1 {
+		t.Fatal("Unexpected failure records", records, err)
+	}
+	if err := ReleaseFailures([]string{metadataKey(good)}); err == nil {
+		t.Fatal("Expected an error in releasing an image without failures")
+	}
+	if err := ReleaseFailures([]string{r.Key}); err != nil {
+		t.Fatal(err)
+	}
+	if admitted := DeferFailedMetadata([]ImageMetadataInfo{bad}, set, now); len(admitted) != 1 {
+		t.Fatal("Expected the released image to be tried")
+	}
+
+	// success clears the failures
+	RecordFailure(good, FailurePull, errors.New("timeout"))
+	ClearFailures(good)
+	if records, _ := ListFailures(); len(records) != 0 {
+		t.Fatal("Unexpected failure records", records)
+	}
+}
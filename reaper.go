@@ -0,0 +1,228 @@
+// reaper.go removes the scan containers and pulled images that Collector leaked, e.g., by crashing
+// between the creation and the removal of a container, or while images were retained on the Docker host.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	blog "github.com/ccpaging/log4go"
+	"github.com/pborman/uuid"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// ScanContainerLabel marks the containers created by Collector. Its value is the InstanceID
+	// of the Collector process that created the container.
+	ScanContainerLabel = "com.banyanops.collector.scan"
+)
+
+var (
+	ReapInterval = flag.Duration("reapinterval", time.Hour,
+		"Interval between removals of orphaned scan containers and pulled images, besides the one at startup (0 for none)")
+	ReapAge = flag.Duration("reapage", time.Hour,
+		"Age after which scan containers of other Collector processes are considered orphaned")
+
+	// InstanceID identifies this Collector process in the labels of its containers.
+	InstanceID = uuid.New()
+
+	// activeContainers holds the containers of this process that haven't been removed yet.
+	activeContainers = struct {
+		sync.Mutex
+		ids map[string]bool
+	}{ids: make(map[string]bool)}
+)
+
+// PulledImage is an image pulled by Collector, recorded in the state store until it is removed.
+type PulledImage struct {
+	Image string
+	// Repo is the repository in the registry, and LocalRepo the name of the repository on the
+	// Docker host, which includes the registry unless it is Docker Hub
+	Repo      string
+	LocalRepo string
+	Tag       string
+	// Platform is the platform pulled from a multi-architecture repo:tag, if any
+	Platform string
+	Pulled   time.Time
+}
+
+// scanLabels returns the labels of the containers created by this process.
+func scanLabels() map[string]string {
+	return map[string]string{ScanContainerLabel: InstanceID}
+}
+
+// registerContainer records that a container of this process exists.
+func registerContainer(containerID string, active bool) {
+	activeContainers.Lock()
+	defer activeContainers.Unlock()
+	if active {
+		activeContainers.ids[containerID] = true
+	} else {
+		delete(activeContainers.ids, containerID)
+	}
+}
+
+// isOrphanContainer returns true if c is a scan container that is no longer in use: either a
+// container of this process that wasn't removed, or one created more than ReapAge ago
+// by another Collector process, which probably crashed.
+func isOrphanContainer(c ContainerSummary, now time.Time) bool {
+	instance, labeled := c.Labels[ScanContainerLabel]
+	named := false
+	for _, name := range c.Names {
+		if strings.HasPrefix(strings.TrimPrefix(name, "/"), ScanContainerNamePrefix) {
+			named = true
+		}
+	}
+	if !labeled && !named {
+		return false
+	}
+	activeContainers.Lock()
+	active := activeContainers.ids[c.Id]
+	activeContainers.Unlock()
+	if active {
+		return false
+	}
+	if instance == InstanceID {
+		return true
+	}
+	return now.Sub(time.Unix(c.Created, 0)) > *ReapAge
+}
+
+// ReapContainers removes the orphaned scan containers (see isOrphanContainer).
+func ReapContainers() {
+	containers, err := ListContainers()
+	if err != nil {
+		except.Error(err, ": Error in listing containers to reap")
+		return
+	}
+	now := time.Now()
+	for _, c := range containers {
+		if !isOrphanContainer(c, now) {
+			continue
+		}
+		blog.Info("Removing orphaned scan container %s %v (%s)", c.Id, c.Names, c.State)
+		if _, err := ForceRemoveContainer(c.Id); err != nil {
+			except.Error(err, ": Error in removing orphaned container", c.Id)
+		}
+	}
+}
+
+// pulledImageKey returns the key of a pulled image in the state store, which is the repo:tag
+// (and platform) it was pulled as.
+func pulledImageKey(image PulledImage) string {
+	key := image.LocalRepo + ":" + image.Tag
+	if image.Platform != "" {
+		key += "@" + image.Platform
+	}
+	return key
+}
+
+// loadPulledImages returns the images pulled by Collector that are still on the Docker host.
+func loadPulledImages() (images []PulledImage, e error) {
+	e = State.ForEach(statePulledImages, func(key string, value []byte) error {
+		var image PulledImage
+		if err := json.Unmarshal(value, &image); err != nil {
+			return errors.New("pulled image " + key + ": " + err.Error())
+		}
+		images = append(images, image)
+		return nil
+	})
+	return
+}
+
+// trackPulledImage records that Collector pulled an image, so that it is removed even if
+// Collector restarts before removing it.
+func trackPulledImage(image PulledImage) {
+	if State == nil {
+		// no state store, e.g., in tests
+		return
+	}
+	if err := State.Put(statePulledImages, pulledImageKey(image), image); err != nil {
+		except.Error(err, ": Error in recording pulled image", pulledImageKey(image))
+	}
+}
+
+// untrackPulledImages forgets the images pulled by Collector that have an ID in imageIDs.
+func untrackPulledImages(imageIDs ImageSet) {
+	if State == nil {
+		return
+	}
+	images, err := loadPulledImages()
+	if err != nil {
+		except.Error(err, ": Error in reading pulled images")
+		return
+	}
+	for _, image := range images {
+		if !imageIDs.Exists(ImageIDType(image.Image)) {
+			continue
+		}
+		if err := State.Delete(statePulledImages, pulledImageKey(image)); err != nil {
+			except.Error(err, ": Error in forgetting pulled image", pulledImageKey(image))
+		}
+	}
+}
+
+// ReapImages removes the images pulled by Collector that are not in retained, i.e., those that
+// were left behind by a previous Collector process. Only the repo:tag that Collector pulled is
+// removed, so an image that has other tags stays on the Docker host. Images of excluded
+// repositories, and repo:tags that now refer to another image, are left alone.
+func ReapImages(retained []ImageMetadataInfo) {
+	keep := NewImageSet()
+	for _, metadata := range retained {
+		keep.Insert(ImageIDType(metadata.Image))
+	}
+	images, err := loadPulledImages()
+	if err != nil {
+		except.Error(err, ": Error in reading pulled images")
+		return
+	}
+	if len(images) == 0 {
+		return
+	}
+	imageMap, err := GetLocalImages(false, false)
+	if err != nil {
+		except.Error(err, ": Error in listing local images to reap")
+		return
+	}
+	done := NewImageSet()
+	for _, image := range images {
+		imageID := ImageIDType(image.Image)
+		if keep.Exists(imageID) {
+			continue
+		}
+		localID, err := imageMap.Image(RepoType(image.LocalRepo), TagType(image.Tag))
+		if err != nil || localID != imageID {
+			blog.Info("Pulled image %s:%s is gone or was replaced; forgetting it", image.LocalRepo, image.Tag)
+			done.Insert(imageID)
+			continue
+		}
+		if ExcludeRepo[RepoType(image.Repo)] || ExcludeRepo[RepoType(image.LocalRepo)] {
+			done.Insert(imageID)
+			continue
+		}
+		apipath := "/images/" + image.LocalRepo + ":" + image.Tag
+		blog.Info("Removing orphaned pulled image %s (%s)", apipath, image.Image)
+		if _, err := DockerAPI(DockerClient, "DELETE", apipath, []byte{}, ""); err != nil {
+			// maybe in use by a container; try again next time
+			except.Error(err, ": Error in removing orphaned image", apipath)
+			continue
+		}
+		done.Insert(imageID)
+	}
+	if len(done) > 0 {
+		untrackPulledImages(done)
+	}
+}
+
+// Reap removes the orphaned scan containers, and then the orphaned pulled images, keeping the
+// images in retained.
+func Reap(retained []ImageMetadataInfo) {
+	config.BanyanUpdate("Removing orphaned scan containers and images")
+	ReapContainers()
+	ReapImages(retained)
+}
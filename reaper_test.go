@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsOrphanContainer(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Minute).Unix()
+	old := now.Add(-*ReapAge - time.Minute).Unix()
+	registerContainer("active", true)
+	defer registerContainer("active", false)
+	ours := map[string]string{ScanContainerLabel: InstanceID}
+	theirs := map[string]string{ScanContainerLabel: "another-instance"}
+	cases := []struct {
+		c      ContainerSummary
+		orphan bool
+	}{
+		{ContainerSummary{Id: "active", Labels: ours, Created: old}, false},
+		{ContainerSummary{Id: "leaked", Labels: ours, Created: recent}, true},
+		{ContainerSummary{Id: "other", Labels: theirs, Created: recent}, false},
+		{ContainerSummary{Id: "crashed", Labels: theirs, Created: old}, true},
+		{ContainerSummary{Id: "unlabeled", Names: []string{"/" + ScanContainerNamePrefix + "x"}, Created: old}, true},
+		{ContainerSummary{Id: "unlabeledrecent", Names: []string{"/" + ScanContainerNamePrefix + "x"}, Created: recent}, false},
+		{ContainerSummary{Id: "user", Names: []string{"/web"}, Created: old}, false},
+		{ContainerSummary{Id: "userlabels", Names: []string{"/web"}, Labels: map[string]string{"app": "web"}, Created: old}, false},
+	}
+	for _, c := range cases {
+		if isOrphanContainer(c.c, now) != c.orphan {
+			t.Fatal(c.c.Id, "expected orphan", c.orphan)
+		}
+	}
+}
+
+func TestTrackPulledImages(t *testing.T) {
+	dir := openTestState(t)
+	defer closeTestState(dir)
+
+	trackPulledImage(PulledImage{Image: "a", Repo: "library/ubuntu", LocalRepo: "ubuntu", Tag: "14.04"})
+	trackPulledImage(PulledImage{Image: "b", Repo: "team/app", LocalRepo: "reg.io/team/app", Tag: "v1"})
+	// the repo:tag now refers to another image
+	trackPulledImage(PulledImage{Image: "c", Repo: "library/ubuntu", LocalRepo: "ubuntu", Tag: "14.04"})
+	// another platform of a multi-architecture repo:tag is a separate image
+	trackPulledImage(PulledImage{Image: "d", Repo: "team/app", LocalRepo: "reg.io/team/app", Tag: "v1",
+		Platform: "linux/arm64"})
+	images, err := loadPulledImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 || images[0].Image != "b" || images[1].Image != "d" || images[2].Image != "c" {
+		t.Fatal("Unexpected pulled images", images)
+	}
+	removed := NewImageSet()
+	removed.Insert("c")
+	removed.Insert("z")
+	untrackPulledImages(removed)
+	images, err = loadPulledImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].Image != "b" || images[1].Image != "d" {
+		t.Fatal("Unexpected pulled images", images)
+	}
+	fmt.Println(images)
+}
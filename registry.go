@@ -2,20 +2,33 @@
 package collector
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	except "github.com/banyanops/collector/except"
 	blog "github.com/ccpaging/log4go"
 )
 
+const (
+	// v2PageSize is the number of entries requested per page from paginated V2 registry APIs.
+	v2PageSize = 100
+)
+
 var (
 	registryRateLimiters limiterSet
+
+	// registryHTTPClient is the client of all registry requests, built on first use, once the
+	// flags are parsed.
+	registryHTTPClient     *http.Client
+	registryHTTPClientOnce sync.Once
 )
 
 type limiterSet struct {
@@ -103,10 +116,25 @@ func (s *HTTPStatusCodeError) Error() string {
 	return "HTTP Status Code " + strconv.Itoa(s.StatusCode)
 }
 
+// registryClient returns the HTTP client shared by registry requests, which doesn't verify the
+// certificate of the registry if --registrytlsnoverify is set.
+func registryClient() *http.Client {
+	registryHTTPClientOnce.Do(func() {
+		if *RegistryTLSNoVerify {
+			tr := &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}
+			registryHTTPClient = &http.Client{Transport: tr}
+		} else {
+			registryHTTPClient = &http.Client{}
+		}
+	})
+	return registryHTTPClient
+}
+
 // RegistryQueryV1 performs an HTTP GET operation from a V1 registry and returns the response.
 func RegistryQueryV1(client *http.Client, URL string) (response []byte, e error) {
 	RegistryLimiterWait()
-	_, _, BasicAuth, XRegistryAuth = GetRegistryURL()
 	req, e := http.NewRequest("GET", URL, nil)
 	if e != nil {
 		return nil, e
@@ -135,30 +163,58 @@ func RegistryQueryV1(client *http.Client, URL string) (response []byte, e error)
 // then we follow the directions in that header to get an access token, and finally
 // re-issue the initial call to get the final response.
 func RegistryQueryV2(client *http.Client, URL string) (response []byte, e error) {
+	response, _, e = RegistryQueryV2Header(client, URL, nil)
+	return
+}
+
+// RegistryQueryV2Header is like RegistryQueryV2, but it also sends the media types listed in accept
+// as Accept headers, and it returns the response headers along with the response body.
+func RegistryQueryV2Header(client *http.Client, URL string, accept []string) (response []byte,
+	header http.Header, e error) {
+	r, e := registryGetV2(client, URL, accept)
+	if e != nil || r == nil {
+		return
+	}
+	defer r.Body.Close()
+	header = r.Header
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		e = &HTTPStatusCodeError{StatusCode: r.StatusCode}
+		return
+	}
+	response, e = ioutil.ReadAll(r.Body)
+	if e != nil {
+		return
+	}
+	blog.Debug("Registry query succeeded")
+	return
+}
+
+// registryGetV2 issues an HTTP GET to a V2 registry, performing token authorization if the
+// registry asks for it, and returns the final response. The caller must close the response body.
+func registryGetV2(client *http.Client, URL string, accept []string) (r *http.Response, e error) {
 	RegistryLimiterWait()
-	_, _, BasicAuth, XRegistryAuth = GetRegistryURL()
-	req, e := http.NewRequest("GET", URL, nil)
+	req, e := newRegistryRequestV2(URL, "Basic "+BasicAuth, accept)
 	if e != nil {
 		return nil, e
 	}
-	req.Header.Set("Authorization", "Basic "+BasicAuth)
-	r, e := client.Do(req)
+	r, e = client.Do(req)
 	if e != nil {
 		return nil, e
 	}
 	if r.StatusCode == 401 {
 		blog.Debug("Registry Query %s got 401", URL)
+		r.Body.Close()
 		// get the WWW-Authenticate header
 		WWWAuth := r.Header.Get("WWW-Authenticate")
 		if WWWAuth == "" {
 			except.Error("Empty WWW-Authenticate", URL)
-			return
+			return nil, nil
 		}
 		arr := strings.Fields(WWWAuth)
 		if len(arr) != 2 {
 			e = errors.New("Invalid WWW-Authenticate format for " + WWWAuth)
 			except.Error(e)
-			return
+			return nil, e
 		}
 		authType := arr[0]
 		blog.Debug("Authorization type: %s", authType)
@@ -166,9 +222,8 @@ func RegistryQueryV2(client *http.Client, URL string) (response []byte, e error)
 		e = parseAuthenticateFields(arr[1], fieldMap)
 		if e != nil {
 			except.Error(e)
-			return
+			return nil, e
 		}
-		r.Body.Close()
 		// access the authentication server to get a token
 		token, err := queryAuthServerV2(client, fieldMap, BasicAuth)
 		if err != nil {
@@ -176,26 +231,99 @@ func RegistryQueryV2(client *http.Client, URL string) (response []byte, e error)
 			return nil, err
 		}
 		// re-issue the original request, this time using the token
-		req, e = http.NewRequest("GET", URL, nil)
+		req, e = newRegistryRequestV2(URL, authType+" "+token, accept)
 		if e != nil {
 			return nil, e
 		}
-		req.Header.Set("Authorization", authType+" "+token)
 		r, e = client.Do(req)
 		if e != nil {
 			return nil, e
 		}
 	}
-	defer r.Body.Close()
-	if r.StatusCode < 200 || r.StatusCode > 299 {
-		e = &HTTPStatusCodeError{StatusCode: r.StatusCode}
+	return
+}
+
+// newRegistryRequestV2 creates a GET request with the given Authorization and Accept headers.
+func newRegistryRequestV2(URL, authorization string, accept []string) (req *http.Request, e error) {
+	req, e = http.NewRequest("GET", URL, nil)
+	if e != nil {
 		return
 	}
-	response, e = ioutil.ReadAll(r.Body)
-	if e != nil {
+	req.Header.Set("Authorization", authorization)
+	for _, mediaType := range accept {
+		req.Header.Add("Accept", mediaType)
+	}
+	return
+}
+
+// v2QueryPages performs an HTTP GET of a paginated V2 registry API, such as the catalog or the
+// tags list. handlePage is called with the body of each page, and pages are followed
+// using the Link: <URL>; rel="next" response header until there are no more.
+func v2QueryPages(client *http.Client, URL string, handlePage func(response []byte) error) (e error) {
+	seen := make(map[string]bool)
+	for URL != "" {
+		if seen[URL] {
+			e = errors.New("Registry pagination loop at " + URL)
+			return
+		}
+		seen[URL] = true
+		response, header, err := RegistryQueryV2Header(client, URL, nil)
+		if err != nil {
+			return err
+		}
+		if e = handlePage(response); e != nil {
+			return
+		}
+		URL, e = nextPageURL(URL, header)
+		if e != nil {
+			return
+		}
+	}
+	return
+}
+
+// nextPageURL returns the URL with rel="next" from the Link header, resolved relative to
+// the URL of the current page, or "" if there is no next page. The next page must be on the
+// same scheme and host as the current one, i.e., RegistryAPIURL.
+func nextPageURL(current string, header http.Header) (next string, e error) {
+	if header == nil {
 		return
 	}
-	blog.Debug("Registry query succeeded")
+	for _, link := range header["Link"] {
+		for _, value := range strings.Split(link, ",") {
+			parts := strings.Split(value, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			isNext := false
+			for _, param := range parts[1:] {
+				param = strings.Replace(strings.TrimSpace(param), `"`, "", -1)
+				if param == "rel=next" {
+					isNext = true
+				}
+			}
+			if !isNext {
+				continue
+			}
+			base, err := url.Parse(current)
+			if err != nil {
+				return "", err
+			}
+			ref, err := url.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return "", err
+			}
+			resolved := base.ResolveReference(ref)
+			if resolved.Scheme != base.Scheme || resolved.Host != base.Host {
+				// the registry credentials must not be sent anywhere else
+				return "", errors.New("Registry next page " + resolved.String() + " is not on " +
+					base.Scheme + "://" + base.Host)
+			}
+			next = resolved.String()
+			return
+		}
+	}
 	return
 }
 
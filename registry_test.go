@@ -1,7 +1,11 @@
 package collector
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -42,3 +46,98 @@ func TestRateLimiter(t *testing.T) {
 
 	return
 }
+
+func TestNextPageURL(t *testing.T) {
+	cases := []struct {
+		link, expected string
+		ok             bool
+	}{
+		{"", "", true},
+		{`</v2/_catalog?last=b&n=2>; rel="next"`, "https://reg.example.com/v2/_catalog?last=b&n=2", true},
+		{`<https://reg.example.com/v2/x/tags/list?last=1>; rel=next`, "https://reg.example.com/v2/x/tags/list?last=1", true},
+		{`<https://other.example.com/v2/x/tags/list?last=1>; rel=next`, "", false},
+		{`<http://reg.example.com/v2/x/tags/list?last=1>; rel=next`, "", false},
+		{`</v2/_catalog?last=a>; rel="prev", </v2/_catalog?last=c>; rel="next"`, "https://reg.example.com/v2/_catalog?last=c", true},
+		{`</v2/_catalog?last=a>; rel="prev"`, "", true},
+	}
+	for _, c := range cases {
+		header := http.Header{}
+		if c.link != "" {
+			header.Set("Link", c.link)
+		}
+		next, e := nextPageURL("https://reg.example.com/v2/_catalog?n=2", header)
+		if (e == nil) != c.ok {
+			t.Fatal(c.link, "error:", e)
+		}
+		if next != c.expected {
+			t.Fatal("link:", c.link, "next:", next, "expected:", c.expected)
+		}
+	}
+}
+
+// newPagingRegistry starts an HTTP server that serves a catalog and the tags of repo test/app,
+// two entries per page.
+func newPagingRegistry() *httptest.Server {
+	pages := map[string][]string{
+		"/v2/_catalog":           {"library/a", "library/b", "team/c", "team/d", "team/e"},
+		"/v2/test/app/tags/list": {"1.0", "1.1", "2.0"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			for i, entry := range entries {
+				if entry == last {
+					start = i + 1
+				}
+			}
+		}
+		end := start + 2
+		if end < len(entries) {
+			w.Header().Set("Link", "<"+r.URL.Path+"?n=2&last="+entries[end-1]+`>; rel="next"`)
+		} else {
+			end = len(entries)
+		}
+		if r.URL.Path == "/v2/_catalog" {
+			json.NewEncoder(w).Encode(V2Catalog{Repositories: entries[start:end]})
+		} else {
+			json.NewEncoder(w).Encode(V2Tag{Name: "test/app", Tags: entries[start:end]})
+		}
+	}))
+	*AuthRegistry = false
+	RegistrySpec = ts.Listener.Addr().String()
+	RegistryAPIURL = ts.URL
+	return ts
+}
+
+func TestV2Catalog(t *testing.T) {
+	ts := newPagingRegistry()
+	defer ts.Close()
+	ExcludeRepo["team/d"] = true
+	defer delete(ExcludeRepo, "team/d")
+	repoSlice, e := v2Catalog(&http.Client{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := []RepoType{"library/a", "library/b", "team/c", "team/e"}
+	if !reflect.DeepEqual(repoSlice, expected) {
+		t.Fatal("catalog:", repoSlice, "expected:", expected)
+	}
+}
+
+func TestV2GetTags(t *testing.T) {
+	ts := newPagingRegistry()
+	defer ts.Close()
+	tags, e := v2GetTags(&http.Client{}, "test/app")
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := []string{"1.0", "1.1", "2.0"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatal("tags:", tags, "expected:", expected)
+	}
+}
@@ -39,7 +39,9 @@ var (
 	RegistryAPIURL string
 	// XRegistryAuth is the base64-encoded AuthConfig object (for X-Registry-Auth HTTP request header)
 	XRegistryAuth string
-	// BasicAuth is the base64-encoded Auth field read from $HOME/.dockercfg
+	// BasicAuth is the base64-encoded Auth field read from $HOME/.dockercfg.
+	// RegistryAPIURL, XRegistryAuth and BasicAuth are set by GetRegistryURL once, before images are
+	// processed, and only read afterwards, by concurrent workers.
 	BasicAuth string
 	// DockerConfig is the name of the config file containing registry authentication information.
 	DockerConfig string
@@ -101,6 +103,10 @@ func RegAuth(registry string) (basicAuth, fullRegistry, authConfig string) {
 		return
 	}
 
+	if len(DockerConfig) == 0 && *Daemonless {
+		// no Docker daemon to ask for its version
+		DockerConfig = os.Getenv("HOME") + "/.docker/config.json"
+	}
 	if len(DockerConfig) == 0 {
 		major, minor, revision, err := DockerVersion()
 		if err != nil {
@@ -0,0 +1,91 @@
+// repofilter.go has functions for selecting the repositories to process by name, by glob pattern,
+// or by regular expression.
+package collector
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RepoRegexpPrefix marks a repo specification as a regular expression.
+	RepoRegexpPrefix = "re:"
+)
+
+var (
+	// RepoPatterns are the glob patterns and regular expressions that select repos to process,
+	// in addition to the repos named in ReposToProcess.
+	RepoPatterns []RepoPattern
+)
+
+// RepoPattern selects the repositories whose names match a glob pattern, e.g., team-a/* or */nginx,
+// or a regular expression, e.g., re:^team-(a|b)/.
+// In a glob pattern, "*" does not match the "/" separator.
+type RepoPattern struct {
+	Spec   string
+	regexp *regexp.Regexp
+}
+
+// IsRepoPattern returns true if spec is a glob pattern or a regular expression rather than a repo name.
+func IsRepoPattern(spec string) bool {
+	return strings.HasPrefix(spec, RepoRegexpPrefix) || strings.ContainsAny(spec, "*?[")
+}
+
+// NewRepoPattern compiles a glob pattern or a regular expression (with the "re:" prefix).
+func NewRepoPattern(spec string) (p RepoPattern, e error) {
+	p.Spec = spec
+	if strings.HasPrefix(spec, RepoRegexpPrefix) {
+		expr := strings.TrimPrefix(spec, RepoRegexpPrefix)
+		if expr == "" {
+			e = errors.New("Empty repo regular expression")
+			return
+		}
+		p.regexp, e = regexp.Compile(expr)
+		return
+	}
+	// check the glob syntax
+	if _, e = path.Match(spec, ""); e != nil {
+		e = errors.New("Invalid repo pattern " + spec + ": " + e.Error())
+	}
+	return
+}
+
+// Match returns true if repo matches the pattern.
+func (p RepoPattern) Match(repo RepoType) bool {
+	return p.matchName(string(repo))
+}
+
+// matchName returns true if name matches the pattern.
+func (p RepoPattern) matchName(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	matched, e := path.Match(p.Spec, name)
+	return e == nil && matched
+}
+
+// prefixSearchTerm returns the registry search term equivalent to the pattern if it is a glob whose
+// only wildcard is a trailing "*", e.g., "banyan/*" returns "banyan". Otherwise it returns "".
+func (p RepoPattern) prefixSearchTerm() string {
+	if p.regexp != nil || !strings.HasSuffix(p.Spec, "*") {
+		return ""
+	}
+	prefix := strings.TrimSuffix(p.Spec, "*")
+	if IsRepoPattern(prefix) {
+		return ""
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// SelectRepos returns the repos in repoSlice that are named in ReposToProcess or match one of
+// RepoPatterns, leaving out those in ExcludeRepo.
+func SelectRepos(repoSlice []RepoType) (selected []RepoType) {
+	for _, repo := range repoSlice {
+		if CheckRepoToProcess(repo) {
+			selected = append(selected, repo)
+		}
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRepoPatternMatch(t *testing.T) {
+	cases := []struct {
+		spec    string
+		repo    RepoType
+		matched bool
+	}{
+		{"team-a/*", "team-a/web", true},
+		{"team-a/*", "team-b/web", false},
+		{"team-a/*", "team-a/web/api", false},
+		{"*/nginx", "library/nginx", true},
+		{"*/nginx", "library/nginx-proxy", false},
+		{"banyan*", "banyanops", true},
+		{"re:^team-(a|b)/", "team-b/db", true},
+		{"re:^team-(a|b)/", "team-c/db", false},
+		{"re:nginx$", "library/nginx", true},
+	}
+	for _, c := range cases {
+		p, e := NewRepoPattern(c.spec)
+		if e != nil {
+			t.Fatal(c.spec, e)
+		}
+		if p.Match(c.repo) != c.matched {
+			t.Fatalf("pattern %s repo %s: expected match=%v", c.spec, c.repo, c.matched)
+		}
+	}
+	for _, spec := range []string{"re:(", "re:", "team[/*"} {
+		if _, e := NewRepoPattern(spec); e == nil {
+			t.Fatal("NewRepoPattern was supposed to fail for", spec)
+		}
+	}
+}
+
+func TestSelectRepos(t *testing.T) {
+	savedRepos, savedPatterns := ReposToProcess, RepoPatterns
+	defer func() { ReposToProcess, RepoPatterns = savedRepos, savedPatterns }()
+	ExcludeRepo["team-a/secret"] = true
+	defer delete(ExcludeRepo, "team-a/secret")
+
+	all := []RepoType{"team-a/web", "team-a/secret", "team-b/web", "library/nginx", "library/redis"}
+	ReposToProcess = map[RepoType]bool{"library/redis": true}
+	RepoPatterns = nil
+	for _, spec := range []string{"team-a/*", "*/nginx"} {
+		p, e := NewRepoPattern(spec)
+		if e != nil {
+			t.Fatal(e)
+		}
+		RepoPatterns = append(RepoPatterns, p)
+	}
+	expected := []RepoType{"team-a/web", "library/nginx", "library/redis"}
+	if selected := SelectRepos(all); !reflect.DeepEqual(selected, expected) {
+		t.Fatal("selected:", selected, "expected:", expected)
+	}
+	if NeedRegistrySearch() != "" {
+		t.Fatal("NeedRegistrySearch should not apply with multiple patterns")
+	}
+
+	ReposToProcess = map[RepoType]bool{}
+	RepoPatterns = RepoPatterns[:1]
+	if term := NeedRegistrySearch(); term != "team-a" {
+		t.Fatal("NeedRegistrySearch returned", term)
+	}
+}
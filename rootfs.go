@@ -0,0 +1,325 @@
+// rootfs.go has functions to analyze images without a Docker daemon: the layer blobs of an image are
+// downloaded from a V2 registry and applied, in order, to a temporary root filesystem.
+package collector
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	except "github.com/banyanops/collector/except"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	MediaTypeForeignLayer        = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCINondistributable = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+
+	// whiteoutPrefix marks a file deleted by a layer, and whiteoutOpaque marks a directory
+	// whose contents in lower layers are hidden.
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+	// maxSymlinks limits the number of symbolic links followed when resolving a path in a rootfs.
+	maxSymlinks = 255
+)
+
+var (
+	Daemonless = flag.Bool("daemonless", false,
+		"Read the packages of images by downloading their layers from the registry (v2 only) instead of pulling them with the Docker daemon; no scripts are run")
+	ScratchDir = flag.String("scratchdir", os.TempDir(),
+		"Directory where image root filesystems are unpacked when --daemonless is set")
+)
+
+// v2ImageLayers returns the layers of the image with the given manifest digest, from the base layer up.
+func v2ImageLayers(client *http.Client, repo, reference string) (layers []V2Descriptor, e error) {
+	response, mediaType, _, e := v2GetManifest(client, repo, reference)
+	if e != nil {
+		return
+	}
+	switch mediaType {
+	case MediaTypeManifestV2Schema1, MediaTypeManifestV2Schema1Signed:
+		var m ManifestV2Schema1
+		if e = json.Unmarshal(response, &m); e != nil {
+			return
+		}
+		// schema 1 lists the layers from the top down
+		for i := len(m.FsLayers) - 1; i >= 0; i-- {
+			layers = append(layers, V2Descriptor{Digest: m.FsLayers[i].BlobSum})
+		}
+	case MediaTypeManifestV2Schema2, MediaTypeOCIManifest:
+		var m ManifestV2Schema2
+		if e = json.Unmarshal(response, &m); e != nil {
+			return
+		}
+		layers = m.Layers
+	default:
+		e = errors.New("Manifest media type " + mediaType + " of " + repo + "@" + reference +
+			" has no image layers")
+	}
+	return
+}
+
+// ExtractImageRootfs downloads the layers of an image from the registry and unpacks them into a new
+// directory under ScratchDir. The caller is responsible for removing the returned rootfs directory.
+func ExtractImageRootfs(metadata ImageMetadataInfo) (rootfs string, e error) {
+	client := registryClient()
+	reference := metadata.ManifestHash
+	if reference == "" {
+		reference = metadata.Tag
+	}
+	layers, e := v2ImageLayers(client, metadata.Repo, reference)
+	if e != nil {
+		return
+	}
+	rootfs, e = ioutil.TempDir(*ScratchDir, "collector-rootfs-")
+	if e != nil {
+		return
+	}
+	for i, layer := range layers {
+		if layer.MediaType == MediaTypeForeignLayer || strings.HasPrefix(layer.MediaType, MediaTypeOCINondistributable) {
+			blog.Warn("Skipping non-distributable layer %s of %s:%s", layer.Digest, metadata.Repo, metadata.Tag)
+			continue
+		}
+		blog.Info("Applying layer %d/%d %s of %s:%s", i+1, len(layers), layer.Digest, metadata.Repo, metadata.Tag)
+		if e = v2ApplyLayer(client, metadata.Repo, layer.Digest, rootfs); e != nil {
+			except.Error(e, ": failed to apply layer", layer.Digest, "of", metadata.Repo, metadata.Tag)
+			os.RemoveAll(rootfs)
+			rootfs = ""
+			return
+		}
+	}
+	return
+}
+
+// v2ApplyLayer streams a layer blob from the registry into rootfs, verifying the blob digest.
+func v2ApplyLayer(client *http.Client, repo, digest, rootfs string) (e error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return errors.New("Unsupported layer digest " + digest)
+	}
+	r, e := registryGetV2(client, RegistryAPIURL+"/v2/"+repo+"/blobs/"+digest, nil)
+	if e != nil {
+		return
+	}
+	if r == nil {
+		return errors.New("Registry authorization failed for layer " + digest)
+	}
+	defer r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return &HTTPStatusCodeError{StatusCode: r.StatusCode}
+	}
+
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(r.Body, h))
+	var layerReader io.Reader = br
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		layerReader = gz
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("zstd compressed layer " + digest + " is not supported")
+	}
+	if e = applyLayer(rootfs, layerReader); e != nil {
+		return
+	}
+	// digest whatever follows the end of the tar archive, too
+	if _, e = io.Copy(ioutil.Discard, br); e != nil {
+		return
+	}
+	if computed := "sha256:" + hex.EncodeToString(h.Sum(nil)); computed != digest {
+		e = errors.New("Layer " + digest + " of repo " + repo + " has digest " + computed)
+	}
+	return
+}
+
+// applyLayer unpacks a layer tar archive on top of the lower layers already present in rootfs.
+// Whiteout entries delete files of lower layers. Device nodes and FIFOs are skipped, and
+// ownership and setuid/setgid bits are not preserved.
+func applyLayer(rootfs string, r io.Reader) (e error) {
+	tr := tar.NewReader(r)
+	// paths added by this layer, which opaque whiteouts must not remove
+	added := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := path.Split(name)
+
+		if base == whiteoutOpaque {
+			if e = removeLowerEntries(rootfs, path.Clean(dir), added); e != nil {
+				return
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			target, err := securePath(rootfs, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), false)
+			if err != nil {
+				return err
+			}
+			if e = os.RemoveAll(target); e != nil {
+				return
+			}
+			continue
+		}
+
+		target, err := securePath(rootfs, name, false)
+		if err != nil {
+			return err
+		}
+		for p := name; p != "/"; p = path.Dir(p) {
+			added[p] = true
+		}
+		// an entry replaces whatever a lower layer has at the same path, except that directories merge
+		if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if e = os.RemoveAll(target); e != nil {
+				return
+			}
+		}
+		if e = os.MkdirAll(filepath.Dir(target), 0755); e != nil {
+			return
+		}
+		mode := os.FileMode(hdr.Mode) & os.ModePerm
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if e = os.Mkdir(target, 0700); e != nil && !os.IsExist(e) {
+				return
+			}
+			// keep directories writable so that upper layers can modify them
+			if e = os.Chmod(target, mode|0700); e != nil {
+				return
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode|0600)
+			if err != nil {
+				return err
+			}
+			_, e = io.Copy(f, tr)
+			f.Close()
+			if e != nil {
+				return
+			}
+		case tar.TypeSymlink:
+			if e = os.Symlink(hdr.Linkname, target); e != nil {
+				return
+			}
+		case tar.TypeLink:
+			source, err := securePath(rootfs, hdr.Linkname, false)
+			if err != nil {
+				return err
+			}
+			if e = os.Link(source, target); e != nil {
+				return
+			}
+		default:
+			blog.Debug("Skipping %s, tar entry type %c", name, hdr.Typeflag)
+		}
+	}
+}
+
+// removeLowerEntries implements an opaque whiteout: it removes the contents of directory dir
+// in rootfs except for the entries added by the current layer.
+func removeLowerEntries(rootfs, dir string, added map[string]bool) (e error) {
+	target, e := securePath(rootfs, dir, true)
+	if e != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, file := range files {
+		if added[path.Join(dir, file.Name())] {
+			continue
+		}
+		if e = os.RemoveAll(filepath.Join(target, file.Name())); e != nil {
+			return
+		}
+	}
+	return
+}
+
+// securePath returns the location in the host filesystem of the absolute path name inside rootfs.
+// Symbolic links found along the way are resolved as if rootfs were the root directory, so the
+// result never escapes rootfs. The last path element is only followed if followLast is true.
+func securePath(rootfs, name string, followLast bool) (hostPath string, e error) {
+	resolved := "/"
+	parts := strings.Split(name, "/")
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		if !followLast && len(parts) == 0 {
+			resolved = next
+			break
+		}
+		fi, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			e = errors.New("Too many levels of symbolic links in " + name)
+			return
+		}
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	hostPath = filepath.Join(rootfs, resolved)
+	return
+}
+
+// getImageDataFromRootfs unpacks an image from the registry and reads its package data. No script
+// is run on the unpacked image: without a container, scripts would run on the host, and images
+// are untrusted.
+func getImageDataFromRootfs(imageID ImageIDType, metadata ImageMetadataInfo) (outMap map[string]interface{}, err error) {
+	rootfs, err := ExtractImageRootfs(metadata)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(rootfs)
+	return getImagePkgDataMap(imageID, rootfs)
+}
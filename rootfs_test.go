@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testLayer describes one tar entry of a test layer.
+type testLayer struct {
+	name     string
+	typeflag byte
+	body     string
+}
+
+// makeLayer returns a gzip-compressed tar archive with the given entries.
+func makeLayer(t *testing.T, entries []testLayer) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0644}
+		switch entry.typeflag {
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeSymlink, tar.TypeLink:
+			hdr.Linkname = entry.body
+		case tar.TypeReg:
+			hdr.Size = int64(len(entry.body))
+		}
+		if e := tw.WriteHeader(hdr); e != nil {
+			t.Fatal(e)
+		}
+		if entry.typeflag == tar.TypeReg {
+			tw.Write([]byte(entry.body))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.String()
+}
+
+func TestExtractImageRootfs(t *testing.T) {
+	lower := makeLayer(t, []testLayer{
+		{"etc/", tar.TypeDir, ""},
+		{"etc/os-release", tar.TypeReg, "ID=test\n"},
+		{"etc/removed", tar.TypeReg, "gone"},
+		{"var/lib/pkgs/", tar.TypeDir, ""},
+		{"var/lib/pkgs/old", tar.TypeReg, "old"},
+		{"escape", tar.TypeSymlink, "../../../../.."},
+		{"abs", tar.TypeSymlink, "/"},
+		{"dev/null", tar.TypeChar, ""},
+	})
+	upper := makeLayer(t, []testLayer{
+		{"etc/.wh.removed", tar.TypeReg, ""},
+		{"var/lib/pkgs/", tar.TypeDir, ""},
+		{"var/lib/pkgs/.wh..wh..opq", tar.TypeReg, ""},
+		{"var/lib/pkgs/new", tar.TypeReg, "new"},
+		{"escape/escaped", tar.TypeReg, "contained"},
+		{"abs/etc/absolute", tar.TypeReg, "contained"},
+		{"etc/hardlink", tar.TypeLink, "etc/os-release"},
+	})
+	lowerDigest := contentDigest([]byte(lower))
+	upperDigest := contentDigest([]byte(upper))
+	manifest := `{"schemaVersion":2,"mediaType":"` + MediaTypeManifestV2Schema2 + `",` +
+		`"config":{"size":10,"digest":"sha256:cc"},"layers":[` +
+		`{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","digest":"` + lowerDigest + `"},` +
+		`{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","digest":"` + upperDigest + `"}]}`
+	manifestDigest := contentDigest([]byte(manifest))
+	ts := newTestRegistry(map[string]testContent{
+		"/v2/test/app/manifests/" + manifestDigest: {MediaTypeManifestV2Schema2, manifest},
+		"/v2/test/app/blobs/" + lowerDigest:        {"", lower},
+		"/v2/test/app/blobs/" + upperDigest:        {"", upper},
+	})
+	defer ts.Close()
+
+	scratch, e := ioutil.TempDir("", "collector-test-")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(scratch)
+	*ScratchDir = scratch
+	metadata := ImageMetadataInfo{ManifestHash: manifestDigest,
+		OtherMetadata: OtherMetadata{Repo: "test/app", Tag: "1.0"}}
+	rootfs, e := ExtractImageRootfs(metadata)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(rootfs)
+
+	expected := map[string]string{
+		"etc/os-release":   "ID=test\n",
+		"etc/hardlink":     "ID=test\n",
+		"var/lib/pkgs/new": "new",
+		"escaped":          "contained",
+		"etc/absolute":     "contained",
+	}
+	for name, content := range expected {
+		data, e := ioutil.ReadFile(filepath.Join(rootfs, name))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if string(data) != content {
+			t.Fatal(name, "has content", string(data), "expected", content)
+		}
+	}
+	for _, name := range []string{"etc/removed", "var/lib/pkgs/old", "dev/null"} {
+		if _, e := os.Lstat(filepath.Join(rootfs, name)); !os.IsNotExist(e) {
+			t.Fatal(name, "should not exist")
+		}
+	}
+	if _, e := os.Lstat(filepath.Join(scratch, "escaped")); !os.IsNotExist(e) {
+		t.Fatal("layer escaped the rootfs")
+	}
+
+	// a corrupted blob must be rejected
+	ts.Close()
+	ts = newTestRegistry(map[string]testContent{
+		"/v2/test/app/manifests/" + manifestDigest: {MediaTypeManifestV2Schema2, manifest},
+		"/v2/test/app/blobs/" + lowerDigest:        {"", upper},
+		"/v2/test/app/blobs/" + upperDigest:        {"", upper},
+	})
+	defer ts.Close()
+	if rootfs, e = ExtractImageRootfs(metadata); e == nil {
+		os.RemoveAll(rootfs)
+		t.Fatal("ExtractImageRootfs should fail on a digest mismatch")
+	}
+}
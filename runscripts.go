@@ -1,8 +1,9 @@
 package collector
 
 import (
-	"gopkg.in/yaml.v2"
+	"errors"
 	"io/ioutil"
+	"reflect"
 	"strings"
 
 	config "github.com/banyanops/collector/config"
@@ -20,50 +21,13 @@ var (
 )
 
 const (
+	// PKGEXTRACTSCRIPT is the output map key of the package data of an image. Package data used to
+	// be produced by a script of this name, and is now read natively from the package databases.
 	PKGEXTRACTSCRIPT = "pkgextractscript.sh"
+	// SCRIPTRESULTS is the output map key of the records of the script executions of an image.
+	SCRIPTRESULTS = "scriptresults"
 )
 
-func parsePkgExtractOutput(output []byte, imageID ImageIDType) (imageDataInfo []ImageDataInfo, err error) {
-	type PkgInfo struct {
-		Pkg, Version, Architecture string
-	}
-
-	var outInfo struct {
-		DistroName string
-		PkgsInfo   []PkgInfo
-	}
-
-	err = yaml.Unmarshal(output, &outInfo)
-	if err != nil {
-		except.Error(err, ": Error in unmrashaling yaml")
-		return
-	}
-
-	for _, pkgInfo := range outInfo.PkgsInfo {
-		var imageData ImageDataInfo
-		imageData.DistroName = outInfo.DistroName
-		imageData.DistroID = getDistroID(outInfo.DistroName)
-		imageData.Image = string(imageID)
-		imageData.Pkg = pkgInfo.Pkg
-		imageData.Version = pkgInfo.Version
-		imageData.Architecture = pkgInfo.Architecture
-		imageDataInfo = append(imageDataInfo, imageData)
-	}
-	// if no package info was found, return a single entry with empty string in the package info fields.
-	if len(outInfo.PkgsInfo) == 0 {
-		var imageData ImageDataInfo
-		imageData.DistroName = outInfo.DistroName
-		imageData.DistroID = getDistroID(outInfo.DistroName)
-		imageData.Image = string(imageID)
-		imageData.Pkg = ""
-		imageData.Version = ""
-		imageData.Architecture = ""
-		imageDataInfo = append(imageDataInfo, imageData)
-	}
-
-	return
-}
-
 func getScripts(dirPath string) (scripts []Script, err error) {
 	files, err := ioutil.ReadDir(dirPath)
 	if err != nil {
@@ -72,20 +36,18 @@ func getScripts(dirPath string) (scripts []Script, err error) {
 	}
 
 	for _, file := range files {
-		file.Name()
-		//figure out type of script
-		var script Script
-		switch {
-		case strings.HasSuffix(file.Name(), ".sh"):
-			blog.Debug("dirpath: " + dirPath + " after removing prefix: " + config.BANYANDIR() + " looks like: " + strings.TrimPrefix(dirPath, config.BANYANDIR()+"/hosttarget"))
-			script = newBashScript(file.Name(), TARGETCONTAINERDIR+strings.TrimPrefix(dirPath, config.BANYANDIR()+"/hosttarget"), []string{""})
-		case strings.HasSuffix(file.Name(), ".py"):
-			script = newPythonScript(file.Name(), TARGETCONTAINERDIR+strings.TrimPrefix(dirPath, config.BANYANDIR()+"/hosttarget"), []string{""})
-		default:
-			except.Warn("Unknown script file type for: " + file.Name())
+		if strings.HasPrefix(file.Name(), ".") || strings.HasSuffix(file.Name(), ScriptManifestSuffix) {
+			continue
+		}
+		//figure out how to run the script
+		manifest, err := loadScriptManifest(dirPath, file.Name())
+		if err != nil {
+			except.Warn(err, ": Skipping script", file.Name())
 			//Ignore this file...
 			continue
 		}
+		blog.Debug("dirpath: " + dirPath + " after removing prefix: " + config.BANYANDIR() + " looks like: " + strings.TrimPrefix(dirPath, config.BANYANDIR()+"/hosttarget"))
+		script := newManifestScript(file.Name(), TARGETCONTAINERDIR+strings.TrimPrefix(dirPath, config.BANYANDIR()+"/hosttarget"), manifest)
 		scripts = append(scripts, script)
 	}
 
@@ -109,31 +71,84 @@ func getScriptsToRun() (scripts []Script) {
 	return
 }
 
-func runAllScripts(imageID ImageIDType) (outMap map[string]interface{}, err error) {
-	//script name -> either byte array, or known types (e.g., ImageDataInfo)
+// getImagePkgDataMap returns the output map of an image with its package data, and the
+// vulnerabilities of its packages, read from its root filesystem if rootfs is not empty.
+func getImagePkgDataMap(imageID ImageIDType, rootfs string) (outMap map[string]interface{}, err error) {
+	//PKGEXTRACTSCRIPT -> []ImageDataInfo; VULNERABILITIES -> []vulndb.Finding
+	//(callers add POLICYVERDICT -> PolicyVerdict, see ApplyPolicy)
 	outMap = make(map[string]interface{})
+	imageDataInfo, err := getImagePkgData(imageID, rootfs)
+	if err != nil {
+		except.Error(err, ": Error in extracting package data")
+		return nil, err
+	}
+	outMap[PKGEXTRACTSCRIPT] = imageDataInfo
+	if VulnDB != nil {
+		outMap[VULNERABILITIES] = MatchVulnerabilities(imageDataInfo)
+	}
+	return
+}
+
+// runAllScripts reads the package data of a pulled image, and runs all the scripts on the image
+// in containers created from it.
+func runAllScripts(imageID ImageIDType) (outMap map[string]interface{}, err error) {
+	//script name -> either ScriptOutput, or known types (e.g., ImageDataInfo, ScriptRecords);
+	//SCRIPTRESULTS -> []ScriptResult; and the package data of getImagePkgDataMap
+	outMap, err = getImagePkgDataMap(imageID, "")
+	if err != nil {
+		return
+	}
+
 	scripts := getScriptsToRun()
-	for _, script := range scripts {
-		//run script
-		output, err := script.Run(imageID)
-		if err != nil {
-			except.Error(err, ": Error in running script: ", script.Name())
-			continue //continue trying to run other scripts
+	// with --singlecontainer, the scripts in the default sandbox run at once in a single container,
+	// and those with their own sandbox in a container each
+	driverResults := make(map[int]ScriptResult)
+	if *SingleContainer {
+		var shared []Script
+		var indices []int
+		for i, script := range scripts {
+			if reflect.DeepEqual(script.Sandbox(), DefaultSandbox) {
+				shared = append(shared, script)
+				indices = append(indices, i)
+			}
 		}
-
-		//analyze script output
-		switch script.Name() {
-		case PKGEXTRACTSCRIPT:
-			imageDataInfo, err := parsePkgExtractOutput(output, imageID)
+		if len(shared) > 0 {
+			results, err := runScriptsInContainer(imageID, shared)
 			if err != nil {
-				except.Error(err, ": Error in parsing PkgExtractOuput")
+				except.Error(err, ": Error in running scripts in a single container")
 				return nil, err
 			}
-			outMap[script.Name()] = imageDataInfo
+			for j, i := range indices {
+				driverResults[i] = results[j]
+			}
+		}
+	}
+	// every execution of a script is recorded, including failed ones
+	scriptResults := []ScriptResult{}
+	for i, script := range scripts {
+		//run script
+		var result ScriptResult
+		var err error
+		driverResult, inDriver := driverResults[i]
+		switch {
+		case inDriver:
+			result = driverResult
+			if result.Error != "" {
+				err = errors.New(result.Error)
+			}
 		default:
-			//script name -> byte array
-			outMap[script.Name()] = output
+			result, err = script.Run(imageID)
+		}
+		scriptResults = append(scriptResults, result)
+		if err != nil {
+			except.Error(err, ": Error in running script: ", script.Name())
+			continue //continue trying to run other scripts
 		}
+		//script name -> records of a structured output envelope, or else output tagged with its format
+		outMap[script.Name()] = structureScriptOutput(script, newScriptOutput(script, []byte(result.Stdout)))
+	}
+	if len(scriptResults) > 0 {
+		outMap[SCRIPTRESULTS] = scriptResults
 	}
 
 	return
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 
@@ -17,7 +18,9 @@ const (
 
 func TestCreateCmd(t *testing.T) {
 	os.Setenv("HOSTNAME", "")
-	jsonString, err := createCmd(ImageIDType("imageID"), "scriptName", "staticBinary", "dirPath")
+	sh := ScriptInfo{name: "scriptName", dirPath: "dirPath", staticBinary: "staticBinary",
+		params: []string{"it's"}}
+	jsonString, err := createCmd(ImageIDType("imageID"), []string{sh.command()}, []string{"NAME=value"}, DefaultSandbox)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -49,13 +52,17 @@ func TestBashScriptRun(t *testing.T) {
 	defer os.RemoveAll(os.Getenv("BANYAN_HOST_DIR"))
 	fsutil.CreateDirIfNotExist(os.Getenv("BANYAN_HOST_DIR") + "/hosttarget/defaultscripts")
 	fsutil.CopyDirTree(os.Getenv("PWD")+"/data/bin/*", os.Getenv("BANYAN_HOST_DIR")+"/hosttarget/bin")
-	fsutil.CopyDir(os.Getenv("PWD")+"/data/defaultscripts", os.Getenv("BANYAN_HOST_DIR")+"/hosttarget/defaultscripts")
-	bs := newBashScript("pkgextractscript.sh", "/banyancollector/defaultscripts", []string{})
-	b, err := bs.Run(ImageIDType("ubuntu"))
+	ioutil.WriteFile(os.Getenv("BANYAN_HOST_DIR")+"/hosttarget/defaultscripts/hello.sh",
+		[]byte("echo hello from $(busybox hostname)\n"), 0755)
+	bs := newBashScript("hello.sh", "/banyancollector/defaultscripts", []string{})
+	result, err := bs.Run(ImageIDType("ubuntu"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Println("Run returned", string(b))
+	fmt.Println("Run returned", result.Stdout)
+	if result.ContainerID == "" || result.ExitStatus != 0 {
+		t.Fatal("Unexpected result", result)
+	}
 }
 
 func TestPostDockerAPI(t *testing.T) {
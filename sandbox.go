@@ -0,0 +1,171 @@
+// sandbox.go has the sandbox configuration of the containers that run scripts on the images
+// being analyzed. By default these containers have no network, a read-only root filesystem with
+// a tmpfs on /tmp, no capabilities, no privilege escalation, and limited memory, CPU and processes,
+// and they run as the unprivileged user nobody.
+package collector
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	SandboxFile = flag.String("sandbox", "",
+		"YAML file overriding the default sandbox of the containers that run scripts (see docs/CollectorDetails.md)")
+
+	// DefaultSandbox is the sandbox of scripts whose manifest doesn't override it.
+	DefaultSandbox = Sandbox{
+		Network:         "none",
+		ReadOnly:        true,
+		NoNewPrivileges: true,
+		Memory:          "512m",
+		CPUs:            1,
+		Pids:            256,
+		Tmpfs:           "64m",
+		User:            "65534",
+	}
+)
+
+// Sandbox configures the isolation and resource limits of a container that runs scripts.
+// All capabilities are dropped, except for those listed in CapAdd.
+type Sandbox struct {
+	// Network is the Docker network mode; none disables networking
+	Network string `yaml:"network"`
+	// ReadOnly makes the root filesystem of the container read-only
+	ReadOnly bool     `yaml:"readonly"`
+	CapAdd   []string `yaml:"capadd"`
+	// NoNewPrivileges prevents processes from gaining privileges, e.g., through setuid binaries
+	NoNewPrivileges bool `yaml:"nonewprivileges"`
+	// Memory is the memory limit, e.g., 512m or 2g (0 for no limit)
+	Memory string `yaml:"memory"`
+	// CPUs is the number of CPUs the container can use (0 for no limit)
+	CPUs float64 `yaml:"cpus"`
+	// Pids is the maximum number of processes (0 for no limit)
+	Pids int64 `yaml:"pids"`
+	// Seccomp is the file of a seccomp profile, or unconfined; the default profile of Docker is used if empty
+	Seccomp string `yaml:"seccomp"`
+	// Tmpfs is the size of the tmpfs mounted on /tmp, e.g., 64m (0 for none)
+	Tmpfs string `yaml:"tmpfs"`
+	// User is the user[:group] that runs the scripts; scripts that need root must set it to 0
+	User string `yaml:"user"`
+
+	// seccompProfile is the contents of the Seccomp file
+	seccompProfile string
+}
+
+// LoadSandbox reads sandbox settings from a YAML file, overriding those of DefaultSandbox.
+func LoadSandbox(filename string) (e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
+	}
+	sandbox := DefaultSandbox
+	if e = yaml.UnmarshalStrict(data, &sandbox); e != nil {
+		return
+	}
+	if e = sandbox.validate(); e != nil {
+		return errors.New(filename + ": " + e.Error())
+	}
+	DefaultSandbox = sandbox
+	blog.Info("Loaded default sandbox from %s: %+v", filename, DefaultSandbox)
+	return
+}
+
+// validate checks the settings of the sandbox, and reads its seccomp profile.
+func (s *Sandbox) validate() (e error) {
+	if _, e = parseSize(s.Memory); e != nil {
+		return errors.New("Invalid sandbox memory: " + e.Error())
+	}
+	if _, e = parseSize(s.Tmpfs); e != nil {
+		return errors.New("Invalid sandbox tmpfs: " + e.Error())
+	}
+	if s.CPUs < 0 || s.Pids < 0 {
+		return errors.New("Sandbox cpus and pids cannot be negative")
+	}
+	if s.Network == "" {
+		return errors.New("Missing sandbox network")
+	}
+	if s.User == "" {
+		// the image would choose the user, often root
+		return errors.New("Missing sandbox user")
+	}
+	for _, c := range s.CapAdd {
+		if c == "" || strings.ContainsAny(c, " \t") {
+			return errors.New("Invalid sandbox capability " + c)
+		}
+	}
+	s.seccompProfile = ""
+	if s.Seccomp != "" && s.Seccomp != "unconfined" {
+		profile, err := ioutil.ReadFile(s.Seccomp)
+		if err != nil {
+			return err
+		}
+		s.seccompProfile = string(profile)
+	}
+	return
+}
+
+// apply sets up the container configuration according to the sandbox.
+func (s Sandbox) apply(container *Container) {
+	container.User = s.User
+	hc := &container.HostConfig
+	hc.NetworkMode = s.Network
+	hc.ReadonlyRootfs = s.ReadOnly
+	hc.CapDrop = []string{"ALL"}
+	hc.CapAdd = s.CapAdd
+	if s.NoNewPrivileges {
+		hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges")
+	}
+	switch {
+	case s.Seccomp == "unconfined":
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp=unconfined")
+	case s.seccompProfile != "":
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp="+s.seccompProfile)
+	}
+	// sizes were checked by validate
+	if memory, _ := parseSize(s.Memory); memory > 0 {
+		hc.Memory = memory
+		// no swap
+		hc.MemorySwap = memory
+	}
+	if s.CPUs > 0 {
+		hc.CpuPeriod = 100000
+		hc.CpuQuota = int64(s.CPUs * 100000)
+	}
+	hc.PidsLimit = s.Pids
+	if size, _ := parseSize(s.Tmpfs); size > 0 {
+		hc.Tmpfs = map[string]string{"/tmp": "rw,noexec,nosuid,nodev,size=" + strconv.FormatInt(size, 10)}
+	}
+}
+
+// parseSize parses a size in bytes with an optional k, m or g suffix, e.g., 512m.
+// An empty size is 0.
+func parseSize(size string) (n int64, e error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'k':
+		multiplier = 1 << 10
+	case 'm':
+		multiplier = 1 << 20
+	case 'g':
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, e = strconv.ParseInt(s, 10, 64)
+	if e != nil || n < 0 {
+		return 0, errors.New("invalid size " + size)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		size string
+		n    int64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{"4096", 4096, true},
+		{"64k", 64 << 10, true},
+		{"512m", 512 << 20, true},
+		{"512MB", 512 << 20, true},
+		{"2g", 2 << 30, true},
+		{"1.5g", 0, false},
+		{"-1m", 0, false},
+		{"m", 0, false},
+		{"lots", 0, false},
+	}
+	for _, c := range cases {
+		n, err := parseSize(c.size)
+		if (err == nil) != c.ok || n != c.n {
+			t.Fatal(c.size, "got", n, err)
+		}
+	}
+}
+
+func TestScriptManifestSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	profile := filepath.Join(dir, "seccomp.json")
+	if err := ioutil.WriteFile(profile, []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withNetwork := DefaultSandbox
+	withNetwork.Network = "bridge"
+	withNetwork.Memory = "1g"
+	withCaps := DefaultSandbox
+	withCaps.CapAdd = []string{"DAC_READ_SEARCH"}
+	withCaps.ReadOnly = false
+	withSeccomp := DefaultSandbox
+	withSeccomp.Seccomp = profile
+	withSeccomp.seccompProfile = `{"defaultAction": "SCMP_ACT_ERRNO"}`
+	asRoot := DefaultSandbox
+	asRoot.User = "0"
+	cases := []struct {
+		script, manifest string
+		ok               bool
+		sandbox          Sandbox
+	}{
+		{"none.sh", "", true, DefaultSandbox},
+		{"nosandbox.sh", "timeout: 1m\n", true, DefaultSandbox},
+		{"network.sh", "sandbox:\n  network: bridge\n  memory: 1g\n", true, withNetwork},
+		{"caps.sh", "sandbox:\n  capadd: [DAC_READ_SEARCH]\n  readonly: false\n", true, withCaps},
+		{"seccomp.sh", "sandbox:\n  seccomp: " + profile + "\n", true, withSeccomp},
+		{"root.sh", "sandbox:\n  user: \"0\"\n", true, asRoot},
+		{"nouser.sh", "sandbox:\n  user: \"\"\n", false, Sandbox{}},
+		{"nofile.sh", "sandbox:\n  seccomp: " + profile + ".missing\n", false, Sandbox{}},
+		{"badmemory.sh", "sandbox:\n  memory: lots\n", false, Sandbox{}},
+		{"badpids.sh", "sandbox:\n  pids: -1\n", false, Sandbox{}},
+		{"badfield.sh", "sandbox:\n  privileged: true\n", false, Sandbox{}},
+	}
+	for _, c := range cases {
+		if c.manifest != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, c.script+ScriptManifestSuffix), []byte(c.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m, err := loadScriptManifest(dir, c.script)
+		if (err == nil) != c.ok {
+			t.Fatal(c.script, "error:", err)
+		}
+		if c.ok && !reflect.DeepEqual(m.Sandbox, c.sandbox) {
+			t.Fatalf("%s: got %+v", c.script, m.Sandbox)
+		}
+	}
+}
+
+func TestCreateCmdSandbox(t *testing.T) {
+	sandbox := DefaultSandbox
+	sandbox.CapAdd = []string{"DAC_READ_SEARCH"}
+	sandbox.CPUs = 0.5
+	sandbox.Seccomp = "unconfined"
+	jsonString, err := createCmd(ImageIDType("imageID"), []string{"true"}, nil, sandbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(string(jsonString))
+	var container Container
+	if err := json.Unmarshal(jsonString, &container); err != nil {
+		t.Fatal(err)
+	}
+	hc := container.HostConfig
+	if hc.NetworkMode != "none" || !hc.ReadonlyRootfs || hc.Privileged ||
+		!reflect.DeepEqual(hc.CapDrop, []string{"ALL"}) || !reflect.DeepEqual(hc.CapAdd, sandbox.CapAdd) ||
+		!reflect.DeepEqual(hc.SecurityOpt, []string{"no-new-privileges", "seccomp=unconfined"}) ||
+		hc.Memory != 512<<20 || hc.MemorySwap != hc.Memory || hc.CpuQuota != 50000 || hc.CpuPeriod != 100000 ||
+		hc.PidsLimit != 256 || hc.Tmpfs["/tmp"] != "rw,noexec,nosuid,nodev,size=67108864" {
+		t.Fatalf("Unexpected host config %+v", hc)
+	}
+	if len(hc.Binds) != 1 || container.User != "65534" {
+		t.Fatalf("Unexpected container %+v", container)
+	}
+
+	// no limits at all
+	sandbox = Sandbox{Network: "bridge"}
+	jsonString, err = createCmd(ImageIDType("imageID"), []string{"true"}, nil, sandbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container = Container{}
+	if err := json.Unmarshal(jsonString, &container); err != nil {
+		t.Fatal(err)
+	}
+	hc = container.HostConfig
+	if hc.ReadonlyRootfs || hc.Memory != 0 || hc.CpuQuota != 0 || hc.PidsLimit != 0 || hc.Tmpfs != nil ||
+		hc.SecurityOpt != nil {
+		t.Fatalf("Unexpected host config %+v", hc)
+	}
+}
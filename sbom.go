@@ -0,0 +1,302 @@
+// sbom.go has the writers of software bills of materials (see --dests): for every image, a
+// CycloneDX or SPDX document with the image, its distribution and its packages, identified by purls.
+package collector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	fsutil "github.com/banyanops/collector/fsutil"
+	version "github.com/banyanops/collector/version"
+	blog "github.com/ccpaging/log4go"
+)
+
+const (
+	// SBOM formats, which are also the names of their --dests
+	SBOMCycloneDX = "cyclonedx"
+	SBOMSPDX      = "spdx"
+
+	// sbomDir is the subdirectory of the output directory where the SBOMs are written.
+	sbomDir = "sbom"
+	// sbomTool is the name of Collector in the SBOMs.
+	sbomTool = "banyanops-collector"
+)
+
+// sbomExtensions are the file extensions of the SBOM formats.
+var sbomExtensions = map[string]string{
+	SBOMCycloneDX: ".cdx.json",
+	SBOMSPDX:      ".spdx.json",
+}
+
+// SBOMWriter writes an SBOM for every image in the output directory, in sbom/IMAGE.cdx.json
+// for CycloneDX, or sbom/IMAGE.spdx.json for SPDX. Image data comes without the repo:tags of the
+// image, so the writer keeps the metadata appended to it; for images whose metadata was written
+// before a restart, the metadata is looked up in the state store.
+type SBOMWriter struct {
+	format string
+	dir    string
+	// metadata maps image IDs to the metadata of their repo:tags
+	metadata map[string][]ImageMetadataInfo
+}
+
+// NewSBOMWriter returns a writer of SBOMs in format (SBOMCycloneDX or SBOMSPDX) to directory dir.
+func NewSBOMWriter(format string, dir string) Writer {
+	return &SBOMWriter{
+		format:   format,
+		dir:      dir,
+		metadata: make(map[string][]ImageMetadataInfo),
+	}
+}
+
+// WriteImageAllData writes the SBOM of every image with package data.
+func (w *SBOMWriter) WriteImageAllData(outMapMap map[string]map[string]interface{}) {
+	blog.Info("Writing %s SBOMs...", w.format)
+	dir := filepath.Join(w.dir, sbomDir)
+	if err := fsutil.CreateDirIfNotExist(dir); err != nil {
+		except.Error(err, ": Error creating SBOM dir: ", dir)
+		return
+	}
+	for imageID, outMap := range outMapMap {
+		pkgs, ok := outMap[PKGEXTRACTSCRIPT].([]ImageDataInfo)
+		if !ok {
+			continue
+		}
+		metadata := w.imageMetadata(imageID)
+		var doc interface{}
+		switch w.format {
+		case SBOMCycloneDX:
+			doc = NewCycloneDX(imageID, metadata, pkgs)
+		case SBOMSPDX:
+			doc = NewSPDX(imageID, metadata, pkgs)
+		default:
+			except.Error("Unknown SBOM format %s", w.format)
+			return
+		}
+		filename := filepath.Join(dir, shortImageID(imageID)+sbomExtensions[w.format])
+		blog.Info("Writing " + filename + "...")
+		b, err := json.MarshalIndent(doc, "", "\t")
+		if err != nil {
+			except.Error(err, ": Error in marshaling SBOM of image", imageID)
+			continue
+		}
+		if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+			except.Error(err, ": Error in writing to file: ", filename)
+		}
+	}
+}
+
+// AppendImageMetadata records the metadata of the images, for their SBOMs.
+func (w *SBOMWriter) AppendImageMetadata(imageMetadata []ImageMetadataInfo) {
+	for _, metadata := range imageMetadata {
+		w.removeMetadata(metadata)
+		w.metadata[metadata.Image] = append(w.metadata[metadata.Image], metadata)
+	}
+}
+
+// RemoveImageMetadata forgets the metadata of the images.
+func (w *SBOMWriter) RemoveImageMetadata(imageMetadata []ImageMetadataInfo) {
+	for _, metadata := range imageMetadata {
+		w.removeMetadata(metadata)
+	}
+}
+
+// removeMetadata forgets the metadata of the repo:tag of metadata.
+func (w *SBOMWriter) removeMetadata(metadata ImageMetadataInfo) {
+	list := w.metadata[metadata.Image]
+	for i, m := range list {
+		if metadataKey(m) == metadataKey(metadata) {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(w.metadata, metadata.Image)
+		return
+	}
+	w.metadata[metadata.Image] = list
+}
+
+// imageMetadata returns the metadata of the repo:tags of an image, sorted by repo:tag.
+func (w *SBOMWriter) imageMetadata(imageID string) (metadata []ImageMetadataInfo) {
+	metadata = w.metadata[imageID]
+	if len(metadata) == 0 && State != nil {
+		metadataSet := NewMetadataSet()
+		if err := LoadMetadataSet(metadataSet); err != nil {
+			except.Warn(err, ": Error in loading metadata from the state store")
+		}
+		for m := range metadataSet {
+			if m.Image == imageID {
+				metadata = append(metadata, m)
+			}
+		}
+	}
+	sort.Slice(metadata, func(i, j int) bool {
+		return metadataKey(metadata[i]) < metadataKey(metadata[j])
+	})
+	return
+}
+
+// shortImageID abbreviates an image ID to 12 hex digits, keeping its algorithm prefix, as in the
+// file names of the file writer.
+func shortImageID(imageID string) string {
+	n := 12
+	if i := strings.Index(imageID, ":"); i >= 0 {
+		n += i + 1
+	}
+	if len(imageID) < n {
+		return imageID
+	}
+	return imageID[:n]
+}
+
+// sbomDistro is the distribution of an image in an SBOM.
+type sbomDistro struct {
+	// name and release are from the distro ID, e.g., debian and bookworm for DEBIAN-bookworm
+	name, release string
+	// pretty is the full name, e.g., Debian GNU/Linux 12 (bookworm)
+	pretty string
+	// purlType, purlNamespace and purlDistro are the type, namespace and distro qualifier of
+	// the purls of its packages
+	purlType, purlNamespace, purlDistro string
+}
+
+// newSBOMDistro returns the distribution of packages pkgs.
+func newSBOMDistro(pkgs []ImageDataInfo) (d sbomDistro) {
+	if len(pkgs) == 0 || pkgs[0].DistroID == "" {
+		d.purlType = "generic"
+		return
+	}
+	distroID := pkgs[0].DistroID
+	d.pretty = pkgs[0].DistroName
+	parts := strings.SplitN(distroID, "-", 2)
+	d.name = strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		d.release = parts[1]
+	}
+	d.purlNamespace = d.name
+	d.purlDistro = strings.ToLower(distroID)
+	switch version.ForDistro(distroID) {
+	case version.Dpkg:
+		// e.g., pkg:deb/debian/curl@7.88.1-10?arch=amd64&distro=bookworm
+		d.purlType = "deb"
+		d.purlDistro = d.release
+	case version.Rpm:
+		d.purlType = "rpm"
+		if d.name == "sles" {
+			d.purlNamespace = "suse"
+		}
+	case version.Apk:
+		d.purlType = "apk"
+	default:
+		d.purlType = "generic"
+		d.purlNamespace = ""
+	}
+	return
+}
+
+// purl returns the package URL (https://github.com/package-url/purl-spec) of package p.
+func (d sbomDistro) purl(p ImageDataInfo) string {
+	qualifiers := map[string]string{}
+	if d.purlType != "generic" {
+		qualifiers["arch"] = p.Architecture
+		qualifiers["distro"] = d.purlDistro
+	}
+	if p.Origin != "" && p.Origin != p.Pkg {
+		qualifiers["upstream"] = p.Origin
+	}
+	pkgVersion := p.Version
+	if i := strings.Index(pkgVersion, ":"); d.purlType == "rpm" && i >= 0 {
+		// the epoch of rpm versions is a qualifier, e.g., pkg:rpm/rhel/openssl@3.0.7-25.el9_3?epoch=1
+		qualifiers["epoch"] = pkgVersion[:i]
+		pkgVersion = pkgVersion[i+1:]
+	}
+	return packageURL(d.purlType, d.purlNamespace, p.Pkg, pkgVersion, qualifiers)
+}
+
+// packageURL builds a purl, with its qualifiers sorted and the empty ones left out.
+func packageURL(typ, namespace, name, pkgVersion string, qualifiers map[string]string) string {
+	s := "pkg:" + typ + "/"
+	if namespace != "" {
+		s += purlEscape(namespace) + "/"
+	}
+	s += purlEscape(name)
+	if pkgVersion != "" {
+		s += "@" + purlEscape(pkgVersion)
+	}
+	keys := []string{}
+	for key, value := range qualifiers {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i == 0 {
+			s += "?"
+		} else {
+			s += "&"
+		}
+		s += key + "=" + purlEscape(qualifiers[key], '/')
+	}
+	return s
+}
+
+// purlEscape percent-encodes the characters of s other than letters, digits, .-_~ and keep, e.g.,
+// the + and : of package versions.
+func purlEscape(s string, keep ...byte) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(".-_~"+string(keep), c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
+}
+
+// imagePURL returns the purl of an image pulled by the repo:tag of metadata, e.g.,
+// pkg:oci/nginx@sha256%3A...?repository_url=docker.io/library/nginx&tag=1.25. The digest is the
+// manifest digest if known, or else the image ID.
+func imagePURL(imageID string, metadata ImageMetadataInfo) string {
+	digest := metadata.ManifestHash
+	if digest == "" {
+		digest = imageID
+	}
+	repo := metadata.Repo
+	name := repo[strings.LastIndex(repo, "/")+1:]
+	registry := metadata.Registry
+	if registry == "" || registry == config.DockerHub {
+		registry = "docker.io"
+	}
+	return packageURL("oci", "", name, digest, map[string]string{"tag": metadata.Tag,
+		"arch": metadata.Architecture, "repository_url": registry + "/" + repo})
+}
+
+// sbomImageName returns the name of an image in an SBOM: its first repo:tag, or its ID.
+func sbomImageName(imageID string, metadata []ImageMetadataInfo) (name, tag string) {
+	if len(metadata) == 0 || metadata[0].Repo == "" {
+		return imageID, ""
+	}
+	return metadata[0].Repo, metadata[0].Tag
+}
+
+// sbomPackages returns the packages of pkgs, without the entry for the distribution of images
+// without packages.
+func sbomPackages(pkgs []ImageDataInfo) (packages []ImageDataInfo) {
+	for _, p := range pkgs {
+		if p.Pkg != "" {
+			packages = append(packages, p)
+		}
+	}
+	return
+}
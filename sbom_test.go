@@ -0,0 +1,175 @@
+package collector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSBOMPackageURL(t *testing.T) {
+	cases := []struct {
+		pkg  ImageDataInfo
+		purl string
+	}{
+		{ImageDataInfo{DistroID: "UBUNTU-jammy", Pkg: "libc6", Version: "2.35-0ubuntu3.6", Architecture: "amd64",
+			Origin: "glibc"}, "pkg:deb/ubuntu/libc6@2.35-0ubuntu3.6?arch=amd64&distro=jammy&upstream=glibc"},
+		{ImageDataInfo{DistroID: "DEBIAN-bookworm", Pkg: "libstdc++6", Version: "1:12.2.0-14", Architecture: "amd64",
+			Origin: "libstdc++6"}, "pkg:deb/debian/libstdc%2B%2B6@1%3A12.2.0-14?arch=amd64&distro=bookworm"},
+		{ImageDataInfo{DistroID: "CENTOS-7", Pkg: "bash", Version: "4.2.46-34.el7", Architecture: "x86_64"},
+			"pkg:rpm/centos/bash@4.2.46-34.el7?arch=x86_64&distro=centos-7"},
+		{ImageDataInfo{DistroID: "REDHAT-9", Pkg: "openssl", Version: "1:3.0.7-25.el9_3", Architecture: "x86_64"},
+			"pkg:rpm/redhat/openssl@3.0.7-25.el9_3?arch=x86_64&distro=redhat-9&epoch=1"},
+		{ImageDataInfo{DistroID: "SLES-15.5", Pkg: "zlib", Version: "1.2.13-150500.4.3.1", Architecture: "x86_64"},
+			"pkg:rpm/suse/zlib@1.2.13-150500.4.3.1?arch=x86_64&distro=sles-15.5"},
+		{ImageDataInfo{DistroID: "ALPINE-3.19", Pkg: "ssl_client", Version: "1.36.1-r15", Architecture: "x86_64",
+			Origin: "busybox"}, "pkg:apk/alpine/ssl_client@1.36.1-r15?arch=x86_64&distro=alpine-3.19&upstream=busybox"},
+		{ImageDataInfo{DistroID: "GENTOO-2.14", Pkg: "bash", Version: "5.1_p16"}, "pkg:generic/bash@5.1_p16"},
+	}
+	for _, c := range cases {
+		purl := newSBOMDistro([]ImageDataInfo{c.pkg}).purl(c.pkg)
+		if purl != c.purl {
+			t.Fatal("Expected", c.purl, "got", purl)
+		}
+	}
+
+	m := ImageMetadataInfo{Image: "sha256:1234", ManifestHash: "sha256:abcd", Registry: "registry-1.docker.io"}
+	m.Repo, m.Tag, m.Architecture = "library/nginx", "1.25", "amd64"
+	expected := "pkg:oci/nginx@sha256%3Aabcd?arch=amd64&repository_url=docker.io/library/nginx&tag=1.25"
+	if purl := imagePURL(m.Image, m); purl != expected {
+		t.Fatal("Expected", expected, "got", purl)
+	}
+	m.ManifestHash, m.Registry = "", "localhost:5000"
+	expected = "pkg:oci/nginx@sha256%3A1234?arch=amd64&repository_url=localhost%3A5000/library/nginx&tag=1.25"
+	if purl := imagePURL(m.Image, m); purl != expected {
+		t.Fatal("Expected", expected, "got", purl)
+	}
+}
+
+// sbomTestData returns the metadata and packages of an Ubuntu image for the SBOM tests.
+func sbomTestData() (imageID string, metadata []ImageMetadataInfo, pkgs []ImageDataInfo) {
+	imageID = "sha256:0123456789abcdef0123456789abcdef"
+	m := ImageMetadataInfo{Image: imageID, Registry: "registry-1.docker.io"}
+	m.Repo, m.Tag = "library/ubuntu", "22.04"
+	metadata = []ImageMetadataInfo{m}
+	for _, p := range []struct{ pkg, version, origin string }{
+		{"bash", "5.1-6ubuntu1", "bash"},
+		{"libc6", "2.35-0ubuntu3.6", "glibc"},
+		{"libc6", "2.35-0ubuntu3.6", "glibc"},
+	} {
+		pkgs = append(pkgs, ImageDataInfo{Image: imageID, DistroName: "Ubuntu 22.04.4 LTS", DistroID: "UBUNTU-jammy",
+			Pkg: p.pkg, Version: p.version, Architecture: "amd64", Origin: p.origin})
+	}
+	return
+}
+
+func TestSBOMCycloneDX(t *testing.T) {
+	imageID, metadata, pkgs := sbomTestData()
+	bom := NewCycloneDX(imageID, metadata, pkgs)
+	if bom.BOMFormat != "CycloneDX" || bom.SpecVersion != "1.5" || !strings.HasPrefix(bom.SerialNumber, "urn:uuid:") {
+		t.Fatal("Unexpected BOM header", bom)
+	}
+	image := bom.Metadata.Component
+	if image.Type != "container" || image.Name != "library/ubuntu" || image.Version != "22.04" ||
+		!strings.HasPrefix(image.PURL, "pkg:oci/ubuntu@") || image.Hashes[0].Content != imageID[7:] {
+		t.Fatal("Unexpected image component", image)
+	}
+	// the operating system, and libc6 once
+	if len(bom.Components) != 3 {
+		t.Fatal("Expected 3 components, got", bom.Components)
+	}
+	if c := bom.Components[0]; c.Type != "operating-system" || c.Name != "ubuntu" || c.Version != "jammy" ||
+		c.Description != "Ubuntu 22.04.4 LTS" {
+		t.Fatal("Unexpected operating system component", c)
+	}
+	if c := bom.Components[2]; c.Type != "library" || c.Name != "libc6" || c.BOMRef != c.PURL ||
+		c.PURL != "pkg:deb/ubuntu/libc6@2.35-0ubuntu3.6?arch=amd64&distro=jammy&upstream=glibc" {
+		t.Fatal("Unexpected package component", c)
+	}
+	if len(bom.Dependencies) != 1 || bom.Dependencies[0].Ref != imageID || len(bom.Dependencies[0].DependsOn) != 3 {
+		t.Fatal("Unexpected dependencies", bom.Dependencies)
+	}
+}
+
+func TestSBOMSPDX(t *testing.T) {
+	imageID, metadata, pkgs := sbomTestData()
+	doc := NewSPDX(imageID, metadata, pkgs)
+	if doc.SPDXVersion != "SPDX-2.3" || doc.Name != "library/ubuntu:22.04" ||
+		!strings.HasPrefix(doc.DocumentNamespace, spdxNamespace+"library%2Fubuntu%3A22.04-") {
+		t.Fatal("Unexpected document header", doc)
+	}
+	if len(doc.Packages) != 4 || len(doc.Relationships) != 4 {
+		t.Fatal("Expected 4 packages and relationships, got", doc.Packages, doc.Relationships)
+	}
+	if p := doc.Packages[0]; p.SPDXID != spdxImageID || p.PrimaryPackagePurpose != "CONTAINER" ||
+		len(p.ExternalRefs) != 1 || !strings.HasPrefix(p.ExternalRefs[0].ReferenceLocator, "pkg:oci/ubuntu@") {
+		t.Fatal("Unexpected image package", p)
+	}
+	if p := doc.Packages[1]; p.SPDXID != spdxOSID || p.PrimaryPackagePurpose != "OPERATING-SYSTEM" {
+		t.Fatal("Unexpected operating system package", p)
+	}
+	p := doc.Packages[3]
+	if p.SPDXID != "SPDXRef-Package-2" || p.Name != "libc6" || p.SourceInfo != "built from source package glibc" ||
+		p.ExternalRefs[0].ReferenceLocator != "pkg:deb/ubuntu/libc6@2.35-0ubuntu3.6?arch=amd64&distro=jammy&upstream=glibc" {
+		t.Fatal("Unexpected package", p)
+	}
+	if r := doc.Relationships[0]; r != (SPDXRelationship{spdxDocumentID, "DESCRIBES", spdxImageID}) {
+		t.Fatal("Unexpected relationship", r)
+	}
+	if r := doc.Relationships[3]; r != (SPDXRelationship{spdxImageID, "CONTAINS", "SPDXRef-Package-2"}) {
+		t.Fatal("Unexpected relationship", r)
+	}
+}
+
+func TestSBOMWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	imageID, metadata, pkgs := sbomTestData()
+	outMapMap := map[string]map[string]interface{}{
+		imageID:          {PKGEXTRACTSCRIPT: pkgs},
+		"sha256:nopkgs0": {"myscript": "output"},
+	}
+	cases := []struct {
+		format, filename string
+	}{
+		{SBOMCycloneDX, "sha256:0123456789ab.cdx.json"},
+		{SBOMSPDX, "sha256:0123456789ab.spdx.json"},
+	}
+	for _, c := range cases {
+		w := NewSBOMWriter(c.format, dir)
+		w.AppendImageMetadata(metadata)
+		w.WriteImageAllData(outMapMap)
+		b, err := ioutil.ReadFile(filepath.Join(dir, sbomDir, c.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if c.format == SBOMCycloneDX && doc["bomFormat"] != "CycloneDX" ||
+			c.format == SBOMSPDX && doc["name"] != "library/ubuntu:22.04" {
+			t.Fatal("Unexpected", c.format, "document", string(b))
+		}
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, sbomDir))
+	if len(files) != len(cases) {
+		t.Fatal("Expected an SBOM per format for the image with packages only, got", len(files), "files")
+	}
+
+	// without the metadata of its repo:tags, an image is named by its ID
+	w := NewSBOMWriter(SBOMSPDX, dir)
+	w.AppendImageMetadata(metadata)
+	w.RemoveImageMetadata(metadata)
+	w.WriteImageAllData(outMapMap)
+	b, _ := ioutil.ReadFile(filepath.Join(dir, sbomDir, cases[1].filename))
+	var doc SPDX
+	if err := json.Unmarshal(b, &doc); err != nil || doc.Name != imageID {
+		t.Fatal("Expected the document to be named", imageID, "got", doc.Name, err)
+	}
+}
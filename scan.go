@@ -0,0 +1,462 @@
+// scan.go resolves a single image reference for a one-shot scan (see the scan command), and writes
+// the scan report of the image.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	vulndb "github.com/banyanops/collector/vulndb"
+	blog "github.com/ccpaging/log4go"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Pull policies of a scan
+	PullMissing = "missing"
+	PullAlways  = "always"
+	PullNever   = "never"
+
+	// Formats of the scan report
+	ReportJSON = "json"
+	ReportYAML = "yaml"
+	ReportText = "text"
+)
+
+// imageIDPattern matches an image ID, full or abbreviated as by docker images.
+var imageIDPattern = regexp.MustCompile(`^(sha256:)?[0-9a-f]{12,64}$`)
+
+// ImageRef is a reference to an image: [REGISTRY/]REPO[:TAG][@DIGEST], or the ID of an image on the
+// Docker host.
+type ImageRef struct {
+	// Registry is config.DockerHub for Docker Hub
+	Registry string
+	Repo     string
+	Tag      string
+	Digest   string
+	// ID is set if the reference may be an image ID
+	ID string
+}
+
+// ParseImageRef parses an image reference the way docker does: the first component of the name is
+// a registry if it has a '.' or a ':' or is localhost, and official images of Docker Hub are in
+// the library namespace. The tag is latest if neither a tag nor a digest is given.
+func ParseImageRef(ref string) (r ImageRef, e error) {
+	if imageIDPattern.MatchString(ref) {
+		r.ID = ref
+	}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if !strings.Contains(r.Digest, ":") {
+			return r, errors.New("Invalid digest in image reference " + ref)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if r.Tag == "" {
+			return r, errors.New("Empty tag in image reference " + ref)
+		}
+	}
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 &&
+		(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Registry, name = parts[0], parts[1]
+	}
+	if r.Registry == "" || r.Registry == "docker.io" || r.Registry == "index.docker.io" {
+		r.Registry = config.DockerHub
+	}
+	if r.Registry == config.DockerHub && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || strings.Contains(name, "*") || !ValidRepoName(name) {
+		return r, errors.New("Invalid repository name in image reference " + ref)
+	}
+	r.Repo = name
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return
+}
+
+// LocalName returns the name of the image on the Docker host, with its tag or digest.
+func (r ImageRef) LocalName() string {
+	name := localRepoName(r.Registry, r.Repo)
+	if r.Digest != "" {
+		return name + "@" + r.Digest
+	}
+	return name + ":" + r.Tag
+}
+
+// String returns the full reference, including the registry.
+func (r ImageRef) String() string {
+	s := r.Registry + "/" + r.Repo
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// localImageStruct is the part of the response of the Docker daemon to an inspect image query
+// that goes into image metadata.
+type localImageStruct struct {
+	ImageStruct
+	RepoDigests []string
+}
+
+// inspectLocalImage returns the metadata of the image name (an ID, or a name with a tag or digest)
+// on the Docker host. found is false if there is no such image.
+func inspectLocalImage(name string) (metadata ImageMetadataInfo, found bool, e error) {
+	resp, e := DockerAPI(DockerClient, "GET", "/images/"+name+"/json", []byte{}, "")
+	if e != nil {
+		if strings.Contains(e.Error(), "status code: 404") {
+			e = nil
+		}
+		return
+	}
+	var m localImageStruct
+	if e = json.Unmarshal(resp, &m); e != nil {
+		return
+	}
+	metadata.Image = m.ID
+	if metadata.Datetime, e = time.Parse(time.RFC3339Nano, m.Created); e != nil {
+		return
+	}
+	metadata.Size = m.Size
+	metadata.Author = m.Author
+	metadata.Checksum = m.Checksum
+	metadata.Comment = m.Comment
+	metadata.Parent = m.Parent
+	metadata.OS = m.OS
+	metadata.Architecture = m.Architecture
+	metadata.Variant = m.Variant
+	for _, repoDigest := range m.RepoDigests {
+		if i := strings.Index(repoDigest, "@"); i >= 0 {
+			metadata.ManifestHash = repoDigest[i+1:]
+			break
+		}
+	}
+	return metadata, true, nil
+}
+
+// ResolveLocalImage returns the metadata of the image of ref on the Docker host, pulling it first
+// according to the pull policy. pulled is true if the image wasn't on the Docker host before,
+// and is to be removed after the scan with RemovePulledImage.
+func ResolveLocalImage(ref ImageRef, pull string) (metadata ImageMetadataInfo, pulled bool, e error) {
+	if ref.ID != "" {
+		// an image ID takes precedence over a repository of the same name, as with docker
+		var found bool
+		if metadata, found, e = inspectLocalImage(ref.ID); e != nil || found {
+			return
+		}
+	}
+	if ref.Repo == "" {
+		return metadata, false, errors.New("No image " + ref.ID + " on the Docker host")
+	}
+	found := false
+	if pull != PullAlways {
+		if metadata, found, e = inspectLocalImage(ref.LocalName()); e != nil {
+			return
+		}
+	}
+	if !found {
+		if pull == PullNever {
+			return metadata, false, errors.New("No image " + ref.LocalName() + " on the Docker host")
+		}
+		if e = pullImageRef(ref); e != nil {
+			return
+		}
+		if metadata, found, e = inspectLocalImage(ref.LocalName()); e != nil {
+			return
+		}
+		if !found {
+			return metadata, false, errors.New("Image " + ref.LocalName() + " not found after pulling it")
+		}
+		pulled = true
+	}
+	metadata.Registry = ref.Registry
+	metadata.Repo = ref.Repo
+	metadata.Tag = ref.Tag
+	if ref.Digest != "" {
+		metadata.ManifestHash = ref.Digest
+	}
+	return
+}
+
+// pullImageRef pulls the image of ref with the Docker daemon.
+func pullImageRef(ref ImageRef) (e error) {
+	_, _, authConfig := RegAuth(ref.Registry)
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	apipath := "/images/create?fromImage=" + url.QueryEscape(localRepoName(ref.Registry, ref.Repo)) +
+		"&tag=" + url.QueryEscape(reference)
+	if platforms, _ := ParsePlatforms(*Platforms); len(platforms) == 1 {
+		apipath += "&platform=" + url.QueryEscape(platforms[0].String())
+	}
+	blog.Info("Pulling %s", ref.String())
+	resp, e := DockerAPI(DockerClient, "POST", apipath, []byte{}, authConfig)
+	if e != nil {
+		return
+	}
+	if strings.Contains(string(resp), `"error":`) {
+		return errors.New("Pull of " + ref.String() + " failed: " + string(resp))
+	}
+	return
+}
+
+// RemovePulledImage removes the image of ref from the Docker host after it was pulled for a scan.
+// Only the pulled name is removed, in case the image has others.
+func RemovePulledImage(ref ImageRef) {
+	if _, e := DockerAPI(DockerClient, "DELETE", "/images/"+ref.LocalName(), []byte{}, ""); e != nil {
+		except.Error(e, ": Error in removing pulled image", ref.LocalName())
+	}
+}
+
+// ResolveRegistryImage returns the metadata of the image of ref as described by its manifest in
+// the registry (see --daemonless). A manifest list must resolve to a single platform through
+// --platforms.
+func ResolveRegistryImage(ref ImageRef) (metadata ImageMetadataInfo, e error) {
+	if ref.Repo == "" {
+		return metadata, errors.New("Image IDs cannot be scanned in daemonless mode")
+	}
+	RegistrySpec = ref.Registry
+	RegistryAPIURL, HubAPI, BasicAuth, XRegistryAuth = GetRegistryURL()
+	client := registryClient()
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	metadataSlice, e := v2GetMetadata(client, ref.Repo, reference)
+	if e != nil {
+		return
+	}
+	if len(metadataSlice) > 1 {
+		platforms := []string{}
+		for _, m := range metadataSlice {
+			platforms = append(platforms, m.Platform())
+		}
+		return metadata, errors.New(ref.String() + " has images for platforms " +
+			strings.Join(platforms, ", ") + "; select one with --platforms")
+	}
+	metadata = metadataSlice[0]
+	metadata.Tag = ref.Tag
+	return
+}
+
+// ScanReportImage identifies the image in a scan report.
+type ScanReportImage struct {
+	Reference string    `json:"reference" yaml:"reference"`
+	ID        string    `json:"id,omitempty" yaml:"id,omitempty"`
+	Registry  string    `json:"registry,omitempty" yaml:"registry,omitempty"`
+	Repo      string    `json:"repo,omitempty" yaml:"repo,omitempty"`
+	Tag       string    `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Digest    string    `json:"digest,omitempty" yaml:"digest,omitempty"`
+	Platform  string    `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Created   time.Time `json:"created" yaml:"created"`
+	Size      uint64    `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// ScanReport is the outcome of a one-shot scan of an image.
+type ScanReport struct {
+	Image   ScanReportImage `json:"image" yaml:"image"`
+	Scanned time.Time       `json:"scanned" yaml:"scanned"`
+	// Data maps the output map keys of runAllScripts to their data, with the output of scripts
+	// decoded from its format
+	Data map[string]interface{} `json:"data" yaml:"data"`
+}
+
+// NewScanReport returns the report of the scan of the image of metadata, referenced by ref, that
+// produced outMap.
+func NewScanReport(ref string, metadata ImageMetadataInfo, outMap map[string]interface{}) (r ScanReport) {
+	r.Image = ScanReportImage{
+		Reference: ref,
+		ID:        metadata.Image,
+		Registry:  metadata.Registry,
+		Repo:      metadata.Repo,
+		Tag:       metadata.Tag,
+		Digest:    metadata.ManifestHash,
+		Platform:  metadata.Platform(),
+		Created:   metadata.Datetime,
+		Size:      metadata.Size,
+	}
+	r.Scanned = time.Now().UTC()
+	r.Data = make(map[string]interface{}, len(outMap))
+	for key, value := range outMap {
+		r.Data[key] = reportValue(value)
+	}
+	return
+}
+
+// reportValue returns the output of a script decoded from its format, and other data as is.
+func reportValue(value interface{}) interface{} {
+	o, ok := value.(ScriptOutput)
+	if !ok {
+		return value
+	}
+	var v interface{}
+	switch o.Format {
+	case ScriptOutputJSON:
+		if json.Unmarshal(o.Data, &v) == nil {
+			return v
+		}
+	case ScriptOutputYAML:
+		if yaml.Unmarshal(o.Data, &v) == nil {
+			return normalizeValue(v)
+		}
+	}
+	return string(o.Data)
+}
+
+// PolicyFailed returns true if the image of the scan violates the policy it was evaluated against.
+func (r ScanReport) PolicyFailed() bool {
+	verdict, ok := r.Data[POLICYVERDICT].(PolicyVerdict)
+	return ok && !verdict.Passed()
+}
+
+// Failed returns the script executions of the scan that failed.
+func (r ScanReport) Failed() (failed []ScriptResult) {
+	results, _ := r.Data[SCRIPTRESULTS].([]ScriptResult)
+	for _, result := range results {
+		if result.Error != "" || result.TimedOut || result.ExitStatus != 0 {
+			failed = append(failed, result)
+		}
+	}
+	return
+}
+
+// WriteScanReport writes report to w in format: json, yaml, or text for a summary.
+func WriteScanReport(w io.Writer, report ScanReport, format string) (e error) {
+	switch format {
+	case ReportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(report)
+	case ReportYAML:
+		// through json, so that the data is keyed as in the json report
+		b, e := json.Marshal(report)
+		if e != nil {
+			return e
+		}
+		var v interface{}
+		if e = yaml.Unmarshal(b, &v); e != nil {
+			return e
+		}
+		b, e = yaml.Marshal(v)
+		if e != nil {
+			return e
+		}
+		_, e = w.Write(b)
+		return e
+	case ReportText:
+		return writeScanSummary(w, report)
+	}
+	return errors.New("Unknown report format " + format)
+}
+
+// writeScanSummary writes a human readable summary of report.
+func writeScanSummary(w io.Writer, report ScanReport) (e error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Image:\t%s\n", report.Image.Reference)
+	fmt.Fprintf(tw, "ID:\t%s\n", report.Image.ID)
+	if report.Image.Digest != "" {
+		fmt.Fprintf(tw, "Digest:\t%s\n", report.Image.Digest)
+	}
+	if report.Image.Platform != "" {
+		fmt.Fprintf(tw, "Platform:\t%s\n", report.Image.Platform)
+	}
+	if pkgs, ok := report.Data[PKGEXTRACTSCRIPT].([]ImageDataInfo); ok {
+		if len(pkgs) > 0 {
+			fmt.Fprintf(tw, "Distribution:\t%s\n", pkgs[0].DistroName)
+		}
+		fmt.Fprintf(tw, "Packages:\t%d\n", len(pkgs))
+	}
+	if findings, ok := report.Data[VULNERABILITIES].([]vulndb.Finding); ok {
+		writeVulnerabilitySummary(tw, findings)
+	}
+	if verdict, ok := report.Data[POLICYVERDICT].(PolicyVerdict); ok {
+		writePolicySummary(tw, verdict)
+	}
+	results, _ := report.Data[SCRIPTRESULTS].([]ScriptResult)
+	if len(results) > 0 {
+		fmt.Fprintf(tw, "\nSCRIPT\tSTATUS\tDURATION\n")
+		for _, result := range results {
+			status := "ok"
+			switch {
+			case result.TimedOut:
+				status = "timed out"
+			case result.Error != "" || result.ExitStatus != 0:
+				status = fmt.Sprintf("failed (exit status %d)", result.ExitStatus)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%.1fs\n", result.Script, status, result.Duration)
+		}
+	}
+	others := []string{}
+	for key := range report.Data {
+		if key != PKGEXTRACTSCRIPT && key != SCRIPTRESULTS && key != VULNERABILITIES &&
+			key != POLICYVERDICT {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	if len(others) > 0 {
+		fmt.Fprintf(tw, "\nOutput of %s; use --format=json or yaml for the data\n", strings.Join(others, ", "))
+	}
+	return tw.Flush()
+}
+
+// writeVulnerabilitySummary writes the number of findings by severity, and the findings.
+func writeVulnerabilitySummary(tw io.Writer, findings []vulndb.Finding) {
+	counts := make(map[string]int)
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	bySeverity := []string{}
+	for _, severity := range []string{vulndb.SeverityCritical, vulndb.SeverityHigh, vulndb.SeverityMedium,
+		vulndb.SeverityLow, vulndb.SeverityNegligible, vulndb.SeverityUnknown} {
+		if counts[severity] > 0 {
+			bySeverity = append(bySeverity, fmt.Sprintf("%d %s", counts[severity], severity))
+		}
+	}
+	fmt.Fprintf(tw, "Vulnerabilities:\t%d", len(findings))
+	if len(bySeverity) > 0 {
+		fmt.Fprintf(tw, " (%s)", strings.Join(bySeverity, ", "))
+	}
+	fmt.Fprintf(tw, "\n")
+	if len(findings) == 0 {
+		return
+	}
+	fmt.Fprintf(tw, "\nVULNERABILITY\tSEVERITY\tPACKAGE\tVERSION\tFIXED IN\n")
+	for _, f := range findings {
+		fixed := f.FixedVersion
+		if fixed == "" {
+			fixed = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", f.ID, f.Severity, f.Pkg, f.Version, fixed)
+	}
+}
+
+// writePolicySummary writes the policy verdict, and the rules that the image violates.
+func writePolicySummary(tw io.Writer, verdict PolicyVerdict) {
+	fmt.Fprintf(tw, "Policy:\t%s (%s)\n", verdict.Verdict, verdict.Policy)
+	if len(verdict.Violations) == 0 {
+		return
+	}
+	fmt.Fprintf(tw, "\nRULE\tSUBJECT\tVIOLATION\n")
+	for _, v := range verdict.Violations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Rule, v.Subject, v.Message)
+	}
+}
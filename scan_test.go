@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/banyanops/collector/config"
+)
+
+func TestParseImageRef(t *testing.T) {
+	hub := config.DockerHub
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	cases := []struct {
+		ref       string
+		r         ImageRef
+		localName string
+		ok        bool
+	}{
+		{"nginx", ImageRef{Registry: hub, Repo: "library/nginx", Tag: "latest"}, "nginx:latest", true},
+		{"nginx:1.25", ImageRef{Registry: hub, Repo: "library/nginx", Tag: "1.25"}, "nginx:1.25", true},
+		{"banyanops/nginx:1.7", ImageRef{Registry: hub, Repo: "banyanops/nginx", Tag: "1.7"},
+			"banyanops/nginx:1.7", true},
+		{"docker.io/library/alpine:3.19", ImageRef{Registry: hub, Repo: "library/alpine", Tag: "3.19"},
+			"alpine:3.19", true},
+		{"localhost:5000/team/app", ImageRef{Registry: "localhost:5000", Repo: "team/app", Tag: "latest"},
+			"localhost:5000/team/app:latest", true},
+		{"registry.example.com/app@" + digest,
+			ImageRef{Registry: "registry.example.com", Repo: "app", Digest: digest},
+			"registry.example.com/app@" + digest, true},
+		{"registry.example.com:443/app:v2@" + digest,
+			ImageRef{Registry: "registry.example.com:443", Repo: "app", Tag: "v2", Digest: digest},
+			"registry.example.com:443/app@" + digest, true},
+		{"0123456789ab", ImageRef{Registry: hub, Repo: "library/0123456789ab", Tag: "latest", ID: "0123456789ab"},
+			"0123456789ab:latest", true},
+		{digest, ImageRef{Registry: hub, Repo: "library/sha256", Tag: strings.Repeat("ab", 32), ID: digest},
+			"sha256:" + strings.Repeat("ab", 32), true},
+		{"nginx:", ImageRef{}, "", false},
+		{"nginx@1.25", ImageRef{}, "", false},
+		{"team/*", ImageRef{}, "", false},
+		{"bad name", ImageRef{}, "", false},
+	}
+	for _, c := range cases {
+		r, err := ParseImageRef(c.ref)
+		if (err == nil) != c.ok {
+			t.Fatal(c.ref, "got", r, err)
+		}
+		if !c.ok {
+			continue
+		}
+		if r != c.r || r.LocalName() != c.localName {
+			t.Fatal(c.ref, "expected", c.r, c.localName, "got", r, r.LocalName())
+		}
+	}
+}
+
+func TestScanReport(t *testing.T) {
+	metadata := ImageMetadataInfo{Image: "sha256:0123456789abcdef", Datetime: time.Unix(0, 0).UTC(),
+		OtherMetadata: OtherMetadata{Repo: "library/nginx", Tag: "1.25", OS: "linux", Architecture: "amd64"},
+		Registry:      config.DockerHub}
+	outMap := map[string]interface{}{
+		PKGEXTRACTSCRIPT: []ImageDataInfo{{Image: metadata.Image, DistroName: "Debian GNU/Linux 12 (bookworm)",
+			Pkg: "libc6", Version: "2.36-9"}},
+		"listUsers.py": ScriptRecords{Schema: "users", Version: 1,
+			Records: []map[string]interface{}{{"name": "root"}}},
+		"config.json": ScriptOutput{Format: ScriptOutputJSON, Data: []byte(`{"port": 80}`)},
+		"config.yaml": ScriptOutput{Format: ScriptOutputYAML, Data: []byte("port: 80\n")},
+		"motd.sh":     ScriptOutput{Format: ScriptOutputText, Data: []byte("hello\n")},
+		SCRIPTRESULTS: []ScriptResult{{Script: "motd.sh"}, {Script: "broken.sh", ExitStatus: 2}},
+	}
+	report := NewScanReport("nginx:1.25", metadata, outMap)
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Script != "broken.sh" {
+		t.Fatal("Unexpected failed scripts", failed)
+	}
+
+	var b bytes.Buffer
+	if err := WriteScanReport(&b, report, ReportJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Image ScanReportImage
+		Data  map[string]interface{}
+	}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Image.Platform != "linux/amd64" || decoded.Image.Repo != "library/nginx" {
+		t.Fatal("Unexpected image", decoded.Image)
+	}
+	for _, key := range []string{"config.json", "config.yaml"} {
+		if port := decoded.Data[key].(map[string]interface{})["port"]; port != float64(80) {
+			t.Fatal("Unexpected output of", key, decoded.Data[key])
+		}
+	}
+	if decoded.Data["motd.sh"] != "hello\n" {
+		t.Fatal("Unexpected text output", decoded.Data["motd.sh"])
+	}
+
+	b.Reset()
+	if err := WriteScanReport(&b, report, ReportYAML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "reference: nginx:1.25") {
+		t.Fatal("Unexpected yaml report", b.String())
+	}
+
+	b.Reset()
+	if err := WriteScanReport(&b, report, ReportText); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(b.String())
+	for _, s := range []string{"Packages:", "Debian GNU/Linux 12", "failed (exit status 2)", "config.json, config.yaml"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatal("Missing", s, "in summary")
+		}
+	}
+	if err := WriteScanReport(&b, report, "xml"); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+}
@@ -3,16 +3,60 @@ package collector
 import (
 	"errors"
 	"strconv"
+	"strings"
+	"time"
 
 	except "github.com/banyanops/collector/except"
 	blog "github.com/ccpaging/log4go"
 )
 
+const (
+	// stopTimeout bounds the wait for a timed-out container to stop once it is killed.
+	stopTimeout = 30 * time.Second
+)
+
 // Script is the common interface to run sripts inside a container
 type Script interface {
-	//We expect YAML output from scripts that needs parsing of output by Banyan service
-	Run(imageID ImageIDType) ([]byte, error)
+	//We expect YAML output from scripts that needs parsing of output by Banyan service.
+	//The result is filled in as far as the script ran, even if an error is returned.
+	Run(imageID ImageIDType) (ScriptResult, error)
 	Name() string
+	// OutputFormat is the format of the script output: yaml, json or text
+	OutputFormat() string
+	// Command is the bash command line that runs the script, including its environment variables
+	Command() string
+	// Timeout is the time after which the script is killed (0 for no limit)
+	Timeout() time.Duration
+	// Sandbox is the sandbox of the container that runs the script
+	Sandbox() Sandbox
+}
+
+// ScriptResult records an execution of a script, for debugging failures in scripts.
+type ScriptResult struct {
+	Script string `json:"script"`
+	// ContainerID is the container in which the script ran, if any
+	ContainerID string    `json:"containerID,omitempty"`
+	Start       time.Time `json:"start"`
+	// Duration is the run time of the script in seconds
+	Duration   float64 `json:"duration"`
+	ExitStatus int     `json:"exitStatus"`
+	TimedOut   bool    `json:"timedOut"`
+	// Error tells why the script failed, if it did
+	Error  string `json:"error,omitempty"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// runError returns the error of a script run that timed out after timeout or exited with a
+// non-zero status, and nil otherwise.
+func runError(r ScriptResult, timeout time.Duration) error {
+	if r.TimedOut {
+		return errors.New("Script " + r.Script + " killed after timeout of " + timeout.String())
+	}
+	if r.ExitStatus != 0 {
+		return errors.New("Bash script exit status: " + strconv.Itoa(r.ExitStatus))
+	}
+	return nil
 }
 
 // Script info for all types (e.g., bash, python, etc.)
@@ -21,6 +65,10 @@ type ScriptInfo struct {
 	dirPath      string
 	params       []string
 	staticBinary string
+	env          []string
+	timeout      time.Duration
+	outputFormat string
+	sandbox      Sandbox
 }
 
 // Create a new bash script
@@ -30,22 +78,83 @@ func newBashScript(scriptName string, path string, params []string) Script {
 		dirPath:      path,
 		params:       params,
 		staticBinary: "bash-static",
+		timeout:      *ScriptTimeout,
+		outputFormat: ScriptOutputText,
+		sandbox:      DefaultSandbox,
 	}
 }
 
-// Create a new python script
-func newPythonScript(scriptName string, path string, params []string) Script {
+// Create a new script that is run as described by its manifest
+func newManifestScript(scriptName string, path string, m ScriptManifest) Script {
 	return &ScriptInfo{
 		name:         scriptName,
 		dirPath:      path,
-		params:       params,
-		staticBinary: "python-static",
+		params:       m.Args,
+		staticBinary: m.Interpreter,
+		env:          m.envList(),
+		timeout:      *m.Timeout,
+		outputFormat: m.Output,
+		sandbox:      m.Sandbox,
+	}
+}
+
+// command returns the bash command line that runs the script, without its environment variables.
+func (sh ScriptInfo) command() string {
+	return sh.commandLine(nil)
+}
+
+// Command returns the bash command line that runs the script with its environment variables.
+func (sh ScriptInfo) Command() string {
+	return sh.commandLine(sh.env)
+}
+
+// commandLine returns the bash command line that runs the script, setting the environment
+// variables env with busybox env.
+func (sh ScriptInfo) commandLine(env []string) string {
+	words := []string{"PATH=" + TARGETCONTAINERDIR + "/bin" + ":$PATH"}
+	if len(env) > 0 {
+		words = append(words, "busybox", "env")
+		for _, e := range env {
+			words = append(words, shellQuote(e))
+		}
 	}
+	words = append(words, sh.staticBinary, shellQuote(sh.dirPath+"/"+sh.name))
+	for _, param := range sh.params {
+		words = append(words, shellQuote(param))
+	}
+	return strings.Join(words, " ")
+}
+
+// Timeout gives the time after which the script is killed
+func (sh ScriptInfo) Timeout() time.Duration {
+	return sh.timeout
+}
+
+// Sandbox gives the sandbox of the container that runs the script
+func (sh ScriptInfo) Sandbox() Sandbox {
+	return sh.sandbox
 }
 
 // Run handles running of a script inside an image
-func (sh ScriptInfo) Run(imageID ImageIDType) (b []byte, err error) {
-	jsonString, err := createCmd(imageID, sh.name, sh.staticBinary, sh.dirPath)
+func (sh ScriptInfo) Run(imageID ImageIDType) (result ScriptResult, err error) {
+	result, err = runContainer(imageID, []string{sh.command()}, sh.env, sh.timeout, sh.sandbox)
+	result.Script = sh.name
+	if err == nil {
+		err = runError(result, sh.timeout)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return
+}
+
+// runContainer runs the bash command cmd, with optional arguments, in a new container created from
+// the image within the sandbox, and returns the exit status and output of the command. If the container
+// runs longer than timeout (if not zero), it is killed and the result is marked as timed out.
+func runContainer(imageID ImageIDType, cmd []string, env []string, timeout time.Duration,
+	sandbox Sandbox) (result ScriptResult, err error) {
+
+	jsonString, err := createCmd(imageID, cmd, env, sandbox)
 	if err != nil {
 		except.Error(err, ": Error in creating command")
 		return
@@ -57,36 +166,72 @@ func (sh ScriptInfo) Run(imageID ImageIDType) (b []byte, err error) {
 		return
 	}
 	blog.Debug("New container ID: %s", containerID)
+	result.ContainerID = containerID
 
-	defer RemoveContainer(containerID)
+	removed := false
+	defer func() {
+		if !removed {
+			RemoveContainer(containerID)
+		}
+	}()
 
+	result.Start = time.Now()
 	jsonString, err = StartContainer(containerID)
 	if err != nil {
 		except.Error(err, ": Error in starting container")
 		return
 	}
 	blog.Debug("Response from StartContainer: %s", string(jsonString))
-	statusCode, err := WaitContainer(containerID)
-	if err != nil {
-		except.Error(err, ": Error in waiting for container to stop")
-		return
+	type waitResult struct {
+		statusCode int
+		err        error
 	}
-	if statusCode != 0 {
-		err = errors.New("Bash script exit status: " + strconv.Itoa(statusCode))
+	waited := make(chan waitResult, 1)
+	go func() {
+		statusCode, err := WaitContainer(containerID)
+		waited <- waitResult{statusCode, err}
+	}()
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	var w waitResult
+	select {
+	case w = <-waited:
+	case <-timer:
+		except.Warn("Container %s of image %s timed out after %s; killing it", containerID, string(imageID), timeout.String())
+		result.TimedOut = true
+		if _, err := KillContainer(containerID); err != nil {
+			// the container must be gone for WaitContainer to return
+			_, err = ForceRemoveContainer(containerID)
+			removed = err == nil
+		}
+		stopTimer := time.NewTimer(stopTimeout)
+		defer stopTimer.Stop()
+		select {
+		case w = <-waited:
+		case <-stopTimer.C:
+			result.Duration = time.Since(result.Start).Seconds()
+			err = errors.New("Container " + containerID + " did not stop " + stopTimeout.String() +
+				" after it timed out")
+			except.Error(err)
+			return
+		}
+	}
+	result.Duration = time.Since(result.Start).Seconds()
+	if err = w.err; err != nil {
+		except.Error(err, ": Error in waiting for container to stop")
 		return
 	}
-	b, err = LogsContainer(containerID)
+	result.ExitStatus = w.statusCode
+	stdout, stderr, err := LogsContainerStreams(containerID)
 	if err != nil {
 		except.Error(err, ":Error in extracting output from container")
 		return
 	}
-	/*
-		_, err = removeContainer(containerID)
-		if err != nil {
-			except.Error(err, ":Error in removing container for image", containerID)
-			return
-		}
-	*/
+	result.Stdout, result.Stderr = string(stdout), string(stderr)
 	return
 }
 
@@ -94,3 +239,8 @@ func (sh ScriptInfo) Run(imageID ImageIDType) (b []byte, err error) {
 func (sh ScriptInfo) Name() string {
 	return sh.name
 }
+
+// OutputFormat gives the format of the script output
+func (sh ScriptInfo) OutputFormat() string {
+	return sh.outputFormat
+}
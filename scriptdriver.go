@@ -0,0 +1,158 @@
+// scriptdriver.go runs all the scripts of an image in a single container (see --singlecontainer).
+// A driver written in bash runs the scripts one after the other, and frames the standard output,
+// standard error and exit status of each script on its own standard output.
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	except "github.com/banyanops/collector/except"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// driverFrameMarker starts the header line of each script result framed by the driver.
+	driverFrameMarker = "BANYAN-SCRIPT-RESULT"
+
+	// scriptDriver is run by bash with arguments in pairs: the timeout of a script in seconds (0 for
+	// none) and its command line. Each script runs in its own process group (set -m), so that a
+	// timeout kills all of its processes. Each result is framed by a header line with the script
+	// index, exit status, a timed out flag, the run time in milliseconds (from /proc/uptime, 0 if
+	// unavailable) and the sizes of the stdout and stderr that follow.
+	scriptDriver = `set -m
+PATH=` + TARGETCONTAINERDIR + `/bin:$PATH
+d=$(busybox mktemp -d 2>/dev/null || busybox mktemp -d -p /dev/shm) || {
+	echo "scriptdriver: cannot create a temporary directory" >&2
+	exit 125
+}
+uptime() {
+	local up rest
+	read up rest 2>/dev/null </proc/uptime && [ "${up#*.}" != "$up" ] && echo $((10#${up/./}*10)) || echo 0
+}
+i=0
+while [ $# -ge 2 ]; do
+	timeout=$1
+	cmd=$2
+	shift 2
+	busybox rm -f "$d/timedout"
+	start=$(uptime)
+	bash-static -c "$cmd" </dev/null >"$d/out" 2>"$d/err" &
+	pid=$!
+	w=
+	if [ "$timeout" -gt 0 ]; then
+		(busybox sleep "$timeout"; : >"$d/timedout"; kill -9 -$pid) >/dev/null 2>&1 &
+		w=$!
+	fi
+	wait $pid
+	rc=$?
+	ms=$(($(uptime)-start))
+	if [ -n "$w" ]; then
+		kill -9 -$w >/dev/null 2>&1
+		wait $w 2>/dev/null
+	fi
+	t=0
+	[ -e "$d/timedout" ] && t=1
+	o=$(busybox wc -c <"$d/out")
+	e=$(busybox wc -c <"$d/err")
+	printf '` + driverFrameMarker + ` %d %d %d %d %d %d\n' $i $rc $t $ms $((o)) $((e))
+	busybox cat "$d/out" "$d/err"
+	i=$((i+1))
+done
+busybox rm -rf "$d"
+`
+)
+
+var (
+	SingleContainer = flag.Bool("singlecontainer", false,
+		"Run all the scripts of an image in a single container, instead of one container per script")
+)
+
+// driverArgs returns the arguments of the driver for running the scripts, and the overall time limit
+// for the driver (0 for none).
+func driverArgs(scripts []Script) (args []string, timeout time.Duration) {
+	args = []string{scriptDriver, "scriptdriver"}
+	for _, script := range scripts {
+		// round timeouts up to whole seconds for busybox sleep
+		seconds := int64((script.Timeout() + time.Second - 1) / time.Second)
+		args = append(args, strconv.FormatInt(seconds, 10), script.Command())
+		if script.Timeout() == 0 {
+			timeout = -1
+		} else if timeout >= 0 {
+			timeout += time.Duration(seconds) * time.Second
+		}
+	}
+	if timeout < 0 {
+		return args, 0
+	}
+	// leave some time for starting the scripts and framing their results
+	return args, timeout + time.Minute
+}
+
+// parseDriverOutput splits the output of the driver into the results of the n scripts it ran.
+// The first parsed results are complete: the driver may have been killed before the others.
+func parseDriverOutput(output []byte, n int) (results []ScriptResult, parsed int) {
+	results = make([]ScriptResult, n)
+	for len(output) > 0 && parsed < n {
+		eol := bytes.IndexByte(output, '\n')
+		if eol < 0 {
+			break
+		}
+		var index, exitStatus, timedOut, ms, stdoutLen, stderrLen int
+		var marker string
+		count, err := fmt.Sscanf(string(output[:eol]), "%s %d %d %d %d %d %d",
+			&marker, &index, &exitStatus, &timedOut, &ms, &stdoutLen, &stderrLen)
+		if err != nil || count != 7 || marker != driverFrameMarker || index != parsed ||
+			stdoutLen < 0 || stderrLen < 0 || eol+1+stdoutLen+stderrLen > len(output) {
+			break
+		}
+		output = output[eol+1:]
+		r := &results[parsed]
+		r.Stdout = string(output[:stdoutLen])
+		r.Stderr = string(output[stdoutLen : stdoutLen+stderrLen])
+		r.ExitStatus = exitStatus
+		r.TimedOut = timedOut == 1
+		r.Duration = float64(ms) / 1000
+		output = output[stdoutLen+stderrLen:]
+		parsed++
+	}
+	return
+}
+
+// runScriptsInContainer runs all the scripts in a single container created from the image, in the
+// default sandbox.
+// A script has a non-empty Error in its result if it failed.
+func runScriptsInContainer(imageID ImageIDType, scripts []Script) (results []ScriptResult, err error) {
+	args, timeout := driverArgs(scripts)
+	driver, err := runContainer(imageID, args, nil, timeout, DefaultSandbox)
+	if err != nil {
+		return
+	}
+	if driver.TimedOut || driver.ExitStatus != 0 {
+		// the results of the scripts that completed are still good
+		except.Warn("Script driver in image %s exited with status %d, timed out: %v", string(imageID),
+			driver.ExitStatus, driver.TimedOut)
+	}
+	results, parsed := parseDriverOutput([]byte(driver.Stdout), len(scripts))
+	start := driver.Start
+	for i, script := range scripts {
+		r := &results[i]
+		r.Script = script.Name()
+		r.ContainerID = driver.ContainerID
+		// the driver runs the scripts one after the other
+		r.Start = start
+		start = start.Add(time.Duration(r.Duration * float64(time.Second)))
+		if i >= parsed {
+			r.Error = "No result from the script driver"
+			if i == parsed {
+				// whatever the driver itself said about it
+				r.Stderr = driver.Stderr
+			}
+		} else if err := runError(*r, script.Timeout()); err != nil {
+			r.Error = err.Error()
+		}
+	}
+	return
+}
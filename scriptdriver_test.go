@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDriverOutput(t *testing.T) {
+	output := []byte(driverFrameMarker + " 0 0 0 20 6 0\nhello\n" +
+		driverFrameMarker + " 1 3 0 10 0 5\noops\n" +
+		driverFrameMarker + " 2 137 1 1000 2 0\nhi")
+	cases := []struct {
+		n      int
+		output []byte
+		parsed int
+		stdout []string
+		status []int
+	}{
+		{3, output, 3, []string{"hello\n", "", "hi"}, []int{0, 3, 137}},
+		// the driver was killed during the third script
+		{4, output[:len(output)-1], 2, []string{"hello\n", "", "", ""}, []int{0, 3, 0, 0}},
+		{1, []byte("garbage\n"), 0, []string{""}, []int{0}},
+		{0, output, 0, []string{}, []int{}},
+	}
+	for _, c := range cases {
+		results, parsed := parseDriverOutput(c.output, c.n)
+		if len(results) != c.n || parsed != c.parsed {
+			t.Fatal("Expected", c.n, "results,", c.parsed, "parsed, got", len(results), parsed)
+		}
+		for i, r := range results {
+			if r.Stdout != c.stdout[i] || r.ExitStatus != c.status[i] {
+				t.Fatalf("Result %d: got %q %d", i, r.Stdout, r.ExitStatus)
+			}
+		}
+	}
+	if results, _ := parseDriverOutput(output, 3); results[1].Stderr != "oops\n" || results[2].Duration != 1 ||
+		!results[2].TimedOut {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+}
+
+// TestScriptDriver runs the driver with the static binaries in data/bin, without a container.
+func TestScriptDriver(t *testing.T) {
+	binDir, err := filepath.Abs("data/bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(binDir + "/bash-static"); err != nil {
+		t.Skip("No static binaries: ", err)
+	}
+	dir, err := ioutil.TempDir("", "scriptdriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"out.sh":   `echo "$1 $GREETING"; echo warning >&2`,
+		"fail.sh":  "echo partial; exit 3",
+		"hang.sh":  "busybox sleep 100 & busybox sleep 100",
+		"after.sh": "printf 'no newline'",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := func(name string, timeout time.Duration, params, env []string) Script {
+		return &ScriptInfo{name: name, dirPath: dir, staticBinary: "bash-static", params: params, env: env,
+			timeout: timeout, outputFormat: ScriptOutputText}
+	}
+	scripts := []Script{
+		script("out.sh", time.Minute, []string{"it's"}, []string{"GREETING=hello world"}),
+		script("fail.sh", 0, nil, nil),
+		script("hang.sh", time.Second, nil, nil),
+		script("after.sh", time.Minute, nil, nil),
+	}
+	args, timeout := driverArgs(scripts)
+	if timeout != 0 {
+		t.Fatal("Expected no driver timeout with a script without timeout, got", timeout)
+	}
+	cmd := exec.Command(binDir+"/bash-static", append([]string{"-c"}, args...)...)
+	cmd.Env = []string{"PATH=" + binDir + ":/usr/bin:/bin"}
+	start := time.Now()
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Driver ran for", time.Since(start))
+	results, parsed := parseDriverOutput(output, len(scripts))
+	if parsed != len(scripts) {
+		t.Fatal("Parsed", parsed, "results of", len(scripts))
+	}
+	expected := []struct {
+		stdout, stderr string
+		timedOut       bool
+		status         int
+	}{
+		{"it's hello world\n", "warning\n", false, 0},
+		{"partial\n", "", false, 3},
+		{"", "", true, 137},
+		{"no newline", "", false, 0},
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Stdout != e.stdout || r.Stderr != e.stderr || r.TimedOut != e.timedOut ||
+			r.ExitStatus != e.status {
+			t.Fatalf("%s: got %+v", scripts[i].Name(), r)
+		}
+	}
+	if results[2].Duration < 1 || results[2].Duration > 30 {
+		t.Fatal("Unexpected duration of the script that timed out:", results[2].Duration)
+	}
+	if time.Since(start) > 30*time.Second {
+		t.Fatal("Script timeout not enforced")
+	}
+}
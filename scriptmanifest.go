@@ -0,0 +1,156 @@
+// scriptmanifest.go reads the optional sidecar manifests that tell the collector how to run a script.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	except "github.com/banyanops/collector/except"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ScriptManifestSuffix is appended to the file name of a script to get the name of its manifest,
+	// e.g., listUsers.py.yaml.
+	ScriptManifestSuffix = ".yaml"
+
+	// Output formats of scripts
+	ScriptOutputYAML = "yaml"
+	ScriptOutputJSON = "json"
+	ScriptOutputText = "text"
+)
+
+var (
+	ScriptTimeout = flag.Duration("scripttimeout", 10*time.Minute,
+		"Time after which a script is killed, unless its manifest sets another timeout (0 for no limit)")
+)
+
+// ScriptManifest describes how to run a script. All the fields are optional.
+type ScriptManifest struct {
+	// Timeout after which the script is killed, e.g., 90s or 5m (default --scripttimeout).
+	Timeout *time.Duration `yaml:"timeout"`
+	// Args are passed to the script on its command line.
+	Args []string `yaml:"args"`
+	// Env holds the environment variables set for the script.
+	Env map[string]string `yaml:"env"`
+	// Interpreter runs the script: a static binary in hosttarget/bin, e.g., bash-static, python-static
+	// or busybox, or else an interpreter found on the PATH of the image, e.g., /usr/bin/perl.
+	// The default is bash-static for .sh scripts and python-static for .py scripts.
+	Interpreter string `yaml:"interpreter"`
+	// Output is the format of the standard output of the script: yaml, json or text (default text).
+	Output string `yaml:"output"`
+	// Sandbox overrides settings of the default sandbox of the container that runs the script.
+	Sandbox Sandbox `yaml:"sandbox"`
+}
+
+// ScriptOutput is the output of a script, in the format declared by its manifest.
+type ScriptOutput struct {
+	Format string
+	Data   []byte
+}
+
+// defaultInterpreters maps script file suffixes to the static binaries that run them.
+var defaultInterpreters = map[string]string{
+	".sh": "bash-static",
+	".py": "python-static",
+}
+
+// loadScriptManifest reads the manifest of the script scriptName in dirPath, if there is one,
+// and fills in the defaults of the fields left out.
+func loadScriptManifest(dirPath, scriptName string) (m ScriptManifest, e error) {
+	filename := filepath.Join(dirPath, scriptName+ScriptManifestSuffix)
+	// the settings left out of the sandbox section of the manifest keep their defaults
+	m.Sandbox = DefaultSandbox
+	data, e := ioutil.ReadFile(filename)
+	switch {
+	case os.IsNotExist(e):
+		e = nil
+	case e != nil:
+		return
+	default:
+		if e = yaml.UnmarshalStrict(data, &m); e != nil {
+			e = errors.New(filename + ": " + e.Error())
+			return
+		}
+	}
+	if e = m.Sandbox.validate(); e != nil {
+		return m, errors.New(filename + ": " + e.Error())
+	}
+	if m.Timeout == nil {
+		m.Timeout = ScriptTimeout
+	}
+	if *m.Timeout < 0 {
+		return m, errors.New(filename + ": negative timeout")
+	}
+	if m.Interpreter == "" {
+		m.Interpreter = defaultInterpreters[filepath.Ext(scriptName)]
+	}
+	if m.Interpreter == "" {
+		return m, errors.New("No interpreter for script " + scriptName + ", and no manifest setting one")
+	}
+	if strings.ContainsAny(m.Interpreter, " \t\n'\"$`\\;&|<>()") {
+		return m, errors.New(filename + ": invalid interpreter " + m.Interpreter)
+	}
+	switch m.Output {
+	case "":
+		m.Output = ScriptOutputText
+	case ScriptOutputYAML, ScriptOutputJSON, ScriptOutputText:
+	default:
+		return m, errors.New(filename + ": unknown output format " + m.Output)
+	}
+	for name := range m.Env {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return m, errors.New(filename + ": invalid environment variable name " + name)
+		}
+	}
+	return
+}
+
+// envList returns the environment variables of the manifest as a sorted list of NAME=value strings.
+func (m ScriptManifest) envList() (env []string) {
+	for name, value := range m.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return
+}
+
+// newScriptOutput tags the output of a script with its declared format. Output that doesn't parse
+// as the declared yaml or json is passed on as text.
+func newScriptOutput(script Script, output []byte) ScriptOutput {
+	format := script.OutputFormat()
+	var err error
+	switch format {
+	case ScriptOutputJSON:
+		var v interface{}
+		err = json.Unmarshal(output, &v)
+	case ScriptOutputYAML:
+		var v interface{}
+		err = yaml.Unmarshal(output, &v)
+	}
+	if err != nil {
+		except.Warn(err, ": Output of script", script.Name(), "is not valid", format, "; treating it as text")
+		format = ScriptOutputText
+	}
+	return ScriptOutput{Format: format, Data: output}
+}
+
+// Extension returns the file name extension for the output format.
+func (o ScriptOutput) Extension() string {
+	if o.Format == ScriptOutputText {
+		return "txt"
+	}
+	return o.Format
+}
+
+// shellQuote quotes s as a single word for bash.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadScriptManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scriptmanifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		script, manifest string
+		ok               bool
+		timeout          time.Duration
+		interpreter      string
+		output           string
+		args, env        []string
+	}{
+		{"plain.sh", "", true, *ScriptTimeout, "bash-static", ScriptOutputText, nil, nil},
+		{"plain.py", "", true, *ScriptTimeout, "python-static", ScriptOutputText, nil, nil},
+		{"plain.pl", "", false, 0, "", "", nil, nil},
+		{"full.pl", "timeout: 90s\nargs: [-a, \"it's\"]\nenv:\n  B: b\n  A: a=1\ninterpreter: /usr/bin/perl\noutput: json\n",
+			true, 90 * time.Second, "/usr/bin/perl", ScriptOutputJSON, []string{"-a", "it's"}, []string{"A=a=1", "B=b"}},
+		{"nolimit.sh", "timeout: 0s\noutput: yaml\n", true, 0, "bash-static", ScriptOutputYAML, nil, nil},
+		{"badformat.sh", "output: xml\n", false, 0, "", "", nil, nil},
+		{"badfield.sh", "timeouts: 5m\n", false, 0, "", "", nil, nil},
+		{"badinterp.sh", "interpreter: bash; rm -rf /\n", false, 0, "", "", nil, nil},
+		{"badenv.sh", "env:\n  A B: c\n", false, 0, "", "", nil, nil},
+	}
+	for _, c := range cases {
+		if c.manifest != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, c.script+ScriptManifestSuffix), []byte(c.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m, err := loadScriptManifest(dir, c.script)
+		if !c.ok {
+			if err == nil {
+				t.Fatal(c.script, "expected an error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(c.script, err)
+		}
+		if *m.Timeout != c.timeout || m.Interpreter != c.interpreter || m.Output != c.output ||
+			!reflect.DeepEqual(m.Args, c.args) || !reflect.DeepEqual(m.envList(), c.env) {
+			t.Fatalf("%s: got %+v timeout %s env %v", c.script, m, *m.Timeout, m.envList())
+		}
+	}
+}
+
+func TestGetScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getscripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.sh":      "echo a",
+		"b.pl":      "print 'b'",
+		"b.pl.yaml": "interpreter: perl\nargs: [x y]\n",
+		"c.txt":     "not a script",
+		".hidden":   "",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scripts, err := getScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != 2 || scripts[0].Name() != "a.sh" || scripts[1].Name() != "b.pl" {
+		t.Fatal("Unexpected scripts", scripts)
+	}
+	cmd := scripts[1].(*ScriptInfo).command()
+	if !strings.HasSuffix(cmd, " perl '"+TARGETCONTAINERDIR+dir+"/b.pl' 'x y'") {
+		t.Fatal("Unexpected command line", cmd)
+	}
+}
+
+func TestNewScriptOutput(t *testing.T) {
+	cases := []struct {
+		format, output, expected string
+	}{
+		{ScriptOutputJSON, `{"a": 1}`, ScriptOutputJSON},
+		{ScriptOutputJSON, `{"a": 1`, ScriptOutputText},
+		{ScriptOutputYAML, "a: 1\nb: [2, 3]\n", ScriptOutputYAML},
+		{ScriptOutputYAML, "a: [1\n", ScriptOutputText},
+		{ScriptOutputText, "anything", ScriptOutputText},
+	}
+	for _, c := range cases {
+		script := &ScriptInfo{name: "script", outputFormat: c.format}
+		o := newScriptOutput(script, []byte(c.output))
+		if o.Format != c.expected || string(o.Data) != c.output {
+			t.Fatal(c.format, c.output, "got", o.Format)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// semver.go has functions to parse and compare semantic version tags, e.g., v1.2.3-rc.1,
+// and to check them against version ranges.
+package collector
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Semver is a parsed semantic version.
+type Semver struct {
+	Major, Minor, Patch uint64
+	Prerelease          []string
+}
+
+// ParseSemver parses a tag of the form [v]MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD].
+// Missing minor and patch numbers are taken to be 0.
+func ParseSemver(tag string) (v Semver, e error) {
+	s := strings.TrimPrefix(tag, "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		if i == len(s)-1 {
+			e = errors.New("Invalid semantic version " + tag)
+			return
+		}
+		v.Prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	arr := strings.Split(s, ".")
+	if len(arr) > 3 {
+		e = errors.New("Invalid semantic version " + tag)
+		return
+	}
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, field := range arr {
+		if field == "" || (len(field) > 1 && field[0] == '0') {
+			e = errors.New("Invalid semantic version " + tag)
+			return
+		}
+		*nums[i], e = strconv.ParseUint(field, 10, 64)
+		if e != nil {
+			e = errors.New("Invalid semantic version " + tag)
+			return
+		}
+	}
+	return
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to, or higher than w in semver precedence.
+func (v Semver) Compare(w Semver) int {
+	if c := compareUint(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+	// a version without prerelease has higher precedence than one with prerelease
+	switch {
+	case len(v.Prerelease) == 0 && len(w.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(w.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(w.Prerelease); i++ {
+		a, b := v.Prerelease[i], w.Prerelease[i]
+		na, errA := strconv.ParseUint(a, 10, 64)
+		nb, errB := strconv.ParseUint(b, 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if c := compareUint(na, nb); c != 0 {
+				return c
+			}
+		case errA == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case errB == nil:
+			return 1
+		case a != b:
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(w.Prerelease)))
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// semverComparator is one condition of a version range, e.g., >=1.2.0.
+type semverComparator struct {
+	op      string
+	version Semver
+}
+
+func (c semverComparator) check(v Semver) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+// SemverRange is a set of conditions that a version must all satisfy.
+type SemverRange []semverComparator
+
+// ParseSemverRange parses a ',' separated list of conditions such as ">=1.2.0,<2", where each condition
+// is a version optionally preceded by one of the operators =, !=, >, >=, <, <=, ^ (compatible with),
+// or ~ (same minor version).
+func ParseSemverRange(spec string) (r SemverRange, e error) {
+	for _, cond := range strings.Split(spec, ",") {
+		cond = strings.TrimSpace(cond)
+		if cond == "" {
+			continue
+		}
+		op := ""
+		for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(cond, prefix) {
+				op = prefix
+				break
+			}
+		}
+		var v Semver
+		if v, e = ParseSemver(strings.TrimSpace(cond[len(op):])); e != nil {
+			return
+		}
+		switch op {
+		case "^":
+			upper := Semver{Major: v.Major + 1}
+			if v.Major == 0 {
+				upper = Semver{Minor: v.Minor + 1}
+			}
+			r = append(r, semverComparator{">=", v}, semverComparator{"<", upper})
+		case "~":
+			r = append(r, semverComparator{">=", v}, semverComparator{"<", Semver{Major: v.Major, Minor: v.Minor + 1}})
+		default:
+			r = append(r, semverComparator{op, v})
+		}
+	}
+	if len(r) == 0 {
+		e = errors.New("Empty version range")
+	}
+	return
+}
+
+// Contains returns true if v satisfies all the conditions of the range.
+func (r SemverRange) Contains(v Semver) bool {
+	for _, c := range r {
+		if !c.check(v) {
+			return false
+		}
+	}
+	return true
+}
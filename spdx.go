@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// SPDX is an SPDX 2.3 JSON document (https://spdx.github.io/spdx-spec/v2.3/).
+type SPDX struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      SPDXCreationInfo   `json:"creationInfo"`
+	Packages          []SPDXPackage      `json:"packages"`
+	Relationships     []SPDXRelationship `json:"relationships"`
+}
+
+// SPDXCreationInfo tells when and by what tool the document was made.
+type SPDXCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+// SPDXPackage is a package of the document: the image, its operating system, or an installed package.
+type SPDXPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	Supplier              string            `json:"supplier,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	Description           string            `json:"description,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums             []SPDXChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []SPDXExternalRef `json:"externalRefs,omitempty"`
+}
+
+// SPDXChecksum is a checksum of a package.
+type SPDXChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+// SPDXExternalRef is a reference of a package outside of the document, e.g., its purl.
+type SPDXExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+// SPDXRelationship relates two elements of the document.
+type SPDXRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+const (
+	spdxNoAssertion = "NOASSERTION"
+	spdxDocumentID  = "SPDXRef-DOCUMENT"
+	spdxImageID     = "SPDXRef-Image"
+	spdxOSID        = "SPDXRef-OperatingSystem"
+	// spdxNamespace prefixes the namespaces of the documents, which must be unique URIs.
+	spdxNamespace = "https://banyanops.com/collector/spdx/"
+)
+
+// purlRef returns the external reference of a purl.
+func purlRef(purl string) SPDXExternalRef {
+	return SPDXExternalRef{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: purl}
+}
+
+// NewSPDX returns the SPDX document of the image imageID, with the metadata of its repo:tags and
+// packages pkgs. The document describes the image, a container package that contains an
+// operating-system package for its distribution and a package for each installed package.
+func NewSPDX(imageID string, metadata []ImageMetadataInfo, pkgs []ImageDataInfo) (doc SPDX) {
+	name, tag := sbomImageName(imageID, metadata)
+	doc.SPDXVersion = "SPDX-2.3"
+	doc.DataLicense = "CC0-1.0"
+	doc.SPDXID = spdxDocumentID
+	doc.Name = name
+	if tag != "" {
+		doc.Name += ":" + tag
+	}
+	doc.DocumentNamespace = spdxNamespace + purlEscape(doc.Name) + "-" + uuid.New()
+	doc.CreationInfo = SPDXCreationInfo{Created: time.Now().UTC().Format(time.RFC3339),
+		Creators: []string{"Tool: " + sbomTool}}
+
+	image := SPDXPackage{SPDXID: spdxImageID, Name: name, VersionInfo: tag, DownloadLocation: spdxNoAssertion,
+		PrimaryPackagePurpose: "CONTAINER"}
+	if strings.HasPrefix(imageID, "sha256:") {
+		image.Checksums = []SPDXChecksum{{Algorithm: "SHA256", ChecksumValue: strings.TrimPrefix(imageID, "sha256:")}}
+	}
+	for _, m := range metadata {
+		if m.Repo != "" {
+			image.ExternalRefs = append(image.ExternalRefs, purlRef(imagePURL(imageID, m)))
+		}
+	}
+	doc.Packages = []SPDXPackage{image}
+	doc.Relationships = []SPDXRelationship{{spdxDocumentID, "DESCRIBES", spdxImageID}}
+
+	distro := newSBOMDistro(pkgs)
+	if distro.name != "" {
+		doc.Packages = append(doc.Packages, SPDXPackage{SPDXID: spdxOSID, Name: distro.name,
+			VersionInfo: distro.release, DownloadLocation: spdxNoAssertion, Description: distro.pretty,
+			PrimaryPackagePurpose: "OPERATING-SYSTEM"})
+		doc.Relationships = append(doc.Relationships, SPDXRelationship{spdxImageID, "CONTAINS", spdxOSID})
+	}
+	seen := make(map[string]bool)
+	for _, p := range sbomPackages(pkgs) {
+		purl := distro.purl(p)
+		if seen[purl] {
+			continue
+		}
+		seen[purl] = true
+		id := "SPDXRef-Package-" + strconv.Itoa(len(seen))
+		pkg := SPDXPackage{SPDXID: id, Name: p.Pkg, VersionInfo: p.Version, Supplier: spdxNoAssertion,
+			DownloadLocation: spdxNoAssertion, ExternalRefs: []SPDXExternalRef{purlRef(purl)}}
+		if p.Origin != "" && p.Origin != p.Pkg {
+			pkg.SourceInfo = "built from source package " + p.Origin
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, SPDXRelationship{spdxImageID, "CONTAINS", id})
+	}
+	return
+}
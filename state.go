@@ -0,0 +1,206 @@
+// state.go keeps the state of Collector across restarts in an embedded key-value store: the
+// processed images and manifest digests, the metadata of every repo:tag seen, the status of the
+// image scans, and the images pulled by Collector.
+package collector
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	config "github.com/banyanops/collector/config"
+	except "github.com/banyanops/collector/except"
+	statestore "github.com/banyanops/collector/statestore"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// Buckets of the state store
+	stateMeta            = "meta"
+	stateProcessedImages = "processedImages"
+	stateManifestHashes  = "manifestHashes"
+	stateMetadata        = "metadata"
+	stateScanStatus      = "scanStatus"
+	statePulledImages    = "pulledImages"
+
+	// stateSchemaKey in stateMeta holds the version of the layout of the state, which is updated
+	// by migrations
+	stateSchemaKey = "schema"
+	stateSchema    = 1
+)
+
+var (
+	StateFile = flag.String("statefile", config.BANYANDIR()+"/hostcollector/state.db",
+		"File of the store that keeps the state of Collector across restarts")
+
+	// State is the state store, opened by OpenState.
+	State *statestore.Store
+)
+
+// ScanStatus is the outcome of the last scan of an image.
+type ScanStatus struct {
+	Scanned time.Time
+	OK      bool
+	Error   string `json:",omitempty"`
+}
+
+// OpenState opens the state store, and migrates the state kept by earlier versions of Collector
+// in flat files: the list of processed images imageListFile, and its manifest digest counterpart
+// imageListFile_ManifestHash. The flat files are renamed with a .migrated suffix.
+func OpenState(filename, imageListFile string) (e error) {
+	State, e = statestore.Open(filename)
+	if e != nil {
+		return
+	}
+	var schema int
+	if _, e = State.Get(stateMeta, stateSchemaKey, &schema); e != nil {
+		return
+	}
+	if schema >= stateSchema {
+		return
+	}
+	blog.Info("Migrating the state of Collector into %s", filename)
+	migrations := []struct {
+		filename string
+		migrate  func(data []byte) error
+	}{
+		{imageListFile, func(data []byte) error { return migrateImageList(data, stateProcessedImages) }},
+		{imageListFile + "_ManifestHash", func(data []byte) error { return migrateImageList(data, stateManifestHashes) }},
+	}
+	for _, m := range migrations {
+		data, err := ioutil.ReadFile(m.filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = m.migrate(data); err != nil {
+			return errors.New(m.filename + ": " + err.Error())
+		}
+		if err = os.Rename(m.filename, m.filename+".migrated"); err != nil {
+			except.Warn(err, ": Cannot rename migrated file", m.filename)
+		}
+		blog.Info("Migrated %s", m.filename)
+	}
+	return State.Put(stateMeta, stateSchemaKey, stateSchema)
+}
+
+// migrateImageList adds the images listed one per line in data to bucket.
+func migrateImageList(data []byte, bucket string) (e error) {
+	s := bufio.NewScanner(strings.NewReader(string(data)))
+	for s.Scan() {
+		if image := strings.TrimSpace(s.Text()); image != "" {
+			if bucket == stateManifestHashes {
+				image = legacyManifestDigest(image)
+			}
+			if e = State.Put(bucket, image, true); e != nil {
+				return
+			}
+		}
+	}
+	return s.Err()
+}
+
+// legacyManifestDigest returns the digest of a manifest recorded by earlier versions of Collector,
+// which recorded the bare hex of the sha256 digest, e.g., 4b3c...; manifest digests now have the
+// algorithm prefix, e.g., sha256:4b3c...
+func legacyManifestDigest(hash string) string {
+	if _, err := hex.DecodeString(hash); err == nil && len(hash) == 2*sha256.Size {
+		return "sha256:" + hash
+	}
+	return hash
+}
+
+// LoadProcessedImages adds the processed image IDs and manifest digests to processedImages.
+func LoadProcessedImages(processedImages ImageSet) {
+	for _, bucket := range []string{stateProcessedImages, stateManifestHashes} {
+		for _, key := range State.Keys(bucket) {
+			processedImages.Insert(ImageIDType(key))
+		}
+	}
+}
+
+// SaveProcessedImages records processed images and manifest digests.
+func SaveProcessedImages(images, manifestHashes ImageSet) (e error) {
+	for image := range images {
+		if e = State.Put(stateProcessedImages, string(image), true); e != nil {
+			return
+		}
+	}
+	for hash := range manifestHashes {
+		if e = State.Put(stateManifestHashes, string(hash), true); e != nil {
+			return
+		}
+	}
+	return
+}
+
+// metadataKey returns the key of the metadata of a repo:tag (and platform) in the state store.
+func metadataKey(metadata ImageMetadataInfo) string {
+	key := metadata.Registry + "/" + metadata.Repo + ":" + metadata.Tag
+	if platform := metadata.Platform(); platform != "" {
+		key += "@" + platform
+	}
+	return key
+}
+
+// LoadMetadataSet adds the metadata of the repo:tags seen before to metadataSet.
+func LoadMetadataSet(metadataSet MetadataSet) (e error) {
+	return State.ForEach(stateMetadata, func(key string, value []byte) error {
+		var metadata ImageMetadataInfo
+		if err := json.Unmarshal(value, &metadata); err != nil {
+			return errors.New("metadata " + key + ": " + err.Error())
+		}
+		metadataSet.Insert(metadata)
+		return nil
+	})
+}
+
+// SaveMetadataSet replaces the metadata of the repo:tags seen with metadataSet.
+func SaveMetadataSet(metadataSet MetadataSet) (e error) {
+	// the set may briefly hold several images of a repo:tag; keep the latest
+	latest := make(map[string]ImageMetadataInfo)
+	for metadata := range metadataSet {
+		key := metadataKey(metadata)
+		if m, ok := latest[key]; !ok || metadata.Datetime.After(m.Datetime) ||
+			metadata.Datetime.Equal(m.Datetime) && metadata.Image > m.Image {
+			latest[key] = metadata
+		}
+	}
+	for key, metadata := range latest {
+		if e = State.Put(stateMetadata, key, metadata); e != nil {
+			return
+		}
+	}
+	for _, key := range State.Keys(stateMetadata) {
+		if _, ok := latest[key]; !ok {
+			if e = State.Delete(stateMetadata, key); e != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// RecordScanStatus records the outcome of a scan of an image; scanErr is nil if it succeeded.
+func RecordScanStatus(imageID ImageIDType, scanErr error) (e error) {
+	status := ScanStatus{Scanned: time.Now(), OK: scanErr == nil}
+	if scanErr != nil {
+		status.Error = scanErr.Error()
+	}
+	return State.Put(stateScanStatus, string(imageID), status)
+}
+
+// GetScanStatus returns the outcome of the last scan of an image, if there was one.
+func GetScanStatus(imageID ImageIDType) (status ScanStatus, found bool, e error) {
+	found, e = State.Get(stateScanStatus, string(imageID), &status)
+	return
+}
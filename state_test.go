@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestState opens a state store in a new temporary directory, which is returned.
+func openTestState(t *testing.T) (dir string) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := OpenState(filepath.Join(dir, "state.db"), filepath.Join(dir, "imagelist")); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func closeTestState(dir string) {
+	State.Close()
+	State = nil
+	os.RemoveAll(dir)
+}
+
+func TestOpenStateMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	imageList := filepath.Join(dir, "imagelist")
+	files := map[string]string{
+		imageList:                   "image1\nimage2\n\nimage1\n",
+		imageList + "_ManifestHash": "sha256:aaa\n" + strings.Repeat("0f", 32) + "\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stateFile := filepath.Join(dir, "state.db")
+	if err := OpenState(stateFile, imageList); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { State.Close(); State = nil }()
+	processed := NewImageSet()
+	LoadProcessedImages(processed)
+	// bare hex manifest digests of earlier versions get the sha256: prefix
+	if len(processed) != 4 || !processed.Exists("image2") || !processed.Exists("sha256:aaa") ||
+		!processed.Exists(ImageIDType("sha256:"+strings.Repeat("0f", 32))) {
+		t.Fatal("Unexpected processed images", processed)
+	}
+	for name := range files {
+		if _, err := os.Stat(name + ".migrated"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the migration happens only once
+	ioutil.WriteFile(imageList, []byte("image4\n"), 0644)
+	State.Close()
+	if err := OpenState(stateFile, imageList); err != nil {
+		t.Fatal(err)
+	}
+	processed = NewImageSet()
+	LoadProcessedImages(processed)
+	if len(processed) != 4 {
+		t.Fatal("Unexpected processed images", processed)
+	}
+}
+
+func TestSaveMetadataSet(t *testing.T) {
+	dir := openTestState(t)
+	defer closeTestState(dir)
+	now := time.Now().UTC()
+	older := ImageMetadataInfo{Image: "old", Datetime: now.Add(-time.Hour), OtherMetadata: OtherMetadata{Repo: "team/app", Tag: "v1"}}
+	newer := ImageMetadataInfo{Image: "new", Datetime: now, OtherMetadata: OtherMetadata{Repo: "team/app", Tag: "v1"}}
+	arm := ImageMetadataInfo{Image: "arm", Datetime: now, OtherMetadata: OtherMetadata{Repo: "team/app", Tag: "v1",
+		OS: "linux", Architecture: "arm64"}}
+	other := ImageMetadataInfo{Image: "other", Datetime: now, OtherMetadata: OtherMetadata{Repo: "team/db", Tag: "v1"}}
+	set := NewMetadataSet()
+	for _, m := range []ImageMetadataInfo{older, newer, arm, other} {
+		set.Insert(m)
+	}
+	if err := SaveMetadataSet(set); err != nil {
+		t.Fatal(err)
+	}
+	set.Delete(other)
+	if err := SaveMetadataSet(set); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMetadataSet()
+	if err := LoadMetadataSet(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || !loaded.Exists(newer) || !loaded.Exists(arm) {
+		t.Fatal("Unexpected metadata", loaded)
+	}
+
+	if err := RecordScanStatus("new", nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, found, err := GetScanStatus("new"); !found || err != nil || !status.OK {
+		t.Fatal("Unexpected scan status", status, found, err)
+	}
+	if _, found, _ := GetScanStatus("old"); found {
+		t.Fatal("Unexpected scan status for old")
+	}
+}
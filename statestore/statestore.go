@@ -0,0 +1,421 @@
+// Package statestore is an embedded key-value store for the state that Collector keeps across
+// restarts. Keys are grouped in buckets, and values are JSON documents. The store is kept in
+// memory, and every change is appended to a log file, which is compacted into a snapshot of
+// the live keys when it has grown well past their number.
+package statestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+
+	blog "github.com/ccpaging/log4go"
+)
+
+const (
+	// logFormat and logVersion are in the header, the first line of the log file.
+	logFormat  = "banyan-collector-state"
+	logVersion = 1
+
+	opPut    = "put"
+	opDelete = "delete"
+
+	// minCompactRecords is the number of records below which the log is never compacted.
+	minCompactRecords = 1000
+)
+
+// header is the first line of the log file.
+type header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// record is a change to the store, one per line of the log file after the header.
+type record struct {
+	Op     string          `json:"op"`
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// Store is a key-value store backed by a log file. It is safe for concurrent use.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	data map[string]map[string]json.RawMessage
+	// records is the number of records in the log file
+	records int
+	// lock is the open lock file, if the store was opened by Open
+	lock     *os.File
+	readOnly bool
+}
+
+var (
+	// ErrLocked is returned by Open if another process has the store open.
+	ErrLocked = errors.New("state store is in use by another process")
+	// ErrReadOnly is returned by the changes to a store opened with OpenReadOnly.
+	ErrReadOnly = errors.New("state store is open read-only")
+)
+
+// Open opens the store kept in the file path, creating it if needed. A record that was cut
+// short at the end of the log, e.g., by a crash, is dropped. Only one process at a time can
+// open a store with Open: the store is locked through the file path.lock until it is closed.
+func Open(path string) (s *Store, e error) {
+	lock, e := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if e != nil {
+		return nil, e
+	}
+	if e = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); e != nil {
+		lock.Close()
+		if e == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, errors.New(path + ": " + e.Error())
+	}
+	s, e = open(path)
+	if e != nil {
+		lock.Close()
+		return nil, e
+	}
+	s.lock = lock
+	return
+}
+
+// OpenReadOnly reads the store kept in the file path, even if another process has it open.
+// The store reflects the file at the time it is opened, and cannot be changed.
+func OpenReadOnly(path string) (s *Store, e error) {
+	s = &Store{path: path, readOnly: true, data: make(map[string]map[string]json.RawMessage)}
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	if _, e = s.load(f); e != nil {
+		return nil, errors.New(path + ": " + e.Error())
+	}
+	return
+}
+
+// open opens the store for reading and appending.
+func open(path string) (s *Store, e error) {
+	s = &Store{path: path, data: make(map[string]map[string]json.RawMessage)}
+	f, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if e != nil {
+		return nil, e
+	}
+	valid, e := s.load(f)
+	if e != nil {
+		f.Close()
+		return nil, errors.New(path + ": " + e.Error())
+	}
+	if valid == 0 {
+		// new store
+		f.Close()
+		if e = s.rewrite(); e != nil {
+			return nil, e
+		}
+		return s, nil
+	}
+	// drop a partial record at the end, and append after the valid records
+	if e = f.Truncate(valid); e != nil {
+		f.Close()
+		return nil, e
+	}
+	if _, e = f.Seek(valid, io.SeekStart); e != nil {
+		f.Close()
+		return nil, e
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	if s.shouldCompact() {
+		if e = s.compact(); e != nil {
+			s.Close()
+			return nil, e
+		}
+	}
+	return s, nil
+}
+
+// load reads the log file, and returns the size of its valid part.
+func (s *Store) load(f *os.File) (valid int64, e error) {
+	r := bufio.NewReader(f)
+	first := true
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without a newline is a record cut short
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		if first {
+			var h header
+			if err := json.Unmarshal(line, &h); err != nil || h.Format != logFormat {
+				return valid, errors.New("not a state store")
+			}
+			if h.Version != logVersion {
+				return valid, errors.New("unsupported state store version " + strconv.Itoa(h.Version))
+			}
+			first = false
+		} else {
+			var rec record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				if _, peekErr := r.Peek(1); peekErr == io.EOF {
+					// garbage in the last line, e.g., from a partial write
+					return valid, nil
+				}
+				return valid, errors.New("corrupt record at offset " + strconv.FormatInt(valid, 10))
+			}
+			s.apply(rec)
+			s.records++
+		}
+		valid += int64(len(line))
+	}
+}
+
+// apply makes the change of rec to the data in memory.
+func (s *Store) apply(rec record) {
+	switch rec.Op {
+	case opPut:
+		bucket := s.data[rec.Bucket]
+		if bucket == nil {
+			bucket = make(map[string]json.RawMessage)
+			s.data[rec.Bucket] = bucket
+		}
+		bucket[rec.Key] = rec.Value
+	case opDelete:
+		delete(s.data[rec.Bucket], rec.Key)
+		if len(s.data[rec.Bucket]) == 0 {
+			delete(s.data, rec.Bucket)
+		}
+	}
+}
+
+// append writes rec to the log, applies it, and compacts the log if it got too long.
+func (s *Store) append(rec record) (e error) {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.f == nil {
+		return errors.New("state store is closed")
+	}
+	line, e := json.Marshal(rec)
+	if e != nil {
+		return
+	}
+	if _, e = s.w.Write(append(line, '\n')); e != nil {
+		return
+	}
+	// flush, so that the record survives a crash of the process
+	if e = s.w.Flush(); e != nil {
+		return
+	}
+	s.apply(rec)
+	s.records++
+	if s.shouldCompact() {
+		// the record is written; the log stays as it is if it cannot be compacted
+		if err := s.compact(); err != nil {
+			blog.Warn("Failed to compact state store %s: %v", s.path, err)
+		}
+	}
+	return
+}
+
+// live returns the number of keys in the store.
+func (s *Store) live() (n int) {
+	for _, bucket := range s.data {
+		n += len(bucket)
+	}
+	return
+}
+
+// shouldCompact returns true if the log has more than twice as many records as there are keys.
+func (s *Store) shouldCompact() bool {
+	return s.records > minCompactRecords && s.records > 2*s.live()
+}
+
+// Get decodes the value of key in bucket into v. It returns false if there is no such key.
+func (s *Store) Get(bucket, key string, v interface{}) (found bool, e error) {
+	s.mu.Lock()
+	value, found := s.data[bucket][key]
+	s.mu.Unlock()
+	if !found {
+		return
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// Exists returns true if bucket has key.
+func (s *Store) Exists(bucket, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.data[bucket][key]
+	return ok
+}
+
+// Put sets the value of key in bucket to v encoded in JSON.
+func (s *Store) Put(bucket, key string, v interface{}) (e error) {
+	value, e := json.Marshal(v)
+	if e != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.data[bucket][key]; ok && bytes.Equal(old, value) {
+		return
+	}
+	return s.append(record{Op: opPut, Bucket: bucket, Key: key, Value: value})
+}
+
+// Delete removes key from bucket, if it is there.
+func (s *Store) Delete(bucket, key string) (e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[bucket][key]; !ok {
+		return
+	}
+	return s.append(record{Op: opDelete, Bucket: bucket, Key: key})
+}
+
+// Keys returns the keys of bucket in sorted order.
+func (s *Store) Keys(bucket string) (keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.data[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// ForEach calls fn with the keys of bucket in sorted order, and their values encoded in JSON,
+// until fn returns an error.
+func (s *Store) ForEach(bucket string, fn func(key string, value []byte) error) (e error) {
+	s.mu.Lock()
+	values := make(map[string]json.RawMessage, len(s.data[bucket]))
+	for key, value := range s.data[bucket] {
+		values[key] = value
+	}
+	s.mu.Unlock()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if e = fn(key, values[key]); e != nil {
+			return
+		}
+	}
+	return
+}
+
+// Compact rewrites the log with one record per key.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.f == nil {
+		return errors.New("state store is closed")
+	}
+	return s.compact()
+}
+
+// compact rewrites the log. The old log file is closed only once the new one replaces it, so the
+// store keeps appending to the old one if the rewrite fails.
+func (s *Store) compact() (e error) {
+	if e = s.w.Flush(); e != nil {
+		return
+	}
+	old := s.f
+	if e = s.rewrite(); e != nil {
+		return
+	}
+	old.Close()
+	return
+}
+
+// rewrite writes the data to a new log file, which atomically replaces the old one, and opens it
+// for appending. The store is left unchanged if it fails.
+func (s *Store) rewrite() (e error) {
+	tmp := s.path + ".tmp"
+	f, e := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if e != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	line, _ := json.Marshal(header{Format: logFormat, Version: logVersion})
+	w.Write(append(line, '\n'))
+	buckets := make([]string, 0, len(s.data))
+	for bucket := range s.data {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	records := 0
+	for _, bucket := range buckets {
+		keys := make([]string, 0, len(s.data[bucket]))
+		for key := range s.data[bucket] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			line, e = json.Marshal(record{Op: opPut, Bucket: bucket, Key: key, Value: s.data[bucket][key]})
+			if e != nil {
+				f.Close()
+				return
+			}
+			w.Write(append(line, '\n'))
+			records++
+		}
+	}
+	if e = w.Flush(); e == nil {
+		e = f.Sync()
+	}
+	if e == nil {
+		e = os.Rename(tmp, s.path)
+	}
+	if e != nil {
+		f.Close()
+		os.Remove(tmp)
+		return
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	s.records = records
+	return
+}
+
+// Close syncs the log file to disk and closes it, releasing the lock of the store.
+func (s *Store) Close() (e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock != nil {
+		defer func() {
+			s.lock.Close()
+			s.lock = nil
+		}()
+	}
+	if s.f == nil {
+		return
+	}
+	if e = s.w.Flush(); e == nil {
+		e = s.f.Sync()
+	}
+	if err := s.f.Close(); e == nil {
+		e = err
+	}
+	s.f = nil
+	return
+}
@@ -0,0 +1,219 @@
+package statestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type value struct {
+	Name  string
+	Count int
+}
+
+func tempStore(t *testing.T) (dir, path string) {
+	dir, err := ioutil.TempDir("", "statestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, filepath.Join(dir, "state.db")
+}
+
+func TestStore(t *testing.T) {
+	dir, path := tempStore(t)
+	defer os.RemoveAll(dir)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("b", "k1", value{"one", 1}); err != nil {
+		t.Fatal(err)
+	}
+	s.Put("b", "k2", value{"two", 2})
+	s.Put("b", "k1", value{"uno", 1})
+	s.Put("other", "k1", true)
+	s.Delete("b", "k2")
+	s.Delete("b", "missing")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen, and check that the changes were kept
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var v value
+	found, err := s.Get("b", "k1", &v)
+	if !found || err != nil || v != (value{"uno", 1}) {
+		t.Fatal("Got", found, err, v)
+	}
+	if s.Exists("b", "k2") || !s.Exists("other", "k1") {
+		t.Fatal("Unexpected keys", s.Keys("b"), s.Keys("other"))
+	}
+	if found, _ := s.Get("none", "k1", &v); found {
+		t.Fatal("Found a key in a missing bucket")
+	}
+	if keys := s.Keys("b"); !reflect.DeepEqual(keys, []string{"k1"}) {
+		t.Fatal("Unexpected keys", keys)
+	}
+}
+
+func TestStoreCompaction(t *testing.T) {
+	dir, path := tempStore(t)
+	defer os.RemoveAll(dir)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 5*minCompactRecords; i++ {
+		if err := s.Put("b", strconv.Itoa(i%10), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.records > minCompactRecords+1 {
+		t.Fatal("Log not compacted:", s.records, "records")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Log size after compaction:", info.Size(), "records:", s.records)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.records != 10 {
+		t.Fatal("Expected 10 records after compaction, got", s.records)
+	}
+	var n int
+	if found, err := s.Get("b", "9", &n); !found || err != nil || n != 5*minCompactRecords-1 {
+		t.Fatal("Got", found, err, n)
+	}
+}
+
+func TestStoreCompactionFailure(t *testing.T) {
+	dir, path := tempStore(t)
+	defer os.RemoveAll(dir)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// the new log cannot be created where a directory is in the way
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*minCompactRecords; i++ {
+		if err := s.Put("b", strconv.Itoa(i%10), i); err != nil {
+			t.Fatal("Put failed with a failing compaction:", err)
+		}
+	}
+	if err := s.Compact(); err == nil {
+		t.Fatal("Compact should fail")
+	}
+	if err := s.Put("b", "last", true); err != nil {
+		t.Fatal("Put failed after a failed compaction:", err)
+	}
+	os.Remove(path + ".tmp")
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var n int
+	if found, err := s.Get("b", "9", &n); !found || err != nil || n != 3*minCompactRecords-1 || !s.Exists("b", "last") {
+		t.Fatal("Got", found, err, n, s.Keys("b"))
+	}
+}
+
+func TestStoreRecovery(t *testing.T) {
+	dir, path := tempStore(t)
+	defer os.RemoveAll(dir)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("b", "k1", 1)
+	s.Put("b", "k2", 2)
+	s.Close()
+
+	// a crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","bucket":"b","key":"k3","val`)
+	f.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.Keys("b"); !reflect.DeepEqual(keys, []string{"k1", "k2"}) {
+		t.Fatal("Unexpected keys", keys)
+	}
+	// appending after the partial record was dropped
+	s.Put("b", "k4", 4)
+	s.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.Keys("b"); !reflect.DeepEqual(keys, []string{"k1", "k2", "k4"}) {
+		t.Fatal("Unexpected keys", keys)
+	}
+	s.Close()
+
+	// corruption in the middle of the log is an error
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, append(append([]byte{}, data[:len(data)-5]...), []byte("\n"+`{"op":"put"}`+"\n")...), 0644)
+	if s, err = Open(path); err == nil {
+		s.Close()
+		t.Fatal("Expected an error for a corrupt log")
+	}
+	ioutil.WriteFile(path, []byte("imagelist\n"), 0644)
+	if s, err = Open(path); err == nil {
+		s.Close()
+		t.Fatal("Expected an error for a file that is not a store")
+	}
+}
+
+func TestStoreLock(t *testing.T) {
+	dir, path := tempStore(t)
+	defer os.RemoveAll(dir)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("b", "k1", 1)
+	if _, err := Open(path); err != ErrLocked {
+		t.Fatal("Expected the store to be locked, got", err)
+	}
+	// readers don't need the lock
+	r, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Exists("b", "k1") {
+		t.Fatal("Missing key in read-only store")
+	}
+	if err := r.Put("b", "k2", 2); err != ErrReadOnly {
+		t.Fatal("Expected a read-only error, got", err)
+	}
+	s.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
@@ -0,0 +1,246 @@
+// tagpolicy.go has functions that select which tags of a repository get processed, according to
+// per-repo tag filters and retention settings given in the repolist file.
+package collector
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	blog "github.com/ccpaging/log4go"
+)
+
+var (
+	// TagPolicies maps a repo name or repo pattern spec (as listed in the repolist file) to its tag policy.
+	TagPolicies = make(map[string]TagPolicy)
+)
+
+// TagPolicy restricts the tags of a repository that are processed.
+// A repolist file line consists of a repo name or pattern followed by any of these options:
+//
+//	include=PATTERN[,PATTERN...]  only process tags matching a glob pattern or re: regular expression
+//	exclude=PATTERN[,PATTERN...]  skip tags matching a glob pattern or re: regular expression
+//	semver=RANGE                  only process semantic version tags in RANGE, e.g., semver=>=1.2,<2
+//	newest=N                      only process the N highest semantic version tags
+//	maxage=DURATION               skip images created longer than DURATION ago, e.g., maxage=30d
+//
+// For example:
+//
+//	team-a/*  exclude=*-debug newest=3 maxage=90d
+type TagPolicy struct {
+	Include []RepoPattern
+	Exclude []RepoPattern
+	Semver  SemverRange
+	Newest  int
+	MaxAge  time.Duration
+	// Options is the text of the options the policy was parsed from.
+	Options string
+}
+
+// semverOnly returns true if the policy only admits semantic version tags.
+func (p TagPolicy) semverOnly() bool {
+	return len(p.Semver) > 0 || p.Newest > 0
+}
+
+// admits checks the tag filters and the age limit of the policy, but not the newest=N limit.
+func (p TagPolicy) admits(metadata ImageMetadataInfo, now time.Time) bool {
+	if len(p.Include) > 0 {
+		included := false
+		for _, pattern := range p.Include {
+			if pattern.matchName(metadata.Tag) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, pattern := range p.Exclude {
+		if pattern.matchName(metadata.Tag) {
+			return false
+		}
+	}
+	if p.semverOnly() {
+		v, e := ParseSemver(metadata.Tag)
+		if e != nil {
+			return false
+		}
+		if len(p.Semver) > 0 && !p.Semver.Contains(v) {
+			return false
+		}
+	}
+	// images with unknown creation time are not subject to the age limit
+	if p.MaxAge > 0 && !metadata.Datetime.IsZero() && now.Sub(metadata.Datetime) > p.MaxAge {
+		return false
+	}
+	return true
+}
+
+// ParseRepoListLine parses a line of the repolist file, which has already been stripped of comments,
+// into the repo name or pattern spec and its tag policy. hasPolicy is false if there were no options.
+func ParseRepoListLine(line string) (spec string, policy TagPolicy, hasPolicy bool, e error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	spec = fields[0]
+	if len(fields) == 1 {
+		return
+	}
+	hasPolicy = true
+	policy, e = ParseTagPolicy(fields[1:])
+	return
+}
+
+// ParseTagPolicy parses tag policy options of the form name=value.
+func ParseTagPolicy(options []string) (policy TagPolicy, e error) {
+	for _, option := range options {
+		arr := strings.SplitN(option, "=", 2)
+		if len(arr) != 2 || arr[1] == "" {
+			e = errors.New("Invalid tag policy option " + option + ", expected name=value")
+			return
+		}
+		name, value := arr[0], arr[1]
+		switch name {
+		case "include", "exclude":
+			for _, spec := range strings.Split(value, ",") {
+				var p RepoPattern
+				if p, e = NewRepoPattern(spec); e != nil {
+					return
+				}
+				if name == "include" {
+					policy.Include = append(policy.Include, p)
+				} else {
+					policy.Exclude = append(policy.Exclude, p)
+				}
+			}
+		case "semver":
+			if policy.Semver, e = ParseSemverRange(value); e != nil {
+				return
+			}
+		case "newest":
+			policy.Newest, e = strconv.Atoi(value)
+			if e != nil || policy.Newest <= 0 {
+				e = errors.New("Invalid tag policy option " + option + ", expected a positive number")
+				return
+			}
+		case "maxage":
+			if policy.MaxAge, e = parseAge(value); e != nil {
+				return
+			}
+		default:
+			e = errors.New("Unknown tag policy option " + option)
+			return
+		}
+	}
+	policy.Options = strings.Join(options, " ")
+	return
+}
+
+// parseAge parses a duration, additionally accepting a number of days ("30d") or weeks ("2w").
+func parseAge(value string) (age time.Duration, e error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil || n <= 0 {
+			e = errors.New("Invalid age " + value)
+			return
+		}
+		age = time.Duration(n) * unit
+		return
+	}
+	age, e = time.ParseDuration(value)
+	if e == nil && age <= 0 {
+		e = errors.New("Invalid age " + value)
+	}
+	return
+}
+
+// TagPolicyFor returns the tag policy that applies to repo: the policy given for the repo name,
+// or else the policy of the first repo pattern that matches the repo.
+func TagPolicyFor(repo RepoType) (policy TagPolicy, ok bool) {
+	if policy, ok = TagPolicies[string(repo)]; ok {
+		return
+	}
+	for _, pattern := range RepoPatterns {
+		if !pattern.Match(repo) {
+			continue
+		}
+		if policy, ok = TagPolicies[pattern.Spec]; ok {
+			return
+		}
+	}
+	return
+}
+
+// ApplyTagPolicies returns the entries of metadataSlice whose tags are admitted by the tag policy of
+// their repo. The newest=N limit is evaluated against all the tags of the repo in currentMetadataSet.
+// Entries that are not admitted are also deleted from currentMetadataSet, so that they get
+// considered again in the next iteration (e.g., after the repolist file changes).
+func ApplyTagPolicies(metadataSlice []ImageMetadataInfo, currentMetadataSet MetadataSet,
+	now time.Time) (selected []ImageMetadataInfo) {
+	if len(TagPolicies) == 0 {
+		return metadataSlice
+	}
+	// for repos with a newest=N limit, find the N highest admitted semver tags
+	newestTags := make(map[string]map[string]bool)
+	tagVersions := make(map[string]map[string]Semver)
+	for metadata := range currentMetadataSet {
+		policy, ok := TagPolicyFor(RepoType(metadata.Repo))
+		if !ok || policy.Newest == 0 || !policy.admits(metadata, now) {
+			continue
+		}
+		if tagVersions[metadata.Repo] == nil {
+			tagVersions[metadata.Repo] = make(map[string]Semver)
+		}
+		v, _ := ParseSemver(metadata.Tag)
+		tagVersions[metadata.Repo][metadata.Tag] = v
+	}
+	for repo, versions := range tagVersions {
+		policy, _ := TagPolicyFor(RepoType(repo))
+		tags := []string{}
+		for tag := range versions {
+			tags = append(tags, tag)
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			if c := versions[tags[i]].Compare(versions[tags[j]]); c != 0 {
+				return c > 0
+			}
+			return tags[i] < tags[j]
+		})
+		if len(tags) > policy.Newest {
+			tags = tags[:policy.Newest]
+		}
+		newestTags[repo] = make(map[string]bool)
+		for _, tag := range tags {
+			newestTags[repo][tag] = true
+		}
+	}
+
+	for _, metadata := range metadataSlice {
+		policy, ok := TagPolicyFor(RepoType(metadata.Repo))
+		admitted := !ok || policy.admits(metadata, now)
+		if admitted && ok && policy.Newest > 0 {
+			admitted = newestTags[metadata.Repo][metadata.Tag]
+		}
+		if !admitted {
+			blog.Debug("Tag policy skips %s:%s", metadata.Repo, metadata.Tag)
+			currentMetadataSet.Delete(metadata)
+			continue
+		}
+		selected = append(selected, metadata)
+	}
+	if skipped := len(metadataSlice) - len(selected); skipped > 0 {
+		blog.Info("Tag policies skipped %d of %d new metadata items", skipped, len(metadataSlice))
+	}
+	return
+}
@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSemverCompare(t *testing.T) {
+	// each version is lower than the next one
+	ordered := []string{"0.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "1.0.1", "1.2", "1.10.0", "2"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, e := ParseSemver(ordered[i])
+		if e != nil {
+			t.Fatal(e)
+		}
+		b, e := ParseSemver(ordered[i+1])
+		if e != nil {
+			t.Fatal(e)
+		}
+		if a.Compare(b) != -1 || b.Compare(a) != 1 || a.Compare(a) != 0 {
+			t.Fatal("wrong order for", ordered[i], ordered[i+1])
+		}
+	}
+	for _, tag := range []string{"latest", "1.2.3.4", "01.2", "1.x", "1.2-", ""} {
+		if _, e := ParseSemver(tag); e == nil {
+			t.Fatal("ParseSemver was supposed to fail for", tag)
+		}
+	}
+}
+
+func TestSemverRange(t *testing.T) {
+	cases := []struct {
+		spec, version string
+		contains      bool
+	}{
+		{">=1.2,<2", "1.2.0", true},
+		{">=1.2,<2", "1.9.9", true},
+		{">=1.2,<2", "2.0.0", false},
+		{">=1.2,<2", "1.1.9", false},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"1.4.0", "1.4.0", true},
+		{"!=1.4.0", "1.4.0", false},
+	}
+	for _, c := range cases {
+		r, e := ParseSemverRange(c.spec)
+		if e != nil {
+			t.Fatal(c.spec, e)
+		}
+		v, _ := ParseSemver(c.version)
+		if r.Contains(v) != c.contains {
+			t.Fatalf("range %s version %s: expected %v", c.spec, c.version, c.contains)
+		}
+	}
+}
+
+func TestParseRepoListLine(t *testing.T) {
+	spec, policy, hasPolicy, e := ParseRepoListLine("  team-a/*  include=1.*,re:^2 exclude=*-debug semver=>=1.2,<3 newest=3 maxage=30d ")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if spec != "team-a/*" || !hasPolicy || len(policy.Include) != 2 || len(policy.Exclude) != 1 ||
+		len(policy.Semver) != 2 || policy.Newest != 3 || policy.MaxAge != 30*24*time.Hour {
+		t.Fatalf("unexpected policy %s %+v", spec, policy)
+	}
+	if _, _, hasPolicy, _ = ParseRepoListLine("library/nginx"); hasPolicy {
+		t.Fatal("line without options should have no policy")
+	}
+	for _, line := range []string{"r newest=0", "r maxage=x", "r bogus=1", "r semver=abc", "r include"} {
+		if _, _, _, e := ParseRepoListLine(line); e == nil {
+			t.Fatal("ParseRepoListLine was supposed to fail for", line)
+		}
+	}
+}
+
+func TestApplyTagPolicies(t *testing.T) {
+	savedPolicies, savedPatterns := TagPolicies, RepoPatterns
+	defer func() { TagPolicies, RepoPatterns = savedPolicies, savedPatterns }()
+
+	now := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	md := func(repo, tag string, ageDays int) ImageMetadataInfo {
+		return ImageMetadataInfo{
+			Image:         repo + tag,
+			Datetime:      now.Add(-time.Duration(ageDays) * 24 * time.Hour),
+			OtherMetadata: OtherMetadata{Repo: repo, Tag: tag},
+		}
+	}
+	current := NewMetadataSet()
+	for _, m := range []ImageMetadataInfo{
+		md("team-a/web", "1.0.0", 100), md("team-a/web", "1.1.0", 50), md("team-a/web", "1.2.0", 20),
+		md("team-a/web", "1.3.0-debug", 10), md("team-a/web", "latest", 1),
+		md("library/redis", "3.0", 400), md("library/redis", "3.2", 10), md("library/redis", "3.2-debug", 10),
+		md("other/app", "whatever", 1000),
+	} {
+		current.Insert(m)
+	}
+	// only the newest tags are new in this iteration
+	newSlice := []ImageMetadataInfo{md("team-a/web", "1.2.0", 20), md("team-a/web", "1.0.0", 100),
+		md("team-a/web", "latest", 1), md("library/redis", "3.0", 400), md("library/redis", "3.2", 10),
+		md("library/redis", "3.2-debug", 10), md("other/app", "whatever", 1000)}
+
+	p, _ := NewRepoPattern("team-a/*")
+	RepoPatterns = []RepoPattern{p}
+	TagPolicies = make(map[string]TagPolicy)
+	TagPolicies["team-a/*"], _ = ParseTagPolicy([]string{"exclude=*-debug", "newest=2"})
+	TagPolicies["library/redis"], _ = ParseTagPolicy([]string{"exclude=*-debug", "maxage=30d"})
+
+	selected := ApplyTagPolicies(newSlice, current, now)
+	got := []string{}
+	for _, m := range selected {
+		got = append(got, m.Repo+":"+m.Tag)
+	}
+	sort.Strings(got)
+	expected := []string{"library/redis:3.2", "other/app:whatever", "team-a/web:1.2.0"}
+	if len(got) != len(expected) {
+		t.Fatal("selected:", got, "expected:", expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatal("selected:", got, "expected:", expected)
+		}
+	}
+	if current.Exists(md("team-a/web", "latest", 1)) || !current.Exists(md("team-a/web", "1.1.0", 50)) {
+		t.Fatal("skipped new metadata should be removed from the current set, and only that")
+	}
+}
@@ -0,0 +1,150 @@
+package version
+
+import (
+	"strings"
+)
+
+// apkSuffixes orders the suffixes of apk versions: the pre-release suffixes sort before a version
+// without suffix, which has the weight apkNoSuffix, and the others after it.
+var apkSuffixes = map[string]int{
+	"alpha": 0,
+	"beta":  1,
+	"pre":   2,
+	"rc":    3,
+	"cvs":   5,
+	"svn":   6,
+	"git":   7,
+	"hg":    8,
+	"p":     9,
+}
+
+const apkNoSuffix = 4
+
+// apkSuffix is a suffix of an apk version, e.g., _rc2.
+type apkSuffix struct {
+	weight int
+	number string
+}
+
+// apkVersion is a parsed apk version:
+// NUMBER{.NUMBER}[LETTER]{_SUFFIX[NUMBER]}[~HASH][-rREVISION].
+type apkVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes []apkSuffix
+	revision string
+}
+
+// parseApk parses an apk version. ok is false if it is not a valid apk version.
+func parseApk(s string) (v apkVersion, ok bool) {
+	if i := strings.LastIndex(s, "-r"); i >= 0 {
+		v.revision = s[i+2:]
+		if !allDigits(v.revision) {
+			return v, false
+		}
+		s = s[:i]
+	}
+	if i := strings.Index(s, "~"); i >= 0 {
+		// a commit hash, which doesn't take part in the ordering
+		s = s[:i]
+	}
+	i := 0
+	for {
+		start := i
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		if i == start {
+			return v, false
+		}
+		v.numbers = append(v.numbers, s[start:i])
+		if i < len(s) && s[i] == '.' {
+			i++
+			continue
+		}
+		break
+	}
+	if i < len(s) && s[i] >= 'a' && s[i] <= 'z' {
+		v.letter = s[i]
+		i++
+	}
+	for i < len(s) {
+		if s[i] != '_' {
+			return v, false
+		}
+		i++
+		start := i
+		for i < len(s) && s[i] >= 'a' && s[i] <= 'z' {
+			i++
+		}
+		weight, known := apkSuffixes[s[start:i]]
+		if !known {
+			return v, false
+		}
+		start = i
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		v.suffixes = append(v.suffixes, apkSuffix{weight, s[start:i]})
+	}
+	return v, true
+}
+
+func allDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareApk compares two apk versions as apk version -t does. The numbers are compared in turn:
+// the first one numerically, and the others as decimal fractions if either starts with 0; a version
+// with more numbers is newer. Then come the letters, the suffixes, where a pre-release suffix
+// (alpha, beta, pre, rc) makes a version older than one without it, and the revisions.
+// Invalid versions are compared as in Unknown.
+func CompareApk(a, b string) int {
+	va, okA := parseApk(a)
+	vb, okB := parseApk(b)
+	if !okA || !okB {
+		return sign(rpmvercmp(a, b))
+	}
+	for i := 0; i < len(va.numbers) && i < len(vb.numbers); i++ {
+		x, y := va.numbers[i], vb.numbers[i]
+		var c int
+		if i > 0 && (x[0] == '0' || y[0] == '0') {
+			c = strings.Compare(strings.TrimRight(x, "0"), strings.TrimRight(y, "0"))
+		} else {
+			c = compareNumbers(x, y)
+		}
+		if c != 0 {
+			return sign(c)
+		}
+	}
+	if c := sign(len(va.numbers) - len(vb.numbers)); c != 0 {
+		return c
+	}
+	if c := sign(int(va.letter) - int(vb.letter)); c != 0 {
+		return c
+	}
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		x, y := apkSuffix{weight: apkNoSuffix}, apkSuffix{weight: apkNoSuffix}
+		if i < len(va.suffixes) {
+			x = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			y = vb.suffixes[i]
+		}
+		if x.weight != y.weight {
+			return sign(x.weight - y.weight)
+		}
+		if c := compareNumbers(x.number, y.number); c != 0 {
+			return c
+		}
+	}
+	return compareNumbers(va.revision, vb.revision)
+}
@@ -0,0 +1,77 @@
+package version
+
+import (
+	"strings"
+)
+
+// CompareDpkg compares two Debian package versions of the form [EPOCH:]UPSTREAM[-REVISION], as
+// dpkg --compare-versions does: the epochs numerically, and then the upstream versions and the
+// revisions with verrevcmp. A missing epoch is 0, and a missing revision is empty.
+func CompareDpkg(a, b string) int {
+	aEpoch, aRest := splitEpoch(a)
+	bEpoch, bRest := splitEpoch(b)
+	if c := compareNumbers(aEpoch, bEpoch); c != 0 {
+		return c
+	}
+	aUpstream, aRevision := splitDpkgRevision(aRest)
+	bUpstream, bRevision := splitDpkgRevision(bRest)
+	if c := verrevcmp(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+	return verrevcmp(aRevision, bRevision)
+}
+
+// splitDpkgRevision splits a version into its upstream version and its revision, which follows
+// the last hyphen.
+func splitDpkgRevision(v string) (upstream, revision string) {
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// dpkgOrder is the weight of a character in the non-digit parts of a version: a tilde sorts
+// before anything, even the end of the part, then letters, then all other characters.
+func dpkgOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// verrevcmp compares upstream versions or revisions as dpkg does: alternately a part of non-digits,
+// compared character by character with dpkgOrder, and a part of digits, compared numerically.
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isDigit(a[i]) || j < len(b) && !isDigit(b[j]) {
+			ac, bc := dpkgOrder(a, i), dpkgOrder(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+		si := i
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		sj := j
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		if c := compareNumbers(a[si:i], b[sj:j]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package version
+
+import (
+	"strings"
+)
+
+// CompareRpm compares two rpm versions of the form [EPOCH:]VERSION[-RELEASE]: the epochs
+// numerically, a missing epoch being 0, and then the versions and the releases with rpmvercmp.
+// The releases are compared only if both versions have one, as when rpm matches dependencies.
+func CompareRpm(a, b string) int {
+	aEpoch, aRest := splitEpoch(a)
+	bEpoch, bRest := splitEpoch(b)
+	if c := compareNumbers(aEpoch, bEpoch); c != 0 {
+		return c
+	}
+	aVersion, aRelease := splitRpmRelease(aRest)
+	bVersion, bRelease := splitRpmRelease(bRest)
+	if c := sign(rpmvercmp(aVersion, bVersion)); c != 0 {
+		return c
+	}
+	if aRelease == "" || bRelease == "" {
+		return 0
+	}
+	return sign(rpmvercmp(aRelease, bRelease))
+}
+
+// splitRpmRelease splits a version into its version and its release, which follows the last hyphen.
+func splitRpmRelease(v string) (version, release string) {
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// isRpmSeparator returns true for the characters that only separate the segments of a version.
+func isRpmSeparator(c byte) bool {
+	return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^'
+}
+
+// rpmvercmp compares two versions or releases as rpm does. They are split into segments of digits,
+// compared numerically, and of letters, compared lexically; a numeric segment is newer than an
+// alphabetic one. A tilde sorts before anything, even the end of the version (e.g., 1.0~rc1 <
+// 1.0), and a caret sorts after the end of the version but before anything else (e.g.,
+// 1.0 < 1.0^git1 < 1.0.1).
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && isRpmSeparator(a[i]) {
+			i++
+		}
+		for j < len(b) && isRpmSeparator(b[j]) {
+			j++
+		}
+		aTilde, bTilde := i < len(a) && a[i] == '~', j < len(b) && b[j] == '~'
+		if aTilde || bTilde {
+			if !aTilde {
+				return 1
+			}
+			if !bTilde {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		aCaret, bCaret := i < len(a) && a[i] == '^', j < len(b) && b[j] == '^'
+		if aCaret || bCaret {
+			if i == len(a) {
+				return -1
+			}
+			if j == len(b) {
+				return 1
+			}
+			if !aCaret {
+				return 1
+			}
+			if !bCaret {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if i == len(a) || j == len(b) {
+			break
+		}
+		si, sj := i, j
+		numeric := isDigit(a[i])
+		if numeric {
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+		} else {
+			for i < len(a) && isAlpha(a[i]) {
+				i++
+			}
+			for j < len(b) && isAlpha(b[j]) {
+				j++
+			}
+		}
+		if sj == j {
+			// segments of different types: numeric is newer
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		var c int
+		if numeric {
+			c = compareNumbers(a[si:i], b[sj:j])
+		} else {
+			c = strings.Compare(a[si:i], b[sj:j])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i == len(a) && j == len(b):
+		return 0
+	case i < len(a):
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,101 @@
+// Package version compares the versions of installed packages the way the package manager of
+// their Linux distribution does: dpkg for Debian and Ubuntu, rpm for Red Hat, Fedora, SUSE and
+// the like, and apk for Alpine.
+package version
+
+import (
+	"strings"
+)
+
+// Scheme is a versioning scheme, named after the package manager that defines it.
+type Scheme string
+
+const (
+	Dpkg Scheme = "dpkg"
+	Rpm  Scheme = "rpm"
+	Apk  Scheme = "apk"
+	// Unknown is the scheme of distributions without a known package manager. Versions are then
+	// compared with rpmvercmp, which orders runs of digits and letters without any other syntax.
+	Unknown Scheme = ""
+)
+
+// distroSchemes maps the prefixes of distro IDs (see data/distromap.yaml) to their versioning scheme.
+var distroSchemes = map[string]Scheme{
+	"UBUNTU":   Dpkg,
+	"DEBIAN":   Dpkg,
+	"REDHAT":   Rpm,
+	"CENTOS":   Rpm,
+	"FEDORA":   Rpm,
+	"AMZN":     Rpm,
+	"PHOTON":   Rpm,
+	"OPENSUSE": Rpm,
+	"SLES":     Rpm,
+	"ORACLE":   Rpm,
+	"ALPINE":   Apk,
+}
+
+// ForDistro returns the versioning scheme of the packages of a distribution, given its distro ID,
+// e.g., DEBIAN-bookworm or REDHAT-9.
+func ForDistro(distroID string) Scheme {
+	prefix := strings.ToUpper(distroID)
+	if i := strings.Index(prefix, "-"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	return distroSchemes[prefix]
+}
+
+// Compare returns -1, 0 or 1 if version a is older than, the same as, or newer than version b
+// in scheme. Versions that are invalid in the scheme are compared as in Unknown.
+func Compare(scheme Scheme, a, b string) int {
+	switch scheme {
+	case Dpkg:
+		return CompareDpkg(a, b)
+	case Rpm:
+		return CompareRpm(a, b)
+	case Apk:
+		return CompareApk(a, b)
+	}
+	return sign(rpmvercmp(a, b))
+}
+
+// Less returns true if version a is older than version b in scheme.
+func Less(scheme Scheme, a, b string) bool {
+	return Compare(scheme, a, b) < 0
+}
+
+// splitEpoch splits the epoch off a version of the form [EPOCH:]VERSION, returning an empty
+// epoch if there is none.
+func splitEpoch(v string) (epoch, rest string) {
+	if i := strings.Index(v, ":"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return "", v
+}
+
+// compareNumbers compares two strings of decimal digits by their numeric value, whatever their length.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,223 @@
+package version
+
+import (
+	"fmt"
+	"testing"
+)
+
+type versionCase struct {
+	a, b string
+	c    int
+}
+
+// checkCases checks that compare orders the versions of cases, both ways.
+func checkCases(t *testing.T, name string, compare func(a, b string) int, cases []versionCase) {
+	for _, c := range cases {
+		if got := compare(c.a, c.b); got != c.c {
+			t.Fatal(name, c.a, c.b, "expected", c.c, "got", got)
+		}
+		if got := compare(c.b, c.a); got != -c.c {
+			t.Fatal(name, c.b, c.a, "expected", -c.c, "got", got)
+		}
+	}
+}
+
+func TestCompareDpkg(t *testing.T) {
+	checkCases(t, "dpkg", CompareDpkg, []versionCase{
+		// from the dpkg test suite
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0-0", 0},
+		{"0:1.0", "1.0", 0},
+		{"1:1.0", "1.0", 1},
+		{"1:1.0", "2.0", 1},
+		{"1:1.0", "0:9.9", 1},
+		{"1.0", "1.0-1", -1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1.0", "1.1", -1},
+		{"1.2", "1.10", -1},
+		{"1.02", "1.2", 0},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0b", -1},
+		{"1.0a", "1.0.", -1},
+		{"1.0+", "1.0.", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~~a", "1.0~~", 1},
+		{"1.0~", "1.0~a", -1},
+		{"1.0-1~bpo1", "1.0-1", -1},
+		{"1.0-1", "1.0-1+b1", -1},
+		// hyphens in the upstream version: the revision follows the last one
+		{"1.0-rc1-1", "1.0-rc2-1", -1},
+		{"1.0-1-1", "1.0-1", 1},
+		// real versions
+		{"2.36-9", "2.36-9+deb12u1", -1},
+		{"2.36-9+deb12u1", "2.36-9+deb12u3", -1},
+		{"7.88.1-10", "7.74.0-1.3", 1},
+		{"1.1.1n-0+deb11u5", "1.1.1n-0+deb11u4", 1},
+		{"3.0.11-1~deb12u2", "3.0.11-1", -1},
+		{"1:2.38.1-5", "2.39.3-6", 1},
+		{"2.35-0ubuntu3.6", "2.35-0ubuntu3.10", -1},
+		{"1.2.13.dfsg-1", "1:1.2.13.dfsg-1", -1},
+	})
+}
+
+func TestCompareRpm(t *testing.T) {
+	checkCases(t, "rpm", CompareRpm, []versionCase{
+		// from the rpmvercmp tests of rpm
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0.1", 0},
+		{"2.0", "2.0.1", -1},
+		{"2.0.1a", "2.0.1a", 0},
+		{"2.0.1a", "2.0.1", 1},
+		{"5.5p1", "5.5p1", 0},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10", 0},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz.4", "xyz.4", 0},
+		{"xyz.4", "8", -1},
+		{"xyz.4", "2", -1},
+		{"5.5p2", "5.6p1", -1},
+		{"5.6p1", "6.5p1", -1},
+		{"6.0.rc1", "6.0", 1},
+		{"10b2", "10a1", 1},
+		{"1.0aa", "1.0a", 1},
+		{"10.0001", "10.1", 0},
+		{"10.0001", "10.0039", -1},
+		{"4.999.9", "5.0", -1},
+		{"20101121", "20101122", -1},
+		{"2_0", "2_0", 0},
+		{"2.0", "2_0", 0},
+		{"a", "a", 0},
+		{"a+", "a_", 0},
+		{"+", "_", 0},
+		{"1.0~rc1", "1.0~rc1", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0^", "1.0^", 0},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^20160101", "1.0.1", -1},
+		{"1.0^20160101^git1", "1.0^20160101", 1},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		// epochs and releases
+		{"1:1.0-1", "2.0-1", 1},
+		{"0:1.0-1", "1.0-1", 0},
+		{"1.0-1.el9", "1.0-2.el9", -1},
+		{"1.0-10.el9", "1.0-9.el9", 1},
+		{"1.0", "1.0-1.el9", 0},
+		{"2.34-83.el9_3.7", "2.34-83.el9_3.12", -1},
+		{"5.14.0-362.8.1.el9_3", "5.14.0-362.13.1.el9_3", -1},
+		{"3.0.7-25.el9_3", "3.0.7-24.el9", 1},
+		{"1.2.11-31.amzn2.0.1", "1.2.11-32.amzn2.0.1", -1},
+	})
+}
+
+func TestCompareApk(t *testing.T) {
+	checkCases(t, "apk", CompareApk, []versionCase{
+		// from the version tests of apk-tools
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0.2", "1.0.10", -1},
+		{"1.2", "1.10", -1},
+		{"1.0", "1.0-r0", 0},
+		{"1.0-r1", "1.0-r0", 1},
+		{"1.0-r10", "1.0-r9", 1},
+		{"1.0-r1", "1.0.1", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0b", -1},
+		{"1.0a", "1.0.1", -1},
+		{"1.0_alpha", "1.0", -1},
+		{"1.0_alpha1", "1.0_alpha2", -1},
+		{"1.0_alpha", "1.0_beta", -1},
+		{"1.0_beta", "1.0_pre", -1},
+		{"1.0_pre", "1.0_rc", -1},
+		{"1.0_rc1", "1.0", -1},
+		{"1.0_rc1", "1.0-r1", -1},
+		{"1.0", "1.0_cvs", -1},
+		{"1.0_cvs", "1.0_svn", -1},
+		{"1.0_svn", "1.0_git", -1},
+		{"1.0_git", "1.0_hg", -1},
+		{"1.0_hg", "1.0_p", -1},
+		{"1.0_p1", "1.0_p2", -1},
+		{"1.0_p1", "1.0.1", -1},
+		{"1.0_git20231201", "1.0_git20240101", -1},
+		{"1.0_rc1_p1", "1.0_rc1", 1},
+		{"1.0_rc1_alpha", "1.0_rc1", -1},
+		{"1.0_alpha_p1", "1.0_alpha1", -1},
+		// numbers after the first one with leading zeros compare as fractions
+		{"1.01", "1.1", -1},
+		{"1.001", "1.01", -1},
+		{"1.010", "1.01", 0},
+		{"1.09", "1.1", -1},
+		{"01.1", "1.1", 0},
+		// commit hashes don't take part in the ordering
+		{"1.0~abc123", "1.0~def456", 0},
+		{"1.0~abc123-r1", "1.0-r2", -1},
+		// real versions
+		{"1.2.4-r2", "1.2.4-r3", -1},
+		{"1.2.4_git20230717-r4", "1.2.4-r0", 1},
+		{"3.1.4-r5", "3.1.4-r6", -1},
+		{"8.5.0-r0", "8.11.1-r0", -1},
+		{"1.36.1-r15", "1.36.1-r7", 1},
+		// invalid versions are compared as in Unknown
+		{"1.0-beta", "1.0-beta", 0},
+		{"1.0-beta", "1.0", 1},
+	})
+}
+
+func TestCompareUnknown(t *testing.T) {
+	checkCases(t, "unknown", func(a, b string) int { return Compare(Unknown, a, b) }, []versionCase{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.10", -1},
+		{"1.10", "1.9", 1},
+		{"1.2", "1.2.1", -1},
+	})
+}
+
+func TestForDistro(t *testing.T) {
+	cases := []struct {
+		distroID string
+		scheme   Scheme
+	}{
+		{"UBUNTU-jammy", Dpkg},
+		{"DEBIAN-bookworm", Dpkg},
+		{"debian-bullseye", Dpkg},
+		{"REDHAT-9", Rpm},
+		{"CENTOS-7", Rpm},
+		{"FEDORA-39", Rpm},
+		{"AMZN-2023", Rpm},
+		{"PHOTON-5.0", Rpm},
+		{"OPENSUSE-15.5", Rpm},
+		{"SLES-15", Rpm},
+		{"ALPINE-3.19", Apk},
+		{"ALPINE", Apk},
+		{"GENTOO-2.14", Unknown},
+		{"", Unknown},
+	}
+	for _, c := range cases {
+		if got := ForDistro(c.distroID); got != c.scheme {
+			t.Fatal(c.distroID, "expected", c.scheme, "got", got)
+		}
+	}
+
+	// the same versions order differently in different schemes
+	a, b := "1.0~rc1", "1.0"
+	for _, scheme := range []Scheme{Dpkg, Rpm, Apk} {
+		fmt.Println(scheme, a, b, Compare(scheme, a, b))
+	}
+	if !Less(Dpkg, a, b) || !Less(Rpm, a, b) {
+		t.Fatal("Expected", a, "older than", b)
+	}
+	if !Less(Dpkg, "1.0-1", "1.0-2") || Less(Rpm, "1.0", "1.0-2") {
+		t.Fatal("Unexpected ordering of revisions")
+	}
+}
@@ -0,0 +1,93 @@
+package vulndb
+
+import (
+	"math"
+	"strings"
+)
+
+// cvss3Weights are the weights of the values of the CVSS v3 base metrics. The weights of the
+// privileges required when the scope is changed are under PR:C.
+var cvss3Weights = map[string]map[string]float64{
+	"AV":   {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC":   {"L": 0.77, "H": 0.44},
+	"PR":   {"N": 0.85, "L": 0.62, "H": 0.27},
+	"PR:C": {"N": 0.85, "L": 0.68, "H": 0.5},
+	"UI":   {"N": 0.85, "R": 0.62},
+	"C":    {"H": 0.56, "L": 0.22, "N": 0},
+	"I":    {"H": 0.56, "L": 0.22, "N": 0},
+	"A":    {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// CVSS3Score computes the base score of a CVSS v3.0 or v3.1 vector, e.g.,
+// CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H. ok is false if the vector is invalid.
+func CVSS3Score(vector string) (score float64, ok bool) {
+	parts := strings.Split(vector, "/")
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "CVSS:3") {
+		return 0, false
+	}
+	metrics := make(map[string]string)
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			return 0, false
+		}
+		metrics[kv[0]] = kv[1]
+	}
+	changed := false
+	switch metrics["S"] {
+	case "U":
+	case "C":
+		changed = true
+	default:
+		return 0, false
+	}
+	w := make(map[string]float64)
+	for _, m := range []string{"AV", "AC", "PR", "UI", "C", "I", "A"} {
+		table := m
+		if m == "PR" && changed {
+			table = "PR:C"
+		}
+		weight, found := cvss3Weights[table][metrics[m]]
+		if !found {
+			return 0, false
+		}
+		w[m] = weight
+	}
+	iss := 1 - (1-w["C"])*(1-w["I"])*(1-w["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * w["AV"] * w["AC"] * w["PR"] * w["UI"]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// roundUp rounds up to one decimal, as specified by CVSS v3.1 to avoid floating point errors.
+func roundUp(x float64) float64 {
+	n := int64(math.Round(x * 100000))
+	if n%10000 == 0 {
+		return float64(n) / 100000
+	}
+	return float64(n/10000+1) / 10
+}
+
+// cvssSeverity returns the severity rating of a CVSS score.
+func cvssSeverity(score float64) string {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityNegligible
+}
@@ -0,0 +1,53 @@
+package vulndb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+// debianRelease is the status of a vulnerability of a source package in a Debian release.
+type debianRelease struct {
+	Status       string `json:"status"`
+	FixedVersion string `json:"fixed_version"`
+	Urgency      string `json:"urgency"`
+}
+
+// debianIssue is a vulnerability of a source package in the Debian security tracker.
+type debianIssue struct {
+	Description string                   `json:"description"`
+	Releases    map[string]debianRelease `json:"releases"`
+}
+
+// loadDebian loads a dump of the Debian security tracker, which maps source packages to
+// vulnerability IDs to their status in every release: resolved in a fixed version (0 if the
+// release was never affected), open, or undetermined, which is ignored.
+func loadDebian(db *DB, filename string) (e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
+	}
+	var tracker map[string]map[string]debianIssue
+	if e = json.Unmarshal(data, &tracker); e != nil {
+		return
+	}
+	for pkg, issues := range tracker {
+		for id, issue := range issues {
+			summary := strings.SplitN(strings.TrimSpace(issue.Description), "\n", 2)[0]
+			for codename, release := range issue.Releases {
+				a := &advisory{id: id, cves: cveIDs(id), summary: summary, source: SourceDebian,
+					severity: NormalizeSeverity(release.Urgency)}
+				switch {
+				case release.Status == "resolved" && release.FixedVersion != "" && release.FixedVersion != "0":
+					a.ranges = [][]event{{{Introduced: "0"}, {Fixed: release.FixedVersion}}}
+				case release.Status == "open":
+					a.ranges = [][]event{{{Introduced: "0"}}}
+				default:
+					continue
+				}
+				db.add("DEBIAN-"+codename, pkg, a)
+			}
+		}
+	}
+	return
+}
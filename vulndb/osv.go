@@ -0,0 +1,164 @@
+package vulndb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// osvSeverity is a severity of an OSV record, e.g., a CVSS_V3 vector, or an Ubuntu priority.
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+// osvRecord is the part of an OSV record (https://ossf.github.io/osv-schema/) used for matching.
+type osvRecord struct {
+	ID       string        `json:"id"`
+	Aliases  []string      `json:"aliases"`
+	Upstream []string      `json:"upstream"`
+	Summary  string        `json:"summary"`
+	Details  string        `json:"details"`
+	Severity []osvSeverity `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Severity []osvSeverity `json:"severity"`
+		Ranges   []struct {
+			Type   string  `json:"type"`
+			Events []event `json:"events"`
+		} `json:"ranges"`
+		Versions          []string `json:"versions"`
+		EcosystemSpecific struct {
+			Urgency string `json:"urgency"`
+		} `json:"ecosystem_specific"`
+		DatabaseSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"database_specific"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+var (
+	// debianReleases and ubuntuReleases map the releases in OSV ecosystems to codenames.
+	debianReleases = map[string]string{"7": "wheezy", "8": "jessie", "9": "stretch", "10": "buster",
+		"11": "bullseye", "12": "bookworm", "13": "trixie", "14": "forky"}
+	ubuntuReleases = map[string]string{"12.04": "precise", "14.04": "trusty", "16.04": "xenial",
+		"18.04": "bionic", "20.04": "focal", "22.04": "jammy", "23.04": "lunar", "23.10": "mantic",
+		"24.04": "noble", "24.10": "oracular", "25.04": "plucky"}
+
+	ubuntuReleaseRegexp = regexp.MustCompile(`\d+\.\d+`)
+	rhelReleaseRegexp   = regexp.MustCompile(`^(?:enterprise_linux:)?(\d+)`)
+	slesReleaseRegexp   = regexp.MustCompile(`^Linux Enterprise Server (\d+)(?: SP(\d+))?`)
+)
+
+// ecosystemDistroID returns the distro ID of an OSV ecosystem, e.g., DEBIAN-bookworm for Debian:12,
+// or an empty string for ecosystems of other distributions or of languages.
+func ecosystemDistroID(ecosystem string) string {
+	parts := strings.SplitN(ecosystem, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	name, release := parts[0], parts[1]
+	switch name {
+	case "Debian":
+		if codename := debianReleases[strings.SplitN(release, ".", 2)[0]]; codename != "" {
+			return "DEBIAN-" + codename
+		}
+	case "Ubuntu":
+		if codename := ubuntuReleases[ubuntuReleaseRegexp.FindString(release)]; codename != "" {
+			return "UBUNTU-" + codename
+		}
+	case "Alpine":
+		return "ALPINE-" + strings.TrimPrefix(release, "v")
+	case "Rocky Linux", "AlmaLinux", "Red Hat":
+		if m := rhelReleaseRegexp.FindStringSubmatch(release); m != nil {
+			if m[1] == "7" {
+				return "REDHAT-7Server"
+			}
+			return "REDHAT-" + m[1]
+		}
+	case "openSUSE":
+		if release == "Tumbleweed" {
+			return "OPENSUSE-TUMBLEWEED"
+		}
+		if strings.HasPrefix(release, "Leap ") {
+			return "OPENSUSE-LEAP-" + strings.TrimPrefix(release, "Leap ")
+		}
+	case "SUSE":
+		if m := slesReleaseRegexp.FindStringSubmatch(release); m != nil {
+			if m[2] != "" {
+				return "SLES-" + m[1] + "." + m[2]
+			}
+			return "SLES-" + m[1]
+		}
+	}
+	return ""
+}
+
+// osvSeverityRating returns the severity of severities: a distribution's rating, or else the
+// rating of the score of a CVSS v3 vector.
+func osvSeverityRating(severities []osvSeverity) string {
+	for _, s := range severities {
+		if s.Type == "Ubuntu" {
+			return NormalizeSeverity(s.Score)
+		}
+	}
+	for _, s := range severities {
+		if s.Type == "CVSS_V3" {
+			if score, ok := CVSS3Score(s.Score); ok {
+				return cvssSeverity(score)
+			}
+		}
+	}
+	return SeverityUnknown
+}
+
+// loadOSV loads an OSV record. Affected packages of ecosystems that aren't Linux distributions
+// are ignored, as are ranges of git commits.
+func loadOSV(db *DB, filename string) (e error) {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return
+	}
+	var r osvRecord
+	if e = json.Unmarshal(data, &r); e != nil {
+		return
+	}
+	summary := r.Summary
+	if summary == "" {
+		summary = strings.SplitN(strings.TrimSpace(r.Details), "\n", 2)[0]
+	}
+	cves := cveIDs(append(append([]string{r.ID}, r.Aliases...), r.Upstream...)...)
+	for _, affected := range r.Affected {
+		distroID := ecosystemDistroID(affected.Package.Ecosystem)
+		if distroID == "" || affected.Package.Name == "" {
+			continue
+		}
+		a := &advisory{id: r.ID, cves: cves, summary: summary, source: SourceOSV,
+			versions: affected.Versions}
+		for _, rating := range []string{affected.EcosystemSpecific.Urgency, affected.DatabaseSpecific.Severity,
+			r.DatabaseSpecific.Severity} {
+			if a.severity = NormalizeSeverity(rating); a.severity != SeverityUnknown {
+				break
+			}
+		}
+		if a.severity == SeverityUnknown {
+			a.severity = osvSeverityRating(append(affected.Severity, r.Severity...))
+		}
+		for _, rng := range affected.Ranges {
+			if rng.Type != "GIT" && len(rng.Events) > 0 {
+				a.ranges = append(a.ranges, rng.Events)
+			}
+		}
+		if len(a.ranges) > 0 || len(a.versions) > 0 {
+			db.add(distroID, affected.Package.Name, a)
+		}
+	}
+	return
+}
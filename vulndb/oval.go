@@ -0,0 +1,213 @@
+package vulndb
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ovalNode is an element of an OVAL document. OVAL documents mix several namespaces, e.g.,
+// red-def:rpminfo_test or linux-def:dpkginfo_test, so elements are matched by local name only.
+type ovalNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Content  string     `xml:",chardata"`
+	Children []ovalNode `xml:",any"`
+}
+
+func (n *ovalNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *ovalNode) child(name string) *ovalNode {
+	for i := range n.Children {
+		if n.Children[i].XMLName.Local == name {
+			return &n.Children[i]
+		}
+	}
+	return nil
+}
+
+// testRefs returns the tests referenced by the criteria n, at any depth.
+func (n *ovalNode) testRefs() (refs []string) {
+	for i := range n.Children {
+		c := &n.Children[i]
+		switch c.XMLName.Local {
+		case "criterion":
+			refs = append(refs, c.attr("test_ref"))
+		case "criteria", "extend_definition":
+			refs = append(refs, c.testRefs()...)
+		}
+	}
+	return
+}
+
+// ovalState is the version of a package that an OVAL test checks against, e.g., less than
+// 0:3.0.7-25.el9_3.
+type ovalState struct {
+	version   string
+	operation string
+}
+
+// events returns the range of the versions that satisfy the state, or nil if it doesn't test versions.
+func (s ovalState) events() []event {
+	switch s.operation {
+	case "less than":
+		return []event{{Introduced: "0"}, {Fixed: s.version}}
+	case "less than or equal":
+		return []event{{Introduced: "0"}, {LastAffected: s.version}}
+	case "greater than", "greater than or equal":
+		return []event{{Introduced: s.version}}
+	}
+	return nil
+}
+
+// loadOVAL loads OVAL definitions for the distro ID that the file is named after, e.g.,
+// REDHAT-9.oval.xml. The criteria of a definition are flattened: it affects every package that
+// a test of the definition checks the version of, in the range of versions of that test; the
+// other tests, e.g., of signing keys or of the release, are ignored, as the file is selected by
+// distro ID.
+func loadOVAL(db *DB, filename string) (e error) {
+	distroID := strings.SplitN(filepath.Base(filename), ".", 2)[0]
+	f, e := os.Open(filename)
+	if e != nil {
+		return
+	}
+	defer f.Close()
+	var root ovalNode
+	if e = xml.NewDecoder(f).Decode(&root); e != nil {
+		return
+	}
+
+	// variables, objects (package names) and states (package versions) by ID
+	variables := make(map[string][]string)
+	if section := root.child("variables"); section != nil {
+		for _, v := range section.Children {
+			for _, value := range v.Children {
+				if value.XMLName.Local == "value" {
+					variables[v.attr("id")] = append(variables[v.attr("id")], strings.TrimSpace(value.Content))
+				}
+			}
+		}
+	}
+	objects := make(map[string][]string)
+	if section := root.child("objects"); section != nil {
+		for i := range section.Children {
+			o := &section.Children[i]
+			name := o.child("name")
+			switch {
+			case name == nil:
+			case name.attr("var_ref") != "":
+				objects[o.attr("id")] = variables[name.attr("var_ref")]
+			default:
+				objects[o.attr("id")] = []string{strings.TrimSpace(name.Content)}
+			}
+		}
+	}
+	states := make(map[string]ovalState)
+	if section := root.child("states"); section != nil {
+		for i := range section.Children {
+			s := &section.Children[i]
+			v := s.child("evr")
+			if v == nil {
+				v = s.child("version")
+			}
+			if v != nil {
+				states[s.attr("id")] = ovalState{strings.TrimSpace(v.Content), v.attr("operation")}
+			}
+		}
+	}
+	type ovalTest struct {
+		names []string
+		state ovalState
+	}
+	tests := make(map[string]ovalTest)
+	if section := root.child("tests"); section != nil {
+		for i := range section.Children {
+			t := &section.Children[i]
+			object, state := t.child("object"), t.child("state")
+			if object == nil || state == nil {
+				continue
+			}
+			if s, found := states[state.attr("state_ref")]; found {
+				tests[t.attr("id")] = ovalTest{objects[object.attr("object_ref")], s}
+			}
+		}
+	}
+
+	definitions := root.child("definitions")
+	if definitions == nil {
+		return
+	}
+	for i := range definitions.Children {
+		d := &definitions.Children[i]
+		if class := d.attr("class"); class != "vulnerability" && class != "patch" {
+			continue
+		}
+		a := &advisory{id: d.attr("id"), source: SourceOVAL, severity: SeverityUnknown}
+		advisoryID := ""
+		if metadata := d.child("metadata"); metadata != nil {
+			if title := metadata.child("title"); title != nil {
+				a.summary = strings.TrimSpace(title.Content)
+			}
+			var ids []string
+			for _, ref := range metadata.Children {
+				if ref.XMLName.Local != "reference" {
+					continue
+				}
+				ids = append(ids, ref.attr("ref_id"))
+				if ref.attr("source") != "CVE" && advisoryID == "" {
+					advisoryID = ref.attr("ref_id")
+				}
+			}
+			if adv := metadata.child("advisory"); adv != nil {
+				if severity := adv.child("severity"); severity != nil {
+					a.severity = NormalizeSeverity(severity.Content)
+				}
+				for _, cve := range adv.Children {
+					if cve.XMLName.Local == "cve" {
+						ids = append(ids, strings.TrimSpace(cve.Content))
+					}
+				}
+			}
+			a.cves = cveIDs(ids...)
+		}
+		switch {
+		case advisoryID != "":
+			a.id = advisoryID
+		case len(a.cves) > 0:
+			// the definition ID is only meaningful within the file
+			a.id = ""
+		}
+		criteria := d.child("criteria")
+		if criteria == nil {
+			continue
+		}
+		// one advisory per package, with the ranges of all its tests
+		byPkg := make(map[string]*advisory)
+		for _, ref := range criteria.testRefs() {
+			t, found := tests[ref]
+			events := t.state.events()
+			if !found || events == nil {
+				continue
+			}
+			for _, name := range t.names {
+				pa := byPkg[name]
+				if pa == nil {
+					copied := *a
+					pa = &copied
+					byPkg[name] = pa
+					db.add(distroID, name, pa)
+				}
+				pa.ranges = append(pa.ranges, events)
+			}
+		}
+	}
+	return
+}
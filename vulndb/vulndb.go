@@ -0,0 +1,329 @@
+// Package vulndb matches installed packages against an offline vulnerability database, a directory
+// with the feeds downloaded ahead of time:
+//
+//	osv/     OSV records, one JSON file each (any depth), e.g., the unzipped dumps of osv.dev
+//	         for the Debian, Ubuntu, Alpine, Rocky Linux, AlmaLinux and SUSE ecosystems
+//	debian/  dumps of the Debian security tracker (https://security-tracker.debian.org/tracker/data/json)
+//	oval/    OVAL definitions, e.g., of Red Hat or Ubuntu, each named after the distro ID it applies
+//	         to, e.g., REDHAT-9.oval.xml or UBUNTU-jammy.cve.oval.xml
+//
+// Every feed is turned into advisories that affect ranges of versions of the packages of a distro ID.
+// Versions are compared with the scheme of the distribution (see package version).
+package vulndb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	version "github.com/banyanops/collector/version"
+)
+
+// Sources of advisories, reported in findings.
+const (
+	SourceOSV    = "osv"
+	SourceDebian = "debian"
+	SourceOVAL   = "oval"
+)
+
+// Severities of findings, normalized from the ratings of the feeds.
+const (
+	SeverityCritical   = "critical"
+	SeverityHigh       = "high"
+	SeverityMedium     = "medium"
+	SeverityLow        = "low"
+	SeverityNegligible = "negligible"
+	SeverityUnknown    = "unknown"
+)
+
+// Package is an installed package to match.
+type Package struct {
+	Name         string
+	Version      string
+	Architecture string
+	// Origin is the name of the source package, which Debian, Ubuntu and Alpine feeds refer to
+	Origin string
+}
+
+// Finding is a vulnerability of an installed package.
+type Finding struct {
+	// ID is the ID of the vulnerability, its CVE ID if it has one
+	ID string `json:"id"`
+	// Advisory is the ID of the record of the feed, e.g., an OSV ID or an RHSA, if it differs from ID
+	Advisory     string `json:"advisory,omitempty"`
+	Pkg          string `json:"pkg"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+	// FixedVersion is the first version with a fix, empty if there is none yet
+	FixedVersion string `json:"fixedVersion,omitempty"`
+	Severity     string `json:"severity"`
+	Summary      string `json:"summary,omitempty"`
+	Source       string `json:"source"`
+}
+
+// event is a bound of a range of affected versions, as in OSV: exactly one of its fields is set.
+// Introduced "0" is the beginning of time.
+type event struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+}
+
+// advisory is a vulnerability of a package of a distro.
+type advisory struct {
+	// id is the ID of the record of the feed, empty if it is just its CVE IDs
+	id string
+	// cves are the CVE IDs of the vulnerability; a finding is reported for each one
+	cves     []string
+	summary  string
+	severity string
+	source   string
+	// ranges are the ranges of affected versions, each a list of events
+	ranges [][]event
+	// versions are affected versions besides the ranges
+	versions []string
+}
+
+// DB is a vulnerability database loaded in memory.
+type DB struct {
+	// advisories maps distro IDs to package names to the advisories affecting them
+	advisories map[string]map[string][]*advisory
+	count      int
+}
+
+// Load loads the vulnerability database in directory dir. The feed subdirectories are optional,
+// but at least one must be present.
+func Load(dir string) (db *DB, e error) {
+	db = &DB{advisories: make(map[string]map[string][]*advisory)}
+	found := false
+	for _, feed := range []struct {
+		subdir string
+		ext    string
+		load   func(db *DB, filename string) error
+	}{
+		{"osv", ".json", loadOSV},
+		{"debian", ".json", loadDebian},
+		{"oval", ".xml", loadOVAL},
+	} {
+		root := filepath.Join(dir, feed.subdir)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		found = true
+		e = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(path) != feed.ext {
+				return err
+			}
+			if err := feed.load(db, path); err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+			return nil
+		})
+		if e != nil {
+			return nil, e
+		}
+	}
+	if !found {
+		return nil, errors.New(dir + " has none of the osv, debian and oval feeds")
+	}
+	return
+}
+
+// Advisories returns the number of advisories in the database, counting each distro and package.
+func (db *DB) Advisories() int {
+	return db.count
+}
+
+// add adds an advisory affecting package pkg of distro distroID.
+func (db *DB) add(distroID, pkg string, a *advisory) {
+	pkgs := db.advisories[distroID]
+	if pkgs == nil {
+		pkgs = make(map[string][]*advisory)
+		db.advisories[distroID] = pkgs
+	}
+	pkgs[pkg] = append(pkgs[pkg], a)
+	db.count++
+}
+
+// feedDistroID returns the distro ID under which the advisories of distroID are kept in the feeds:
+// Debian testing and unstable (e.g., DEBIAN-trixie-sid) are sid, and the minor releases of old Red
+// Hat releases (e.g., REDHAT-6Server-6.10) their major release.
+func feedDistroID(distroID string) string {
+	switch {
+	case strings.HasPrefix(distroID, "DEBIAN-") && strings.HasSuffix(distroID, "-sid"):
+		return "DEBIAN-sid"
+	case strings.HasPrefix(distroID, "REDHAT-") && strings.Count(distroID, "-") == 2:
+		return distroID[:strings.LastIndex(distroID, "-")]
+	}
+	return distroID
+}
+
+// Match returns the vulnerabilities of the packages pkgs of an image of distro distroID, sorted by
+// package and ID. Packages are looked up by name and by origin.
+func (db *DB) Match(distroID string, pkgs []Package) (findings []Finding) {
+	findings = []Finding{}
+	byPkg := db.advisories[feedDistroID(distroID)]
+	if byPkg == nil {
+		return
+	}
+	scheme := version.ForDistro(distroID)
+	type findingKey struct {
+		id, pkg, version, arch string
+	}
+	seen := make(map[findingKey]bool)
+	for _, p := range pkgs {
+		names := []string{p.Name}
+		if p.Origin != "" && p.Origin != p.Name {
+			names = append(names, p.Origin)
+		}
+		for _, name := range names {
+			for _, a := range byPkg[name] {
+				affected, fixed := a.affects(scheme, p.Version)
+				if !affected {
+					continue
+				}
+				ids := a.cves
+				if len(ids) == 0 {
+					ids = []string{a.id}
+				}
+				for _, id := range ids {
+					key := findingKey{id, p.Name, p.Version, p.Architecture}
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+					f := Finding{ID: id, Pkg: p.Name, Version: p.Version, Architecture: p.Architecture,
+						FixedVersion: fixed, Severity: a.severity, Summary: a.summary, Source: a.source}
+					if a.id != "" && a.id != id {
+						f.Advisory = a.id
+					}
+					findings = append(findings, f)
+				}
+			}
+		}
+	}
+	sort.Sort(byFinding(findings))
+	return
+}
+
+// affects returns true if version v is affected by the advisory, and the first fixed version
+// after v, if any.
+func (a *advisory) affects(scheme version.Scheme, v string) (affected bool, fixed string) {
+	for _, events := range a.ranges {
+		if ok, f := rangeAffects(scheme, events, v); ok {
+			return true, f
+		}
+	}
+	for _, affectedVersion := range a.versions {
+		if version.Compare(scheme, v, affectedVersion) == 0 {
+			return true, ""
+		}
+	}
+	return false, ""
+}
+
+// rangeAffects evaluates the events of a range for version v, as described by the OSV schema:
+// the events are sorted by version, and v is affected after an introduced event that it is not
+// older than, until a fixed event that it is not older than, or a last affected event older than it.
+// The evaluation stops at the first event newer than v, which is the fixed version if v is affected.
+func rangeAffects(scheme version.Scheme, events []event, v string) (affected bool, fixed string) {
+	sorted := make([]event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareEventVersions(scheme, sorted[i].version(), sorted[j].version()) < 0
+	})
+	for _, e := range sorted {
+		if e.Introduced != "0" && compareEventVersions(scheme, v, e.version()) < 0 {
+			if affected && e.Fixed != "" {
+				fixed = e.Fixed
+			}
+			break
+		}
+		switch {
+		case e.Introduced != "":
+			affected = true
+		case e.Fixed != "":
+			affected = false
+		case e.LastAffected != "":
+			// v is not older than the last affected version
+			affected = affected && compareEventVersions(scheme, v, e.LastAffected) == 0
+		}
+	}
+	return
+}
+
+func (e event) version() string {
+	return e.Introduced + e.Fixed + e.LastAffected
+}
+
+// compareEventVersions compares an installed version with the version of an event. Introduced "0"
+// is older than any version.
+func compareEventVersions(scheme version.Scheme, a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "0":
+		return -1
+	case b == "0":
+		return 1
+	}
+	return version.Compare(scheme, a, b)
+}
+
+// byFinding sorts findings by package, architecture, version and ID.
+type byFinding []Finding
+
+func (a byFinding) Len() int      { return len(a) }
+func (a byFinding) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byFinding) Less(i, j int) bool {
+	x, y := a[i], a[j]
+	if x.Pkg != y.Pkg {
+		return x.Pkg < y.Pkg
+	}
+	if x.Architecture != y.Architecture {
+		return x.Architecture < y.Architecture
+	}
+	if x.Version != y.Version {
+		return x.Version < y.Version
+	}
+	return x.ID < y.ID
+}
+
+// NormalizeSeverity maps the severity rating of a feed, e.g., Important (Red Hat), unimportant
+// (Debian) or MODERATE (GitHub), to one of the Severity constants.
+func NormalizeSeverity(rating string) string {
+	switch strings.ToLower(strings.TrimSpace(strings.TrimRight(rating, "*"))) {
+	case "critical":
+		return SeverityCritical
+	case "high", "important":
+		return SeverityHigh
+	case "medium", "moderate":
+		return SeverityMedium
+	case "low":
+		return SeverityLow
+	case "negligible", "unimportant", "none":
+		return SeverityNegligible
+	}
+	return SeverityUnknown
+}
+
+// cveIDs returns the CVE IDs among ids, without duplicates.
+func cveIDs(ids ...string) (cves []string) {
+	for _, id := range ids {
+		if strings.HasPrefix(id, "CVE-") && !containsString(cves, id) {
+			cves = append(cves, id)
+		}
+	}
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
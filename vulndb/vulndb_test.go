@@ -0,0 +1,419 @@
+package vulndb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const osvDebian = `{
+	"id": "DSA-5532-1",
+	"aliases": ["CVE-2023-5363"],
+	"summary": "openssl security update",
+	"affected": [{
+		"package": {"ecosystem": "Debian:12", "name": "openssl"},
+		"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.0.11-1~deb12u2"}]}]
+	}, {
+		"package": {"ecosystem": "Debian:11", "name": "openssl"},
+		"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.1.1w-0+deb11u1"}]}]
+	}],
+	"severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:N/A:N"}]
+}`
+
+const osvAlpine = `{
+	"id": "CVE-2023-42363",
+	"details": "A use-after-free in busybox awk.\nMore details.",
+	"affected": [{
+		"package": {"ecosystem": "Alpine:v3.19", "name": "busybox"},
+		"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.36.1-r16"}]},
+			{"type": "GIT", "repo": "https://git.busybox.net/busybox", "events": [{"introduced": "0"}, {"fixed": "abc"}]}],
+		"database_specific": {"severity": "MEDIUM"}
+	}, {
+		"package": {"ecosystem": "PyPI", "name": "busybox"},
+		"versions": ["1.0"]
+	}]
+}`
+
+const debianTracker = `{
+	"curl": {
+		"CVE-2023-38545": {
+			"description": "SOCKS5 heap buffer overflow",
+			"releases": {
+				"bookworm": {"status": "resolved", "fixed_version": "7.88.1-10+deb12u4", "urgency": "high"},
+				"bullseye": {"status": "resolved", "fixed_version": "0", "urgency": "not yet assigned"},
+				"sid": {"status": "resolved", "fixed_version": "8.3.0-3", "urgency": "high"}
+			}
+		},
+		"CVE-2023-38546": {
+			"description": "cookie injection with none file",
+			"releases": {
+				"bookworm": {"status": "open", "urgency": "unimportant"},
+				"trixie": {"status": "undetermined", "urgency": "low"}
+			}
+		}
+	},
+	"openssl": {
+		"CVE-2023-5363": {
+			"description": "Incorrect cipher key and IV length processing",
+			"releases": {"bookworm": {"status": "resolved", "fixed_version": "3.0.11-1~deb12u2", "urgency": "medium**"}}
+		}
+	}
+}`
+
+const ovalRedHat = `<?xml version="1.0" encoding="utf-8"?>
+<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5"
+	xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux">
+	<definitions>
+		<definition class="patch" id="oval:com.redhat.rhsa:def:20237877" version="637">
+			<metadata>
+				<title>RHSA-2023:7877: openssl security update (Low)</title>
+				<reference ref_id="RHSA-2023:7877" ref_url="https://access.redhat.com/errata/RHSA-2023:7877" source="RHSA"/>
+				<reference ref_id="CVE-2023-5678" ref_url="https://access.redhat.com/security/cve/CVE-2023-5678" source="CVE"/>
+				<advisory from="secalert@redhat.com">
+					<severity>Low</severity>
+					<cve href="https://access.redhat.com/security/cve/CVE-2023-3446">CVE-2023-3446</cve>
+				</advisory>
+			</metadata>
+			<criteria operator="OR">
+				<criterion comment="Red Hat Enterprise Linux must be installed" test_ref="oval:com.redhat.rhba:tst:20191992005"/>
+				<criteria operator="AND">
+					<criteria operator="OR">
+						<criterion comment="openssl is earlier than 1:3.0.7-25.el9_3" test_ref="oval:com.redhat.rhsa:tst:20237877001"/>
+						<criterion comment="openssl-libs is earlier than 1:3.0.7-25.el9_3" test_ref="oval:com.redhat.rhsa:tst:20237877003"/>
+					</criteria>
+					<criterion comment="openssl is signed with Red Hat redhatrelease2 key" test_ref="oval:com.redhat.rhsa:tst:20237877002"/>
+				</criteria>
+			</criteria>
+		</definition>
+		<definition class="inventory" id="oval:com.redhat.rhba:def:20191992" version="1">
+			<metadata><title>Red Hat Enterprise Linux 9 is installed</title></metadata>
+			<criteria><criterion test_ref="oval:com.redhat.rhba:tst:20191992005"/></criteria>
+		</definition>
+	</definitions>
+	<tests>
+		<red-def:rpminfo_test check="at least one" id="oval:com.redhat.rhsa:tst:20237877001" version="637">
+			<red-def:object object_ref="oval:com.redhat.rhsa:obj:20237877001"/>
+			<red-def:state state_ref="oval:com.redhat.rhsa:ste:20237877001"/>
+		</red-def:rpminfo_test>
+		<red-def:rpminfo_test check="at least one" id="oval:com.redhat.rhsa:tst:20237877002" version="637">
+			<red-def:object object_ref="oval:com.redhat.rhsa:obj:20237877001"/>
+			<red-def:state state_ref="oval:com.redhat.rhsa:ste:20220000002"/>
+		</red-def:rpminfo_test>
+		<red-def:rpminfo_test check="at least one" id="oval:com.redhat.rhsa:tst:20237877003" version="637">
+			<red-def:object object_ref="oval:com.redhat.rhsa:obj:20237877003"/>
+			<red-def:state state_ref="oval:com.redhat.rhsa:ste:20237877001"/>
+		</red-def:rpminfo_test>
+		<red-def:rpminfo_test check="at least one" id="oval:com.redhat.rhba:tst:20191992005" version="1">
+			<red-def:object object_ref="oval:com.redhat.rhba:obj:20191992003"/>
+			<red-def:state state_ref="oval:com.redhat.rhba:ste:20191992003"/>
+		</red-def:rpminfo_test>
+	</tests>
+	<objects>
+		<red-def:rpminfo_object id="oval:com.redhat.rhsa:obj:20237877001" version="637">
+			<red-def:name>openssl</red-def:name>
+		</red-def:rpminfo_object>
+		<red-def:rpminfo_object id="oval:com.redhat.rhsa:obj:20237877003" version="637">
+			<red-def:name>openssl-libs</red-def:name>
+		</red-def:rpminfo_object>
+		<red-def:rpminfo_object id="oval:com.redhat.rhba:obj:20191992003" version="1">
+			<red-def:name>redhat-release</red-def:name>
+		</red-def:rpminfo_object>
+	</objects>
+	<states>
+		<red-def:rpminfo_state id="oval:com.redhat.rhsa:ste:20237877001" version="637">
+			<red-def:arch datatype="string" operation="pattern match">aarch64|x86_64</red-def:arch>
+			<red-def:evr datatype="evr_string" operation="less than">1:3.0.7-25.el9_3</red-def:evr>
+		</red-def:rpminfo_state>
+		<red-def:rpminfo_state id="oval:com.redhat.rhsa:ste:20220000002" version="637">
+			<red-def:signature_keyid operation="equals">199e2f91fd431d51</red-def:signature_keyid>
+		</red-def:rpminfo_state>
+		<red-def:rpminfo_state id="oval:com.redhat.rhba:ste:20191992003" version="1">
+			<red-def:version operation="pattern match">^9[^\d]</red-def:version>
+		</red-def:rpminfo_state>
+	</states>
+</oval_definitions>`
+
+const ovalUbuntu = `<?xml version="1.0" encoding="UTF-8"?>
+<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5"
+	xmlns:linux-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux">
+	<definitions>
+		<definition class="vulnerability" id="oval:com.ubuntu.jammy:def:202338545000000" version="1">
+			<metadata>
+				<title>CVE-2023-38545 on Ubuntu 22.04 LTS (jammy) - high</title>
+				<reference source="CVE" ref_id="CVE-2023-38545" ref_url="https://ubuntu.com/security/CVE-2023-38545"/>
+				<advisory><severity>High</severity></advisory>
+			</metadata>
+			<criteria>
+				<criterion test_ref="oval:com.ubuntu.jammy:tst:202338545000000" comment="(Note: 'curl' is related to the CVE)"/>
+			</criteria>
+		</definition>
+		<definition class="vulnerability" id="oval:com.ubuntu.jammy:def:202328321000000" version="1">
+			<metadata>
+				<title>CVE-2023-28321 on Ubuntu 22.04 LTS (jammy) - low</title>
+				<reference source="CVE" ref_id="CVE-2023-28321" ref_url="https://ubuntu.com/security/CVE-2023-28321"/>
+				<advisory><severity>Low</severity></advisory>
+			</metadata>
+			<criteria>
+				<criterion test_ref="oval:com.ubuntu.jammy:tst:202328321000000" comment="while related CVE is not fixed"/>
+			</criteria>
+		</definition>
+	</definitions>
+	<tests>
+		<linux-def:dpkginfo_test id="oval:com.ubuntu.jammy:tst:202338545000000" version="1" check_existence="at_least_one_exists" check="at least one">
+			<linux-def:object object_ref="oval:com.ubuntu.jammy:obj:202338545000000"/>
+			<linux-def:state state_ref="oval:com.ubuntu.jammy:ste:202338545000000"/>
+		</linux-def:dpkginfo_test>
+		<linux-def:dpkginfo_test id="oval:com.ubuntu.jammy:tst:202328321000000" version="1" check_existence="at_least_one_exists" check="at least one">
+			<linux-def:object object_ref="oval:com.ubuntu.jammy:obj:202338545000000"/>
+			<linux-def:state state_ref="oval:com.ubuntu.jammy:ste:202328321000000"/>
+		</linux-def:dpkginfo_test>
+	</tests>
+	<objects>
+		<linux-def:dpkginfo_object id="oval:com.ubuntu.jammy:obj:202338545000000" version="1">
+			<linux-def:name var_ref="oval:com.ubuntu.jammy:var:202338545000000" var_check="at least one"/>
+		</linux-def:dpkginfo_object>
+	</objects>
+	<states>
+		<linux-def:dpkginfo_state id="oval:com.ubuntu.jammy:ste:202338545000000" version="1">
+			<linux-def:evr datatype="debian_evr_string" operation="less than">0:7.81.0-1ubuntu1.14</linux-def:evr>
+		</linux-def:dpkginfo_state>
+		<linux-def:dpkginfo_state id="oval:com.ubuntu.jammy:ste:202328321000000" version="1">
+			<linux-def:evr datatype="debian_evr_string" operation="greater than">0:0</linux-def:evr>
+		</linux-def:dpkginfo_state>
+	</states>
+	<variables>
+		<constant_variable id="oval:com.ubuntu.jammy:var:202338545000000" version="1" datatype="string" comment="curl binaries">
+			<value>curl</value>
+			<value>libcurl4</value>
+		</constant_variable>
+	</variables>
+</oval_definitions>`
+
+// writeDB writes a vulnerability database with files, which maps file names to contents, to a
+// new temporary directory.
+func writeDB(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "vulndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		filename := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMatch(t *testing.T) {
+	dir := writeDB(t, map[string]string{
+		"osv/debian/DSA-5532-1.json":           osvDebian,
+		"osv/alpine/v3.19/CVE-2023-42363.json": osvAlpine,
+		"osv/README.md":                        "not a record",
+		"debian/tracker.json":                  debianTracker,
+		"oval/REDHAT-9.oval.xml":               ovalRedHat,
+		"oval/UBUNTU-jammy.cve.oval.xml":       ovalUbuntu,
+	})
+	defer os.RemoveAll(dir)
+	db, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Loaded", db.Advisories(), "advisories")
+
+	cases := []struct {
+		distroID string
+		pkgs     []Package
+		findings []Finding
+	}{
+		{"DEBIAN-bookworm", []Package{
+			{Name: "libssl3", Version: "3.0.11-1~deb12u1", Architecture: "amd64", Origin: "openssl"},
+			{Name: "curl", Version: "7.88.1-10+deb12u3", Architecture: "amd64"},
+			{Name: "bash", Version: "5.2.15-2+b2", Architecture: "amd64"}},
+			[]Finding{
+				{ID: "CVE-2023-38545", Pkg: "curl", Version: "7.88.1-10+deb12u3", Architecture: "amd64",
+					FixedVersion: "7.88.1-10+deb12u4", Severity: SeverityHigh, Summary: "SOCKS5 heap buffer overflow",
+					Source: SourceDebian},
+				{ID: "CVE-2023-38546", Pkg: "curl", Version: "7.88.1-10+deb12u3", Architecture: "amd64",
+					Severity: SeverityNegligible, Summary: "cookie injection with none file", Source: SourceDebian},
+				// in both OSV and the tracker: the first one loaded is reported
+				{ID: "CVE-2023-5363", Advisory: "DSA-5532-1", Pkg: "libssl3", Version: "3.0.11-1~deb12u1",
+					Architecture: "amd64", FixedVersion: "3.0.11-1~deb12u2", Severity: SeverityHigh,
+					Summary: "openssl security update", Source: SourceOSV}}},
+		{"DEBIAN-bookworm", []Package{{Name: "libssl3", Version: "3.0.11-1~deb12u2", Origin: "openssl"},
+			{Name: "curl", Version: "7.88.1-10+deb12u4"}},
+			[]Finding{{ID: "CVE-2023-38546", Pkg: "curl", Version: "7.88.1-10+deb12u4", Severity: SeverityNegligible,
+				Summary: "cookie injection with none file", Source: SourceDebian}}},
+		{"DEBIAN-bullseye", []Package{{Name: "curl", Version: "7.74.0-1.3+deb11u7"}}, []Finding{}},
+		{"DEBIAN-trixie-sid", []Package{{Name: "curl", Version: "8.3.0-2"}},
+			[]Finding{{ID: "CVE-2023-38545", Pkg: "curl", Version: "8.3.0-2", FixedVersion: "8.3.0-3",
+				Severity: SeverityHigh, Summary: "SOCKS5 heap buffer overflow", Source: SourceDebian}}},
+		{"ALPINE-3.19", []Package{{Name: "busybox-binsh", Version: "1.36.1-r15", Architecture: "x86_64", Origin: "busybox"},
+			{Name: "busybox", Version: "1.36.1-r16", Architecture: "x86_64", Origin: "busybox"}},
+			[]Finding{{ID: "CVE-2023-42363", Pkg: "busybox-binsh", Version: "1.36.1-r15", Architecture: "x86_64",
+				FixedVersion: "1.36.1-r16", Severity: SeverityMedium, Summary: "A use-after-free in busybox awk.",
+				Source: SourceOSV}}},
+		{"ALPINE-3.18", []Package{{Name: "busybox", Version: "1.36.1-r0"}}, []Finding{}},
+		// rpm versions are compared with their epochs
+		{"REDHAT-9", []Package{{Name: "openssl-libs", Version: "1:3.0.7-24.el9", Architecture: "x86_64", Origin: "openssl"},
+			{Name: "openssl", Version: "1:3.0.7-25.el9_3", Architecture: "x86_64", Origin: "openssl"},
+			{Name: "redhat-release", Version: "9.3-0.5.el9", Architecture: "x86_64"}},
+			[]Finding{
+				{ID: "CVE-2023-3446", Advisory: "RHSA-2023:7877", Pkg: "openssl-libs", Version: "1:3.0.7-24.el9",
+					Architecture: "x86_64", FixedVersion: "1:3.0.7-25.el9_3", Severity: SeverityLow,
+					Summary: "RHSA-2023:7877: openssl security update (Low)", Source: SourceOVAL},
+				{ID: "CVE-2023-5678", Advisory: "RHSA-2023:7877", Pkg: "openssl-libs", Version: "1:3.0.7-24.el9",
+					Architecture: "x86_64", FixedVersion: "1:3.0.7-25.el9_3", Severity: SeverityLow,
+					Summary: "RHSA-2023:7877: openssl security update (Low)", Source: SourceOVAL}}},
+		{"UBUNTU-jammy", []Package{{Name: "libcurl4", Version: "7.81.0-1ubuntu1.13", Origin: "curl"}},
+			[]Finding{
+				{ID: "CVE-2023-28321", Pkg: "libcurl4", Version: "7.81.0-1ubuntu1.13", Severity: SeverityLow,
+					Summary: "CVE-2023-28321 on Ubuntu 22.04 LTS (jammy) - low", Source: SourceOVAL},
+				{ID: "CVE-2023-38545", Pkg: "libcurl4", Version: "7.81.0-1ubuntu1.13",
+					FixedVersion: "0:7.81.0-1ubuntu1.14", Severity: SeverityHigh,
+					Summary: "CVE-2023-38545 on Ubuntu 22.04 LTS (jammy) - high", Source: SourceOVAL}}},
+		{"FEDORA-39", []Package{{Name: "curl", Version: "8.2.1-3.fc39"}}, []Finding{}},
+	}
+	for _, c := range cases {
+		findings := db.Match(c.distroID, c.pkgs)
+		if !reflect.DeepEqual(findings, c.findings) {
+			t.Fatal(c.distroID, c.pkgs, "expected", c.findings, "got", findings)
+		}
+	}
+
+	empty := writeDB(t, map[string]string{"feeds/x.json": "{}"})
+	defer os.RemoveAll(empty)
+	if _, err := Load(empty); err == nil {
+		t.Fatal("Expected an error for a database without feeds")
+	}
+	bad := writeDB(t, map[string]string{"osv/bad.json": "{"})
+	defer os.RemoveAll(bad)
+	if _, err := Load(bad); err == nil {
+		t.Fatal("Expected an error for a malformed record")
+	}
+}
+
+func TestRangeAffects(t *testing.T) {
+	events := []event{{Introduced: "0"}, {Fixed: "1.2-1"}, {Introduced: "2.0-1"}, {LastAffected: "2.1-1"},
+		{Introduced: "3.0-1"}, {Fixed: "3.2-1"}, {Fixed: "3.1-1"}}
+	cases := []struct {
+		v        string
+		affected bool
+		fixed    string
+	}{
+		{"1.0-1", true, "1.2-1"},
+		{"1.2-1", false, ""},
+		{"1.5-1", false, ""},
+		{"2.0-1", true, ""},
+		{"2.1-1", true, ""},
+		{"2.1-2", false, ""},
+		{"3.0-5", true, "3.1-1"},
+		{"3.1-1", false, ""},
+		{"3.1~rc1-1", true, "3.1-1"},
+		{"4.0-1", false, ""},
+	}
+	for _, c := range cases {
+		affected, fixed := rangeAffects("dpkg", events, c.v)
+		if affected != c.affected || fixed != c.fixed {
+			t.Fatal(c.v, "expected", c.affected, c.fixed, "got", affected, fixed)
+		}
+	}
+
+	// the epoch of an rpm version counts: 1.0 is 0:1.0, older than 1:1.0
+	events = []event{{Introduced: "0"}, {Fixed: "1:1.0-1"}}
+	for _, c := range []struct {
+		v        string
+		affected bool
+	}{
+		{"1.0-1", true},
+		{"0:1.0-1", true},
+		{"1:1.0-1", false},
+		{"1:0.9-1", true},
+		{"2:0.1-1", false},
+	} {
+		if affected, _ := rangeAffects("rpm", events, c.v); affected != c.affected {
+			t.Fatal(c.v, "expected affected", c.affected)
+		}
+	}
+}
+
+func TestEcosystemDistroID(t *testing.T) {
+	cases := []struct {
+		ecosystem string
+		distroID  string
+	}{
+		{"Debian:12", "DEBIAN-bookworm"},
+		{"Debian:11", "DEBIAN-bullseye"},
+		{"Debian:99", ""},
+		{"Ubuntu:22.04:LTS", "UBUNTU-jammy"},
+		{"Ubuntu:Pro:18.04:LTS", "UBUNTU-bionic"},
+		{"Ubuntu:23.10", "UBUNTU-mantic"},
+		{"Alpine:v3.19", "ALPINE-3.19"},
+		{"Rocky Linux:9", "REDHAT-9"},
+		{"AlmaLinux:8", "REDHAT-8"},
+		{"Red Hat:enterprise_linux:9::appstream", "REDHAT-9"},
+		{"Red Hat:enterprise_linux:7::server", "REDHAT-7Server"},
+		{"openSUSE:Leap 15.5", "OPENSUSE-LEAP-15.5"},
+		{"openSUSE:Tumbleweed", "OPENSUSE-TUMBLEWEED"},
+		{"SUSE:Linux Enterprise Server 15 SP5", "SLES-15.5"},
+		{"SUSE:Linux Enterprise Server 12", "SLES-12"},
+		{"Debian", ""},
+		{"PyPI", ""},
+		{"Go:stdlib", ""},
+	}
+	for _, c := range cases {
+		if got := ecosystemDistroID(c.ecosystem); got != c.distroID {
+			t.Fatal(c.ecosystem, "expected", c.distroID, "got", got)
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	cvss := []struct {
+		vector string
+		score  float64
+		ok     bool
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10.0, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:N/A:N", 7.5, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1, true},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", 7.8, true},
+		{"CVSS:3.1/AV:L/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", 1.8, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:L/UI:N/S:C/C:L/I:N/A:N", 5.0, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:X/C:H/I:H/A:H", 0, false},
+		{"CVSS:3.1/AV:N/AC:L", 0, false},
+		{"AV:N/AC:L/Au:N/C:P/I:P/A:P", 0, false},
+	}
+	for _, c := range cvss {
+		if score, ok := CVSS3Score(c.vector); score != c.score || ok != c.ok {
+			t.Fatal(c.vector, "expected", c.score, c.ok, "got", score, ok)
+		}
+	}
+
+	ratings := []struct {
+		rating   string
+		severity string
+	}{
+		{"CRITICAL", SeverityCritical},
+		{"Important", SeverityHigh},
+		{"high", SeverityHigh},
+		{"MODERATE", SeverityMedium},
+		{"medium**", SeverityMedium},
+		{"Low", SeverityLow},
+		{"unimportant", SeverityNegligible},
+		{"Negligible", SeverityNegligible},
+		{"not yet assigned", SeverityUnknown},
+		{"", SeverityUnknown},
+	}
+	for _, c := range ratings {
+		if got := NormalizeSeverity(c.rating); got != c.severity {
+			t.Fatal(c.rating, "expected", c.severity, "got", got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// vulnerabilities.go matches the packages of images against an offline vulnerability database
+// (see --vulndb and package vulndb).
+package collector
+
+import (
+	vulndb "github.com/banyanops/collector/vulndb"
+	blog "github.com/ccpaging/log4go"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	// VULNERABILITIES is the output map key of the vulnerabilities of the packages of an image,
+	// as []vulndb.Finding.
+	VULNERABILITIES = "vulnerabilities"
+)
+
+var (
+	VulnDBDir = flag.String("vulndb", "",
+		"Directory of an offline vulnerability database (osv, debian and oval subdirectories) to match the packages of images against")
+	// VulnDB is the vulnerability database loaded from VulnDBDir, nil if there is none.
+	VulnDB *vulndb.DB
+)
+
+// LoadVulnDB loads the vulnerability database in dir and makes it the current VulnDB.
+func LoadVulnDB(dir string) (e error) {
+	db, e := vulndb.Load(dir)
+	if e != nil {
+		return
+	}
+	VulnDB = db
+	blog.Info("Loaded %d advisories from vulnerability database %s", db.Advisories(), dir)
+	return
+}
+
+// MatchVulnerabilities returns the vulnerabilities of the packages pkgs of an image in VulnDB.
+func MatchVulnerabilities(pkgs []ImageDataInfo) []vulndb.Finding {
+	if len(pkgs) == 0 {
+		return []vulndb.Finding{}
+	}
+	installed := make([]vulndb.Package, 0, len(pkgs))
+	for _, p := range pkgs {
+		if p.Pkg == "" {
+			// an image without packages has a single entry for its distribution
+			continue
+		}
+		installed = append(installed, vulndb.Package{Name: p.Pkg, Version: p.Version,
+			Architecture: p.Architecture, Origin: p.Origin})
+	}
+	return VulnDB.Match(pkgs[0].DistroID, installed)
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vulndb "github.com/banyanops/collector/vulndb"
+)
+
+func TestMatchVulnerabilities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vulndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "osv"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "osv", "CVE-2023-42363.json"), []byte(`{
+		"id": "CVE-2023-42363",
+		"summary": "use-after-free in awk",
+		"affected": [{
+			"package": {"ecosystem": "Alpine:v3.19", "name": "busybox"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.36.1-r16"}]}],
+			"database_specific": {"severity": "MEDIUM"}
+		}]}`), 0644)
+	if err := LoadVulnDB(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Expected an error for a missing database")
+	}
+	if err := LoadVulnDB(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { VulnDB = nil }()
+
+	pkgs := []ImageDataInfo{
+		{Image: "sha256:abc", DistroName: "Alpine Linux v3.19", DistroID: "ALPINE-3.19", Pkg: "busybox",
+			Version: "1.36.1-r15", Architecture: "x86_64", Origin: "busybox"},
+		{Image: "sha256:abc", DistroName: "Alpine Linux v3.19", DistroID: "ALPINE-3.19", Pkg: "ssl_client",
+			Version: "1.36.1-r15", Architecture: "x86_64", Origin: "busybox"},
+		{Image: "sha256:abc", DistroName: "Alpine Linux v3.19", DistroID: "ALPINE-3.19", Pkg: "musl",
+			Version: "1.2.4-r2", Architecture: "x86_64", Origin: "musl"},
+	}
+	findings := MatchVulnerabilities(pkgs)
+	if len(findings) != 2 || findings[0].Pkg != "busybox" || findings[1].Pkg != "ssl_client" ||
+		findings[0].FixedVersion != "1.36.1-r16" || findings[0].Severity != vulndb.SeverityMedium {
+		t.Fatal("Unexpected findings", findings)
+	}
+	if findings := MatchVulnerabilities(nil); findings == nil || len(findings) != 0 {
+		t.Fatal("Expected no findings for an image without packages", findings)
+	}
+
+	report := NewScanReport("alpine:3.19", ImageMetadataInfo{Image: "sha256:abc"},
+		map[string]interface{}{PKGEXTRACTSCRIPT: pkgs, VULNERABILITIES: findings})
+	var b bytes.Buffer
+	if err := WriteScanReport(&b, report, ReportText); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(b.String())
+	for _, s := range []string{"Vulnerabilities:  2 (2 medium)", "CVE-2023-42363", "1.36.1-r16"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatal("Missing", s, "in summary")
+		}
+	}
+	if strings.Contains(b.String(), "Output of") {
+		t.Fatal("Vulnerabilities reported as script output")
+	}
+}
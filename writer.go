@@ -11,7 +11,11 @@ import (
 // "banyanWriter": invokes banyan API to send data to SAAS dashboard
 type Writer interface {
 	// Write output obtained by all the scripts to the appropriate writer plugin
-	// Note: outMapMap maps: ImageID -> Script -> Output
+	// Note: outMapMap maps: ImageID -> Script -> Output, where Output is []ImageDataInfo for
+	// package data, ScriptRecords for structured script output, and ScriptOutput otherwise.
+	// The records of the script executions are under SCRIPTRESULTS as []ScriptResult, and the
+	// vulnerabilities of the packages under VULNERABILITIES as []vulndb.Finding, and the policy
+	// verdict under POLICYVERDICT as PolicyVerdict.
 	WriteImageAllData(outMapMap map[string]map[string]interface{})
 
 	// Append Image metadata to the appropriate writer plugin
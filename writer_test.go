@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
 	fsutil "github.com/banyanops/collector/fsutil"
+	vulndb "github.com/banyanops/collector/vulndb"
 	blog "github.com/ccpaging/log4go"
 )
 
@@ -25,7 +27,7 @@ func TestWriteImageAllData(t *testing.T) {
 	outMapMap := make(map[string]map[string]interface{})
 
 	// Testing imagedata...
-	var idata = []ImageDataInfo{{"111", "a", "b", "c", "dn1", "did1"}, {"111", "d", "e", "f", "dn2", "did2"}, {"121", "g", "h", "i", "dn3", "did3"}}
+	var idata = []ImageDataInfo{{"111", "a", "b", "c", "dn1", "did1", "o1"}, {"111", "d", "e", "f", "dn2", "did2", "o2"}, {"121", "g", "h", "i", "dn3", "did3", "o3"}}
 	for _, c := range cases {
 		outMap[c.script] = idata
 		outMapMap[c.image] = outMap
@@ -57,6 +59,63 @@ func TestWriteImageAllData(t *testing.T) {
 		}
 	}
 
+	// Testing script output in the format declared by its manifest...
+	for _, o := range []ScriptOutput{
+		{ScriptOutputYAML, []byte("users:\n- root\n")},
+		{ScriptOutputJSON, []byte(`{"users": ["root"]}`)},
+		{ScriptOutputText, []byte("root\n")},
+	} {
+		script := "Y" + o.Format + ".py"
+		outMap[script] = o
+		outMapMap["image"] = outMap
+		b := testWriteToFile(t, outMapMap, script, "image", "/tmp", o.Extension(), "-miscdata")
+		if !bytes.Equal(b, o.Data) {
+			t.Fatal("Input/Output script", o.Format, "output don't match", len(b), len(o.Data))
+		}
+	}
+
+	// Testing structured script output...
+	records := ScriptRecords{Schema: "users", Version: 1,
+		Records: []map[string]interface{}{{"name": "root", "uid": float64(0), "gid": float64(0)}}}
+	outMap["Zusers.py"] = records
+	outMapMap["image"] = outMap
+	b := testWriteToFile(t, outMapMap, "Zusers.py", "image", "/tmp", "json", "-records")
+	var written ScriptRecords
+	if err := json.Unmarshal(b, &written); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, records) {
+		t.Fatal("Input/Output script records don't match", written, records)
+	}
+
+	// Testing script execution records...
+	results := []ScriptResult{{Script: "fail.sh", ContainerID: "c1", Start: time.Unix(1500000000, 0).UTC(),
+		Duration: 1.5, ExitStatus: 2, Error: "Bash script exit status: 2", Stdout: "partial\n", Stderr: "oops\n"}}
+	outMap[SCRIPTRESULTS] = results
+	outMapMap["image"] = outMap
+	b = testWriteToFile(t, outMapMap, SCRIPTRESULTS, "image", "/tmp", "json", "-results")
+	var writtenResults []ScriptResult
+	if err := json.Unmarshal(b, &writtenResults); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(writtenResults, results) {
+		t.Fatal("Input/Output script results don't match", writtenResults, results)
+	}
+
+	// Testing vulnerabilities...
+	findings := []vulndb.Finding{{ID: "CVE-2023-38545", Pkg: "curl", Version: "7.88.1-10+deb12u3",
+		FixedVersion: "7.88.1-10+deb12u4", Severity: vulndb.SeverityHigh, Source: vulndb.SourceDebian}}
+	outMap[VULNERABILITIES] = findings
+	outMapMap["image"] = outMap
+	b = testWriteToFile(t, outMapMap, VULNERABILITIES, "image", "/tmp", "json", "-findings")
+	var writtenFindings []vulndb.Finding
+	if err := json.Unmarshal(b, &writtenFindings); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(writtenFindings, findings) {
+		t.Fatal("Input/Output findings don't match", writtenFindings, findings)
+	}
+
 	//Pass...
 	return
 }
@@ -101,9 +160,9 @@ func TestWriteImageMetadata(t *testing.T) {
 
 	// Testing imagedata...
 	var imdata = []ImageMetadataInfo{
-		{"111", time.Now(), OtherMetadata{"r1", "t1", 100, "a1", "c1", "c1", "p1"}, "", ""},
-		{"121", time.Now(), OtherMetadata{"r2", "t2", 100, "a2", "c2", "c2", "p2"}, "", ""},
-		{"131", time.Now(), OtherMetadata{"r3", "t3", 100, "a3", "c3", "c3", "p3"}, "", ""},
+		{"111", time.Now(), OtherMetadata{"r1", "t1", 100, "a1", "c1", "c1", "p1", "linux", "amd64", ""}, "", ""},
+		{"121", time.Now(), OtherMetadata{"r2", "t2", 100, "a2", "c2", "c2", "p2", "linux", "amd64", ""}, "", ""},
+		{"131", time.Now(), OtherMetadata{"r3", "t3", 100, "a3", "c3", "c3", "p3", "linux", "amd64", ""}, "", ""},
 	}
 
 	// Remove output file if it already exists -- since we append